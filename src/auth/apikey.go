@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+	"test_service/util"
+)
+
+// ApiKeyScheme is the Authorization header scheme used to present api keys ("Authorization: ApiKey <key>")
+const ApiKeyScheme = "ApiKey"
+
+// apiKeyPrefix is prepended to every generated key so leaked keys are easy to identify
+const apiKeyPrefix = "tsk_"
+
+// defaults used when the config does not specify them
+const (
+	defaultApiKeyCacheTTL      = 1 * time.Minute
+	defaultLastUsedFlushPeriod = 1 * time.Minute
+)
+
+// errors returned by the api key manager
+var (
+	// ErrApiKeyNotFound is returned when the requested api key does not exist
+	ErrApiKeyNotFound = errors.New("api key not found")
+
+	// ErrInvalidApiKey is returned when a presented key is unknown, revoked or expired
+	ErrInvalidApiKey = errors.New("invalid api key")
+)
+
+// ApiKeyStore persists api keys (satisfied by repository.ApiKeyRepository implementations)
+type ApiKeyStore interface {
+	CreateApiKey(ctx context.Context, key *models.ApiKey) error
+	GetApiKey(ctx context.Context, id string) (*models.ApiKey, error)
+	GetApiKeyByHash(ctx context.Context, hashedKey string) (*models.ApiKey, error)
+	ListApiKeys(ctx context.Context) ([]models.ApiKey, error)
+	UpdateApiKeyHash(ctx context.Context, id, prefix, hashedKey string) error
+	RevokeApiKey(ctx context.Context, id string, revokedAt time.Time) error
+	UpdateApiKeysLastUsed(ctx context.Context, lastUsed map[string]time.Time) error
+}
+
+// SharedApiKeyCache is implemented by stores caching api key lookups across replicas (e.g. cache.CachedStore)
+// the manager does not cache lookups of such stores itself, so invalidations made by other replicas reach it
+type SharedApiKeyCache interface {
+	CachesApiKeys() bool
+}
+
+// apiKeyCacheEntry is a cached lookup of a known key
+type apiKeyCacheEntry struct {
+	key       *models.ApiKey
+	expiresAt time.Time
+}
+
+// ApiKeyManager creates, rotates and revokes api keys and authenticates requests presenting them
+// lookups of known keys are cached in memory (expired ones are dropped periodically) and last-used timestamps
+// are flushed to the store periodically. revocations on other replicas take effect once the cached entry
+// expires (cacheTTL), unless the store caches lookups across replicas itself
+type ApiKeyManager struct {
+	// store where keys are persisted
+	store ApiKeyStore
+
+	// how long lookups are cached (not cached if zero)
+	cacheTTL time.Duration
+
+	// how often last-used timestamps are written to the store
+	flushInterval time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// cache of key hash to known key
+	cacheLock sync.Mutex
+	cache     map[string]apiKeyCacheEntry
+
+	// last-used timestamps (by key id) pending a flush
+	lastUsedLock sync.Mutex
+	lastUsed     map[string]time.Time
+
+	// stopCh signals the flush routine to exit
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewApiKeyManager creates an api key manager backed by the store
+func NewApiKeyManager(config *proto.ApiKeyConfig, store ApiKeyStore, logger *log.Entry) (*ApiKeyManager, error) {
+	cacheTTL, err := util.ParseDuration(config.CacheTTL, defaultApiKeyCacheTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	if shared, ok := store.(SharedApiKeyCache); ok && shared.CachesApiKeys() {
+		cacheTTL = 0
+	}
+
+	flushInterval, err := util.ParseDuration(config.LastUsedFlushInterval, defaultLastUsedFlushPeriod)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApiKeyManager{
+		store:         store,
+		cacheTTL:      cacheTTL,
+		flushInterval: flushInterval,
+		logger:        logger,
+		cache:         make(map[string]apiKeyCacheEntry),
+		lastUsed:      make(map[string]time.Time),
+		stopCh:        make(chan struct{}),
+	}, nil
+}
+
+// Start runs a background routine that flushes last-used timestamps to the store and drops expired lookups
+func (m *ApiKeyManager) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.stopCh:
+				m.flushLastUsed()
+				return
+			case <-ticker.C:
+				m.flushLastUsed()
+				m.sweepCache()
+			}
+		}
+	}()
+}
+
+// Stop terminates the flush routine after a final flush
+func (m *ApiKeyManager) Stop() {
+	close(m.stopCh)
+	m.wg.Wait()
+}
+
+// Create generates a new api key. the plaintext key is only returned here and cannot be recovered later
+func (m *ApiKeyManager) Create(ctx context.Context, name string, scopes []string, expiresIn time.Duration) (*models.ApiKey, string, error) {
+	plaintext, prefix, hashedKey, err := generateApiKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &models.ApiKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Prefix:    prefix,
+		HashedKey: hashedKey,
+	}
+	key.SetScopes(scopes)
+
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := m.store.CreateApiKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	m.logger.Infof("api key %s (%s) created", key.ID, key.Name)
+	return key, plaintext, nil
+}
+
+// List returns all api keys
+func (m *ApiKeyManager) List(ctx context.Context) ([]models.ApiKey, error) {
+	return m.store.ListApiKeys(ctx)
+}
+
+// Rotate replaces the secret of an existing key, keeping its id, scopes and expiry
+// the previous secret stops working immediately on this replica
+func (m *ApiKeyManager) Rotate(ctx context.Context, id string) (*models.ApiKey, string, error) {
+	key, err := m.get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !key.IsActive(time.Now()) {
+		return nil, "", ErrInvalidApiKey
+	}
+
+	plaintext, prefix, hashedKey, err := generateApiKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := m.store.UpdateApiKeyHash(ctx, id, prefix, hashedKey); err != nil {
+		return nil, "", m.mapError(err)
+	}
+
+	m.invalidate(key.HashedKey)
+	key.Prefix = prefix
+	key.HashedKey = hashedKey
+
+	m.logger.Infof("api key %s (%s) rotated", key.ID, key.Name)
+	return key, plaintext, nil
+}
+
+// Revoke permanently disables a key
+func (m *ApiKeyManager) Revoke(ctx context.Context, id string) error {
+	key, err := m.get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := m.store.RevokeApiKey(ctx, id, time.Now()); err != nil {
+		return m.mapError(err)
+	}
+
+	m.invalidate(key.HashedKey)
+	m.logger.Infof("api key %s (%s) revoked", key.ID, key.Name)
+	return nil
+}
+
+// Authenticate validates a presented key and returns the principal it represents
+func (m *ApiKeyManager) Authenticate(ctx context.Context, plaintext string) (*Principal, error) {
+	hashedKey := HashApiKey(plaintext)
+	key, err := m.lookup(ctx, hashedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if key == nil || !key.IsActive(now) {
+		return nil, ErrInvalidApiKey
+	}
+
+	m.lastUsedLock.Lock()
+	m.lastUsed[key.ID] = now
+	m.lastUsedLock.Unlock()
+
+	return &Principal{
+		Subject: "apikey:" + key.ID,
+		Scopes:  key.ScopeList(),
+	}, nil
+}
+
+// lookup fetches a key by hash through the cache, returning nil for unknown keys
+// unknown keys are not cached, so presenting random keys does not grow the cache
+func (m *ApiKeyManager) lookup(ctx context.Context, hashedKey string) (*models.ApiKey, error) {
+	if m.cacheTTL <= 0 {
+		return m.mapLookup(m.store.GetApiKeyByHash(ctx, hashedKey))
+	}
+
+	now := time.Now()
+	m.cacheLock.Lock()
+	entry, ok := m.cache[hashedKey]
+	m.cacheLock.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.key, nil
+	}
+
+	key, err := m.mapLookup(m.store.GetApiKeyByHash(ctx, hashedKey))
+	if key == nil {
+		return nil, err
+	}
+
+	m.cacheLock.Lock()
+	m.cache[hashedKey] = apiKeyCacheEntry{key: key, expiresAt: now.Add(m.cacheTTL)}
+	m.cacheLock.Unlock()
+
+	return key, nil
+}
+
+// mapLookup translates the store's miss of a lookup into a nil key
+func (m *ApiKeyManager) mapLookup(key *models.ApiKey, err error) (*models.ApiKey, error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	return key, err
+}
+
+// get fetches a key by id from the store's primary, as it is about to be updated
+func (m *ApiKeyManager) get(ctx context.Context, id string) (*models.ApiKey, error) {
+	key, err := m.store.GetApiKey(repository.WithPrimary(ctx), id)
+	if err != nil {
+		return nil, m.mapError(err)
+	}
+
+	return key, nil
+}
+
+// invalidate drops a key from the cache
+func (m *ApiKeyManager) invalidate(hashedKey string) {
+	m.cacheLock.Lock()
+	delete(m.cache, hashedKey)
+	m.cacheLock.Unlock()
+}
+
+// sweepCache drops expired lookups from the cache
+func (m *ApiKeyManager) sweepCache() {
+	now := time.Now()
+	m.cacheLock.Lock()
+	defer m.cacheLock.Unlock()
+
+	for hashedKey, entry := range m.cache {
+		if !now.Before(entry.expiresAt) {
+			delete(m.cache, hashedKey)
+		}
+	}
+}
+
+// flushLastUsed writes pending last-used timestamps to the store
+func (m *ApiKeyManager) flushLastUsed() {
+	m.lastUsedLock.Lock()
+	pending := m.lastUsed
+	m.lastUsed = make(map[string]time.Time)
+	m.lastUsedLock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if err := m.store.UpdateApiKeysLastUsed(context.Background(), pending); err != nil {
+		m.logger.Errorf("failed to record api key usage: %v", err)
+	}
+}
+
+// mapError translates store errors into api key manager errors
+func (m *ApiKeyManager) mapError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrApiKeyNotFound
+	}
+
+	return err
+}
+
+// HashApiKey returns the hex encoded SHA-256 digest of a key
+// keys are high entropy random values so a fast hash is sufficient
+func HashApiKey(plaintext string) string {
+	digest := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(digest[:])
+}
+
+// ParseApiKeyHeader extracts the key from an "ApiKey <key>" authorization header value
+// returns false if the header uses a different scheme
+func ParseApiKeyHeader(header string) (string, bool) {
+	fields := strings.Fields(header)
+	if len(fields) != 2 || !strings.EqualFold(fields[0], ApiKeyScheme) {
+		return "", false
+	}
+
+	return fields[1], true
+}
+
+// generateApiKey creates a new random key, returning the plaintext, its display prefix and hash
+func generateApiKey() (string, string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %v", err)
+	}
+
+	plaintext := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	prefix := plaintext[:len(apiKeyPrefix)+6]
+	return plaintext, prefix, HashApiKey(plaintext), nil
+}
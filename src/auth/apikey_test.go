@@ -0,0 +1,225 @@
+// Contains api key manager unit testcases
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+)
+
+// memoryApiKeyStore is an in-memory api key store used by the testcases
+type memoryApiKeyStore struct {
+	keys map[string]*models.ApiKey
+}
+
+func (m *memoryApiKeyStore) CreateApiKey(ctx context.Context, key *models.ApiKey) error {
+	copied := *key
+	m.keys[key.ID] = &copied
+	return nil
+}
+
+func (m *memoryApiKeyStore) GetApiKey(ctx context.Context, id string) (*models.ApiKey, error) {
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	copied := *key
+	return &copied, nil
+}
+
+func (m *memoryApiKeyStore) GetApiKeyByHash(ctx context.Context, hashedKey string) (*models.ApiKey, error) {
+	for _, key := range m.keys {
+		if key.HashedKey == hashedKey {
+			copied := *key
+			return &copied, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (m *memoryApiKeyStore) ListApiKeys(ctx context.Context) ([]models.ApiKey, error) {
+	var keys []models.ApiKey
+	for _, key := range m.keys {
+		keys = append(keys, *key)
+	}
+
+	return keys, nil
+}
+
+func (m *memoryApiKeyStore) UpdateApiKeyHash(ctx context.Context, id, prefix, hashedKey string) error {
+	key, ok := m.keys[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	key.Prefix = prefix
+	key.HashedKey = hashedKey
+	return nil
+}
+
+func (m *memoryApiKeyStore) RevokeApiKey(ctx context.Context, id string, revokedAt time.Time) error {
+	key, ok := m.keys[id]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+
+	key.RevokedAt = &revokedAt
+	return nil
+}
+
+func (m *memoryApiKeyStore) UpdateApiKeysLastUsed(ctx context.Context, lastUsed map[string]time.Time) error {
+	for id, usedAt := range lastUsed {
+		if key, ok := m.keys[id]; ok {
+			usedAt := usedAt
+			key.LastUsedAt = &usedAt
+		}
+	}
+
+	return nil
+}
+
+// TestApiKeyManager unit tests api key creation, authentication, rotation and revocation
+func TestApiKeyManager(test *testing.T) {
+	store := &memoryApiKeyStore{keys: make(map[string]*models.ApiKey)}
+	manager, err := NewApiKeyManager(&proto.ApiKeyConfig{CacheTTL: "1h"}, store, log.WithField("test", "apikeys"))
+	if err != nil {
+		test.Errorf("failed to create api key manager: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	key, plaintext, err := manager.Create(ctx, "batch-job", []string{"read", "write"}, 0)
+	if err != nil {
+		test.Errorf("failed to create api key: %v", err)
+		return
+	}
+
+	if store.keys[key.ID].HashedKey == plaintext {
+		test.Errorf("api key must not be stored in plaintext")
+	}
+
+	principal, err := manager.Authenticate(ctx, plaintext)
+	if err != nil {
+		test.Errorf("failed to authenticate api key: %v", err)
+		return
+	}
+
+	if !principal.HasScope("write") || principal.Subject != "apikey:"+key.ID {
+		test.Errorf("unexpected principal for api key: %+v", principal)
+	}
+
+	// usage is only persisted on flush
+	manager.flushLastUsed()
+	if store.keys[key.ID].LastUsedAt == nil {
+		test.Errorf("api key usage was not recorded")
+	}
+
+	// the old secret stops working after rotation (despite being cached)
+	_, rotated, err := manager.Rotate(ctx, key.ID)
+	if err != nil {
+		test.Errorf("failed to rotate api key: %v", err)
+		return
+	}
+
+	if _, err := manager.Authenticate(ctx, plaintext); err != ErrInvalidApiKey {
+		test.Errorf("rotated api key should be rejected, got: %v", err)
+	}
+
+	if _, err := manager.Authenticate(ctx, rotated); err != nil {
+		test.Errorf("failed to authenticate rotated api key: %v", err)
+	}
+
+	// revoked keys are rejected
+	if err := manager.Revoke(ctx, key.ID); err != nil {
+		test.Errorf("failed to revoke api key: %v", err)
+		return
+	}
+
+	if _, err := manager.Authenticate(ctx, rotated); err != ErrInvalidApiKey {
+		test.Errorf("revoked api key should be rejected, got: %v", err)
+	}
+
+	if err := manager.Revoke(ctx, "unknown"); err != ErrApiKeyNotFound {
+		test.Errorf("expected not found error for unknown key, got: %v", err)
+	}
+
+	// unknown keys are rejected without being cached, expired lookups are swept
+	if _, err := manager.Authenticate(ctx, "tsk_unknown"); err != ErrInvalidApiKey {
+		test.Errorf("unknown api key should be rejected, got: %v", err)
+	}
+
+	if _, ok := manager.cache[HashApiKey("tsk_unknown")]; ok || len(manager.cache) != 1 {
+		test.Errorf("unexpected cached lookups: %d", len(manager.cache))
+	}
+
+	for hashedKey, entry := range manager.cache {
+		entry.expiresAt = time.Now()
+		manager.cache[hashedKey] = entry
+	}
+
+	manager.sweepCache()
+	if len(manager.cache) != 0 {
+		test.Errorf("expired lookups not swept: %d", len(manager.cache))
+	}
+
+	// expired keys are rejected
+	_, expired, err := manager.Create(ctx, "short-lived", nil, time.Nanosecond)
+	if err != nil {
+		test.Errorf("failed to create api key: %v", err)
+		return
+	}
+
+	time.Sleep(time.Millisecond)
+	if _, err := manager.Authenticate(ctx, expired); err != ErrInvalidApiKey {
+		test.Errorf("expired api key should be rejected, got: %v", err)
+	}
+}
+
+// sharedCacheStore is an api key store caching lookups across replicas
+type sharedCacheStore struct {
+	*memoryApiKeyStore
+}
+
+func (s *sharedCacheStore) CachesApiKeys() bool {
+	return true
+}
+
+// TestApiKeyManagerSharedCache unit tests that lookups of stores caching them across replicas are not cached again
+func TestApiKeyManagerSharedCache(test *testing.T) {
+	store := &sharedCacheStore{memoryApiKeyStore: &memoryApiKeyStore{keys: make(map[string]*models.ApiKey)}}
+	manager, err := NewApiKeyManager(&proto.ApiKeyConfig{CacheTTL: "1h"}, store, log.WithField("test", "apikeys"))
+	if err != nil {
+		test.Errorf("failed to create api key manager: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	key, plaintext, err := manager.Create(ctx, "batch-job", nil, 0)
+	if err != nil {
+		test.Errorf("failed to create api key: %v", err)
+		return
+	}
+
+	if _, err := manager.Authenticate(ctx, plaintext); err != nil {
+		test.Errorf("failed to authenticate api key: %v", err)
+		return
+	}
+
+	// a revocation made by another replica is seen right away
+	if err := store.RevokeApiKey(ctx, key.ID, time.Now()); err != nil {
+		test.Errorf("failed to revoke api key: %v", err)
+		return
+	}
+
+	if _, err := manager.Authenticate(ctx, plaintext); err != ErrInvalidApiKey || len(manager.cache) != 0 {
+		test.Errorf("revoked api key should be rejected, got: %v (%d cached)", err, len(manager.cache))
+	}
+}
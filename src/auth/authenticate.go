@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authorizationHeader carries credentials for REST requests (and RPC metadata, lower-cased)
+const authorizationHeader = "Authorization"
+
+// GinMiddleware authenticates REST requests presenting an api key and attaches the principal to the request context
+// requests without an api key are passed through (they are anonymous unless authenticated otherwise)
+func (m *ApiKeyManager) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := ParseApiKeyHeader(c.GetHeader(authorizationHeader))
+		if !ok {
+			c.Next()
+			return
+		}
+
+		principal, err := m.Authenticate(c.Request.Context(), key)
+		if err != nil {
+			m.logger.Warnf("api key authentication failed: %v", err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": ErrInvalidApiKey.Error()})
+			return
+		}
+
+		c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor authenticates unary RPCs presenting an api key in the "authorization" metadata
+func (m *ApiKeyManager) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := m.authenticateRPC(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming RPCs presenting an api key in the "authorization" metadata
+func (m *ApiKeyManager) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		ctx, err := m.authenticateRPC(ss.Context())
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticateRPC returns a context carrying the principal if the RPC presents a valid api key
+func (m *ApiKeyManager) authenticateRPC(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, header := range md.Get(authorizationHeader) {
+		key, ok := ParseApiKeyHeader(header)
+		if !ok {
+			continue
+		}
+
+		principal, err := m.Authenticate(ctx, key)
+		if err != nil {
+			m.logger.Warnf("api key authentication failed: %v", err)
+			return nil, status.Error(codes.Unauthenticated, ErrInvalidApiKey.Error())
+		}
+
+		return WithPrincipal(ctx, principal), nil
+	}
+
+	return ctx, nil
+}
+
+// contextServerStream overrides the context of a server stream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the overridden context
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
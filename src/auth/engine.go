@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// enforcement modes supported by the policy engine
+const (
+	// ModeEnforce rejects requests that violate the policy
+	ModeEnforce = "enforce"
+
+	// ModeAudit only logs policy violations and lets requests through (dry-run)
+	ModeAudit = "audit"
+)
+
+// defaultReloadInterval is used when the config does not specify a reload interval
+const defaultReloadInterval = 30 * time.Second
+
+// PolicyEngine evaluates requests against the authorization policy
+// the policy file is watched and hot reloaded when it changes on disk
+type PolicyEngine struct {
+	// path to the policy file
+	policyFile string
+
+	// enforcement mode (enforce or audit)
+	mode string
+
+	// deny requests not matching any rule
+	defaultDeny bool
+
+	// how often the policy file is checked for modifications
+	reloadInterval time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// lock guards the policy and its modification time
+	lock    sync.RWMutex
+	policy  *Policy
+	modTime time.Time
+
+	// stopCh signals the reload routine to exit
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewPolicyEngine creates a policy engine from the authorization config and loads the policy file
+func NewPolicyEngine(config *proto.AuthorizationConfig, logger *log.Entry) (*PolicyEngine, error) {
+	if config.Mode != ModeEnforce && config.Mode != ModeAudit {
+		return nil, fmt.Errorf("unsupported authorization mode %q", config.Mode)
+	}
+
+	if config.PolicyFile == "" {
+		return nil, fmt.Errorf("authorization policy file not specified")
+	}
+
+	reloadInterval, err := util.ParseDuration(config.ReloadInterval, defaultReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := &PolicyEngine{
+		policyFile:     config.PolicyFile,
+		mode:           config.Mode,
+		defaultDeny:    config.DefaultDeny,
+		reloadInterval: reloadInterval,
+		logger:         logger,
+		stopCh:         make(chan struct{}),
+	}
+
+	if err := engine.Reload(); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+// Start runs a background routine that reloads the policy whenever the file changes
+func (e *PolicyEngine) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(e.reloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.stopCh:
+				return
+			case <-ticker.C:
+				if !e.policyChanged() {
+					continue
+				}
+
+				// keep serving the previous policy if the new one is invalid
+				if err := e.Reload(); err != nil {
+					e.logger.Errorf("failed to reload authorization policy, keeping previous policy: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop terminates the reload routine
+func (e *PolicyEngine) Stop() {
+	close(e.stopCh)
+	e.wg.Wait()
+}
+
+// Reload reads the policy file and atomically swaps in the new policy
+func (e *PolicyEngine) Reload() error {
+	info, err := os.Stat(e.policyFile)
+	if err != nil {
+		return err
+	}
+
+	policy, err := LoadPolicy(e.policyFile)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	e.policy = policy
+	e.modTime = info.ModTime()
+	e.lock.Unlock()
+
+	e.logger.Infof("authorization policy loaded from %s (%d rules, mode: %s)",
+		e.policyFile, len(policy.Rules), e.mode)
+	return nil
+}
+
+// AuthorizeRoute evaluates a REST request and returns true if it should be served
+// violations are logged; in audit mode they are allowed through
+func (e *PolicyEngine) AuthorizeRoute(principal *Principal, method, route string) bool {
+	decision := e.currentPolicy().EvaluateRoute(principal, method, route, e.defaultDeny)
+	return e.enforce(decision, principal, method+" "+route)
+}
+
+// AuthorizeRPC evaluates an RPC request and returns true if it should be served
+// violations are logged; in audit mode they are allowed through
+func (e *PolicyEngine) AuthorizeRPC(principal *Principal, fullMethod string) bool {
+	decision := e.currentPolicy().EvaluateRPC(principal, fullMethod, e.defaultDeny)
+	return e.enforce(decision, principal, fullMethod)
+}
+
+// enforce applies the engine's mode to a policy decision
+func (e *PolicyEngine) enforce(decision Decision, principal *Principal, target string) bool {
+	if decision.Allowed {
+		return true
+	}
+
+	subject := AnonymousRole
+	if principal.IsAuthenticated() {
+		subject = principal.Subject
+	}
+
+	fields := log.Fields{
+		"subject": subject,
+		"target":  target,
+		"rule":    decision.Rule,
+		"reason":  decision.Reason,
+		"mode":    e.mode,
+	}
+
+	if e.mode == ModeAudit {
+		e.logger.WithFields(fields).Warn("authorization policy violation (audit only)")
+		return true
+	}
+
+	e.logger.WithFields(fields).Warn("authorization policy violation, request denied")
+	return false
+}
+
+// currentPolicy safely fetches the active policy
+func (e *PolicyEngine) currentPolicy() *Policy {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.policy
+}
+
+// policyChanged checks if the policy file has been modified since it was last loaded
+func (e *PolicyEngine) policyChanged() bool {
+	info, err := os.Stat(e.policyFile)
+	if err != nil {
+		e.logger.Errorf("failed to stat authorization policy file: %v", err)
+		return false
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return !info.ModTime().Equal(e.modTime)
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GinMiddleware enforces the authorization policy on REST requests
+// requests for unregistered routes are passed through so Gin can return a 404
+func (e *PolicyEngine) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			c.Next()
+			return
+		}
+
+		principal := PrincipalFromContext(c.Request.Context())
+		if !e.AuthorizeRoute(principal, c.Request.Method, route) {
+			statusCode := http.StatusForbidden
+			if !principal.IsAuthenticated() {
+				statusCode = http.StatusUnauthorized
+			}
+
+			c.AbortWithStatusJSON(statusCode, gin.H{"error": http.StatusText(statusCode)})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor enforces the authorization policy on unary RPCs
+func (e *PolicyEngine) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if err := e.authorizeRPC(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor enforces the authorization policy on streaming RPCs
+func (e *PolicyEngine) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if err := e.authorizeRPC(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// authorizeRPC maps a policy decision to a gRPC status error
+func (e *PolicyEngine) authorizeRPC(ctx context.Context, fullMethod string) error {
+	principal := PrincipalFromContext(ctx)
+	if e.AuthorizeRPC(principal, fullMethod) {
+		return nil
+	}
+
+	if !principal.IsAuthenticated() {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	return status.Error(codes.PermissionDenied, "permission denied")
+}
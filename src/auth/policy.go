@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"test_service/util"
+)
+
+// Policy is the declarative authorization policy loaded from the policy file
+// rules are evaluated in order and the first rule matching the request decides access
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule grants access to a set of routes and RPC methods to callers holding
+// any of the listed roles or scopes
+type Rule struct {
+	// Name of the rule (used when logging decisions)
+	Name string `yaml:"name"`
+
+	// Routes are Gin route patterns in the form "<METHOD> <path>", e.g. "GET /v1/ping"
+	// method may be "*", path may use path.Match globs or end in "/*" to match a subtree
+	Routes []string `yaml:"routes"`
+
+	// Rpcs are gRPC full method names, e.g. "/test_service.TestServiceRPC/Ping"
+	// path.Match globs are supported, e.g. "/test_service.TestServiceRPC/*"
+	Rpcs []string `yaml:"rpcs"`
+
+	// Roles allowed by the rule ("anonymous" allows every caller)
+	Roles []string `yaml:"roles"`
+
+	// Scopes allowed by the rule
+	Scopes []string `yaml:"scopes"`
+}
+
+// Decision is the outcome of evaluating the policy for a request
+type Decision struct {
+	// Allowed is true if the caller may proceed
+	Allowed bool
+
+	// Rule that matched the request (empty if none matched)
+	Rule string
+
+	// Reason explains the decision, mainly for logging
+	Reason string
+}
+
+// LoadPolicy reads and validates a policy file
+func LoadPolicy(policyFile string) (*Policy, error) {
+	data, err := ioutil.ReadFile(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy file %s: %v", policyFile, err)
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", policyFile, err)
+	}
+
+	return &policy, nil
+}
+
+// validate ensures all patterns in the policy are well formed
+func (p *Policy) validate() error {
+	for i, rule := range p.Rules {
+		if len(rule.Roles) == 0 && len(rule.Scopes) == 0 {
+			return fmt.Errorf("rule %d (%s) grants no roles or scopes", i, rule.Name)
+		}
+
+		for _, route := range rule.Routes {
+			if _, _, err := util.SplitRoute(route); err != nil {
+				return fmt.Errorf("rule %d (%s): %v", i, rule.Name, err)
+			}
+		}
+
+		for _, rpc := range rule.Rpcs {
+			if _, err := path.Match(rpc, ""); err != nil {
+				return fmt.Errorf("rule %d (%s): bad rpc pattern %q", i, rule.Name, rpc)
+			}
+		}
+	}
+
+	return nil
+}
+
+// EvaluateRoute decides whether the principal may call the Gin route (method + registered route pattern)
+func (p *Policy) EvaluateRoute(principal *Principal, method, route string, defaultDeny bool) Decision {
+	for _, rule := range p.Rules {
+		for _, r := range rule.Routes {
+			if util.MatchRoute(r, method, route) {
+				return rule.evaluate(principal)
+			}
+		}
+	}
+
+	return defaultDecision(defaultDeny)
+}
+
+// EvaluateRPC decides whether the principal may call the gRPC method
+func (p *Policy) EvaluateRPC(principal *Principal, fullMethod string, defaultDeny bool) Decision {
+	for _, rule := range p.Rules {
+		for _, pattern := range rule.Rpcs {
+			if util.MatchPattern(pattern, fullMethod) {
+				return rule.evaluate(principal)
+			}
+		}
+	}
+
+	return defaultDecision(defaultDeny)
+}
+
+// evaluate checks the principal against the roles and scopes of a matching rule
+func (r *Rule) evaluate(principal *Principal) Decision {
+	for _, role := range r.Roles {
+		if principal.HasRole(role) {
+			return Decision{Allowed: true, Rule: r.Name, Reason: "role " + role}
+		}
+	}
+
+	for _, scope := range r.Scopes {
+		if principal.HasScope(scope) {
+			return Decision{Allowed: true, Rule: r.Name, Reason: "scope " + scope}
+		}
+	}
+
+	return Decision{Allowed: false, Rule: r.Name, Reason: "missing required role or scope"}
+}
+
+// defaultDecision is applied when no rule matches the request
+func defaultDecision(defaultDeny bool) Decision {
+	if defaultDeny {
+		return Decision{Allowed: false, Reason: "no matching rule (deny by default)"}
+	}
+
+	return Decision{Allowed: true, Reason: "no matching rule (allow by default)"}
+}
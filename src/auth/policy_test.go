@@ -0,0 +1,142 @@
+// Contains authorization policy unit testcases
+package auth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// testPolicy used by the testcases below
+const testPolicy = `
+rules:
+  - name: "public"
+    routes: ["GET /v1/ping"]
+    rpcs: ["/test_service.TestServiceRPC/Ping"]
+    roles: ["anonymous"]
+  - name: "admin"
+    routes: ["* /v1/admin/*"]
+    rpcs: ["/test_service.TestServiceRPC/*"]
+    roles: ["admin"]
+    scopes: ["admin"]
+`
+
+// TestPolicyEngine unit tests policy evaluation for routes and RPCs in enforce and audit modes
+func TestPolicyEngine(test *testing.T) {
+	testObj, err := util.TestInit("test-policy-engine")
+	if err != nil {
+		test.Errorf("failed to initialize test object: %v", err)
+		return
+	}
+
+	defer testObj.TestCleanup(test)
+
+	policyFile := filepath.Join(testObj.TestDir, "policy.yaml")
+	if err := ioutil.WriteFile(policyFile, []byte(testPolicy), 0644); err != nil {
+		test.Errorf("failed to write policy file: %v", err)
+		return
+	}
+
+	config := &proto.AuthorizationConfig{
+		PolicyFile:  policyFile,
+		Mode:        ModeEnforce,
+		DefaultDeny: true,
+	}
+
+	engine, err := NewPolicyEngine(config, log.WithField("test", "policy"))
+	if err != nil {
+		test.Errorf("failed to create policy engine: %v", err)
+		return
+	}
+
+	admin := &Principal{Subject: "ops", Roles: []string{"admin"}}
+	reader := &Principal{Subject: "batch", Scopes: []string{"read"}}
+
+	testcases := []struct {
+		principal *Principal
+		method    string
+		route     string
+		rpc       string
+		allowed   bool
+	}{
+		{principal: nil, method: "GET", route: "/v1/ping", allowed: true},
+		{principal: nil, method: "GET", route: "/v1/admin/apikeys", allowed: false},
+		{principal: reader, method: "POST", route: "/v1/admin/apikeys/:id", allowed: false},
+		{principal: admin, method: "POST", route: "/v1/admin/apikeys/:id", allowed: true},
+		{principal: admin, method: "GET", route: "/v1/unlisted", allowed: false},
+		{principal: nil, rpc: "/test_service.TestServiceRPC/Ping", allowed: true},
+		{principal: reader, rpc: "/test_service.TestServiceRPC/Other", allowed: false},
+		{principal: admin, rpc: "/test_service.TestServiceRPC/Other", allowed: true},
+	}
+
+	for i, tc := range testcases {
+		var allowed bool
+		if tc.rpc != "" {
+			allowed = engine.AuthorizeRPC(tc.principal, tc.rpc)
+		} else {
+			allowed = engine.AuthorizeRoute(tc.principal, tc.method, tc.route)
+		}
+
+		if allowed != tc.allowed {
+			test.Errorf("testcase %d: expected allowed=%v, got %v", i, tc.allowed, allowed)
+		}
+	}
+
+	// audit mode should let violations through
+	config.Mode = ModeAudit
+	auditEngine, err := NewPolicyEngine(config, log.WithField("test", "policy"))
+	if err != nil {
+		test.Errorf("failed to create audit policy engine: %v", err)
+		return
+	}
+
+	if !auditEngine.AuthorizeRoute(nil, "GET", "/v1/admin/apikeys") {
+		test.Errorf("audit mode must not reject requests")
+	}
+
+	// an invalid policy must be rejected on reload and the previous policy kept
+	if err := ioutil.WriteFile(policyFile, []byte("rules: [{name: bad}]"), 0644); err != nil {
+		test.Errorf("failed to write policy file: %v", err)
+		return
+	}
+
+	if err := engine.Reload(); err == nil {
+		test.Errorf("expected reload of invalid policy to fail")
+	}
+
+	if !engine.AuthorizeRoute(nil, "GET", "/v1/ping") {
+		test.Errorf("previous policy should still be active after a failed reload")
+	}
+}
+
+// TestSamplePolicy unit tests the policy shipped in the config directory
+func TestSamplePolicy(test *testing.T) {
+	policy, err := LoadPolicy(filepath.Join("..", "..", "config", "policy.yaml"))
+	if err != nil {
+		test.Errorf("failed to load sample policy: %v", err)
+		return
+	}
+
+	admin := &Principal{Subject: "ops", Scopes: []string{AdminScope}}
+	testcases := []struct {
+		principal *Principal
+		rpc       string
+		allowed   bool
+	}{
+		{principal: nil, rpc: "/grpc.health.v1.Health/Check", allowed: true},
+		{principal: nil, rpc: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", allowed: false},
+		{principal: admin, rpc: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", allowed: true},
+		{principal: admin, rpc: "/test_service.TestServiceRPC/TriggerJob", allowed: true},
+	}
+
+	for _, tc := range testcases {
+		if allowed := policy.EvaluateRPC(tc.principal, tc.rpc, true).Allowed; allowed != tc.allowed {
+			test.Errorf("testcase %s: expected allowed=%v, got %v", tc.rpc, tc.allowed, allowed)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Auth package provides identity (principal) handling and role based
+// access control for the service's REST and RPC endpoints
+
+package auth
+
+import (
+	"context"
+	"errors"
+)
+
+// AnonymousRole is implicitly held by every caller, authenticated or not
+// policy rules can grant it to expose endpoints publicly
+const AnonymousRole = "anonymous"
+
+// AdminScope is required to manage credentials (create, rotate and revoke api keys)
+// whatever the mode of the authorization policy
+const AdminScope = "admin"
+
+// errors returned by RequireScope
+var (
+	// ErrUnauthenticated is returned when the caller has not been authenticated
+	ErrUnauthenticated = errors.New("authentication required")
+
+	// ErrPermissionDenied is returned when the caller has not been granted the required scope
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Principal represents the authenticated identity of a caller
+type Principal struct {
+	// Subject uniquely identifies the caller (user id, api key id, etc)
+	Subject string
+
+	// Roles held by the caller
+	Roles []string
+
+	// Scopes granted to the caller
+	Scopes []string
+}
+
+// principalKey is the context key under which the principal is stored
+type principalKey struct{}
+
+// WithPrincipal returns a copy of the context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext fetches the principal from the context
+// returns nil if the caller has not been authenticated
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// RequireScope checks that the context carries an authenticated principal granted the scope
+func RequireScope(ctx context.Context, scope string) error {
+	principal := PrincipalFromContext(ctx)
+	if !principal.IsAuthenticated() {
+		return ErrUnauthenticated
+	}
+
+	if !principal.HasScope(scope) {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// IsAuthenticated returns true if the principal represents an authenticated caller
+func (p *Principal) IsAuthenticated() bool {
+	return p != nil && p.Subject != ""
+}
+
+// HasRole checks whether the principal holds the role
+// every principal (including a nil one) holds the anonymous role
+func (p *Principal) HasRole(role string) bool {
+	if role == AnonymousRole {
+		return true
+	}
+
+	if p == nil {
+		return false
+	}
+
+	return contains(p.Roles, role)
+}
+
+// HasScope checks whether the principal has been granted the scope
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+
+	return contains(p.Scopes, scope)
+}
+
+// contains checks if a string slice holds the requested value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,322 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
+
+	"test_service/kvstore"
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultMaxEntries  = 10000
+	defaultTTL         = 5 * time.Minute
+	defaultLocalTTL    = 30 * time.Second
+	defaultNegativeTTL = 30 * time.Second
+
+	// sharedKeyPrefix namespaces cached entities in the kv store
+	sharedKeyPrefix = "cache/"
+
+	// invalidationTTL keeps invalidated entities out of the shared tier, outlasting the loads that may have read
+	// them before the invalidation (loads are bounded by the datastore timeout)
+	invalidationTTL = 10 * time.Second
+)
+
+// errors returned by loaders and by Get
+var (
+	// ErrNotFound is returned by loaders for missing entities (which are cached too) and by Get for cached misses
+	ErrNotFound = errors.New("entity not found")
+
+	// ErrNotFoundUncached is returned by loaders for missing entities whose miss is not cached, for lookups by keys
+	// callers choose freely (which would fill the cache with misses). Get returns ErrNotFound for them
+	ErrNotFoundUncached = errors.New("entity not found (uncached)")
+)
+
+// invalidated marks an invalidated entity in the shared tier, loads do not fill the tier while it is set
+var invalidated = []byte{0}
+
+// metrics exported by the cache, labelled by entity
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Number of cache lookups by result (local_hit, shared_hit, miss)",
+	}, []string{"entity", "result"})
+
+	loadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_loads_total",
+		Help: "Number of entities loaded on cache misses by result (ok, not_found, error)",
+	}, []string{"entity", "result"})
+
+	evictionsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Number of entities evicted from the in process cache to make room",
+	})
+)
+
+// ttls of the entities of a kind
+type ttls struct {
+	ttl         time.Duration
+	localTTL    time.Duration
+	negativeTTL time.Duration
+}
+
+// Cache is a two tier read-through cache: an in process lru in front of the shared kv store (if configured)
+// misses are loaded once per key even when requested concurrently, and missing entities are cached too.
+// invalidations delete entities from both tiers, other replicas drop their in process copies when notified
+// by the kv store (entities stay in process for at most localTTL in case notifications are missed).
+// the shared tier is only filled if the entity is absent, and invalidations mark the entity for invalidationTTL,
+// so a replica that loaded an entity before another replica invalidated it cannot cache the stale copy
+type Cache struct {
+	// local tier and the shared tier (nil without a kv store)
+	local  *lru
+	shared kvstore.Store
+
+	// ttls of all entities and their overrides by entity
+	defaults ttls
+	entities map[string]ttls
+
+	// group de-duplicates concurrent loads of a key
+	group singleflight.Group
+
+	// invalidations counts invalidations, loads racing with one do not cache their (possibly stale) result
+	invalidations uint64
+
+	// logger object
+	logger *log.Entry
+
+	// cancel stops watching the shared tier
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCache creates a cache, shared may be nil to only cache in process
+func NewCache(config *proto.CacheConfig, shared kvstore.Store, logger *log.Entry) (*Cache, error) {
+	defaults, err := parseTTLs(config.Ttl, config.LocalTTL, config.NegativeTTL,
+		ttls{ttl: defaultTTL, localTTL: defaultLocalTTL, negativeTTL: defaultNegativeTTL})
+	if err != nil {
+		return nil, err
+	}
+
+	entities := make(map[string]ttls)
+	for _, entity := range config.Entities {
+		entities[entity.Name], err = parseTTLs(entity.Ttl, entity.LocalTTL, entity.NegativeTTL, defaults)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxEntries := int(config.MaxEntries)
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	return &Cache{
+		local:    newLRU(maxEntries, config.MaxBytes, evictionsTotal.Inc),
+		shared:   shared,
+		defaults: defaults,
+		entities: entities,
+		logger:   logger,
+	}, nil
+}
+
+// Shared reports whether entities are cached in a tier shared by the replicas (invalidations reach them all)
+func (c *Cache) Shared() bool {
+	return c.shared != nil
+}
+
+// Start watches the shared tier, dropping in process copies of entities changed or invalidated by other replicas
+func (c *Cache) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	if c.shared == nil {
+		return nil
+	}
+
+	events, err := c.shared.Watch(ctx, sharedKeyPrefix)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for event := range events {
+			c.local.delete(strings.TrimPrefix(event.Key, sharedKeyPrefix))
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops watching the shared tier
+func (c *Cache) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.wg.Wait()
+}
+
+// Get decodes the cached entity into dest (a pointer), loading it on a miss
+// load returns the entity (of the type dest points to) or ErrNotFound if it does not exist
+// concurrent misses of a key share a single load, which runs with the context of the first caller
+func (c *Cache) Get(ctx context.Context, entity, key string, dest interface{},
+	load func(ctx context.Context) (interface{}, error)) error {
+	cacheKey := entity + "/" + key
+	if value, ok := c.local.get(cacheKey, time.Now()); ok {
+		requestsTotal.WithLabelValues(entity, "local_hit").Inc()
+		return decode(value, dest)
+	}
+
+	value, err, _ := c.group.Do(cacheKey, func() (interface{}, error) {
+		return c.fetch(ctx, entity, cacheKey, load)
+	})
+	if err != nil {
+		return err
+	}
+
+	return decode(value.([]byte), dest)
+}
+
+// Invalidate deletes entities from the cache of every replica, to be called when they are written
+func (c *Cache) Invalidate(ctx context.Context, entity string, keys ...string) {
+	atomic.AddUint64(&c.invalidations, 1)
+	for _, key := range keys {
+		cacheKey := entity + "/" + key
+		c.local.delete(cacheKey)
+		if c.shared == nil {
+			continue
+		}
+
+		if err := c.shared.Set(ctx, sharedKeyPrefix+cacheKey, invalidated, invalidationTTL); err != nil {
+			c.logger.Warnf("failed to invalidate cached %s: %v", cacheKey, err)
+		}
+	}
+}
+
+// fetch returns the encoded entity from the shared tier or the loader, caching it in both tiers
+// an empty value represents a missing entity
+func (c *Cache) fetch(ctx context.Context, entity, cacheKey string,
+	load func(ctx context.Context) (interface{}, error)) ([]byte, error) {
+	entityTTLs := c.ttls(entity)
+	if c.shared != nil {
+		value, ok, err := c.shared.Get(ctx, sharedKeyPrefix+cacheKey)
+		if err != nil {
+			// the cache is an optimization, requests are served from the repository while the kv store is down
+			c.logger.Warnf("failed to read cached %s: %v", cacheKey, err)
+		} else if ok && !bytes.Equal(value, invalidated) {
+			requestsTotal.WithLabelValues(entity, "shared_hit").Inc()
+			localTTL := entityTTLs.localTTL
+			if len(value) == 0 && entityTTLs.negativeTTL < localTTL {
+				localTTL = entityTTLs.negativeTTL
+			}
+
+			c.local.set(cacheKey, value, time.Now().Add(localTTL))
+			return value, nil
+		}
+	}
+
+	requestsTotal.WithLabelValues(entity, "miss").Inc()
+	invalidations := atomic.LoadUint64(&c.invalidations)
+	loaded, err := load(ctx)
+
+	var value []byte
+	ttl := entityTTLs.ttl
+	switch {
+	case errors.Is(err, ErrNotFoundUncached):
+		loadsTotal.WithLabelValues(entity, "not_found").Inc()
+		return nil, ErrNotFound
+	case errors.Is(err, ErrNotFound):
+		loadsTotal.WithLabelValues(entity, "not_found").Inc()
+		value = []byte{}
+		ttl = entityTTLs.negativeTTL
+	case err != nil:
+		loadsTotal.WithLabelValues(entity, "error").Inc()
+		return nil, err
+	default:
+		loadsTotal.WithLabelValues(entity, "ok").Inc()
+		if value, err = encode(loaded); err != nil {
+			return nil, err
+		}
+	}
+
+	if atomic.LoadUint64(&c.invalidations) != invalidations {
+		return value, nil
+	}
+
+	// the entity is not cached if it was invalidated (or cached by another replica) since it was looked up
+	if c.shared != nil {
+		filled, err := c.shared.CompareAndSwap(ctx, sharedKeyPrefix+cacheKey, nil, value, ttl)
+		if err != nil {
+			c.logger.Warnf("failed to cache %s: %v", cacheKey, err)
+		} else if !filled {
+			return value, nil
+		}
+	}
+
+	localTTL := entityTTLs.localTTL
+	if ttl < localTTL {
+		localTTL = ttl
+	}
+
+	c.local.set(cacheKey, value, time.Now().Add(localTTL))
+	return value, nil
+}
+
+// ttls returns the ttls of an entity
+func (c *Cache) ttls(entity string) ttls {
+	if entityTTLs, ok := c.entities[entity]; ok {
+		return entityTTLs
+	}
+
+	return c.defaults
+}
+
+// parseTTLs parses configured ttls, empty values keep the defaults
+func parseTTLs(ttl, localTTL, negativeTTL string, defaults ttls) (ttls, error) {
+	var parsed ttls
+	var err error
+	if parsed.ttl, err = util.ParseDuration(ttl, defaults.ttl); err != nil {
+		return parsed, err
+	}
+
+	if parsed.localTTL, err = util.ParseDuration(localTTL, defaults.localTTL); err != nil {
+		return parsed, err
+	}
+
+	parsed.negativeTTL, err = util.ParseDuration(negativeTTL, defaults.negativeTTL)
+	return parsed, err
+}
+
+// encode serializes an entity (gob encodes all exported fields, unlike json which honors the api's field tags)
+func encode(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// decode deserializes an entity into dest, returning ErrNotFound for cached misses
+func decode(value []byte, dest interface{}) error {
+	if len(value) == 0 {
+		return ErrNotFound
+	}
+
+	return gob.NewDecoder(bytes.NewReader(value)).Decode(dest)
+}
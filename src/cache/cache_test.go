@@ -0,0 +1,226 @@
+// Contains cache unit testcases
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/kvstore"
+	proto "test_service/protobuf/generated"
+)
+
+// entity cached by the tests
+type entity struct {
+	Name string
+}
+
+// TestLRUBounds unit tests the eviction and expiry of the in process tier
+func TestLRUBounds(test *testing.T) {
+	evicted := 0
+	l := newLRU(2, 0, func() { evicted++ })
+	now := time.Now()
+	l.set("a", []byte("1"), now.Add(time.Minute))
+	l.set("b", []byte("2"), now.Add(time.Minute))
+	l.get("a", now)
+	l.set("c", []byte("3"), now.Add(time.Minute))
+
+	// the least recently used entry is evicted
+	if _, ok := l.get("b", now); ok || evicted != 1 || l.len() != 2 {
+		test.Errorf("least recently used entry not evicted (%d evicted, %d entries)", evicted, l.len())
+		return
+	}
+
+	if _, ok := l.get("a", now.Add(time.Hour)); ok {
+		test.Errorf("expired entry returned")
+		return
+	}
+
+	// entries are also bounded by their size
+	l = newLRU(10, 7, nil)
+	l.set("a", []byte("123"), now.Add(time.Minute))
+	l.set("b", []byte("123"), now.Add(time.Minute))
+	if _, ok := l.get("a", now); ok || l.len() != 1 {
+		test.Errorf("entries exceed the size bound (%d entries)", l.len())
+		return
+	}
+}
+
+// TestCache unit tests read-through loads, negative caching and invalidation across replicas
+func TestCache(test *testing.T) {
+	ctx := context.Background()
+	shared := kvstore.NewMemoryStore()
+	defer shared.Close()
+
+	config := &proto.CacheConfig{Enabled: true, Ttl: "1m", LocalTTL: "1m", NegativeTTL: "1m"}
+	logger := log.WithField("test", "cache")
+	first, err := NewCache(config, shared, logger)
+	if err != nil {
+		test.Errorf("failed to create cache: %v", err)
+		return
+	}
+
+	second, err := NewCache(config, shared, logger)
+	if err != nil {
+		test.Errorf("failed to create cache: %v", err)
+		return
+	}
+
+	for _, c := range []*Cache{first, second} {
+		if err := c.Start(); err != nil {
+			test.Errorf("failed to start cache: %v", err)
+			return
+		}
+		defer c.Stop()
+	}
+
+	var loads int32
+	value := "one"
+	load := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(10 * time.Millisecond)
+		if value == "" {
+			return nil, ErrNotFound
+		}
+
+		return &entity{Name: value}, nil
+	}
+
+	// concurrent misses share a single load
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var result entity
+			if err := first.Get(ctx, "entity", "1", &result, load); err != nil || result.Name != "one" {
+				test.Errorf("unexpected entity %v: %v", result, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the other replica is served by the shared tier
+	var result entity
+	if err := second.Get(ctx, "entity", "1", &result, load); err != nil || result.Name != "one" || loads != 1 {
+		test.Errorf("unexpected entity %v (%d loads): %v", result, loads, err)
+		return
+	}
+
+	// invalidations drop the entity from every replica
+	value = "two"
+	first.Invalidate(ctx, "entity", "1")
+	time.Sleep(50 * time.Millisecond)
+	if err := second.Get(ctx, "entity", "1", &result, load); err != nil || result.Name != "two" || loads != 2 {
+		test.Errorf("unexpected entity %v (%d loads): %v", result, loads, err)
+		return
+	}
+
+	// missing entities are cached too
+	value = ""
+	for i := 0; i < 2; i++ {
+		if err := first.Get(ctx, "entity", "2", &result, load); !errors.Is(err, ErrNotFound) || loads != 3 {
+			test.Errorf("missing entity not cached (%d loads): %v", loads, err)
+			return
+		}
+	}
+
+	// load failures are not cached
+	failure := errors.New("failure")
+	for i := 0; i < 2; i++ {
+		err := first.Get(ctx, "entity", "3", &result, func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, failure
+		})
+		if !errors.Is(err, failure) {
+			test.Errorf("load failure not returned: %v", err)
+			return
+		}
+	}
+
+	if loads != 5 {
+		test.Errorf("load failure cached (%d loads)", loads)
+		return
+	}
+}
+
+// TestCacheFills unit tests that misses of uncached lookups and loads racing with invalidations are not cached
+func TestCacheFills(test *testing.T) {
+	ctx := context.Background()
+	shared := kvstore.NewMemoryStore()
+	defer shared.Close()
+
+	config := &proto.CacheConfig{Enabled: true, Ttl: "1m", LocalTTL: "1m", NegativeTTL: "1m"}
+	logger := log.WithField("test", "cache")
+	local, err := NewCache(config, nil, logger)
+	if err != nil || local.Shared() {
+		test.Errorf("unexpected in process cache (shared %v): %v", local != nil && local.Shared(), err)
+		return
+	}
+
+	first, err := NewCache(config, shared, logger)
+	if err != nil || !first.Shared() {
+		test.Errorf("failed to create shared cache: %v", err)
+		return
+	}
+
+	second, err := NewCache(config, shared, logger)
+	if err != nil {
+		test.Errorf("failed to create shared cache: %v", err)
+		return
+	}
+
+	// misses of uncached lookups are loaded every time and stored in neither tier
+	var loads int32
+	var result entity
+	for i := 0; i < 2; i++ {
+		err := first.Get(ctx, "entity", "1", &result, func(ctx context.Context) (interface{}, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, ErrNotFoundUncached
+		})
+		if !errors.Is(err, ErrNotFound) {
+			test.Errorf("unexpected error of uncached miss: %v", err)
+			return
+		}
+	}
+
+	if stored, _ := shared.Scan(ctx, sharedKeyPrefix); loads != 2 || len(stored) != 0 {
+		test.Errorf("uncached miss cached (%d loads, stored %v)", loads, stored)
+		return
+	}
+
+	// a load that read the entity before another replica invalidated it does not cache the stale copy
+	loading, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		var stale entity
+		done <- first.Get(ctx, "entity", "2", &stale, func(ctx context.Context) (interface{}, error) {
+			close(loading)
+			<-release
+			return &entity{Name: "stale"}, nil
+		})
+	}()
+
+	<-loading
+	second.Invalidate(ctx, "entity", "2")
+	close(release)
+	if err := <-done; err != nil {
+		test.Errorf("failed to load entity: %v", err)
+		return
+	}
+
+	for _, c := range []*Cache{first, second} {
+		err := c.Get(ctx, "entity", "2", &result, func(ctx context.Context) (interface{}, error) {
+			return &entity{Name: "fresh"}, nil
+		})
+		if err != nil || result.Name != "fresh" {
+			test.Errorf("stale entity cached %v: %v", result, err)
+			return
+		}
+	}
+}
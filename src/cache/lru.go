@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruEntry is an encoded value held by the lru (an empty value caches a missing entity)
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// size approximates the memory held by the entry
+func (e *lruEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// lru is a least recently used cache bounded by its number of entries and their size
+type lru struct {
+	lock sync.Mutex
+
+	// bounds of the cache (0 maxBytes is unbounded)
+	maxEntries int
+	maxBytes   int64
+
+	// entries ordered from most to least recently used, and indexed by key
+	order   *list.List
+	entries map[string]*list.Element
+	bytes   int64
+
+	// onEvict is called (with the lru locked) for every entry evicted to make room
+	onEvict func()
+}
+
+// newLRU creates an empty lru
+func newLRU(maxEntries int, maxBytes int64, onEvict func()) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		onEvict:    onEvict,
+	}
+}
+
+// get returns the unexpired value of a key
+func (l *lru) get(key string, now time.Time) ([]byte, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	element, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if !now.Before(entry.expiresAt) {
+		l.remove(element)
+		return nil, false
+	}
+
+	l.order.MoveToFront(element)
+	return entry.value, true
+}
+
+// set stores a value until the expiry, evicting the least recently used entries to stay within bounds
+func (l *lru) set(key string, value []byte, expiresAt time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+
+	entry := &lruEntry{key: key, value: value, expiresAt: expiresAt}
+	if l.maxBytes > 0 && entry.size() > l.maxBytes {
+		return
+	}
+
+	l.entries[key] = l.order.PushFront(entry)
+	l.bytes += entry.size()
+	for l.order.Len() > l.maxEntries || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.remove(l.order.Back())
+		if l.onEvict != nil {
+			l.onEvict()
+		}
+	}
+}
+
+// delete removes a key
+func (l *lru) delete(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if element, ok := l.entries[key]; ok {
+		l.remove(element)
+	}
+}
+
+// len returns the number of entries
+func (l *lru) len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.order.Len()
+}
+
+// remove drops an entry, the lru must be locked
+func (l *lru) remove(element *list.Element) {
+	entry := l.order.Remove(element).(*lruEntry)
+	delete(l.entries, entry.key)
+	l.bytes -= entry.size()
+}
@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"test_service/models"
+	"test_service/repository"
+)
+
+// ApiKeyEntity is the cache entity (for ttl overrides and metrics) of api keys
+const ApiKeyEntity = "apikey"
+
+// CachedStore is a repository.Store whose api key lookups are served by the cache
+// writes made through it invalidate the entities they change, reads in a transaction or forced to the
+// primary bypass the cache. last used times are not invalidated (they are informational and flushed often)
+type CachedStore struct {
+	repository.Store
+
+	// cache of the store's entities
+	cache *Cache
+}
+
+// NewCachedStore wraps the store with the cache
+func NewCachedStore(store repository.Store, cache *Cache) *CachedStore {
+	return &CachedStore{Store: store, cache: cache}
+}
+
+// CachesApiKeys reports whether api key lookups are cached (and invalidated) across replicas by the store
+func (s *CachedStore) CachesApiKeys() bool {
+	return s.cache.Shared()
+}
+
+// CreateApiKey persists a new api key, dropping cached misses of it
+func (s *CachedStore) CreateApiKey(ctx context.Context, key *models.ApiKey) error {
+	if err := s.Store.CreateApiKey(ctx, key); err != nil {
+		return err
+	}
+
+	s.cache.Invalidate(ctx, ApiKeyEntity, "id:"+key.ID, "hash:"+key.HashedKey)
+	return nil
+}
+
+// GetApiKey fetches an api key by its id
+func (s *CachedStore) GetApiKey(ctx context.Context, id string) (*models.ApiKey, error) {
+	if repository.RequiresPrimary(ctx) {
+		return s.Store.GetApiKey(ctx, id)
+	}
+
+	return s.getApiKey(ctx, "id:"+id, func(ctx context.Context) (*models.ApiKey, error) {
+		return s.Store.GetApiKey(ctx, id)
+	})
+}
+
+// GetApiKeyByHash fetches an api key by the hash of the key
+// misses are not cached, as callers choose the keys they present and would fill the cache with them
+func (s *CachedStore) GetApiKeyByHash(ctx context.Context, hashedKey string) (*models.ApiKey, error) {
+	if repository.RequiresPrimary(ctx) {
+		return s.Store.GetApiKeyByHash(ctx, hashedKey)
+	}
+
+	return s.getApiKey(ctx, "hash:"+hashedKey, func(ctx context.Context) (*models.ApiKey, error) {
+		apiKey, err := s.Store.GetApiKeyByHash(ctx, hashedKey)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFoundUncached
+		}
+
+		return apiKey, err
+	})
+}
+
+// UpdateApiKeyHash replaces the hash (and prefix) of an api key, invalidating its old and new hash
+func (s *CachedStore) UpdateApiKeyHash(ctx context.Context, id, prefix, hashedKey string) error {
+	return s.update(ctx, id, func() error {
+		return s.Store.UpdateApiKeyHash(ctx, id, prefix, hashedKey)
+	}, "hash:"+hashedKey)
+}
+
+// RevokeApiKey marks an api key as revoked
+func (s *CachedStore) RevokeApiKey(ctx context.Context, id string, revokedAt time.Time) error {
+	return s.update(ctx, id, func() error {
+		return s.Store.RevokeApiKey(ctx, id, revokedAt)
+	})
+}
+
+// update applies an update to an api key and invalidates the keys it is cached under
+// the current hash is read from the primary first, as the update may replace it
+func (s *CachedStore) update(ctx context.Context, id string, apply func() error, keys ...string) error {
+	keys = append(keys, "id:"+id)
+	if current, err := s.Store.GetApiKey(repository.WithPrimary(ctx), id); err == nil {
+		keys = append(keys, "hash:"+current.HashedKey)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err := apply(); err != nil {
+		return err
+	}
+
+	s.cache.Invalidate(ctx, ApiKeyEntity, keys...)
+	return nil
+}
+
+// getApiKey reads an api key through the cache, translating between the repository's and the cache's misses
+func (s *CachedStore) getApiKey(ctx context.Context, key string,
+	load func(ctx context.Context) (*models.ApiKey, error)) (*models.ApiKey, error) {
+	var apiKey models.ApiKey
+	err := s.cache.Get(ctx, ApiKeyEntity, key, &apiKey, func(ctx context.Context) (interface{}, error) {
+		loaded, err := load(ctx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+
+		return loaded, err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiKey, nil
+}
+
+// ensure the cached store implements the store
+var _ repository.Store = (*CachedStore)(nil)
@@ -0,0 +1,230 @@
+// Client package for the TestServiceRPC service
+// wraps the generated client with the defaults every caller needs: TLS, per call deadlines,
+// retries of idempotent methods, request id/trace/auth propagation, DNS round robin balancing and
+// an optional resilience policy (circuit breaker, bulkhead) of the service
+
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	proto "test_service/protobuf/generated"
+	"test_service/resilience"
+)
+
+// defaults used when the options do not specify them
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 3
+)
+
+// serviceName is the fully qualified name of the RPC service
+const serviceName = "test_service.TestServiceRPC"
+
+// idempotentMethods are safe to retry since they do not change server state
+var idempotentMethods = []string{"Ping", "ListApiKeys", "ListJobs"}
+
+// Options configures a client
+type Options struct {
+	// Target is the address of the service. addresses without a scheme (host:port)
+	// are resolved through DNS and balanced across all resolved addresses
+	Target string
+
+	// TLS config used to secure the connection (plaintext if nil)
+	TLS *tls.Config
+
+	// Timeout applied to calls whose context has no deadline (defaults to 10s, negative disables it)
+	Timeout time.Duration
+
+	// MaxAttempts made for idempotent methods failing with Unavailable (defaults to 3, 1 disables retries)
+	MaxAttempts int
+
+	// ApiKey sent with every call, if set. keys are only sent over TLS connections unless
+	// AllowInsecureApiKey is set
+	ApiKey string
+
+	// AllowInsecureApiKey sends the api key over plaintext connections (e.g. within a private network)
+	AllowInsecureApiKey bool
+
+	// Policy guarding unary calls with its circuit breaker, bulkhead and timeout, if set
+	Policy *resilience.Policy
+
+	// DialOptions appended to the client's own options
+	DialOptions []grpc.DialOption
+}
+
+// Client is a TestServiceRPC client over a managed connection
+type Client struct {
+	proto.TestServiceRPCClient
+
+	// underlying connection
+	conn *grpc.ClientConn
+}
+
+// NewClient creates a client. the connection is established lazily on the first call
+func NewClient(options Options) (*Client, error) {
+	if options.Target == "" {
+		return nil, fmt.Errorf("client target not specified")
+	}
+
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+
+	maxAttempts := options.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	serviceConfig, err := serviceConfigJSON(maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	transportCredentials := insecure.NewCredentials()
+	if options.TLS != nil {
+		transportCredentials = credentials.NewTLS(options.TLS)
+	}
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithDefaultServiceConfig(serviceConfig),
+		grpc.WithChainUnaryInterceptor(timeoutInterceptor(timeout), propagationUnaryInterceptor()),
+		grpc.WithChainStreamInterceptor(propagationStreamInterceptor()),
+	}
+
+	if options.Policy != nil {
+		dialOptions = append(dialOptions, grpc.WithChainUnaryInterceptor(options.Policy.UnaryClientInterceptor()))
+	}
+
+	if options.ApiKey != "" {
+		if options.TLS == nil && !options.AllowInsecureApiKey {
+			return nil, fmt.Errorf("api keys are only sent over TLS, set AllowInsecureApiKey to send them in plaintext")
+		}
+
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(&apiKeyCredentials{
+			key:           options.ApiKey,
+			allowInsecure: options.AllowInsecureApiKey,
+		}))
+	}
+
+	conn, err := grpc.Dial(target(options.Target), append(dialOptions, options.DialOptions...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{TestServiceRPCClient: proto.NewTestServiceRPCClient(conn), conn: conn}, nil
+}
+
+// Conn returns the underlying connection (e.g. to create clients for other services on the same server)
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the underlying connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// LoadTLSConfig creates a TLS config trusting the CA file (system roots if empty)
+// and presenting the certificate/key pair as the client certificate if set (mutual TLS)
+func LoadTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		ca, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// target resolves addresses without a scheme through DNS so that all of a name's addresses are balanced
+func target(address string) string {
+	if strings.Contains(address, ":///") || strings.HasPrefix(address, "unix:") {
+		return address
+	}
+
+	return "dns:///" + address
+}
+
+// serviceConfigJSON builds the default service config: round robin balancing across resolved
+// addresses and a retry policy for idempotent methods
+func serviceConfigJSON(maxAttempts int) (string, error) {
+	type methodName struct {
+		Service string `json:"service"`
+		Method  string `json:"method"`
+	}
+
+	type retryPolicy struct {
+		MaxAttempts          int      `json:"maxAttempts"`
+		InitialBackoff       string   `json:"initialBackoff"`
+		MaxBackoff           string   `json:"maxBackoff"`
+		BackoffMultiplier    float64  `json:"backoffMultiplier"`
+		RetryableStatusCodes []string `json:"retryableStatusCodes"`
+	}
+
+	type methodConfig struct {
+		Name        []methodName `json:"name"`
+		RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+	}
+
+	config := struct {
+		LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig"`
+		MethodConfig        []methodConfig        `json:"methodConfig,omitempty"`
+	}{
+		LoadBalancingConfig: []map[string]struct{}{{"round_robin": {}}},
+	}
+
+	// grpc requires at least 2 attempts in a retry policy
+	if maxAttempts > 1 {
+		retried := methodConfig{
+			RetryPolicy: &retryPolicy{
+				MaxAttempts:          maxAttempts,
+				InitialBackoff:       "0.1s",
+				MaxBackoff:           "1s",
+				BackoffMultiplier:    2,
+				RetryableStatusCodes: []string{"UNAVAILABLE"},
+			},
+		}
+
+		for _, method := range idempotentMethods {
+			retried.Name = append(retried.Name, methodName{Service: serviceName, Method: method})
+		}
+
+		config.MethodConfig = append(config.MethodConfig, retried)
+	}
+
+	data, err := json.Marshal(config)
+	return string(data), err
+}
@@ -0,0 +1,163 @@
+// Contains rpc client unit testcases
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"test_service/propagation"
+	proto "test_service/protobuf/generated"
+)
+
+// pingServer records the metadata and deadline of the pings it receives
+type pingServer struct {
+	proto.UnimplementedTestServiceRPCServer
+
+	md          metadata.MD
+	hasDeadline bool
+}
+
+// Ping records the call and answers it
+func (s *pingServer) Ping(ctx context.Context, request *proto.PingRequest) (*proto.PingResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	_, s.hasDeadline = ctx.Deadline()
+	return &proto.PingResponse{Message: "pong"}, nil
+}
+
+// TestClient unit tests api key credentials, default deadlines and propagation of the client
+func TestClient(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Errorf("failed to listen: %v", err)
+		return
+	}
+
+	server := grpc.NewServer()
+	service := &pingServer{}
+	proto.RegisterTestServiceRPCServer(server, service)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	// api keys are not sent over plaintext connections unless explicitly allowed
+	if _, err := NewClient(Options{Target: listener.Addr().String(), ApiKey: "secret"}); err == nil {
+		test.Errorf("client sending an api key over plaintext created")
+		return
+	}
+
+	client, err := NewClient(Options{Target: listener.Addr().String(), ApiKey: "secret", AllowInsecureApiKey: true})
+	if err != nil {
+		test.Errorf("failed to create client: %v", err)
+		return
+	}
+	defer client.Close()
+
+	ctx := propagation.NewContext(context.Background(), propagation.Values{
+		propagation.RequestIDHeader:   "request-1",
+		propagation.TraceParentHeader: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+	if _, err := client.Ping(ctx, &proto.PingRequest{}); err != nil {
+		test.Errorf("failed to ping: %v", err)
+		return
+	}
+
+	if get(service.md, "authorization") != "ApiKey secret" || get(service.md, "x-request-id") != "request-1" ||
+		get(service.md, "traceparent") == "" || !service.hasDeadline {
+		test.Errorf("unexpected metadata %v (deadline %v)", service.md, service.hasDeadline)
+		return
+	}
+
+	// calls made outside of a request are assigned a request id
+	if _, err := client.Ping(context.Background(), &proto.PingRequest{}); err != nil {
+		test.Errorf("failed to ping: %v", err)
+		return
+	}
+
+	if requestID := get(service.md, "x-request-id"); requestID == "" || requestID == "request-1" {
+		test.Errorf("request id not generated: %q", requestID)
+		return
+	}
+
+	// keys require TLS unless plaintext was allowed
+	if !(&apiKeyCredentials{key: "secret"}).RequireTransportSecurity() ||
+		(&apiKeyCredentials{key: "secret", allowInsecure: true}).RequireTransportSecurity() {
+		test.Errorf("unexpected transport security requirement of api key credentials")
+	}
+}
+
+// TestServiceConfig unit tests retrying idempotent methods
+func TestServiceConfig(test *testing.T) {
+	config, err := serviceConfigJSON(3)
+	if err != nil {
+		test.Errorf("failed to build service config: %v", err)
+		return
+	}
+
+	var parsed struct {
+		MethodConfig []struct {
+			Name []struct {
+				Method string `json:"method"`
+			} `json:"name"`
+			RetryPolicy struct {
+				MaxAttempts int `json:"maxAttempts"`
+			} `json:"retryPolicy"`
+		} `json:"methodConfig"`
+	}
+	if err := json.Unmarshal([]byte(config), &parsed); err != nil || len(parsed.MethodConfig) != 1 ||
+		parsed.MethodConfig[0].RetryPolicy.MaxAttempts != 3 ||
+		len(parsed.MethodConfig[0].Name) != len(idempotentMethods) {
+		test.Errorf("unexpected service config %s: %v", config, err)
+		return
+	}
+
+	// every retried method exists in the service
+	methods := map[string]bool{}
+	for _, method := range proto.TestServiceRPC_ServiceDesc.Methods {
+		methods[method.MethodName] = true
+	}
+
+	for _, method := range idempotentMethods {
+		if !methods[method] {
+			test.Errorf("retried method %s not found in service %s", method, serviceName)
+			return
+		}
+	}
+
+	// a single attempt disables retries
+	if config, _ := serviceConfigJSON(1); strings.Contains(config, "methodConfig") {
+		test.Errorf("retries not disabled: %s", config)
+	}
+}
+
+// TestTarget unit tests resolving targets through DNS
+func TestTarget(test *testing.T) {
+	testcases := []struct {
+		address string
+		target  string
+	}{
+		{address: "test-service:8001", target: "dns:///test-service:8001"},
+		{address: "dns:///test-service:8001", target: "dns:///test-service:8001"},
+		{address: "passthrough:///10.0.0.1:8001", target: "passthrough:///10.0.0.1:8001"},
+		{address: "unix:/tmp/test.sock", target: "unix:/tmp/test.sock"},
+	}
+
+	for _, tc := range testcases {
+		if resolved := target(tc.address); resolved != tc.target {
+			test.Errorf("testcase %s: expected %s, got %s", tc.address, tc.target, resolved)
+		}
+	}
+}
+
+// get returns the first value of a metadata key (empty if missing)
+func get(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
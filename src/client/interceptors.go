@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"test_service/auth"
+	"test_service/propagation"
+)
+
+// timeoutInterceptor applies the default deadline to unary calls made without one
+// streams are long lived and are left to the caller's context
+func timeoutInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// propagationUnaryInterceptor forwards the request id and trace context of the request being served
+// calls made outside of a request are assigned a new request id
+func propagationUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// propagationStreamInterceptor forwards the request id and trace context on stream creation
+func propagationStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
+
+// outgoingContext adds the propagated values to the outgoing metadata
+func outgoingContext(ctx context.Context) context.Context {
+	if propagation.RequestID(ctx) == "" {
+		ctx = propagation.WithRequestID(ctx, propagation.NewRequestID())
+	}
+
+	return propagation.AppendToOutgoingContext(ctx)
+}
+
+// apiKeyCredentials sends an api key in the authorization metadata of each call
+type apiKeyCredentials struct {
+	// key sent with each call
+	key string
+
+	// allowInsecure sends the key over plaintext connections
+	allowInsecure bool
+}
+
+// GetRequestMetadata returns the authorization metadata
+func (c *apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": auth.ApiKeyScheme + " " + c.key}, nil
+}
+
+// RequireTransportSecurity requires TLS unless plaintext connections were explicitly allowed
+func (c *apiKeyCredentials) RequireTransportSecurity() bool {
+	return !c.allowInsecure
+}
+
+// ensure the credentials satisfy the interface
+var _ credentials.PerRPCCredentials = (*apiKeyCredentials)(nil)
@@ -0,0 +1,94 @@
+// Contains blueprint tool unit testcases
+package main
+
+import (
+	"testing"
+)
+
+// TestToSnake unit tests converting Go identifiers to snake case
+func TestToSnake(test *testing.T) {
+	testcases := []struct {
+		identifier string
+		snake      string
+	}{
+		{identifier: "GetOrder", snake: "get_order"},
+		{identifier: "Ping", snake: "ping"},
+		{identifier: "ListApiKeys", snake: "list_api_keys"},
+		{identifier: "GetHTTPStatus", snake: "get_http_status"},
+		{identifier: "HTTPServer", snake: "http_server"},
+		{identifier: "getOrder", snake: "get_order"},
+		{identifier: "Order2Items", snake: "order2_items"},
+	}
+
+	for _, tc := range testcases {
+		if snake := toSnake(tc.identifier); snake != tc.snake {
+			test.Errorf("testcase %s: expected %s, got %s", tc.identifier, tc.snake, snake)
+		}
+	}
+}
+
+// TestRemoveSections unit tests dropping top level sections from a yaml config
+func TestRemoveSections(test *testing.T) {
+	content := "# comment\nservice:\n  name: \"test\"\nqueue:\n  driver: \"nats\"\n  subjects:\n    - \"a\"\n" +
+		"kvstore:\n  driver: \"redis\"\n"
+
+	testcases := []struct {
+		name     string
+		sections map[string]bool
+		expected string
+	}{
+		{name: "none", sections: map[string]bool{}, expected: content},
+		{name: "nested lines", sections: map[string]bool{"queue": true},
+			expected: "# comment\nservice:\n  name: \"test\"\nkvstore:\n  driver: \"redis\"\n"},
+		{name: "last section", sections: map[string]bool{"kvstore": true},
+			expected: "# comment\nservice:\n  name: \"test\"\nqueue:\n  driver: \"nats\"\n  subjects:\n    - \"a\"\n"},
+		{name: "missing section", sections: map[string]bool{"outbox": true}, expected: content},
+	}
+
+	for _, tc := range testcases {
+		if removed := removeSections(content, tc.sections); removed != tc.expected {
+			test.Errorf("testcase %s: expected %q, got %q", tc.name, tc.expected, removed)
+		}
+	}
+}
+
+// TestSamplePath unit tests filling in the parameters of route paths
+func TestSamplePath(test *testing.T) {
+	testcases := []struct {
+		path   string
+		sample string
+	}{
+		{path: "/v1/orders", sample: "/v1/orders"},
+		{path: "/v1/orders/:id", sample: "/v1/orders/1"},
+		{path: "/v1/orders/:id/items/:item", sample: "/v1/orders/1/items/1"},
+		{path: "/v1/files/*path", sample: "/v1/files/1"},
+	}
+
+	for _, tc := range testcases {
+		if sample := samplePath(tc.path); sample != tc.sample {
+			test.Errorf("testcase %s: expected %s, got %s", tc.path, tc.sample, sample)
+		}
+	}
+}
+
+// TestReplacePort unit tests replacing port numbers appearing as whole numbers
+func TestReplacePort(test *testing.T) {
+	testcases := []struct {
+		content  string
+		from     string
+		to       string
+		replaced string
+	}{
+		{content: `apiPort: "8000"`, from: "8000", to: "9000", replaced: `apiPort: "9000"`},
+		{content: "EXPOSE 8000 8001", from: "8001", to: "9001", replaced: "EXPOSE 8000 9001"},
+		{content: "maxBodyBytes: 80000", from: "8000", to: "9000", replaced: "maxBodyBytes: 80000"},
+		{content: "port: 18000", from: "8000", to: "9000", replaced: "port: 18000"},
+		{content: "host:8000/v1", from: "8000", to: "8000", replaced: "host:8000/v1"},
+	}
+
+	for _, tc := range testcases {
+		if replaced := replacePort(tc.content, tc.from, tc.to); replaced != tc.replaced {
+			test.Errorf("testcase %q: expected %q, got %q", tc.content, tc.replaced, replaced)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// validEndpointName matches endpoint names, which are used as Go identifiers and RPC names
+var validEndpointName = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// moduleLine matches the module directive of go.mod
+var moduleLine = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+
+// serviceBlock matches the RPC service definition of a proto file
+var serviceBlock = regexp.MustCompile(`(?s)service\s+(\w+)\s*\{.*?\n\}`)
+
+// endpoint describes the scaffolded endpoint (template data)
+type endpoint struct {
+	// Module path of the service
+	Module string
+
+	// Name of the endpoint, controller method and RPC (e.g. GetOrder)
+	Name string
+
+	// Method and Path of the REST route
+	Method string
+	Path   string
+
+	// SamplePath is a request path matching the route (used by the test)
+	SamplePath string
+}
+
+// addEndpointCommand scaffolds a proto RPC, gin route, controller method, model and test
+func addEndpointCommand(args []string) error {
+	flags := flag.NewFlagSet("add endpoint", flag.ExitOnError)
+	dir := flags.String("dir", ".", "Root of the service")
+	name := flags.String("name", "", "Name of the endpoint in CamelCase (e.g. GetOrder)")
+	method := flags.String("method", http.MethodGet, "HTTP method of the REST route")
+	path := flags.String("path", "", "Path of the REST route (defaults to /v1/<name>)")
+	flags.Parse(args)
+
+	if !validEndpointName.MatchString(*name) {
+		return fmt.Errorf("invalid endpoint name %q (CamelCase Go identifier expected)", *name)
+	}
+
+	e := endpoint{
+		Name:   *name,
+		Method: strings.ToUpper(*method),
+		Path:   *path,
+	}
+
+	if e.Path == "" {
+		e.Path = "/v1/" + strings.ReplaceAll(toSnake(e.Name), "_", "-")
+	}
+
+	if !strings.HasPrefix(e.Path, "/") {
+		return fmt.Errorf("invalid path %q", e.Path)
+	}
+
+	e.SamplePath = samplePath(e.Path)
+	src := filepath.Join(*dir, "src")
+	goMod, err := ioutil.ReadFile(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return err
+	}
+
+	match := moduleLine.FindSubmatch(goMod)
+	if match == nil {
+		return fmt.Errorf("module directive not found in go.mod")
+	}
+
+	e.Module = string(match[1])
+	protoFiles, err := filepath.Glob(filepath.Join(src, "protobuf", "*_rpc.proto"))
+	if err != nil {
+		return err
+	} else if len(protoFiles) != 1 {
+		return fmt.Errorf("expected a single rpc proto file, found %d", len(protoFiles))
+	}
+
+	fileName := toSnake(e.Name) + ".go"
+	files := map[string]*template.Template{
+		filepath.Join(src, "models", fileName):                        modelTemplate,
+		filepath.Join(src, "controllers", fileName):                   controllerTemplate,
+		filepath.Join(src, "controllers", toSnake(e.Name)+"_test.go"): controllerTestTemplate,
+		filepath.Join(src, "server", "rpc_"+fileName):                 rpcTemplate,
+	}
+
+	for file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%s already exists", file)
+		}
+	}
+
+	// edit existing files first, they validate that the endpoint does not exist yet
+	if err := addRPC(protoFiles[0], e); err != nil {
+		return err
+	}
+
+	if err := addRoute(filepath.Join(src, "router", "router.go"), e); err != nil {
+		return err
+	}
+
+	for file, tmpl := range files {
+		var content bytes.Buffer
+		if err := tmpl.Execute(&content, e); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(file, content.Bytes(), 0644); err != nil {
+			return err
+		}
+
+		fmt.Printf("created %s\n", file)
+	}
+
+	fmt.Println("next steps: make protobuf, implement the controller and rpc handlers and " +
+		"grant access to the route and rpc in config/policy.yaml if authorization is enabled")
+	return nil
+}
+
+// addRPC adds the RPC and its request/response messages to the proto file
+func addRPC(protoFile string, e endpoint) error {
+	data, err := ioutil.ReadFile(protoFile)
+	if err != nil {
+		return err
+	}
+
+	content := string(data)
+	if regexp.MustCompile(`\brpc\s+` + e.Name + `\s*\(`).MatchString(content) {
+		return fmt.Errorf("rpc %s already exists in %s", e.Name, protoFile)
+	}
+
+	block := serviceBlock.FindStringIndex(content)
+	if block == nil {
+		return fmt.Errorf("service definition not found in %s", protoFile)
+	}
+
+	// insert the rpc before the closing brace of the service
+	end := block[1] - 1
+	rpc := fmt.Sprintf("\n    rpc %s(%sRequest) returns (%sResponse) {}\n", e.Name, e.Name, e.Name)
+	content = strings.TrimRight(content[:end], "\n") + "\n" + rpc + content[end:]
+
+	var messages bytes.Buffer
+	if err := protoMessagesTemplate.Execute(&messages, e); err != nil {
+		return err
+	}
+
+	content = strings.TrimRight(content, "\n") + "\n" + messages.String()
+	fmt.Printf("updated %s\n", protoFile)
+	return ioutil.WriteFile(protoFile, []byte(content), 0644)
+}
+
+// addRoute registers the route with the router, after the existing routes
+func addRoute(routerFile string, e endpoint) error {
+	data, err := ioutil.ReadFile(routerFile)
+	if err != nil {
+		return err
+	}
+
+	content := string(data)
+	if strings.Contains(content, "ctrl."+e.Name+")") {
+		return fmt.Errorf("route for %s already exists in %s", e.Name, routerFile)
+	}
+
+	anchor := "\n\treturn r, nil\n"
+	if strings.Count(content, anchor) != 1 {
+		return fmt.Errorf("router definition not found in %s", routerFile)
+	}
+
+	route := fmt.Sprintf("\n\tr.Handle(%q, %q, ctrl.%s)\n", e.Method, e.Path, e.Name)
+	content = strings.Replace(content, anchor, route+anchor, 1)
+	fmt.Printf("updated %s\n", routerFile)
+	return ioutil.WriteFile(routerFile, []byte(content), 0644)
+}
+
+// samplePath fills in the parameters of a route path ("/v1/orders/:id" -> "/v1/orders/1")
+func samplePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "1"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// templates of the scaffolded code
+var (
+	protoMessagesTemplate = template.Must(template.New("proto").Parse(`
+// {{.Name}}Request is the request body used by clients for the {{.Name}} RPC
+message {{.Name}}Request {
+}
+
+// {{.Name}}Response is the response body returned by the server for the {{.Name}} RPC
+message {{.Name}}Response {
+    string message = 1;
+}
+`))
+
+	modelTemplate = template.Must(template.New("model").Parse(`package models
+
+// {{.Name}}Response is the server response for the {{.Name}} API endpoint
+type {{.Name}}Response struct {
+	Message string ` + "`json:\"message\"`" + `
+}
+`))
+
+	controllerTemplate = template.Must(template.New("controller").Parse(`package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"{{.Module}}/models"
+)
+
+// {{.Name}} API endpoint handler
+func (ctrl *Controller) {{.Name}}(c *gin.Context) {
+	// XXX: implement {{.Name}}
+	response := models.{{.Name}}Response{Message: "{{.Name}}"}
+	c.JSON(http.StatusOK, &response)
+}
+`))
+
+	controllerTestTemplate = template.Must(template.New("test").Parse(`// Contains {{.Name}} API endpoint testcases
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"{{.Module}}/models"
+)
+
+// Test{{.Name}} unit tests the {{.Name}} API endpoint handler
+func Test{{.Name}}(test *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctrl := NewController(nil, log.NewEntry(log.StandardLogger()))
+	r := gin.New()
+	r.Handle("{{.Method}}", "{{.Path}}", ctrl.{{.Name}})
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest("{{.Method}}", "{{.SamplePath}}", nil))
+	if recorder.Code != http.StatusOK {
+		test.Errorf("unexpected status %d for {{.Name}} request", recorder.Code)
+		return
+	}
+
+	var response models.{{.Name}}Response
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		test.Errorf("invalid response to {{.Name}} request: %v", err)
+		return
+	}
+}
+`))
+
+	rpcTemplate = template.Must(template.New("rpc").Parse(`package server
+
+import (
+	"context"
+
+	proto "{{.Module}}/protobuf/generated"
+)
+
+// {{.Name}} rpc request handler
+func (s *Server) {{.Name}}(ctx context.Context, request *proto.{{.Name}}Request) (*proto.{{.Name}}Response, error) {
+	// XXX: implement {{.Name}}
+	response := &proto.{{.Name}}Response{
+		Message: "{{.Name}}",
+	}
+
+	return response, nil
+}
+`))
+)
@@ -0,0 +1,39 @@
+// Scaffolding tool for services built from the blueprint
+// "blueprint new" creates a new service from the blueprint's tree and
+// "blueprint add endpoint" adds a REST endpoint and RPC to an existing service
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// usage of the tool
+const usage = `usage: blueprint <command> [flags]
+
+commands:
+  new            create a new service from the blueprint
+  add endpoint   add a REST endpoint and RPC to a service
+
+run "blueprint <command> -h" for the command's flags
+`
+
+// main routine for the tool
+func main() {
+	var err error
+	switch {
+	case len(os.Args) >= 2 && os.Args[1] == "new":
+		err = newCommand(os.Args[2:])
+	case len(os.Args) >= 3 && os.Args[1] == "add" && os.Args[2] == "endpoint":
+		err = addEndpointCommand(os.Args[3:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
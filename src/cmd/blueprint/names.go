@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// validServiceName matches service names ("orders", "order-history", "order_history")
+var validServiceName = regexp.MustCompile(`^[a-z][a-z0-9]*([_-][a-z0-9]+)*$`)
+
+// names of a service in the forms used across the tree
+type names struct {
+	// Snake case name (binaries, proto package, config)
+	Snake string
+
+	// Kebab case name (kubernetes resources, log dirs)
+	Kebab string
+
+	// Camel case name (RPC service and Go identifiers)
+	Camel string
+
+	// Upper case name (environment variables)
+	Upper string
+}
+
+// newNames derives the forms of a service name
+func newNames(name string) (names, error) {
+	if !validServiceName.MatchString(name) {
+		return names{}, fmt.Errorf("invalid service name %q (lower case letters, digits, '-' and '_' allowed)", name)
+	}
+
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' })
+	var camel strings.Builder
+	for _, word := range words {
+		camel.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+
+	snake := strings.Join(words, "_")
+	return names{
+		Snake: snake,
+		Kebab: strings.Join(words, "-"),
+		Camel: camel.String(),
+		Upper: strings.ToUpper(snake),
+	}, nil
+}
+
+// toSnake converts a Go identifier to snake case ("GetOrder" -> "get_order")
+func toSnake(identifier string) string {
+	var snake strings.Builder
+	runes := []rune(identifier)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// start a new word unless this continues an acronym
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				snake.WriteRune('_')
+			}
+
+			r = unicode.ToLower(r)
+		}
+
+		snake.WriteRune(r)
+	}
+
+	return snake.String()
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// blueprint's own names, replaced by the new service's names
+const (
+	blueprintModule  = "test_service"
+	blueprintApiPort = "8000"
+	blueprintRpcPort = "8001"
+)
+
+// skippedPaths are never copied to a new service (relative to the blueprint's root)
+var skippedPaths = map[string]bool{
+	".git":                   true,
+	"bin":                    true,
+	"src/protobuf/generated": true,
+	"src/cmd/blueprint":      true,
+}
+
+// portPaths are the files whose port numbers are replaced (relative to the blueprint's root)
+var portPaths = map[string]bool{
+	"config/config.yaml":        true,
+	"deployment/deployment.yml": true,
+	"Dockerfile":                true,
+}
+
+// renamedFiles are the files (by extension or name) whose contents are renamed, others (e.g. go.sum,
+// binaries) are copied as is
+var renamedFiles = map[string]bool{
+	".go":        true,
+	".proto":     true,
+	".sql":       true,
+	".yaml":      true,
+	".yml":       true,
+	".md":        true,
+	"go.mod":     true,
+	"Makefile":   true,
+	"Dockerfile": true,
+	".gitignore": true,
+}
+
+// module is an optional part of the blueprint that can be left out of a new service
+type module struct {
+	// top level config sections of the module
+	configSections []string
+
+	// files of the module (relative to the blueprint's root)
+	files []string
+}
+
+// modules that can be left out of a new service
+// their code stays in the tree (it is disabled when its config is absent) but their config and files are dropped
+var modules = map[string]module{
+	"datastore": {
+		configSections: []string{"datastore", "outbox", "jobQueue", "cache"},
+	},
+	"kvstore": {
+		configSections: []string{"kvstore", "leaderElection", "idempotency"},
+	},
+	"queue": {
+		configSections: []string{"queue"},
+	},
+	"auth": {
+		configSections: []string{"authorization", "authentication"},
+		files:          []string{"config/policy.yaml"},
+	},
+}
+
+// newCommand creates a new service from the blueprint
+func newCommand(args []string) error {
+	flags := flag.NewFlagSet("new", flag.ExitOnError)
+	name := flags.String("name", "", "Name of the service (e.g. orders)")
+	modulePath := flags.String("module", "", "Go module path of the service (e.g. github.com/acme/orders)")
+	out := flags.String("out", "", "Directory to create the service in (defaults to ./<name>)")
+	source := flags.String("source", "", "Root of the blueprint (defaults to the enclosing blueprint checkout)")
+	apiPort := flags.String("api-port", blueprintApiPort, "Port of the REST API server")
+	rpcPort := flags.String("rpc-port", blueprintRpcPort, "Port of the RPC server")
+	without := flags.String("without", "", "Comma separated modules to leave out (datastore, kvstore, queue, auth)")
+	flags.Parse(args)
+
+	serviceNames, err := newNames(*name)
+	if err != nil {
+		return err
+	}
+
+	if *modulePath == "" {
+		return fmt.Errorf("module path not specified")
+	}
+
+	excluded := map[string]bool{}
+	for _, moduleName := range strings.Split(*without, ",") {
+		if moduleName = strings.TrimSpace(moduleName); moduleName == "" {
+			continue
+		}
+
+		if _, ok := modules[moduleName]; !ok {
+			return fmt.Errorf("unknown module %q", moduleName)
+		}
+
+		excluded[moduleName] = true
+	}
+
+	root := *source
+	if root == "" {
+		if root, err = findBlueprintRoot(); err != nil {
+			return err
+		}
+	}
+
+	target := *out
+	if target == "" {
+		target = serviceNames.Kebab
+	}
+
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+
+	g := &generator{
+		root:     root,
+		target:   target,
+		replacer: newReplacer(serviceNames, *modulePath),
+		apiPort:  *apiPort,
+		rpcPort:  *rpcPort,
+		skipped:  map[string]bool{},
+		sections: map[string]bool{},
+	}
+
+	for path := range skippedPaths {
+		g.skipped[path] = true
+	}
+
+	for moduleName := range excluded {
+		for _, file := range modules[moduleName].files {
+			g.skipped[file] = true
+		}
+
+		for _, section := range modules[moduleName].configSections {
+			g.sections[section] = true
+		}
+	}
+
+	if err := g.loadIgnored(); err != nil {
+		return err
+	}
+
+	if err := filepath.Walk(root, g.copy); err != nil {
+		return err
+	}
+
+	fmt.Printf("created %s in %s\nnext steps: cd %s && make protobuf && make build && make test\n",
+		serviceNames.Snake, target, target)
+	return nil
+}
+
+// findBlueprintRoot returns the closest enclosing directory holding the blueprint (src/go.mod)
+func findBlueprintRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "src", "go.mod")); err == nil {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("blueprint not found, run from within a blueprint checkout or use -source")
+		}
+
+		dir = parent
+	}
+}
+
+// newReplacer replaces the blueprint's names with the service's names
+// longer names are listed first so that they take precedence
+func newReplacer(serviceNames names, modulePath string) *strings.Replacer {
+	return strings.NewReplacer(
+		"module "+blueprintModule, "module "+modulePath,
+		`"`+blueprintModule+"/", `"`+modulePath+"/",
+		"TestService", serviceNames.Camel,
+		"TEST_SERVICE", serviceNames.Upper,
+		"test_service", serviceNames.Snake,
+		"test-service", serviceNames.Kebab,
+	)
+}
+
+// generator copies the blueprint's tree to a new service
+type generator struct {
+	// root of the blueprint and directory of the new service
+	root   string
+	target string
+
+	// replacer of names in paths and file contents
+	replacer *strings.Replacer
+
+	// ports of the new service
+	apiPort string
+	rpcPort string
+
+	// paths (relative to the root) that are not copied
+	skipped map[string]bool
+
+	// ignore patterns of the blueprint (.gitignore)
+	ignored []string
+
+	// config sections of left out modules
+	sections map[string]bool
+}
+
+// loadIgnored reads the blueprint's .gitignore so that local artifacts are not copied
+func (g *generator) loadIgnored() error {
+	fh, err := os.Open(filepath.Join(g.root, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			g.ignored = append(g.ignored, line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// isIgnored returns true if a path matches an ignore pattern
+// patterns starting with "/" match paths relative to the root, others match file names
+func (g *generator) isIgnored(relPath string) bool {
+	for _, pattern := range g.ignored {
+		subject := filepath.Base(relPath)
+		if strings.HasPrefix(pattern, "/") {
+			pattern, subject = pattern[1:], relPath
+		}
+
+		if matched, _ := filepath.Match(strings.TrimSuffix(pattern, "/"), subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copy copies a file of the blueprint to the new service, renaming it and the contents of source, config and
+// build files, ports are only replaced in the config, deployment and Dockerfile
+func (g *generator) copy(path string, info os.FileInfo, err error) error {
+	if err != nil {
+		return err
+	}
+
+	relPath, err := filepath.Rel(g.root, path)
+	if err != nil {
+		return err
+	}
+
+	relPath = filepath.ToSlash(relPath)
+	if relPath != "." && (g.skipped[relPath] || g.isIgnored(relPath)) {
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	}
+
+	targetPath := filepath.Join(g.target, g.replacer.Replace(relPath))
+	if info.IsDir() {
+		return os.MkdirAll(targetPath, 0755)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if !renamedFiles[filepath.Ext(relPath)] && !renamedFiles[filepath.Base(relPath)] {
+		return ioutil.WriteFile(targetPath, data, info.Mode())
+	}
+
+	content := g.replacer.Replace(string(data))
+	if portPaths[relPath] {
+		content = replacePort(content, blueprintApiPort, g.apiPort)
+		content = replacePort(content, blueprintRpcPort, g.rpcPort)
+	}
+
+	switch relPath {
+	case "config/config.yaml":
+		content = removeSections(content, g.sections)
+	case "Dockerfile":
+		content = removeSkippedCopies(content, g.skipped)
+	}
+
+	return ioutil.WriteFile(targetPath, []byte(content), info.Mode())
+}
+
+// replacePort replaces a port number wherever it appears as a whole number
+func replacePort(content, from, to string) string {
+	if from == to {
+		return content
+	}
+
+	return regexp.MustCompile(`\b`+from+`\b`).ReplaceAllString(content, to)
+}
+
+// removeSections drops top level sections from a yaml config
+// a section spans its key and all the indented lines that follow it, the final newline is kept
+func removeSections(content string, sections map[string]bool) string {
+	var kept []string
+	skipping := false
+	for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+		if line != "" && line[0] != ' ' && line[0] != '#' {
+			skipping = sections[strings.TrimSuffix(strings.Fields(line)[0], ":")]
+		}
+
+		if !skipping {
+			kept = append(kept, line)
+		}
+	}
+
+	if strings.HasSuffix(content, "\n") {
+		kept = append(kept, "")
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+// removeSkippedCopies drops Dockerfile COPY instructions of files that are not part of the service
+func removeSkippedCopies(content string, skipped map[string]bool) string {
+	var kept []string
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "COPY" && skipped[fields[1]] {
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,60 @@
+// Api key commands for the service
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/auth"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+)
+
+// apiKeyUsage describes the apikey subcommands
+const apiKeyUsage = `usage: test_service [flags] apikey <command>
+
+commands:
+  create NAME [SCOPES]   create an api key with the comma separated scopes (default "admin") and print it
+                         (bootstraps the first admin key, further keys can be created through the admin endpoints)
+`
+
+// apiKey runs an apikey subcommand against the configured datastore
+func apiKey(args []string) error {
+	if len(args) < 2 || len(args) > 3 || args[0] != "create" {
+		return fmt.Errorf(apiKeyUsage)
+	}
+
+	scopes := []string{auth.AdminScope}
+	if len(args) == 3 {
+		scopes = strings.Split(args[2], ",")
+	}
+
+	protoConfig, err := bootstrap(*configPath)
+	if err != nil {
+		return err
+	}
+
+	logger := log.NewEntry(log.StandardLogger())
+	repo, err := repository.NewRepository(protoConfig.Datastore, logger)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	manager, err := auth.NewApiKeyManager(&proto.ApiKeyConfig{}, repo, logger)
+	if err != nil {
+		return err
+	}
+
+	key, plaintext, err := manager.Create(context.Background(), args[1], scopes, 0)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created api key %s (%s): %s\n", key.ID, strings.Join(scopes, ","), plaintext)
+	return nil
+}
@@ -4,13 +4,11 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
 
 	proto "test_service/protobuf/generated"
 	"test_service/server"
@@ -32,10 +30,31 @@ func bootstrap(configPath string) (*proto.Config, error) {
 
 	protoConfig := &proto.Config{
 		Service: &proto.ServiceConfig{
-			Name:     config.Service.Name,
-			FqdnOrIP: config.Service.FqdnOrIP,
-			ApiPort:  config.Service.ApiPort,
-			RpcPort:  config.Service.RpcPort,
+			Name:              config.Service.Name,
+			FqdnOrIP:          config.Service.FqdnOrIP,
+			ApiPort:           config.Service.ApiPort,
+			RpcPort:           config.Service.RpcPort,
+			ReadHeaderTimeout: config.Service.ReadHeaderTimeout,
+			ReadTimeout:       config.Service.ReadTimeout,
+			WriteTimeout:      config.Service.WriteTimeout,
+			IdleTimeout:       config.Service.IdleTimeout,
+			MaxHeaderBytes:    config.Service.MaxHeaderBytes,
+			MaxBodyBytes:      config.Service.MaxBodyBytes,
+			RequestTimeout:    config.Service.RequestTimeout,
+			RpcServer: &proto.RpcServerConfig{
+				KeepaliveTime:                config.Service.RpcServer.KeepaliveTime,
+				KeepaliveTimeout:             config.Service.RpcServer.KeepaliveTimeout,
+				KeepaliveMinTime:             config.Service.RpcServer.KeepaliveMinTime,
+				KeepalivePermitWithoutStream: config.Service.RpcServer.KeepalivePermitWithoutStream,
+				MaxConnectionIdle:            config.Service.RpcServer.MaxConnectionIdle,
+				MaxConnectionAge:             config.Service.RpcServer.MaxConnectionAge,
+				MaxConnectionAgeGrace:        config.Service.RpcServer.MaxConnectionAgeGrace,
+				MaxRecvMsgBytes:              config.Service.RpcServer.MaxRecvMsgBytes,
+				MaxSendMsgBytes:              config.Service.RpcServer.MaxSendMsgBytes,
+				MaxConcurrentStreams:         config.Service.RpcServer.MaxConcurrentStreams,
+				Compression:                  config.Service.RpcServer.Compression,
+				Reflection:                   config.Service.RpcServer.Reflection,
+			},
 		},
 		Logging: &proto.LoggingConfig{
 			LogDir:       config.Logging.LogDir,
@@ -47,33 +66,274 @@ func bootstrap(configPath string) (*proto.Config, error) {
 			InstanceName: instanceName,
 		},
 		Datastore: &proto.DatastoreConfig{
-			FqdnOrIP: config.Datastore.FqdnOrIP,
-			Port:     config.Datastore.Port,
-			Username: config.Datastore.Username,
-			Password: config.Datastore.Password,
-			DbName:   config.Datastore.DbName,
+			Driver:              config.Datastore.Driver,
+			FqdnOrIP:            config.Datastore.FqdnOrIP,
+			Port:                config.Datastore.Port,
+			Username:            config.Datastore.Username,
+			Password:            config.Datastore.Password,
+			DbName:              config.Datastore.DbName,
+			MigrateOnStartup:    config.Datastore.MigrateOnStartup,
+			MaxOpenConns:        config.Datastore.MaxOpenConns,
+			MaxIdleConns:        config.Datastore.MaxIdleConns,
+			ConnMaxLifetime:     config.Datastore.ConnMaxLifetime,
+			ConnMaxIdleTime:     config.Datastore.ConnMaxIdleTime,
+			ConnectTimeout:      config.Datastore.ConnectTimeout,
+			SslMode:             config.Datastore.SslMode,
+			SslRootCert:         config.Datastore.SslRootCert,
+			SslCert:             config.Datastore.SslCert,
+			SslKey:              config.Datastore.SslKey,
+			StartupTimeout:      config.Datastore.StartupTimeout,
+			HealthCheckInterval: config.Datastore.HealthCheckInterval,
+			MaxReplicaLag:       config.Datastore.MaxReplicaLag,
+		},
+		Kvstore: &proto.KVStoreConfig{
+			Driver:         config.KVStore.Driver,
+			FqdnOrIP:       config.KVStore.FqdnOrIP,
+			Port:           config.KVStore.Port,
+			Password:       config.KVStore.Password,
+			Database:       config.KVStore.Database,
+			KeyPrefix:      config.KVStore.KeyPrefix,
+			ConnectTimeout: config.KVStore.ConnectTimeout,
+			PoolSize:       config.KVStore.PoolSize,
+			Tls:            config.KVStore.Tls,
+		},
+		Authorization: &proto.AuthorizationConfig{
+			PolicyFile:     config.Authorization.PolicyFile,
+			Mode:           config.Authorization.Mode,
+			DefaultDeny:    config.Authorization.DefaultDeny,
+			ReloadInterval: config.Authorization.ReloadInterval,
+		},
+		Authentication: &proto.AuthenticationConfig{
+			ApiKeys: &proto.ApiKeyConfig{
+				Enabled:               config.Authentication.ApiKeys.Enabled,
+				CacheTTL:              config.Authentication.ApiKeys.CacheTTL,
+				LastUsedFlushInterval: config.Authentication.ApiKeys.LastUsedFlushInterval,
+			},
+		},
+		RateLimit: &proto.RateLimitConfig{
+			Enabled:        config.RateLimit.Enabled,
+			Global:         toProtoRateLimit(config.RateLimit.Global),
+			PerClient:      toProtoRateLimit(config.RateLimit.PerClient),
+			Distributed:    config.RateLimit.Distributed,
+			TrustedProxies: config.RateLimit.TrustedProxies,
+		},
+		Idempotency: &proto.IdempotencyConfig{
+			Enabled:         config.Idempotency.Enabled,
+			Backend:         config.Idempotency.Backend,
+			Window:          config.Idempotency.Window,
+			InFlightTimeout: config.Idempotency.InFlightTimeout,
+			Routes:          config.Idempotency.Routes,
+			Rpcs:            config.Idempotency.Rpcs,
+		},
+		LoadShedding: &proto.LoadSheddingConfig{
+			Enabled:         config.LoadShedding.Enabled,
+			Mode:            config.LoadShedding.Mode,
+			MaxInFlight:     config.LoadShedding.MaxInFlight,
+			MinInFlight:     config.LoadShedding.MinInFlight,
+			InitialInFlight: config.LoadShedding.InitialInFlight,
+			TargetLatency:   config.LoadShedding.TargetLatency,
+		},
+		Cache: &proto.CacheConfig{
+			Enabled:     config.Cache.Enabled,
+			MaxEntries:  config.Cache.MaxEntries,
+			MaxBytes:    config.Cache.MaxBytes,
+			Ttl:         config.Cache.TTL,
+			LocalTTL:    config.Cache.LocalTTL,
+			NegativeTTL: config.Cache.NegativeTTL,
+		},
+		Outbox: &proto.OutboxConfig{
+			Enabled:         config.Outbox.Enabled,
+			PollInterval:    config.Outbox.PollInterval,
+			BatchSize:       config.Outbox.BatchSize,
+			PublishTimeout:  config.Outbox.PublishTimeout,
+			MaxAttempts:     config.Outbox.MaxAttempts,
+			MinRetryBackoff: config.Outbox.MinRetryBackoff,
+			MaxRetryBackoff: config.Outbox.MaxRetryBackoff,
+			Retention:       config.Outbox.Retention,
+		},
+		JobQueue: &proto.JobQueueConfig{
+			Enabled:         config.JobQueue.Enabled,
+			Concurrency:     config.JobQueue.Concurrency,
+			PollInterval:    config.JobQueue.PollInterval,
+			HandlerTimeout:  config.JobQueue.HandlerTimeout,
+			LockTimeout:     config.JobQueue.LockTimeout,
+			MaxAttempts:     config.JobQueue.MaxAttempts,
+			MinRetryBackoff: config.JobQueue.MinRetryBackoff,
+			MaxRetryBackoff: config.JobQueue.MaxRetryBackoff,
+			DrainTimeout:    config.JobQueue.DrainTimeout,
+			Retention:       config.JobQueue.Retention,
+		},
+		LeaderElection: &proto.LeaderElectionConfig{
+			Backend:       config.LeaderElection.Backend,
+			Name:          config.LeaderElection.Name,
+			LeaseDuration: config.LeaderElection.LeaseDuration,
+			RenewInterval: config.LeaderElection.RenewInterval,
+			RetryInterval: config.LeaderElection.RetryInterval,
+		},
+		Queue: &proto.QueueConfig{
+			Driver:         config.Queue.Driver,
+			Url:            config.Queue.URL,
+			Username:       config.Queue.Username,
+			Password:       config.Queue.Password,
+			Stream:         config.Queue.Stream,
+			ConnectTimeout: config.Queue.ConnectTimeout,
+			AckWait:        config.Queue.AckWait,
+		},
+		Scheduler: &proto.SchedulerConfig{
+			Enabled: config.Scheduler.Enabled,
 		},
 	}
 
+	for _, replica := range config.Datastore.Replicas {
+		protoConfig.Datastore.Replicas = append(protoConfig.Datastore.Replicas, &proto.ReplicaConfig{
+			Name:     replica.Name,
+			FqdnOrIP: replica.FqdnOrIP,
+			Port:     replica.Port,
+		})
+	}
+
+	for _, job := range config.Scheduler.Jobs {
+		protoConfig.Scheduler.Jobs = append(protoConfig.Scheduler.Jobs, &proto.JobConfig{
+			Name:       job.Name,
+			Schedule:   job.Schedule,
+			Interval:   job.Interval,
+			Jitter:     job.Jitter,
+			Timeout:    job.Timeout,
+			LeaderOnly: job.LeaderOnly,
+			Disabled:   job.Disabled,
+		})
+	}
+
+	for _, consumer := range config.Queue.Consumers {
+		protoConfig.Queue.Consumers = append(protoConfig.Queue.Consumers, &proto.ConsumerConfig{
+			Name:            consumer.Name,
+			Topic:           consumer.Topic,
+			Group:           consumer.Group,
+			Concurrency:     consumer.Concurrency,
+			MaxAttempts:     consumer.MaxAttempts,
+			MinRetryBackoff: consumer.MinRetryBackoff,
+			MaxRetryBackoff: consumer.MaxRetryBackoff,
+			DeadLetterTopic: consumer.DeadLetterTopic,
+			HandlerTimeout:  consumer.HandlerTimeout,
+			DrainTimeout:    consumer.DrainTimeout,
+		})
+	}
+
+	for _, entity := range config.Cache.Entities {
+		protoConfig.Cache.Entities = append(protoConfig.Cache.Entities, &proto.EntityCacheConfig{
+			Name:        entity.Name,
+			Ttl:         entity.TTL,
+			LocalTTL:    entity.LocalTTL,
+			NegativeTTL: entity.NegativeTTL,
+		})
+	}
+
+	for _, routeLimit := range config.Service.RouteLimits {
+		protoConfig.Service.RouteLimits = append(protoConfig.Service.RouteLimits, &proto.RouteLimit{
+			Routes:         routeLimit.Routes,
+			MaxBodyBytes:   routeLimit.MaxBodyBytes,
+			RequestTimeout: routeLimit.RequestTimeout,
+		})
+	}
+
+	for _, rule := range config.RateLimit.Rules {
+		protoConfig.RateLimit.Rules = append(protoConfig.RateLimit.Rules, &proto.RateLimitRule{
+			Name:   rule.Name,
+			Routes: rule.Routes,
+			Rpcs:   rule.Rpcs,
+			Limit:  toProtoRateLimit(rule.Limit),
+		})
+	}
+
+	protoConfig.Resilience = &proto.ResilienceConfig{}
+	for _, dependency := range config.Resilience.Dependencies {
+		protoConfig.Resilience.Dependencies = append(protoConfig.Resilience.Dependencies, &proto.DependencyConfig{
+			Name:      dependency.Name,
+			Timeout:   dependency.Timeout,
+			Retry:     toProtoRetry(dependency.Retry),
+			Breaker:   toProtoBreaker(dependency.Breaker),
+			Bulkhead:  toProtoBulkhead(dependency.Bulkhead),
+			Readiness: dependency.Readiness,
+		})
+	}
+
+	protoConfig.HttpClients = &proto.HttpClientsConfig{RedactHeaders: config.HttpClients.RedactHeaders}
+	for _, client := range config.HttpClients.Clients {
+		protoConfig.HttpClients.Clients = append(protoConfig.HttpClients.Clients, &proto.HttpClientConfig{
+			Name:                  client.Name,
+			BaseUrl:               client.BaseUrl,
+			Timeout:               client.Timeout,
+			ConnectTimeout:        client.ConnectTimeout,
+			ResponseHeaderTimeout: client.ResponseHeaderTimeout,
+			IdleConnTimeout:       client.IdleConnTimeout,
+			MaxIdleConnsPerHost:   client.MaxIdleConnsPerHost,
+			Tls: &proto.HttpClientTlsConfig{
+				CaFile:             client.Tls.CaFile,
+				CertFile:           client.Tls.CertFile,
+				KeyFile:            client.Tls.KeyFile,
+				ServerName:         client.Tls.ServerName,
+				InsecureSkipVerify: client.Tls.InsecureSkipVerify,
+			},
+			Retry:    toProtoRetry(client.Retry),
+			Breaker:  toProtoBreaker(client.Breaker),
+			Bulkhead: toProtoBulkhead(client.Bulkhead),
+		})
+	}
+
+	for _, class := range config.LoadShedding.Priorities {
+		protoConfig.LoadShedding.Priorities = append(protoConfig.LoadShedding.Priorities, &proto.PriorityClass{
+			Priority: class.Priority,
+			Routes:   class.Routes,
+			Rpcs:     class.Rpcs,
+		})
+	}
+
 	return protoConfig, nil
 }
 
+// toProtoRateLimit translates a rate limit to its proto definition
+func toProtoRateLimit(limit server.RateLimit) *proto.RateLimit {
+	return &proto.RateLimit{
+		RatePerSecond: limit.RatePerSecond,
+		Burst:         limit.Burst,
+	}
+}
+
+// toProtoRetry translates a retry config to its proto definition
+func toProtoRetry(retry server.Retry) *proto.RetryConfig {
+	return &proto.RetryConfig{
+		MaxAttempts:    retry.MaxAttempts,
+		InitialBackoff: retry.InitialBackoff,
+		MaxBackoff:     retry.MaxBackoff,
+		Multiplier:     retry.Multiplier,
+		Jitter:         retry.Jitter,
+	}
+}
+
+// toProtoBreaker translates a circuit breaker config to its proto definition
+func toProtoBreaker(breaker server.Breaker) *proto.BreakerConfig {
+	return &proto.BreakerConfig{
+		Enabled:          breaker.Enabled,
+		FailureThreshold: breaker.FailureThreshold,
+		OpenTimeout:      breaker.OpenTimeout,
+		HalfOpenRequests: breaker.HalfOpenRequests,
+	}
+}
+
+// toProtoBulkhead translates a bulkhead config to its proto definition
+func toProtoBulkhead(bulkhead server.Bulkhead) *proto.BulkheadConfig {
+	return &proto.BulkheadConfig{
+		MaxConcurrent: bulkhead.MaxConcurrent,
+		MaxWait:       bulkhead.MaxWait,
+	}
+}
+
 // getConfig extracts config from service config file (yml) and provided flags
 func getConfig(path string) (*server.Config, error) {
-	var config server.Config
 	if path == "" {
 		return nil, fmt.Errorf("service config file path is empty")
 	}
 
 	log.Infof("reading yaml config from %q", path)
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, err
-	}
-
-	return &config, nil
+	return server.ReadConfig(path)
 }
@@ -15,6 +15,9 @@ import (
 var (
 	// configPath of service config
 	configPath = flag.String("c", "config.yaml", "Path to service config")
+
+	// migrationsDir where "migrate create" writes new migrations
+	migrationsDir = flag.String("migrations", "repository/migrations", "Path to schema migrations (migrate create)")
 )
 
 // main routine for the service
@@ -22,6 +25,26 @@ var (
 func main() {
 	flag.Parse()
 
+	// schema migrations are managed through subcommands instead of running the service
+	if flag.NArg() > 0 && flag.Arg(0) == "migrate" {
+		if err := migrate(flag.Args()[1:]); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	// the first admin api key is created through a subcommand, since managing keys requires one
+	if flag.NArg() > 0 && flag.Arg(0) == "apikey" {
+		if err := apiKey(flag.Args()[1:]); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	protoConfig, err := bootstrap(*configPath)
 	if err != nil {
 		log.Error("failed to bootstrap test_service")
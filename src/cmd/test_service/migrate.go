@@ -0,0 +1,109 @@
+// Schema migration commands for the service
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/repository"
+)
+
+// migrateUsage describes the migrate subcommands
+const migrateUsage = `usage: test_service [flags] migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [N]      revert the latest N applied migrations (default 1)
+  status        list migrations and whether they are applied
+  create NAME   create empty up/down migration files for every driver in the migrations directory
+`
+
+// migrate runs a migrate subcommand against the configured datastore
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return fmt.Errorf(migrateUsage)
+		}
+
+		files, err := repository.CreateMigration(*migrationsDir, args[1])
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			fmt.Printf("created %s\n", file)
+		}
+
+		return nil
+	}
+
+	protoConfig, err := bootstrap(*configPath)
+	if err != nil {
+		return err
+	}
+
+	logger := log.NewEntry(log.StandardLogger())
+	repo, err := repository.NewRepository(protoConfig.Datastore, logger)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		applied, err := migrator.Up(ctx)
+		fmt.Printf("applied %d migrations\n", applied)
+		return err
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+
+		reverted, err := migrator.Down(ctx, steps)
+		fmt.Printf("reverted %d migrations\n", reverted)
+		return err
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, status := range statuses {
+			state, appliedAt := "pending", ""
+			if status.Applied {
+				state, appliedAt = "applied", status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+
+			if status.Modified {
+				state = "modified"
+			}
+
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		}
+
+		return tw.Flush()
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"test_service/auth"
+	"test_service/client"
+)
+
+// cli holds connections to a service instance
+type cli struct {
+	// addresses (host:port) of the REST and RPC servers
+	apiAddress string
+	rpcAddress string
+
+	// apiKey presented with every request (if set)
+	apiKey string
+
+	// insecure allows sending the api key over plaintext connections
+	insecure bool
+
+	// tls config of the REST and RPC connections (plaintext if nil)
+	tls *tls.Config
+
+	// timeout of each request
+	timeout time.Duration
+
+	// rpc client, created on first use
+	rpcClient *client.Client
+
+	// http client of the REST server, created on first use
+	httpClient *http.Client
+}
+
+// rpc returns the rpc client, connecting on first use
+func (c *cli) rpc() (*client.Client, error) {
+	if c.rpcClient != nil {
+		return c.rpcClient, nil
+	}
+
+	rpcClient, err := client.NewClient(client.Options{
+		Target:  c.rpcAddress,
+		TLS:     c.tls,
+		Timeout: c.timeout,
+		ApiKey:  c.apiKey,
+
+		AllowInsecureApiKey: c.insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.rpcClient = rpcClient
+	return rpcClient, nil
+}
+
+// http returns the http client of the REST server, using the TLS config if set
+func (c *cli) http() *http.Client {
+	if c.httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tls
+		c.httpClient = &http.Client{Transport: transport}
+	}
+
+	return c.httpClient
+}
+
+// close releases the connections
+func (c *cli) close() {
+	if c.rpcClient != nil {
+		c.rpcClient.Close()
+	}
+
+	if c.httpClient != nil {
+		c.httpClient.CloseIdleConnections()
+	}
+}
+
+// rest issues a REST request with an optional JSON body, returning the decoded JSON response
+func (c *cli) rest(method, path string, body interface{}) (interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		reader = bytes.NewReader(data)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	scheme := "http"
+	if c.tls != nil {
+		scheme = "https"
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, scheme+"://"+c.apiAddress+path, reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", auth.ApiKeyScheme+" "+c.apiKey)
+	}
+
+	response, err := c.http().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("%s %s: %s (invalid JSON response: %v)", method, path, response.Status, err)
+	}
+
+	if response.StatusCode >= http.StatusBadRequest {
+		if object, ok := result.(map[string]interface{}); ok && object["error"] != nil {
+			return nil, fmt.Errorf("%s %s: %s: %v", method, path, response.Status, object["error"])
+		}
+
+		return nil, fmt.Errorf("%s %s: %s", method, path, response.Status)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+)
+
+// pingCommand pings the REST and RPC servers, reporting their responses and latencies
+func pingCommand(c *cli, args []string) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("usage: ping")
+	}
+
+	start := time.Now()
+	restResponse, err := c.rest(http.MethodGet, "/v1/ping", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	restLatency := time.Since(start)
+	rpcClient, err := c.rpc()
+	if err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	rpcResponse, err := rpcClient.Ping(context.Background(), &proto.PingRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"rest": map[string]interface{}{
+			"response": restResponse,
+			"latency":  restLatency.String(),
+		},
+		"rpc": map[string]interface{}{
+			"response": map[string]interface{}{"message": rpcResponse.Message},
+			"latency":  time.Since(start).String(),
+		},
+	}, nil
+}
+
+// healthCommand reports the health of the REST server, the service's readiness and the RPC server's health service
+func healthCommand(c *cli, args []string) (interface{}, error) {
+	if len(args) != 0 {
+		return nil, fmt.Errorf("usage: health")
+	}
+
+	restHealth, err := c.rest(http.MethodGet, "/v1/health", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// readiness failures are reported as part of the result rather than failing the command
+	readiness, err := c.rest(http.MethodGet, "/v1/ready", nil)
+	if err != nil {
+		readiness = err.Error()
+	}
+
+	rpcClient, err := c.rpc()
+	if err != nil {
+		return nil, err
+	}
+
+	rpcHealth, err := healthpb.NewHealthClient(rpcClient.Conn()).Check(context.Background(),
+		&healthpb.HealthCheckRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"rest":  restHealth,
+		"ready": readiness,
+		"rpc":   map[string]interface{}{"status": rpcHealth.Status.String()},
+	}, nil
+}
+
+// configCommand shows the service config
+func configCommand(c *cli, args []string) (interface{}, error) {
+	if len(args) != 1 || args[0] != "show" {
+		return nil, fmt.Errorf("usage: config show")
+	}
+
+	return c.rest(http.MethodGet, "/v1/admin/config", nil)
+}
+
+// logLevelCommand shows or changes the service's logging level
+func logLevelCommand(c *cli, args []string) (interface{}, error) {
+	switch {
+	case len(args) == 1 && args[0] == "get":
+		return c.rest(http.MethodGet, "/v1/admin/loglevel", nil)
+	case len(args) == 2 && args[0] == "set":
+		return c.rest(http.MethodPut, "/v1/admin/loglevel", &models.LogLevelRequest{Level: args[1]})
+	default:
+		return nil, fmt.Errorf("usage: loglevel get | loglevel set <level>")
+	}
+}
+
+// jobsCommand lists the scheduled jobs or triggers one
+func jobsCommand(c *cli, args []string) (interface{}, error) {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return c.rest(http.MethodGet, "/v1/admin/jobs", nil)
+	case len(args) == 2 && args[0] == "trigger":
+		return c.rest(http.MethodPost, "/v1/admin/jobs/"+url.PathEscape(args[1])+"/trigger", nil)
+	default:
+		return nil, fmt.Errorf("usage: jobs list | jobs trigger <name>")
+	}
+}
@@ -0,0 +1,151 @@
+// Command line client for the service
+// reads the service config to discover the REST and RPC endpoints of an instance
+// and offers on-call friendly subcommands on top of them
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"test_service/client"
+	"test_service/server"
+)
+
+// globals
+var (
+	// configPath of service config (used to discover the service's endpoints)
+	configPath = flag.String("c", "config.yaml", "Path to service config")
+
+	// host overrides the service's fqdn/ip from the config
+	host = flag.String("host", "", "Service host (defaults to fqdnOrIP from the config)")
+
+	// output format of command results
+	output = flag.String("o", outputTable, "Output format (table or json)")
+
+	// apiKey presented to the service
+	apiKey = flag.String("api-key", os.Getenv("TEST_SERVICE_API_KEY"), "API key (defaults to $TEST_SERVICE_API_KEY)")
+
+	// insecure allows sending the api key over plaintext connections
+	insecure = flag.Bool("insecure", false, "Send the API key over plaintext connections")
+
+	// useTLS connects to the REST and RPC servers over TLS
+	useTLS = flag.Bool("tls", false, "Connect over TLS (implied by -ca, -cert and -key)")
+
+	// caFile trusted to verify the servers' certificates
+	caFile = flag.String("ca", "", "CA file used to verify the service's certificates (defaults to the system roots)")
+
+	// certFile and keyFile of the client certificate (mutual TLS)
+	certFile = flag.String("cert", "", "Client certificate file (mutual TLS)")
+	keyFile  = flag.String("key", "", "Client key file (mutual TLS)")
+
+	// timeout of each request
+	timeout = flag.Duration("timeout", 10*time.Second, "Request timeout")
+)
+
+// usage of the cli
+const usage = `usage: test_service_cli [flags] <command> [args]
+
+commands:
+  ping                      ping the REST and RPC servers
+  health                    report health of the REST and RPC servers
+  config show               show the service config (secrets redacted)
+  loglevel get              show the service's logging level
+  loglevel set <level>      change the service's logging level (until restart)
+  jobs list                 list scheduled jobs and their last runs
+  jobs trigger <name>       run a scheduled job right away
+  rpc list                  list RPC methods
+  rpc call <method> [json]  call an RPC method with a JSON request (e.g. rpc call Ping '{}')
+
+flags:
+`
+
+// command runs a subcommand with its arguments
+type command func(cli *cli, args []string) (interface{}, error)
+
+// commands maps subcommand names to their handlers
+var commands = map[string]command{
+	"ping":     pingCommand,
+	"health":   healthCommand,
+	"config":   configCommand,
+	"loglevel": logLevelCommand,
+	"jobs":     jobsCommand,
+	"rpc":      rpcCommand,
+}
+
+// main routine for the cli
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(os.Stderr, "unsupported output format %q\n", *output)
+		os.Exit(2)
+	}
+
+	config, err := server.ReadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read service config %s: %v\n", *configPath, err)
+		os.Exit(1)
+	}
+
+	var tlsConfig *tls.Config
+	if *useTLS || *caFile != "" || *certFile != "" || *keyFile != "" {
+		tlsConfig, err = client.LoadTLSConfig(*caFile, *certFile, *keyFile, "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load TLS config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// keys are only presented over TLS, unless plaintext is explicitly allowed
+	if *apiKey != "" && tlsConfig == nil && !*insecure {
+		fmt.Fprintln(os.Stderr, "the API key is only sent over TLS, pass -tls (or -insecure to send it in plaintext)")
+		os.Exit(2)
+	}
+
+	serviceHost := *host
+	if serviceHost == "" {
+		serviceHost = config.Service.FqdnOrIP
+	}
+
+	c := &cli{
+		apiAddress: net.JoinHostPort(serviceHost, config.Service.ApiPort),
+		rpcAddress: net.JoinHostPort(serviceHost, config.Service.RpcPort),
+		apiKey:     *apiKey,
+		insecure:   *insecure,
+		tls:        tlsConfig,
+		timeout:    *timeout,
+	}
+	defer c.close()
+
+	result, err := run(c, flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := printResult(os.Stdout, *output, result); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to print result: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// supported output formats
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// printResult writes a command's result in the requested format
+func printResult(w io.Writer, format string, result interface{}) error {
+	if format == outputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if rows, ok := objectRows(result); ok {
+		// lists of objects are printed with a column per key
+		columns := columnNames(rows)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range rows {
+			var values []string
+			for _, column := range columns {
+				values = append(values, row[column])
+			}
+
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+
+		return tw.Flush()
+	}
+
+	// anything else is flattened into key/value pairs
+	fields := map[string]string{}
+	flatten("", result, fields)
+	var keys []string
+	for key := range fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	fmt.Fprintln(tw, "KEY\tVALUE")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", key, fields[key])
+	}
+
+	return tw.Flush()
+}
+
+// objectRows returns the flattened rows of a list of objects
+func objectRows(result interface{}) ([]map[string]string, bool) {
+	list, ok := result.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+
+	var rows []map[string]string
+	for _, item := range list {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return nil, false
+		}
+
+		row := map[string]string{}
+		flatten("", item, row)
+		rows = append(rows, row)
+	}
+
+	return rows, true
+}
+
+// columnNames returns the sorted union of the rows' keys
+func columnNames(rows []map[string]string) []string {
+	seen := map[string]bool{}
+	var columns []string
+	for _, row := range rows {
+		for column := range row {
+			if !seen[column] {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+
+	sort.Strings(columns)
+	return columns
+}
+
+// flatten adds the leaf values of a decoded JSON value to fields, keyed by their dotted path
+func flatten(prefix string, value interface{}, fields map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			flatten(join(key), item, fields)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flatten(join(fmt.Sprint(i)), item, fields)
+		}
+	case nil:
+		fields[prefix] = ""
+	default:
+		fields[prefix] = fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	proto "test_service/protobuf/generated"
+)
+
+// defaultService is assumed for methods named without their service
+var defaultService = proto.File_test_service_rpc_proto.Services().Get(0)
+
+// rpcCommand lists or calls RPC methods
+func rpcCommand(c *cli, args []string) (interface{}, error) {
+	switch {
+	case len(args) == 1 && args[0] == "list":
+		return listMethods(), nil
+	case (len(args) == 2 || len(args) == 3) && args[0] == "call":
+		request := "{}"
+		if len(args) == 3 {
+			request = args[2]
+		}
+
+		return c.callMethod(args[1], request)
+	default:
+		return nil, fmt.Errorf("usage: rpc list | rpc call <method> [json]")
+	}
+}
+
+// listMethods lists the methods of the service's RPC services
+func listMethods() interface{} {
+	var methods []interface{}
+	services := proto.File_test_service_rpc_proto.Services()
+	for i := 0; i < services.Len(); i++ {
+		for j := 0; j < services.Get(i).Methods().Len(); j++ {
+			method := services.Get(i).Methods().Get(j)
+			methods = append(methods, map[string]interface{}{
+				"method":   fullMethodName(method),
+				"request":  string(method.Input().FullName()),
+				"response": string(method.Output().FullName()),
+			})
+		}
+	}
+
+	return methods
+}
+
+// callMethod calls a unary RPC method with a JSON encoded request, returning the decoded JSON response
+// the method may be named as "Ping", "TestServiceRPC/Ping" or "/test_service.TestServiceRPC/Ping"
+func (c *cli) callMethod(name, request string) (interface{}, error) {
+	method, err := findMethod(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if method.IsStreamingClient() || method.IsStreamingServer() {
+		return nil, fmt.Errorf("streaming method %s is not supported", fullMethodName(method))
+	}
+
+	in := dynamicpb.NewMessage(method.Input())
+	if err := protojson.Unmarshal([]byte(request), in); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", method.Input().FullName(), err)
+	}
+
+	rpcClient, err := c.rpc()
+	if err != nil {
+		return nil, err
+	}
+
+	out := dynamicpb.NewMessage(method.Output())
+	if err := rpcClient.Conn().Invoke(context.Background(), fullMethodName(method), in, out); err != nil {
+		return nil, err
+	}
+
+	data, err := protojson.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	return result, json.Unmarshal(data, &result)
+}
+
+// findMethod looks up a method's descriptor
+func findMethod(name string) (protoreflect.MethodDescriptor, error) {
+	name = strings.TrimPrefix(name, "/")
+	serviceName, methodName := string(defaultService.FullName()), name
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		serviceName, methodName = name[:i], name[i+1:]
+		if !strings.Contains(serviceName, ".") {
+			serviceName = string(defaultService.ParentFile().Package()) + "." + serviceName
+		}
+	}
+
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(serviceName))
+	if err != nil {
+		return nil, fmt.Errorf("unknown service %s", serviceName)
+	}
+
+	service, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", serviceName)
+	}
+
+	method := service.Methods().ByName(protoreflect.Name(methodName))
+	if method == nil {
+		return nil, fmt.Errorf("unknown method %s of service %s", methodName, serviceName)
+	}
+
+	return method, nil
+}
+
+// fullMethodName returns the gRPC name of a method ("/<service>/<method>")
+func fullMethodName(method protoreflect.MethodDescriptor) string {
+	return fmt.Sprintf("/%s/%s", method.Parent().FullName(), method.Name())
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"test_service/models"
+)
+
+// redactedValue replaces secrets in the config served by the admin endpoint
+const redactedValue = "REDACTED"
+
+// sensitiveFields are (substrings of) config field names holding secrets
+var sensitiveFields = []string{"password", "secret", "token"}
+
+// ShowConfig API endpoint handler returning the service config with secrets redacted
+func (ctrl *Controller) ShowConfig(c *gin.Context) {
+	config := protobuf.Clone(ctrl.Config)
+	redact(config.ProtoReflect())
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(config)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// LogLevel API endpoint handler returning the server's logging level
+func (ctrl *Controller) LogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, &models.LogLevelResponse{Level: log.GetLevel().String()})
+}
+
+// SetLogLevel API endpoint handler changing the server's logging level at runtime
+// the change is not persisted, a restart reverts to the configured level
+func (ctrl *Controller) SetLogLevel(c *gin.Context) {
+	var request models.LogLevelRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	level, err := log.ParseLevel(request.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.Logger.Infof("changing logging level from %s to %s", log.GetLevel(), level)
+	log.SetLevel(level)
+	c.JSON(http.StatusOK, &models.LogLevelResponse{Level: level.String()})
+}
+
+// redact blanks out non empty secrets in a config message (recursively)
+func redact(message protoreflect.Message) {
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.Message() != nil && field.IsList():
+			for i := 0; i < value.List().Len(); i++ {
+				redact(value.List().Get(i).Message())
+			}
+		case field.Message() != nil && !field.IsMap():
+			redact(value.Message())
+		case field.Kind() == protoreflect.StringKind && !field.IsList() && !field.IsMap() && isSensitive(field):
+			message.Set(field, protoreflect.ValueOfString(redactedValue))
+		}
+
+		return true
+	})
+}
+
+// isSensitive returns true if the field holds a secret
+func isSensitive(field protoreflect.FieldDescriptor) bool {
+	name := strings.ToLower(string(field.Name()))
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"test_service/auth"
+	"test_service/models"
+	"test_service/util"
+)
+
+// CreateApiKey API endpoint handler to create a new api key
+func (ctrl *Controller) CreateApiKey(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	var request models.CreateApiKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	expiresIn, err := util.ParseDuration(request.ExpiresIn, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plaintext, err := ctrl.ApiKeys.Create(c.Request.Context(), request.Name, request.Scopes, expiresIn)
+	if err != nil {
+		ctrl.apiKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newApiKeyResponse(key, plaintext))
+}
+
+// ListApiKeys API endpoint handler to list all api keys
+func (ctrl *Controller) ListApiKeys(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	keys, err := ctrl.ApiKeys.List(c.Request.Context())
+	if err != nil {
+		ctrl.apiKeyError(c, err)
+		return
+	}
+
+	response := models.ListApiKeysResponse{ApiKeys: []models.ApiKeyResponse{}}
+	for i := range keys {
+		response.ApiKeys = append(response.ApiKeys, newApiKeyResponse(&keys[i], ""))
+	}
+
+	c.JSON(http.StatusOK, &response)
+}
+
+// RotateApiKey API endpoint handler to replace the secret of an api key
+func (ctrl *Controller) RotateApiKey(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	key, plaintext, err := ctrl.ApiKeys.Rotate(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		ctrl.apiKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newApiKeyResponse(key, plaintext))
+}
+
+// RevokeApiKey API endpoint handler to revoke an api key
+func (ctrl *Controller) RevokeApiKey(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	if err := ctrl.ApiKeys.Revoke(c.Request.Context(), c.Param("id")); err != nil {
+		ctrl.apiKeyError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// requireAdmin rejects requests that are not made by an admin, returning false if the request was rejected
+// credentials and scheduled jobs are only managed by admins even when the authorization policy is not enforced
+func (ctrl *Controller) requireAdmin(c *gin.Context) bool {
+	switch err := auth.RequireScope(c.Request.Context(), auth.AdminScope); {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// apiKeyError maps api key manager errors to API responses
+func (ctrl *Controller) apiKeyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, auth.ErrApiKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, auth.ErrInvalidApiKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "api key is revoked or expired"})
+	default:
+		ctrl.Logger.Errorf("api key request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// newApiKeyResponse builds the API representation of an api key
+func newApiKeyResponse(key *models.ApiKey, plaintext string) models.ApiKeyResponse {
+	scopes := key.ScopeList()
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return models.ApiKeyResponse{ApiKey: key, Scopes: scopes, Key: plaintext}
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"test_service/jobqueue"
+	"test_service/models"
+	"test_service/repository"
+)
+
+// defaultJobListLimit is the number of jobs listed when the request does not specify a limit
+const defaultJobListLimit = 100
+
+// ListBackgroundJobs API endpoint handler to list the most recent jobs of the job queue along with the
+// number of jobs of each type by status, optionally filtered by type, status and limit query parameters
+func (ctrl *Controller) ListBackgroundJobs(c *gin.Context) {
+	filter := repository.BackgroundJobFilter{
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+		Limit:  defaultJobListLimit,
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+
+		filter.Limit = value
+	}
+
+	jobs, err := ctrl.JobQueue.List(c.Request.Context(), filter)
+	if err != nil {
+		ctrl.backgroundJobError(c, err)
+		return
+	}
+
+	counts, err := ctrl.JobQueue.Counts(c.Request.Context())
+	if err != nil {
+		ctrl.backgroundJobError(c, err)
+		return
+	}
+
+	response := models.ListBackgroundJobsResponse{Jobs: jobs, Counts: counts}
+	if response.Jobs == nil {
+		response.Jobs = []models.BackgroundJob{}
+	}
+
+	if response.Counts == nil {
+		response.Counts = []models.BackgroundJobCount{}
+	}
+
+	c.JSON(http.StatusOK, &response)
+}
+
+// GetBackgroundJob API endpoint handler to show the status of a job of the job queue
+func (ctrl *Controller) GetBackgroundJob(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return
+	}
+
+	job, err := ctrl.JobQueue.Get(c.Request.Context(), id)
+	if err != nil {
+		ctrl.backgroundJobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// backgroundJobError maps job queue errors to API responses
+func (ctrl *Controller) backgroundJobError(c *gin.Context, err error) {
+	if errors.Is(err, jobqueue.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctrl.Logger.Errorf("job queue request failed: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -5,22 +5,53 @@ import (
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
+	"test_service/auth"
+	"test_service/jobqueue"
+	"test_service/kvstore"
+	"test_service/leader"
 	"test_service/models"
+	proto "test_service/protobuf/generated"
 	"test_service/repository"
+	"test_service/scheduler"
 )
 
 // Controller is a wrapper object for all API handlers
 type Controller struct {
-	// Repository object (includes conn object to the db/repo)
-	Repository *repository.Repository
+	// Repository the handlers read and persist data through (nil if the datastore is disabled)
+	Repository repository.Store
 
 	// Logger object
 	Logger *log.Entry
+
+	// Config of the service instance (served with secrets redacted)
+	Config *proto.Config
+
+	// KVStore shared by the replicas of the service (nil if the kv store is disabled)
+	KVStore kvstore.Store
+
+	// Leader elector of the service's replicas (nil if leader election is disabled)
+	Leader *leader.Elector
+
+	// Scheduler running the service's periodic jobs (nil if the scheduler is disabled)
+	Scheduler *scheduler.Scheduler
+
+	// JobQueue holding the service's background jobs (nil if the job queue is disabled)
+	JobQueue *jobqueue.Queue
+
+	// ApiKeys manages api keys (nil if api key authentication is disabled)
+	ApiKeys *auth.ApiKeyManager
+
+	// RpcServer hosting the service's RPCs (used for introspection)
+	RpcServer *grpc.Server
+
+	// ReadinessChecks of the service's dependencies, keyed by name (a nil error means ready)
+	ReadinessChecks map[string]func() error
 }
 
 // NewController will create a new controller object
-func NewController(repo *repository.Repository, logger *log.Entry) Controller {
+func NewController(repo repository.Store, logger *log.Entry) Controller {
 	return Controller{
 		Repository: repo,
 		Logger:     logger,
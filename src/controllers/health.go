@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"test_service/models"
+)
+
+// Health API endpoint handler reporting service liveness (and the current leader)
+func (ctrl *Controller) Health(c *gin.Context) {
+	response := models.HealthResponse{Status: "ok"}
+	if ctrl.Leader != nil {
+		status := &models.LeaderStatus{Identity: ctrl.Leader.Identity()}
+		status.FencingToken, status.IsLeader = ctrl.Leader.Token()
+		leader, err := ctrl.Leader.Leader(c.Request.Context())
+		status.Leader = leader
+		if err != nil {
+			status.Error = err.Error()
+		}
+
+		response.Leader = status
+	}
+
+	c.JSON(http.StatusOK, &response)
+}
+
+// Ready API endpoint handler reporting whether the service's dependencies are ready (503 otherwise)
+func (ctrl *Controller) Ready(c *gin.Context) {
+	response := models.ReadinessResponse{Status: "ready", Checks: map[string]string{}}
+	code := http.StatusOK
+	for name, check := range ctrl.ReadinessChecks {
+		response.Checks[name] = "ok"
+		if err := check(); err != nil {
+			response.Checks[name] = err.Error()
+			response.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, &response)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"test_service/models"
+	"test_service/scheduler"
+)
+
+// ListJobs API endpoint handler to list the scheduled jobs and their last runs
+func (ctrl *Controller) ListJobs(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	response := models.ListJobsResponse{Jobs: []models.JobResponse{}}
+	for _, status := range ctrl.Scheduler.Status() {
+		response.Jobs = append(response.Jobs, newJobResponse(status))
+	}
+
+	c.JSON(http.StatusOK, &response)
+}
+
+// TriggerJob API endpoint handler to run a job right away
+func (ctrl *Controller) TriggerJob(c *gin.Context) {
+	if !ctrl.requireAdmin(c) {
+		return
+	}
+
+	status, err := ctrl.Scheduler.Trigger(c.Param("name"))
+	switch {
+	case err == nil:
+		c.JSON(http.StatusAccepted, newJobResponse(status))
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, scheduler.ErrJobRunning), errors.Is(err, scheduler.ErrNotLeader):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		ctrl.Logger.Errorf("failed to trigger job: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// newJobResponse builds the API representation of a job
+func newJobResponse(status scheduler.JobStatus) models.JobResponse {
+	response := models.JobResponse{
+		Name:       status.Name,
+		Schedule:   status.Schedule,
+		LeaderOnly: status.LeaderOnly,
+		Running:    status.Running,
+		LastResult: status.LastResult,
+		LastError:  status.LastError,
+		Runs:       status.Runs,
+		Failures:   status.Failures,
+	}
+
+	if !status.NextRunAt.IsZero() {
+		response.NextRunAt = &status.NextRunAt
+	}
+
+	if !status.LastRunAt.IsZero() {
+		response.LastRunAt = &status.LastRunAt
+		response.LastDuration = status.LastDuration.String()
+	}
+
+	return response
+}
@@ -0,0 +1,15 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"test_service/introspection"
+)
+
+// RpcServices API endpoint handler listing the services and methods hosted by the rpc server
+// along with the schemas of their request/response messages
+func (ctrl *Controller) RpcServices(c *gin.Context) {
+	c.JSON(http.StatusOK, introspection.DescribeServices(ctrl.RpcServer))
+}
@@ -3,12 +3,20 @@ module test_service
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.23.0
 	github.com/gin-gonic/gin v1.7.2
-	github.com/google/uuid v1.2.0
+	github.com/glebarez/sqlite v1.7.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/google/uuid v1.3.0
+	github.com/nats-io/nats-server/v2 v2.8.4
+	github.com/nats-io/nats.go v1.16.0
+	github.com/prometheus/client_golang v1.12.2
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/sirupsen/logrus v1.8.1
-	google.golang.org/grpc v1.38.0
+	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4
+	google.golang.org/grpc v1.43.0
 	google.golang.org/protobuf v1.26.0
 	gopkg.in/yaml.v2 v2.4.0
 	gorm.io/driver/postgres v1.3.1
-	gorm.io/gorm v1.23.3
+	gorm.io/gorm v1.24.5
 )
@@ -0,0 +1,403 @@
+// HTTP client package creating the clients the service calls other APIs with
+// requests are resolved against the client's base url, carry the request id/trace context of the request
+// being served, are guarded by a resilience policy (retries, circuit breaker, bulkhead) and are measured
+// and logged with sensitive headers redacted
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	"test_service/client"
+	"test_service/propagation"
+	proto "test_service/protobuf/generated"
+	"test_service/resilience"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultTimeout             = 30 * time.Second
+	defaultConnectTimeout      = 5 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConnsPerHost = 10
+)
+
+// idempotencyKeyHeader marks requests that are safe to retry whatever their method
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// redactedValue replaces the values of sensitive headers in logs
+const redactedValue = "REDACTED"
+
+// defaultRedactedHeaders are always redacted in logs
+var defaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// metrics exported by the clients, labelled by client name
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_client_requests_total",
+		Help: "Number of outbound HTTP requests by method and status code (error if no response was received)",
+	}, []string{"client", "method", "code"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_client_request_duration_seconds",
+		Help:    "Time taken by outbound HTTP requests, including retries",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"client", "method"})
+)
+
+// Client is a named HTTP client, relative request urls are resolved against its base url
+type Client struct {
+	*http.Client
+
+	// name of the client and the url requests are resolved against (nil if not configured)
+	name    string
+	baseURL *url.URL
+
+	// transport of the client
+	transport *http.Transport
+}
+
+// NewClient creates a client from its config, the values of the headers to redact are not logged
+func NewClient(config *proto.HttpClientConfig, redactHeaders []string, logger *log.Entry) (*Client, error) {
+	if config.Name == "" {
+		return nil, fmt.Errorf("http client without a name")
+	}
+
+	var baseURL *url.URL
+	if config.BaseUrl != "" {
+		var err error
+		if baseURL, err = url.Parse(config.BaseUrl); err != nil {
+			return nil, fmt.Errorf("invalid base url of http client %s: %v", config.Name, err)
+		}
+
+		if baseURL.Scheme == "" || baseURL.Host == "" {
+			return nil, fmt.Errorf("base url of http client %s must be absolute", config.Name)
+		}
+	}
+
+	timeout, err := util.ParseDuration(config.Timeout, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	connectTimeout, err := util.ParseDuration(config.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	responseHeaderTimeout, err := util.ParseDuration(config.ResponseHeaderTimeout, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout, err := util.ParseDuration(config.IdleConnTimeout, defaultIdleConnTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	maxIdleConnsPerHost := int(config.MaxIdleConnsPerHost)
+	if maxIdleConnsPerHost <= 0 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	tlsConfig, err := newTLSConfig(config.Tls)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls config of http client %s: %v", config.Name, err)
+	}
+
+	// the policy has no timeout of its own, which would cancel reading the response body once a request returns
+	// (attempts are bounded by the response header timeout instead)
+	policy, err := resilience.NewPolicy(config.Name, &proto.DependencyConfig{
+		Retry:    config.Retry,
+		Breaker:  config.Breaker,
+		Bulkhead: config.Bulkhead,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	redact := map[string]bool{}
+	for _, header := range append(append([]string{}, defaultRedactedHeaders...), redactHeaders...) {
+		redact[http.CanonicalHeaderKey(header)] = true
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: responseHeaderTimeout,
+		IdleConnTimeout:       idleConnTimeout,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		ForceAttemptHTTP2:     true,
+	}
+
+	return &Client{
+		Client: &http.Client{
+			Timeout: timeout,
+			Transport: &roundTripper{
+				name:    config.Name,
+				next:    transport,
+				baseURL: baseURL,
+				policy:  policy.WithClassifier(isFailure),
+				redact:  redact,
+				logger:  logger.WithField("client", config.Name),
+			},
+		},
+		name:      config.Name,
+		baseURL:   baseURL,
+		transport: transport,
+	}, nil
+}
+
+// Name returns the name of the client
+func (c *Client) Name() string {
+	return c.name
+}
+
+// NewRequest creates a request for the context, resolving the url against the base url of the client
+func (c *Client) NewRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	requestURL, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.baseURL != nil {
+		requestURL = c.baseURL.ResolveReference(requestURL)
+	}
+
+	return http.NewRequestWithContext(ctx, method, requestURL.String(), body)
+}
+
+// Close closes the idle connections of the client
+func (c *Client) Close() {
+	c.transport.CloseIdleConnections()
+}
+
+// newTLSConfig creates the TLS config of a client (nil keeps the defaults)
+func newTLSConfig(config *proto.HttpClientTlsConfig) (*tls.Config, error) {
+	if config == nil || (config.CaFile == "" && config.CertFile == "" && config.KeyFile == "" &&
+		config.ServerName == "" && !config.InsecureSkipVerify) {
+		return nil, nil
+	}
+
+	tlsConfig, err := client.LoadTLSConfig(config.CaFile, config.CertFile, config.KeyFile, config.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
+	return tlsConfig, nil
+}
+
+// statusError is the failure of an attempt whose response shows the API unavailable or overloaded
+type statusError struct {
+	code int
+}
+
+// Error returns the status of the response
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.code, http.StatusText(e.code))
+}
+
+// isFailure treats errors sending requests and responses showing the API unavailable or overloaded as failures
+func isFailure(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) || resilience.DefaultClassifier(err)
+}
+
+// isFailureStatus returns true for response status codes showing the API unavailable or overloaded
+func isFailureStatus(code int) bool {
+	return code >= http.StatusInternalServerError || code == http.StatusTooManyRequests
+}
+
+// roundTripper sends the requests of a client through its policy
+type roundTripper struct {
+	// name of the client
+	name string
+
+	// next sends the requests
+	next http.RoundTripper
+
+	// baseURL relative urls are resolved against (nil if not configured)
+	baseURL *url.URL
+
+	// policy guarding the requests
+	policy *resilience.Policy
+
+	// redact holds the canonical names of the headers whose values are not logged
+	redact map[string]bool
+
+	// logger object
+	logger *log.Entry
+}
+
+// RoundTrip sends a request, retrying it if it is safe to repeat
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = req.Clone(ctx)
+	if req.URL.Host == "" && t.baseURL != nil {
+		req.URL = t.baseURL.ResolveReference(req.URL)
+		req.Host = ""
+	}
+
+	// the values of the request being served are propagated, calls made outside of one get a request id
+	for header, value := range propagation.FromContext(ctx) {
+		if req.Header.Get(header) == "" {
+			req.Header.Set(header, value)
+		}
+	}
+
+	if req.Header.Get(propagation.RequestIDHeader) == "" {
+		req.Header.Set(propagation.RequestIDHeader, propagation.NewRequestID())
+	}
+
+	var resp *http.Response
+	attempts := 0
+	attempt := func(ctx context.Context) error {
+		attempts++
+		attemptReq := req.WithContext(ctx)
+		if attempts > 1 {
+			// the response of the failed attempt is discarded
+			discard(resp)
+			resp = nil
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return err
+				}
+
+				attemptReq.Body = body
+			}
+		}
+
+		var err error
+		if resp, err = t.next.RoundTrip(attemptReq); err != nil {
+			return err
+		}
+
+		if isFailureStatus(resp.StatusCode) {
+			return &statusError{code: resp.StatusCode}
+		}
+
+		return nil
+	}
+
+	start := time.Now()
+	var err error
+	if t.retryable(req) {
+		err = t.policy.Execute(ctx, attempt)
+	} else {
+		callCtx, done, guardErr := t.policy.Guard(ctx)
+		if err = guardErr; err == nil {
+			err = attempt(callCtx)
+			done(err)
+		}
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		err = nil
+	}
+
+	if err != nil {
+		discard(resp)
+		resp = nil
+	}
+
+	t.report(req, resp, err, attempts, time.Since(start))
+	return resp, err
+}
+
+// retryable returns true if the request is safe to repeat (idempotent method or an idempotency key) and its
+// body can be sent again
+func (t *roundTripper) retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return req.Header.Get(idempotencyKeyHeader) != ""
+	}
+}
+
+// report records the metrics and logs of a request
+func (t *roundTripper) report(req *http.Request, resp *http.Response, err error, attempts int,
+	duration time.Duration) {
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+
+	requestsTotal.WithLabelValues(t.name, req.Method, code).Inc()
+	requestDuration.WithLabelValues(t.name, req.Method).Observe(duration.Seconds())
+
+	// query strings may carry secrets, so only the scheme, host and path are logged
+	target := url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: req.URL.Path}
+	logger := t.logger.WithFields(log.Fields{
+		"method":     req.Method,
+		"url":        target.String(),
+		"status":     code,
+		"attempts":   attempts,
+		"durationMs": duration.Milliseconds(),
+		"requestId":  req.Header.Get(propagation.RequestIDHeader),
+	})
+
+	if logger.Logger.IsLevelEnabled(log.DebugLevel) {
+		logger = logger.WithField("headers", t.redactHeaders(req.Header))
+	}
+
+	switch {
+	case err != nil:
+		logger.Warnf("outbound request failed: %v", err)
+	case isFailureStatus(resp.StatusCode):
+		logger.Warn("outbound request failed")
+	default:
+		logger.Debug("outbound request completed")
+	}
+}
+
+// redactHeaders returns the headers to log, replacing the values of sensitive ones
+func (t *roundTripper) redactHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		if t.redact[http.CanonicalHeaderKey(name)] {
+			headers[name] = redactedValue
+			continue
+		}
+
+		if len(values) > 0 {
+			headers[name] = values[0]
+		}
+	}
+
+	return headers
+}
+
+// discard drains and closes the body of a response so its connection can be reused
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
@@ -0,0 +1,54 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// ErrClientNotFound is returned for clients missing from the config
+var ErrClientNotFound = errors.New("http client not found")
+
+// Factory holds the HTTP clients configured for the service by name
+type Factory struct {
+	clients map[string]*Client
+}
+
+// NewFactory creates the clients of the config
+func NewFactory(config *proto.HttpClientsConfig, logger *log.Entry) (*Factory, error) {
+	factory := &Factory{clients: map[string]*Client{}}
+	for _, clientConfig := range config.Clients {
+		if _, ok := factory.clients[clientConfig.Name]; ok {
+			return nil, fmt.Errorf("duplicate http client %s", clientConfig.Name)
+		}
+
+		client, err := NewClient(clientConfig, config.RedactHeaders, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		factory.clients[clientConfig.Name] = client
+	}
+
+	return factory, nil
+}
+
+// Client returns the client with the name, ErrClientNotFound if it is not configured
+func (f *Factory) Client(name string) (*Client, error) {
+	client, ok := f.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrClientNotFound, name)
+	}
+
+	return client, nil
+}
+
+// Close closes the idle connections of the clients
+func (f *Factory) Close() {
+	for _, client := range f.clients {
+		client.Close()
+	}
+}
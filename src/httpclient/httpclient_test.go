@@ -0,0 +1,164 @@
+// Contains http client unit testcases
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"test_service/propagation"
+	proto "test_service/protobuf/generated"
+	"test_service/resilience"
+)
+
+// TestClient unit tests base urls, propagation, retries, the circuit breaker and redacted logs of clients
+func TestClient(test *testing.T) {
+	var requests, failures int32
+	var lastRequestID, lastTraceParent, lastBody atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		body, _ := ioutil.ReadAll(r.Body)
+		lastBody.Store(string(body))
+		lastRequestID.Store(r.Header.Get(propagation.RequestIDHeader))
+		lastTraceParent.Store(r.Header.Get(propagation.TraceParentHeader))
+		if r.URL.Path == "/api/flaky" && atomic.AddInt32(&failures, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/api/down" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	logger := log.New()
+	logger.SetLevel(log.DebugLevel)
+	hook := logtest.NewLocal(logger)
+	factory, err := NewFactory(&proto.HttpClientsConfig{
+		Clients: []*proto.HttpClientConfig{{
+			Name:    "api",
+			BaseUrl: server.URL + "/api/",
+			Retry:   &proto.RetryConfig{MaxAttempts: 2, InitialBackoff: "1ms"},
+			Breaker: &proto.BreakerConfig{Enabled: true, FailureThreshold: 4, OpenTimeout: "1h"},
+		}},
+		RedactHeaders: []string{"X-Api-Key"},
+	}, logger.WithField("test", "httpclient"))
+	if err != nil {
+		test.Errorf("failed to create factory: %v", err)
+		return
+	}
+	defer factory.Close()
+
+	if _, err := factory.Client("missing"); !errors.Is(err, ErrClientNotFound) {
+		test.Errorf("unexpected error getting a missing client: %v", err)
+		return
+	}
+
+	client, err := factory.Client("api")
+	if err != nil {
+		test.Errorf("failed to get client: %v", err)
+		return
+	}
+
+	send := func(ctx context.Context, method, target, body string, headers map[string]string) (int, error) {
+		req, err := client.NewRequest(ctx, method, target, strings.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	// relative urls are resolved against the base url and the values of the request being served are propagated
+	ctx := propagation.NewContext(context.Background(), propagation.Values{
+		propagation.RequestIDHeader:   "request-1",
+		propagation.TraceParentHeader: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+	code, err := send(ctx, http.MethodGet, "items", "", map[string]string{"Authorization": "Bearer secret",
+		"X-Api-Key": "secret"})
+	if err != nil || code != http.StatusOK || lastRequestID.Load() != "request-1" ||
+		lastTraceParent.Load() == "" {
+		test.Errorf("unexpected response %d (request id %v): %v", code, lastRequestID.Load(), err)
+		return
+	}
+
+	// sensitive headers are redacted in logs
+	entry := hook.LastEntry()
+	headers, _ := entry.Data["headers"].(map[string]string)
+	if entry.Data["client"] != "api" || headers["Authorization"] != redactedValue ||
+		headers["X-Api-Key"] != redactedValue || headers[propagation.RequestIDHeader] != "request-1" {
+		test.Errorf("unexpected log entry %+v", entry.Data)
+		return
+	}
+
+	// calls made outside of a request are assigned a request id
+	if _, err := send(context.Background(), http.MethodGet, "items", "", nil); err != nil ||
+		lastRequestID.Load() == "" || lastRequestID.Load() == "request-1" {
+		test.Errorf("request id not generated (%v): %v", lastRequestID.Load(), err)
+		return
+	}
+
+	// idempotent requests and requests with an idempotency key are retried, resending their body
+	atomic.StoreInt32(&requests, 0)
+	for _, request := range []struct {
+		method  string
+		headers map[string]string
+	}{
+		{method: http.MethodGet},
+		{method: http.MethodPost, headers: map[string]string{"Idempotency-Key": "key-1"}},
+	} {
+		if code, err := send(ctx, request.method, "flaky", "payload", request.headers); err != nil ||
+			code != http.StatusOK || lastBody.Load() != "payload" {
+			test.Errorf("%s not retried (%d): %v", request.method, code, err)
+			return
+		}
+	}
+
+	if code, err := send(ctx, http.MethodPost, "flaky", "payload", nil); err != nil ||
+		code != http.StatusServiceUnavailable || atomic.LoadInt32(&requests) != 5 {
+		test.Errorf("post retried (%d, %d requests): %v", code, requests, err)
+		return
+	}
+
+	// the breaker opens after consecutive failures and fails requests fast
+	if _, err := send(ctx, http.MethodGet, "items", "", nil); err != nil {
+		test.Errorf("failed to send request: %v", err)
+		return
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	for i := 0; i < 2; i++ {
+		if code, err := send(ctx, http.MethodGet, "down", "", nil); err != nil || code != http.StatusBadGateway {
+			test.Errorf("unexpected response %d: %v", code, err)
+			return
+		}
+	}
+
+	if _, err := send(ctx, http.MethodGet, "items", "", nil); !errors.Is(err, resilience.ErrBreakerOpen) ||
+		atomic.LoadInt32(&requests) != 4 {
+		test.Errorf("request not failed fast (%d requests): %v", requests, err)
+		return
+	}
+}
@@ -0,0 +1,442 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"test_service/auth"
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/router"
+	"test_service/util"
+)
+
+// headers (metadata keys for RPCs) of idempotent requests
+const (
+	// HeaderName carries the idempotency key of a REST request
+	HeaderName = "Idempotency-Key"
+
+	// MetadataKey carries the idempotency key of an RPC
+	MetadataKey = "idempotency-key"
+
+	// ReplayedHeader is set on replayed responses (as response header metadata for RPCs)
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultWindow          = 24 * time.Hour
+	defaultInFlightTimeout = 1 * time.Minute
+
+	// maxKeyLength bounds the length of idempotency keys
+	maxKeyLength = 255
+
+	// storeTimeout bounds storing a response, which is done after the request's context may be done
+	storeTimeout = 5 * time.Second
+)
+
+// replayedHeaders are the response headers stored along with the body of REST responses
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// credentialRoutes issue plaintext api keys, which are emptied from their stored responses
+var credentialRoutes = map[string]bool{
+	"POST /v1/admin/apikeys":            true,
+	"POST /v1/admin/apikeys/:id/rotate": true,
+}
+
+// errors of requests repeating an idempotency key
+var (
+	errKeyReused = errors.New("idempotency key was used with a different request")
+	errInFlight  = errors.New("a request with the idempotency key is in progress")
+)
+
+// metrics exported by the middleware
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "idempotency_requests_total",
+		Help: "Number of requests carrying an idempotency key by result (new, replayed, reused, in_flight)",
+	}, []string{"result"})
+)
+
+// Idempotency replays the responses of requests retried with the same idempotency key
+// the first request with a key reserves it while it is in flight and stores its response for the window,
+// repeating the request then replays the response. reusing the key with a different request is rejected
+// (422 or InvalidArgument), as is repeating it while the first request is in flight (409 or Aborted).
+// keys are scoped to the authenticated caller, and failed requests (5xx, 429 or RPC errors) release their
+// key so they can be retried. store failures are logged and the request is let through (fail open)
+type Idempotency struct {
+	// store holding the records of the keys, namespaced with the prefix
+	store  Store
+	prefix string
+
+	// how long responses are replayed and in-flight requests hold their key
+	window          time.Duration
+	inFlightTimeout time.Duration
+
+	// routes and rpcs honoring keys
+	routes []string
+	rpcs   []string
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewIdempotency creates the middleware storing keys in the store, namespaced with the prefix (typically the
+// service name)
+func NewIdempotency(config *proto.IdempotencyConfig, store Store, prefix string, logger *log.Entry) (*Idempotency, error) {
+	window, err := util.ParseDuration(config.Window, defaultWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlightTimeout, err := util.ParseDuration(config.InFlightTimeout, defaultInFlightTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range config.Routes {
+		if _, _, err := util.SplitRoute(route); err != nil {
+			return nil, fmt.Errorf("idempotency: %v", err)
+		}
+	}
+
+	return &Idempotency{
+		store:           store,
+		prefix:          prefix,
+		window:          window,
+		inFlightTimeout: inFlightTimeout,
+		routes:          config.Routes,
+		rpcs:            config.Rpcs,
+		logger:          logger,
+	}, nil
+}
+
+// GinMiddleware honors the Idempotency-Key header of the configured (or mutating) routes
+func (i *Idempotency) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderName)
+		if key == "" || !i.honorsRoute(c.Request.Method, c.FullPath()) {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest,
+				gin.H{"error": fmt.Sprintf("idempotency key longer than %d characters", maxKeyLength)})
+			return
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			if errors.Is(err, router.ErrBodyTooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
+
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		ctx := c.Request.Context()
+		storeKey := i.storeKey(ctx, key)
+		fingerprint := fingerprint(c.Request.Method, c.Request.URL.RequestURI(), body)
+		reservation := newReservation(fingerprint)
+		record, err := i.reserve(ctx, storeKey, reservation)
+		switch {
+		case errors.Is(err, errKeyReused):
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, errInFlight):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.Next()
+			return
+		case record != nil:
+			for name, value := range record.Headers {
+				c.Header(name, value)
+			}
+
+			c.Header(ReplayedHeader, "true")
+			c.Writer.WriteHeader(record.StatusCode)
+			c.Writer.Write(record.Body)
+			c.Abort()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		statusCode := writer.Status()
+		if statusCode >= http.StatusInternalServerError || statusCode == http.StatusTooManyRequests {
+			i.release(storeKey, reservation)
+			return
+		}
+
+		record = &Record{Fingerprint: fingerprint, Completed: true, StatusCode: statusCode,
+			Headers: map[string]string{}, Body: redactBody(c.Request.Method, c.FullPath(), writer.body.Bytes())}
+		for _, name := range replayedHeaders {
+			if value := writer.Header().Get(name); value != "" {
+				record.Headers[name] = value
+			}
+		}
+
+		i.complete(storeKey, reservation, record)
+	}
+}
+
+// UnaryServerInterceptor honors the idempotency-key metadata of the configured (or all) unary RPCs
+func (i *Idempotency) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		keys := md.Get(MetadataKey)
+		request, ok := req.(protobuf.Message)
+		if len(keys) == 0 || keys[0] == "" || !ok || !i.honorsRPC(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		key := keys[0]
+		if len(key) > maxKeyLength {
+			return nil, status.Errorf(codes.InvalidArgument, "idempotency key longer than %d characters", maxKeyLength)
+		}
+
+		payload, err := protobuf.MarshalOptions{Deterministic: true}.Marshal(request)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to encode request: %v", err)
+		}
+
+		storeKey := i.storeKey(ctx, key)
+		fingerprint := fingerprint("RPC", info.FullMethod, payload)
+		reservation := newReservation(fingerprint)
+		record, err := i.reserve(ctx, storeKey, reservation)
+		switch {
+		case errors.Is(err, errKeyReused):
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		case errors.Is(err, errInFlight):
+			return nil, status.Error(codes.Aborted, err.Error())
+		case err != nil:
+			return handler(ctx, req)
+		case record != nil:
+			var response anypb.Any
+			if err := protobuf.Unmarshal(record.Body, &response); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to decode stored response: %v", err)
+			}
+
+			message, err := response.UnmarshalNew()
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to decode stored response: %v", err)
+			}
+
+			grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(ReplayedHeader), "true"))
+			return message, nil
+		}
+
+		resp, err := handler(ctx, req)
+		message, ok := resp.(protobuf.Message)
+		if err != nil || !ok {
+			i.release(storeKey, reservation)
+			return resp, err
+		}
+
+		response, err := anypb.New(redactMessage(message))
+		if err == nil {
+			var body []byte
+			if body, err = protobuf.Marshal(response); err == nil {
+				i.complete(storeKey, reservation, &Record{Fingerprint: fingerprint, Completed: true, Body: body})
+			}
+		}
+
+		if err != nil {
+			i.logger.Errorf("failed to encode response of %s for idempotency key: %v", info.FullMethod, err)
+			i.release(storeKey, reservation)
+		}
+
+		return resp, nil
+	}
+}
+
+// newReservation creates the in-flight record of a request, its token identifies the request holding the key
+func newReservation(fingerprint string) *Record {
+	return &Record{Fingerprint: fingerprint, Token: uuid.New().String()}
+}
+
+// reserve reserves the key for a request, returning the stored record of a completed request to replay
+// errKeyReused or errInFlight are returned if the key is held by another request
+func (i *Idempotency) reserve(ctx context.Context, storeKey string, reservation *Record) (*Record, error) {
+	fingerprint := reservation.Fingerprint
+	existing, err := i.store.Reserve(ctx, storeKey, reservation, i.inFlightTimeout)
+	switch {
+	case err != nil:
+		i.logger.Errorf("failed to reserve idempotency key, handling request without it: %v", err)
+		return nil, err
+	case existing == nil:
+		requestsTotal.WithLabelValues("new").Inc()
+		return nil, nil
+	case existing.Fingerprint != fingerprint:
+		requestsTotal.WithLabelValues("reused").Inc()
+		return nil, errKeyReused
+	case !existing.Completed:
+		requestsTotal.WithLabelValues("in_flight").Inc()
+		return nil, errInFlight
+	default:
+		requestsTotal.WithLabelValues("replayed").Inc()
+		return existing, nil
+	}
+}
+
+// complete stores the response of the request holding the key for the window
+// a request that outlived the in-flight timeout lost the key to a retry and leaves the retry's record alone
+func (i *Idempotency) complete(storeKey string, reservation, record *Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	err := i.store.Complete(ctx, storeKey, reservation, record, i.window)
+	switch {
+	case errors.Is(err, ErrReservationLost):
+		i.logger.Warnf("request outlived the in-flight timeout of its idempotency key, response not stored")
+	case err != nil:
+		i.logger.Errorf("failed to store response for idempotency key: %v", err)
+	}
+}
+
+// release releases the key of a failed request so it can be retried, unless the key was lost to a retry
+func (i *Idempotency) release(storeKey string, reservation *Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+	err := i.store.Release(ctx, storeKey, reservation)
+	if err != nil && !errors.Is(err, ErrReservationLost) {
+		i.logger.Errorf("failed to release idempotency key: %v", err)
+	}
+}
+
+// storeKey namespaces a key with the prefix and the caller, hashing it to bound its length
+func (i *Idempotency) storeKey(ctx context.Context, key string) string {
+	caller := "anonymous"
+	if principal := auth.PrincipalFromContext(ctx); principal.IsAuthenticated() {
+		caller = principal.Subject
+	}
+
+	digest := sha256.Sum256([]byte(caller + "\x00" + key))
+	return i.prefix + "/idempotency/" + hex.EncodeToString(digest[:])
+}
+
+// honorsRoute checks whether keys are honored on a route, all mutating routes if none are configured
+func (i *Idempotency) honorsRoute(method, route string) bool {
+	if route == "" {
+		return false
+	}
+
+	if len(i.routes) == 0 {
+		switch method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			return true
+		default:
+			return false
+		}
+	}
+
+	for _, pattern := range i.routes {
+		if util.MatchRoute(pattern, method, route) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// honorsRPC checks whether keys are honored on an RPC, all RPCs if none are configured
+func (i *Idempotency) honorsRPC(fullMethod string) bool {
+	if len(i.rpcs) == 0 {
+		return true
+	}
+
+	for _, pattern := range i.rpcs {
+		if util.MatchPattern(pattern, fullMethod) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fingerprint hashes the method, target and payload of a request
+func fingerprint(method, target string, payload []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + target + "\n"))
+	hash.Write(payload)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// redactBody empties the plaintext api key of the REST responses issuing credentials before they are stored
+// replays of key creations and rotations return the key's metadata only, secrets are never persisted
+func redactBody(method, route string, body []byte) []byte {
+	if !credentialRoutes[method+" "+route] {
+		return body
+	}
+
+	var response models.ApiKeyResponse
+	if err := json.Unmarshal(body, &response); err != nil || response.Key == "" {
+		return body
+	}
+
+	response.Key = ""
+	redacted, err := json.Marshal(response)
+	if err != nil {
+		return nil
+	}
+
+	return redacted
+}
+
+// redactMessage empties the plaintext api key of the RPC responses issuing credentials before they are stored
+func redactMessage(message protobuf.Message) protobuf.Message {
+	response, ok := message.(*proto.ApiKeyResponse)
+	if !ok || response.Key == "" {
+		return message
+	}
+
+	redacted := protobuf.Clone(response).(*proto.ApiKeyResponse)
+	redacted.Key = ""
+	return redacted
+}
+
+// recordingWriter copies the response body written by the handlers
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// Write writes the data to the response and the copy
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes the string to the response and the copy
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
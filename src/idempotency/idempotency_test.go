@@ -0,0 +1,376 @@
+// Contains idempotency unit testcases
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"test_service/kvstore"
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+	"test_service/router"
+)
+
+// TestGinMiddleware unit tests replaying, rejecting and releasing keys of REST requests with both backends
+func TestGinMiddleware(test *testing.T) {
+	logger := log.WithField("test", "idempotency")
+	repo, err := repository.NewRepository(&proto.DatastoreConfig{Driver: repository.DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	if _, err := migrator.Up(context.Background()); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	gin.SetMode(gin.TestMode)
+	for name, store := range map[string]Store{
+		BackendKVStore:   NewKVStore(kvstore.NewMemoryStore()),
+		BackendDatastore: NewRepositoryStore(repo, logger),
+	} {
+		idempotent, err := NewIdempotency(&proto.IdempotencyConfig{}, store, "test_service", logger)
+		if err != nil {
+			test.Errorf("%s: failed to create middleware: %v", name, err)
+			return
+		}
+
+		var created, failed int32
+		release := make(chan struct{})
+		router := gin.New()
+		router.Use(idempotent.GinMiddleware())
+		router.POST("/items", func(c *gin.Context) {
+			count := atomic.AddInt32(&created, 1)
+			if c.Query("slow") != "" {
+				<-release
+			}
+
+			c.Header("Location", "/items/1")
+			c.JSON(http.StatusCreated, gin.H{"created": count})
+		})
+		router.POST("/failing", func(c *gin.Context) {
+			if atomic.AddInt32(&failed, 1) == 1 {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "unavailable"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{})
+		})
+
+		request := func(target, key, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+			if key != "" {
+				req.Header.Set(HeaderName, key)
+			}
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		// the retried request is replayed without running the handler again
+		first := request("/items", "key-1", `{"name":"a"}`)
+		replayed := request("/items", "key-1", `{"name":"a"}`)
+		if first.Code != http.StatusCreated || replayed.Code != http.StatusCreated ||
+			replayed.Body.String() != first.Body.String() || replayed.Header().Get("Location") != "/items/1" ||
+			replayed.Header().Get(ReplayedHeader) != "true" || atomic.LoadInt32(&created) != 1 {
+			test.Errorf("%s: request not replayed (%d %s, %d %s)", name, first.Code, first.Body, replayed.Code,
+				replayed.Body)
+			return
+		}
+
+		// reusing the key with another request is rejected, requests without a key are not affected
+		if reused := request("/items", "key-1", `{"name":"b"}`); reused.Code != http.StatusUnprocessableEntity {
+			test.Errorf("%s: reused key not rejected: %d", name, reused.Code)
+			return
+		}
+
+		if request("/items", "", "").Code != http.StatusCreated || request("/items", "", "").Code != http.StatusCreated ||
+			atomic.LoadInt32(&created) != 3 {
+			test.Errorf("%s: requests without a key not handled", name)
+			return
+		}
+
+		if long := request("/items", strings.Repeat("k", maxKeyLength+1), ""); long.Code != http.StatusBadRequest {
+			test.Errorf("%s: long key not rejected: %d", name, long.Code)
+			return
+		}
+
+		// repeating a request in flight is rejected
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- request("/items?slow=true", "key-2", "")
+		}()
+
+		for atomic.LoadInt32(&created) != 4 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if conflict := request("/items?slow=true", "key-2", ""); conflict.Code != http.StatusConflict {
+			test.Errorf("%s: request in flight not rejected: %d", name, conflict.Code)
+			return
+		}
+
+		close(release)
+		if slow := <-done; slow.Code != http.StatusCreated {
+			test.Errorf("%s: slow request failed: %d", name, slow.Code)
+			return
+		}
+
+		// failed requests release their key so they can be retried
+		if request("/failing", "key-3", "").Code != http.StatusServiceUnavailable ||
+			request("/failing", "key-3", "").Code != http.StatusOK || atomic.LoadInt32(&failed) != 2 {
+			test.Errorf("%s: failed request not retried", name)
+			return
+		}
+
+		// bodies over the size limit of the route are rejected
+		req := httptest.NewRequest(http.MethodPost, "/items", tooLargeBody{})
+		req.Header.Set(HeaderName, "key-4")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			test.Errorf("%s: body too large not rejected: %d", name, recorder.Code)
+			return
+		}
+	}
+}
+
+// TestUnaryServerInterceptor unit tests replaying and rejecting keys of RPCs
+func TestUnaryServerInterceptor(test *testing.T) {
+	logger := log.WithField("test", "idempotency")
+	idempotent, err := NewIdempotency(&proto.IdempotencyConfig{Rpcs: []string{"/test_service.TestServiceRPC/Create*"}},
+		NewKVStore(kvstore.NewMemoryStore()), "test_service", logger)
+	if err != nil {
+		test.Errorf("failed to create interceptor: %v", err)
+		return
+	}
+
+	var calls int32
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		count := atomic.AddInt32(&calls, 1)
+		if req.(*proto.CreateApiKeyRequest).Name == "invalid" {
+			return nil, status.Error(codes.InvalidArgument, "invalid name")
+		}
+
+		return &proto.PingResponse{Message: strings.Repeat("x", int(count))}, nil
+	}
+
+	interceptor := idempotent.UnaryServerInterceptor()
+	call := func(method, key, name string) (interface{}, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, key))
+		return interceptor(ctx, &proto.CreateApiKeyRequest{Name: name},
+			&grpc.UnaryServerInfo{FullMethod: "/test_service.TestServiceRPC/" + method}, handler)
+	}
+
+	first, err := call("CreateApiKey", "key-1", "a")
+	if err != nil {
+		test.Errorf("failed to call: %v", err)
+		return
+	}
+
+	replayed, err := call("CreateApiKey", "key-1", "a")
+	if err != nil || replayed.(*proto.PingResponse).Message != first.(*proto.PingResponse).Message ||
+		atomic.LoadInt32(&calls) != 1 {
+		test.Errorf("call not replayed (%v): %v", replayed, err)
+		return
+	}
+
+	if _, err := call("CreateApiKey", "key-1", "b"); status.Code(err) != codes.InvalidArgument {
+		test.Errorf("reused key not rejected: %v", err)
+		return
+	}
+
+	// failed calls release their key, and keys of other RPCs are ignored
+	for i := 0; i < 2; i++ {
+		if _, err := call("CreateApiKey", "key-2", "invalid"); status.Code(err) != codes.InvalidArgument {
+			test.Errorf("unexpected error of failing call: %v", err)
+			return
+		}
+
+		if _, err := call("RotateApiKey", "key-3", "a"); err != nil {
+			test.Errorf("failed to call: %v", err)
+			return
+		}
+	}
+
+	if atomic.LoadInt32(&calls) != 5 {
+		test.Errorf("unexpected number of calls %d", calls)
+		return
+	}
+}
+
+// TestCredentialRedaction unit tests that plaintext api keys are never stored nor replayed
+func TestCredentialRedaction(test *testing.T) {
+	logger := log.WithField("test", "idempotency")
+	kv := kvstore.NewMemoryStore()
+	idempotent, err := NewIdempotency(&proto.IdempotencyConfig{}, NewKVStore(kv), "test_service", logger)
+	if err != nil {
+		test.Errorf("failed to create middleware: %v", err)
+		return
+	}
+
+	const secret = "tsk_secret"
+	interceptor := idempotent.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/test_service.TestServiceRPC/CreateApiKey"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &proto.ApiKeyResponse{ApiKey: &proto.ApiKey{Id: "1"}, Key: secret}, nil
+	}
+
+	var responses []*proto.ApiKeyResponse
+	for i := 0; i < 2; i++ {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(MetadataKey, "key-1"))
+		response, err := interceptor(ctx, &proto.CreateApiKeyRequest{Name: "a"}, info, handler)
+		if err != nil {
+			test.Errorf("failed to call: %v", err)
+			return
+		}
+
+		responses = append(responses, response.(*proto.ApiKeyResponse))
+	}
+
+	// the first call returns the key, the replay only its metadata
+	if responses[0].Key != secret || responses[1].Key != "" || responses[1].ApiKey.GetId() != "1" {
+		test.Errorf("unexpected responses %v", responses)
+		return
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(idempotent.GinMiddleware())
+	router.POST("/v1/admin/apikeys", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, models.ApiKeyResponse{ApiKey: &models.ApiKey{ID: "2"}, Key: secret})
+	})
+
+	var bodies []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/admin/apikeys", strings.NewReader(`{"name":"b"}`))
+		req.Header.Set(HeaderName, "key-2")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		bodies = append(bodies, recorder.Body.String())
+	}
+
+	if !strings.Contains(bodies[0], secret) || strings.Contains(bodies[1], secret) ||
+		!strings.Contains(bodies[1], `"id":"2"`) {
+		test.Errorf("unexpected bodies %v", bodies)
+		return
+	}
+
+	// stored records never hold the key
+	stored, err := kv.Scan(context.Background(), "")
+	if err != nil || len(stored) != 2 {
+		test.Errorf("unexpected stored records %v: %v", stored, err)
+		return
+	}
+
+	for _, value := range stored {
+		var record Record
+		if err := json.Unmarshal(value.Value, &record); err != nil || !record.Completed ||
+			strings.Contains(string(record.Body), secret) {
+			test.Errorf("key stored in record %s (%s): %v", value.Key, record.Body, err)
+			return
+		}
+	}
+}
+
+// TestReservations unit tests that requests outliving their reservation leave the key of a retry alone
+func TestReservations(test *testing.T) {
+	logger := log.WithField("test", "idempotency")
+	repo, err := repository.NewRepository(&proto.DatastoreConfig{Driver: repository.DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	for name, store := range map[string]Store{
+		BackendKVStore:   NewKVStore(kvstore.NewMemoryStore()),
+		BackendDatastore: NewRepositoryStore(repo, logger),
+	} {
+		// the first request outlives its reservation and a retry reserves the key again
+		first, retry := newReservation("fingerprint"), newReservation("fingerprint")
+		if existing, err := store.Reserve(ctx, "key", first, 10*time.Millisecond); err != nil || existing != nil {
+			test.Errorf("%s: failed to reserve key (existing %v): %v", name, existing, err)
+			return
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if existing, err := store.Reserve(ctx, "key", retry, time.Minute); err != nil || existing != nil {
+			test.Errorf("%s: expired reservation not replaced (existing %v): %v", name, existing, err)
+			return
+		}
+
+		// the first request can neither complete nor release the retry's reservation
+		completed := &Record{Fingerprint: "fingerprint", Completed: true, StatusCode: http.StatusOK}
+		if err := store.Complete(ctx, "key", first, completed, time.Minute); !errors.Is(err, ErrReservationLost) {
+			test.Errorf("%s: lost reservation completed: %v", name, err)
+			return
+		}
+
+		if err := store.Release(ctx, "key", first); !errors.Is(err, ErrReservationLost) {
+			test.Errorf("%s: lost reservation released: %v", name, err)
+			return
+		}
+
+		existing, err := store.Reserve(ctx, "key", newReservation("fingerprint"), time.Minute)
+		if err != nil || existing == nil || existing.Completed || existing.Token != retry.Token {
+			test.Errorf("%s: retry's reservation not kept (existing %v): %v", name, existing, err)
+			return
+		}
+
+		// the retry holding the key completes it
+		if err := store.Complete(ctx, "key", retry, completed, time.Minute); err != nil {
+			test.Errorf("%s: failed to complete key: %v", name, err)
+			return
+		}
+
+		if err := store.Release(ctx, "key", retry); !errors.Is(err, ErrReservationLost) {
+			test.Errorf("%s: completed key released: %v", name, err)
+			return
+		}
+	}
+}
+
+// tooLargeBody fails reads like a body over the size limit of its route
+type tooLargeBody struct{}
+
+// Read fails with router.ErrBodyTooLarge
+func (tooLargeBody) Read(p []byte) (int, error) {
+	return 0, router.ErrBodyTooLarge
+}
@@ -0,0 +1,256 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/kvstore"
+	"test_service/models"
+	"test_service/repository"
+)
+
+// supported backends storing idempotency keys
+const (
+	// BackendKVStore keeps keys in the kv store, expiring with its ttl
+	BackendKVStore = "kvstore"
+
+	// BackendDatastore keeps keys in the datastore (idempotency_keys table)
+	BackendDatastore = "datastore"
+)
+
+// expired keys are deleted from the datastore at this interval
+const cleanupInterval = 10 * time.Minute
+
+// maxReserveAttempts bounds retrying to reserve a key whose record expired while reading it
+const maxReserveAttempts = 3
+
+// ErrReservationLost is returned when completing or releasing a key no longer held by the reservation,
+// because it expired after the in-flight timeout and was reserved again by a retry
+var ErrReservationLost = errors.New("idempotency key reservation lost")
+
+// Record is the state of a request made with an idempotency key
+type Record struct {
+	// Fingerprint of the request (method, path or RPC and payload)
+	Fingerprint string `json:"fingerprint"`
+
+	// Token identifies the reservation of an in-flight request
+	Token string `json:"token,omitempty"`
+
+	// Completed is set once the response is stored, the request is in flight until then
+	Completed bool `json:"completed"`
+
+	// StatusCode, Headers and Body of the response (Body holds the encoded response message of RPCs)
+	StatusCode int               `json:"statusCode,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       []byte            `json:"body,omitempty"`
+}
+
+// Store holds the records of idempotency keys
+type Store interface {
+	// Reserve records an in-flight request for the key for the ttl, unless the key has a record which is returned
+	Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, error)
+
+	// Complete replaces the reserved record of the key, keeping it for the ttl
+	// ErrReservationLost is returned if the key no longer holds the reservation
+	Complete(ctx context.Context, key string, reserved, record *Record, ttl time.Duration) error
+
+	// Release removes the reserved record of the key so the request can be made again
+	// ErrReservationLost is returned if the key no longer holds the reservation
+	Release(ctx context.Context, key string, reserved *Record) error
+}
+
+// KVStore is a Store keeping records in the kv store
+type KVStore struct {
+	store kvstore.Store
+}
+
+// NewKVStore creates a store keeping records in the kv store
+func NewKVStore(store kvstore.Store) *KVStore {
+	return &KVStore{store: store}
+}
+
+// Reserve creates the key if it does not exist, otherwise returns its record
+func (k *KVStore) Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < maxReserveAttempts; attempt++ {
+		created, err := k.store.CompareAndSwap(ctx, key, nil, value, ttl)
+		if err != nil || created {
+			return nil, err
+		}
+
+		existing, found, err := k.store.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// the record expired in between, try creating it again
+		if !found {
+			continue
+		}
+
+		var existingRecord Record
+		if err := json.Unmarshal(existing, &existingRecord); err != nil {
+			return nil, fmt.Errorf("corrupt idempotency record %s: %v", key, err)
+		}
+
+		return &existingRecord, nil
+	}
+
+	return nil, fmt.Errorf("failed to reserve idempotency key %s after %d attempts", key, maxReserveAttempts)
+}
+
+// Complete swaps the reserved record of the key for the completed one
+func (k *KVStore) Complete(ctx context.Context, key string, reserved, record *Record, ttl time.Duration) error {
+	old, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	swapped, err := k.store.CompareAndSwap(ctx, key, old, value, ttl)
+	if err == nil && !swapped {
+		return ErrReservationLost
+	}
+
+	return err
+}
+
+// Release deletes the key if it holds the reserved record
+func (k *KVStore) Release(ctx context.Context, key string, reserved *Record) error {
+	old, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := k.store.CompareAndDelete(ctx, key, old)
+	if err == nil && !deleted {
+		return ErrReservationLost
+	}
+
+	return err
+}
+
+// RepositoryStore is a Store keeping records in the datastore
+// expired records are deleted periodically while keys are being reserved
+type RepositoryStore struct {
+	repo repository.IdempotencyKeyRepository
+
+	// logger object
+	logger *log.Entry
+
+	// lock guards when expired records were last deleted
+	lock        sync.Mutex
+	lastCleanup time.Time
+}
+
+// NewRepositoryStore creates a store keeping records in the datastore
+func NewRepositoryStore(repo repository.IdempotencyKeyRepository, logger *log.Entry) *RepositoryStore {
+	return &RepositoryStore{repo: repo, logger: logger, lastCleanup: time.Now()}
+}
+
+// Reserve creates the key if it does not exist (or expired), otherwise returns its record
+func (r *RepositoryStore) Reserve(ctx context.Context, key string, record *Record, ttl time.Duration) (*Record, error) {
+	r.cleanup()
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < maxReserveAttempts; attempt++ {
+		created, err := r.repo.CreateIdempotencyKey(ctx, &models.IdempotencyKey{Key: key, Record: value,
+			ExpiresAt: time.Now().Add(ttl)})
+		if err != nil || created {
+			return nil, err
+		}
+
+		existing, err := r.repo.GetIdempotencyKey(ctx, key)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var existingRecord Record
+		if err := json.Unmarshal(existing.Record, &existingRecord); err != nil {
+			return nil, fmt.Errorf("corrupt idempotency record %s: %v", key, err)
+		}
+
+		return &existingRecord, nil
+	}
+
+	return nil, fmt.Errorf("failed to reserve idempotency key %s after %d attempts", key, maxReserveAttempts)
+}
+
+// Complete updates the reserved record of the key
+func (r *RepositoryStore) Complete(ctx context.Context, key string, reserved, record *Record, ttl time.Duration) error {
+	old, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	err = r.repo.UpdateIdempotencyKey(ctx, key, old, value, time.Now().Add(ttl))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrReservationLost
+	}
+
+	return err
+}
+
+// Release deletes the key if it holds the reserved record
+func (r *RepositoryStore) Release(ctx context.Context, key string, reserved *Record) error {
+	old, err := json.Marshal(reserved)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := r.repo.DeleteIdempotencyKey(ctx, key, old)
+	if err == nil && !deleted {
+		return ErrReservationLost
+	}
+
+	return err
+}
+
+// cleanup deletes the expired records in the background if they were not deleted for a while
+func (r *RepositoryStore) cleanup() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if time.Since(r.lastCleanup) < cleanupInterval {
+		return
+	}
+
+	r.lastCleanup = time.Now()
+	go func() {
+		deleted, err := r.repo.DeleteExpiredIdempotencyKeys(context.Background(), time.Now())
+		if err != nil {
+			r.logger.Errorf("failed to delete expired idempotency keys: %v", err)
+			return
+		}
+
+		if deleted > 0 {
+			r.logger.Infof("deleted %d expired idempotency keys", deleted)
+		}
+	}()
+}
@@ -0,0 +1,131 @@
+// Introspection package describes the services hosted by the RPC server using the
+// protobuf descriptors compiled into the binary, so a running instance can be explored
+// without access to the .proto files
+
+package introspection
+
+import (
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	"test_service/models"
+)
+
+// DescribeServices describes the services registered with the rpc server along with
+// the schemas of all messages they use
+func DescribeServices(server *grpc.Server) *models.RpcServicesResponse {
+	response := &models.RpcServicesResponse{
+		Services: []models.RpcService{},
+		Messages: make(map[string]models.RpcMessage),
+	}
+
+	serviceInfo := server.GetServiceInfo()
+	names := make([]string, 0, len(serviceInfo))
+	for name := range serviceInfo {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		service := models.RpcService{Name: name, Methods: []models.RpcMethod{}}
+		descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+		serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+		if err != nil || !ok {
+			// no descriptor compiled in, fall back to the method names known to grpc
+			for _, method := range serviceInfo[name].Methods {
+				service.Methods = append(service.Methods, models.RpcMethod{
+					Name:            method.Name,
+					FullMethod:      "/" + name + "/" + method.Name,
+					ClientStreaming: method.IsClientStream,
+					ServerStreaming: method.IsServerStream,
+				})
+			}
+
+			response.Services = append(response.Services, service)
+			continue
+		}
+
+		service.File = serviceDescriptor.ParentFile().Path()
+		methods := serviceDescriptor.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			method := methods.Get(i)
+			service.Methods = append(service.Methods, models.RpcMethod{
+				Name:            string(method.Name()),
+				FullMethod:      "/" + name + "/" + string(method.Name()),
+				Request:         string(method.Input().FullName()),
+				Response:        string(method.Output().FullName()),
+				ClientStreaming: method.IsStreamingClient(),
+				ServerStreaming: method.IsStreamingServer(),
+			})
+
+			describeMessage(method.Input(), response.Messages)
+			describeMessage(method.Output(), response.Messages)
+		}
+
+		response.Services = append(response.Services, service)
+	}
+
+	return response
+}
+
+// describeMessage adds the schema of a message, and of all messages it references, to messages
+func describeMessage(descriptor protoreflect.MessageDescriptor, messages map[string]models.RpcMessage) {
+	name := string(descriptor.FullName())
+	if _, ok := messages[name]; ok {
+		return
+	}
+
+	message := models.RpcMessage{Fields: []models.RpcField{}}
+
+	// register before walking the fields to terminate on recursive messages
+	messages[name] = message
+
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		rpcField := models.RpcField{
+			Name:     string(field.Name()),
+			JsonName: field.JSONName(),
+			Number:   int32(field.Number()),
+			Type:     field.Kind().String(),
+			Repeated: field.IsList(),
+			Map:      field.IsMap(),
+		}
+
+		if oneOf := field.ContainingOneof(); oneOf != nil && !oneOf.IsSynthetic() {
+			rpcField.OneOf = string(oneOf.Name())
+		}
+
+		switch {
+		case field.IsMap():
+			rpcField.TypeName = "map<" + fieldTypeName(field.MapKey()) + ", " + fieldTypeName(field.MapValue()) + ">"
+			if valueMessage := field.MapValue().Message(); valueMessage != nil {
+				describeMessage(valueMessage, messages)
+			}
+		case field.Message() != nil:
+			rpcField.TypeName = string(field.Message().FullName())
+			describeMessage(field.Message(), messages)
+		case field.Enum() != nil:
+			rpcField.TypeName = string(field.Enum().FullName())
+		}
+
+		message.Fields = append(message.Fields, rpcField)
+	}
+
+	messages[name] = message
+}
+
+// fieldTypeName returns the message/enum name of a field, or its scalar kind
+func fieldTypeName(field protoreflect.FieldDescriptor) string {
+	switch {
+	case field.Message() != nil:
+		return string(field.Message().FullName())
+	case field.Enum() != nil:
+		return string(field.Enum().FullName())
+	default:
+		return field.Kind().String()
+	}
+}
@@ -0,0 +1,199 @@
+// Contains job queue unit testcases
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+)
+
+// TestJobQueue unit tests priorities, delayed jobs, unique keys, retries and draining of the job queue
+func TestJobQueue(test *testing.T) {
+	logger := log.WithField("test", "jobqueue")
+	repo, err := repository.NewRepository(&proto.DatastoreConfig{Driver: repository.DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	config := &proto.JobQueueConfig{Concurrency: 1, PollInterval: "10ms", MinRetryBackoff: "1ms",
+		MaxRetryBackoff: "1ms", HandlerTimeout: "1s", LockTimeout: "2s"}
+	queue := NewQueue(config, repo, logger)
+
+	// jobs enqueued in a rolled back transaction are never run
+	rollback := errors.New("rollback")
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := queue.Enqueue(ctx, "email", map[string]string{"to": "rolled-back"}); err != nil {
+			return err
+		}
+
+		return rollback
+	})
+	if err != rollback {
+		test.Errorf("unexpected transaction error: %v", err)
+		return
+	}
+
+	// jobs run by priority, delayed jobs once due, and unique keys are held while a job is pending
+	for _, enqueue := range []struct {
+		to      string
+		options []EnqueueOption
+	}{
+		{to: "low"},
+		{to: "high", options: []EnqueueOption{WithPriority(10), WithUniqueKey("high")}},
+		{to: "later", options: []EnqueueOption{WithPriority(20), RunAt(time.Now().Add(200 * time.Millisecond))}},
+		{to: "fail", options: []EnqueueOption{WithMaxAttempts(2)}},
+	} {
+		if _, err := queue.Enqueue(ctx, "email", map[string]string{"to": enqueue.to}, enqueue.options...); err != nil {
+			test.Errorf("failed to enqueue job: %v", err)
+			return
+		}
+	}
+
+	duplicate, err := queue.Enqueue(ctx, "email", map[string]string{"to": "duplicate"}, WithUniqueKey("high"))
+	if err != ErrDuplicateJob || duplicate == nil || duplicate.Priority != 10 {
+		test.Errorf("duplicate job enqueued (%+v): %v", duplicate, err)
+		return
+	}
+
+	var lock sync.Mutex
+	var handled []string
+	release := make(chan struct{})
+	worker, err := NewWorker(config, queue, map[string]Handler{
+		"email": func(ctx context.Context, job *Job) error {
+			var payload map[string]string
+			if err := job.Decode(&payload); err != nil {
+				return err
+			}
+
+			lock.Lock()
+			handled = append(handled, payload["to"])
+			lock.Unlock()
+
+			switch payload["to"] {
+			case "fail":
+				return errors.New("failure")
+			case "slow":
+				<-release
+			}
+
+			return nil
+		},
+	}, "worker", logger)
+	if err != nil {
+		test.Errorf("failed to create worker: %v", err)
+		return
+	}
+
+	worker.Start()
+	if !waitFor(func() bool {
+		counts, err := queue.Counts(ctx)
+		return err == nil && len(counts) == 2 && counts[0].Status == models.JobFailed && counts[0].Count == 1 &&
+			counts[1].Status == models.JobSucceeded && counts[1].Count == 3
+	}) {
+		counts, _ := queue.Counts(ctx)
+		test.Errorf("jobs not run (handled %v): %+v", handled, counts)
+		return
+	}
+
+	lock.Lock()
+	order := handled
+	lock.Unlock()
+	if len(order) != 5 || order[0] != "high" || order[4] != "later" {
+		test.Errorf("unexpected order of jobs %v", order)
+		return
+	}
+
+	failed, err := queue.List(ctx, repository.BackgroundJobFilter{Status: models.JobFailed})
+	if err != nil || len(failed) != 1 || failed[0].Attempts != 2 || failed[0].LastError != "failure" {
+		test.Errorf("unexpected failed jobs %+v: %v", failed, err)
+		return
+	}
+
+	if job, err := queue.Get(ctx, failed[0].ID); err != nil || job.FinishedAt == nil {
+		test.Errorf("unexpected job %+v: %v", job, err)
+		return
+	}
+
+	if _, err := queue.Get(ctx, 1000); err != ErrJobNotFound {
+		test.Errorf("unexpected error getting a missing job: %v", err)
+		return
+	}
+
+	// the unique key is released once the job finished
+	if _, err := queue.Enqueue(ctx, "email", map[string]string{"to": "slow"}, WithUniqueKey("high")); err != nil {
+		test.Errorf("failed to enqueue job: %v", err)
+		return
+	}
+
+	// stopping the worker waits for running jobs
+	if !waitFor(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(handled) == 6
+	}) {
+		test.Errorf("slow job not run")
+		return
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		worker.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		test.Errorf("worker stopped with a job running")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		test.Errorf("worker not stopped after draining")
+		return
+	}
+
+	if running, err := queue.List(ctx, repository.BackgroundJobFilter{Status: models.JobRunning}); err != nil ||
+		len(running) != 0 {
+		test.Errorf("jobs left running %+v: %v", running, err)
+		return
+	}
+}
+
+// waitFor polls the condition for up to 5 seconds
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
@@ -0,0 +1,176 @@
+package jobqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultMaxAttempts = 10
+)
+
+// errors returned by the queue
+var (
+	ErrDuplicateJob = errors.New("a job with the unique key is already pending or running")
+	ErrJobNotFound  = errors.New("job not found")
+)
+
+// metrics exported by the queue, labelled by job type
+var (
+	enqueuedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jobqueue_jobs_enqueued_total",
+		Help: "Number of jobs enqueued",
+	}, []string{"type"})
+)
+
+// Store is the datastore holding the jobs
+type Store interface {
+	repository.Transactor
+	repository.BackgroundJobRepository
+}
+
+// Job is a job being run, passed to its handler
+type Job struct {
+	*models.BackgroundJob
+}
+
+// Decode decodes the JSON payload of the job into v
+func (j *Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs the jobs of a type, a returned error (or panic) retries the job with backoff
+// handlers may run a job more than once (e.g. when a worker stops responding), so they should be idempotent
+type Handler func(ctx context.Context, job *Job) error
+
+// EnqueueOption configures a job being enqueued
+type EnqueueOption func(job *models.BackgroundJob)
+
+// WithPriority sets the priority of the job, jobs with a greater priority run first (0 by default)
+func WithPriority(priority int32) EnqueueOption {
+	return func(job *models.BackgroundJob) {
+		job.Priority = priority
+	}
+}
+
+// RunAt delays the job until the given time
+func RunAt(runAt time.Time) EnqueueOption {
+	return func(job *models.BackgroundJob) {
+		job.RunAt = runAt
+	}
+}
+
+// WithMaxAttempts sets how many times the job is attempted before it fails
+func WithMaxAttempts(maxAttempts int32) EnqueueOption {
+	return func(job *models.BackgroundJob) {
+		job.MaxAttempts = maxAttempts
+	}
+}
+
+// WithUniqueKey de-duplicates the job, it is not enqueued while a pending or running job has the same key
+func WithUniqueKey(key string) EnqueueOption {
+	return func(job *models.BackgroundJob) {
+		job.UniqueKey = &key
+	}
+}
+
+// Queue enqueues jobs in the datastore and reports their status, jobs are run by workers (of any replica)
+type Queue struct {
+	// store holding the jobs
+	store Store
+
+	// maxAttempts of jobs enqueued without one
+	maxAttempts int32
+
+	// logger object
+	logger *log.Entry
+
+	// wake signals the workers of this replica that a job is due
+	wake chan struct{}
+}
+
+// NewQueue creates a job queue stored in the store
+func NewQueue(config *proto.JobQueueConfig, store Store, logger *log.Entry) *Queue {
+	maxAttempts := config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	return &Queue{store: store, maxAttempts: maxAttempts, logger: logger, wake: make(chan struct{}, 1)}
+}
+
+// Enqueue records a job of the given type with a JSON encoded payload
+// called with a context from WithTx, the job is only run if the transaction commits. ErrDuplicateJob is
+// returned along with the existing job if a pending or running job holds the job's unique key
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{},
+	options ...EnqueueOption) (*models.BackgroundJob, error) {
+	job := &models.BackgroundJob{Type: jobType, Status: models.JobPending, MaxAttempts: q.maxAttempts}
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		job.Payload = encoded
+	}
+
+	for _, option := range options {
+		option(job)
+	}
+
+	now := time.Now()
+	if job.RunAt.IsZero() {
+		job.RunAt = now
+	}
+
+	created, err := q.store.EnqueueBackgroundJob(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	if !created {
+		return job, ErrDuplicateJob
+	}
+
+	enqueuedTotal.WithLabelValues(jobType).Inc()
+	if !job.RunAt.After(now) {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return job, nil
+}
+
+// Get fetches a job by its id
+func (q *Queue) Get(ctx context.Context, id int64) (*models.BackgroundJob, error) {
+	job, err := q.store.GetBackgroundJob(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrJobNotFound
+	}
+
+	return job, err
+}
+
+// List fetches the most recent jobs matching the filter
+func (q *Queue) List(ctx context.Context, filter repository.BackgroundJobFilter) ([]models.BackgroundJob, error) {
+	return q.store.ListBackgroundJobs(ctx, filter)
+}
+
+// Counts counts the jobs of each type by status
+func (q *Queue) Counts(ctx context.Context) ([]models.BackgroundJobCount, error) {
+	return q.store.CountBackgroundJobs(ctx)
+}
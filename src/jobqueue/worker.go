@@ -0,0 +1,370 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultConcurrency     = 1
+	defaultPollInterval    = 1 * time.Second
+	defaultHandlerTimeout  = 5 * time.Minute
+	defaultLockTimeout     = 10 * time.Minute
+	defaultMinRetryBackoff = 1 * time.Second
+	defaultMaxRetryBackoff = 1 * time.Hour
+	defaultDrainTimeout    = 30 * time.Second
+	defaultRetention       = 7 * 24 * time.Hour
+
+	// finished jobs are deleted at this interval
+	cleanupInterval = 10 * time.Minute
+
+	// storeTimeout bounds recording the outcome of a job, which is done after the job's context is done
+	storeTimeout = 10 * time.Second
+)
+
+// metrics exported by the workers, labelled by job type
+var (
+	processedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "jobqueue_jobs_processed_total",
+		Help: "Number of job attempts by result (succeeded, retried, failed)",
+	}, []string{"type", "result"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "jobqueue_job_duration_seconds",
+		Help:    "Time taken to run a job",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"type"})
+
+	jobsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "jobqueue_jobs_in_flight",
+		Help: "Number of jobs being run",
+	}, []string{"type"})
+)
+
+// Worker is a pool running the jobs of the queue that have a registered handler
+// jobs are claimed from the datastore (workers of several replicas may run concurrently) and locked for the
+// lock timeout, after which a job whose worker stopped responding is run again. stopping the worker stops
+// claiming jobs and waits for the running ones (up to the drain timeout, after which they are cancelled and
+// put back in the queue)
+type Worker struct {
+	// queue the jobs are claimed from and the handlers by job type
+	queue    *Queue
+	handlers map[string]Handler
+	types    []string
+
+	// identity of the worker, recorded on the jobs it claims
+	identity string
+
+	// worker settings
+	concurrency     int
+	pollInterval    time.Duration
+	handlerTimeout  time.Duration
+	lockTimeout     time.Duration
+	minRetryBackoff time.Duration
+	maxRetryBackoff time.Duration
+	drainTimeout    time.Duration
+	retention       time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// ctx is cancelled to stop claiming jobs, handlerCtx to interrupt handlers after the drain timeout
+	ctx           context.Context
+	cancel        context.CancelFunc
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+	wg            sync.WaitGroup
+	inFlight      sync.WaitGroup
+}
+
+// NewWorker creates a worker running the queue's jobs with the handlers (by job type)
+func NewWorker(config *proto.JobQueueConfig, queue *Queue, handlers map[string]Handler, identity string,
+	logger *log.Entry) (*Worker, error) {
+	pollInterval, err := util.ParseDuration(config.PollInterval, defaultPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerTimeout, err := util.ParseDuration(config.HandlerTimeout, defaultHandlerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	lockTimeout, err := util.ParseDuration(config.LockTimeout, defaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if lockTimeout <= handlerTimeout {
+		return nil, fmt.Errorf("job lock timeout %s must be greater than the handler timeout %s",
+			lockTimeout, handlerTimeout)
+	}
+
+	minRetryBackoff, err := util.ParseDuration(config.MinRetryBackoff, defaultMinRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetryBackoff, err := util.ParseDuration(config.MaxRetryBackoff, defaultMaxRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	drainTimeout, err := util.ParseDuration(config.DrainTimeout, defaultDrainTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	retention, err := util.ParseDuration(config.Retention, defaultRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := int(config.Concurrency)
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	types := make([]string, 0, len(handlers))
+	for jobType := range handlers {
+		types = append(types, jobType)
+	}
+
+	sort.Strings(types)
+	ctx, cancel := context.WithCancel(context.Background())
+	handlerCtx, handlerCancel := context.WithCancel(context.Background())
+	return &Worker{
+		queue:           queue,
+		handlers:        handlers,
+		types:           types,
+		identity:        identity,
+		concurrency:     concurrency,
+		pollInterval:    pollInterval,
+		handlerTimeout:  handlerTimeout,
+		lockTimeout:     lockTimeout,
+		minRetryBackoff: minRetryBackoff,
+		maxRetryBackoff: maxRetryBackoff,
+		drainTimeout:    drainTimeout,
+		retention:       retention,
+		logger:          logger,
+		ctx:             ctx,
+		cancel:          cancel,
+		handlerCtx:      handlerCtx,
+		handlerCancel:   handlerCancel,
+	}, nil
+}
+
+// Start claims and runs jobs, and cleans up finished jobs, in the background until stopped
+func (w *Worker) Start() {
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		w.claim()
+	}()
+
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(cleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				w.cleanup()
+			}
+		}
+	}()
+}
+
+// Stop stops claiming jobs and drains the running ones
+// jobs still running after the drain timeout are cancelled and put back in the queue
+func (w *Worker) Stop() {
+	w.cancel()
+	w.wg.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		w.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(w.drainTimeout):
+		w.logger.Warnf("cancelling running jobs after waiting %s", w.drainTimeout)
+		w.handlerCancel()
+		<-drained
+	}
+
+	w.handlerCancel()
+}
+
+// claim claims due jobs while worker slots are free, polling when no job is due
+func (w *Worker) claim() {
+	if len(w.types) == 0 {
+		return
+	}
+
+	slots := make(chan struct{}, w.concurrency)
+	for {
+		// wait for a free slot and take the other free ones, claiming a job for each
+		select {
+		case slots <- struct{}{}:
+		case <-w.ctx.Done():
+			return
+		}
+
+		free := 1
+		for free < w.concurrency {
+			select {
+			case slots <- struct{}{}:
+				free++
+				continue
+			default:
+			}
+
+			break
+		}
+
+		now := time.Now()
+		jobs, err := w.queue.store.ClaimBackgroundJobs(w.ctx, w.types, free, w.identity, now, now.Add(w.lockTimeout))
+		for i := len(jobs); i < free; i++ {
+			<-slots
+		}
+
+		if err != nil && w.ctx.Err() == nil {
+			w.logger.Errorf("failed to claim jobs: %v", err)
+		}
+
+		for i := range jobs {
+			job := jobs[i]
+			w.inFlight.Add(1)
+			jobsInFlight.WithLabelValues(job.Type).Inc()
+			go func() {
+				defer func() {
+					jobsInFlight.WithLabelValues(job.Type).Dec()
+					<-slots
+					w.inFlight.Done()
+				}()
+
+				w.run(&job)
+			}()
+		}
+
+		if len(jobs) == free {
+			continue
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.queue.wake:
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
+
+// run runs a claimed job and records its outcome
+func (w *Worker) run(job *models.BackgroundJob) {
+	// a job claimed again after its lock expired may have exhausted its attempts without recording a failure
+	if job.Attempts > job.MaxAttempts {
+		w.finish(job, "failed", func(ctx context.Context) error {
+			return w.queue.store.FailBackgroundJob(ctx, job.ID, job.Attempts, time.Now(),
+				"job lock expired after its last attempt")
+		})
+
+		return
+	}
+
+	start := time.Now()
+	err := w.invoke(job)
+	jobDuration.WithLabelValues(job.Type).Observe(time.Since(start).Seconds())
+
+	switch {
+	case err == nil:
+		w.finish(job, "succeeded", func(ctx context.Context) error {
+			return w.queue.store.CompleteBackgroundJob(ctx, job.ID, job.Attempts, time.Now())
+		})
+	case w.handlerCtx.Err() != nil:
+		// interrupted by stopping the worker, the job is run again right away by another worker
+		w.logger.Warnf("job %d (%s) interrupted: %v", job.ID, job.Type, err)
+		w.finish(job, "retried", func(ctx context.Context) error {
+			return w.queue.store.RetryBackgroundJob(ctx, job.ID, job.Attempts, time.Now(), err.Error())
+		})
+	case job.Attempts >= job.MaxAttempts:
+		w.logger.Errorf("job %d (%s) failed after %d attempts: %v", job.ID, job.Type, job.Attempts, err)
+		w.finish(job, "failed", func(ctx context.Context) error {
+			return w.queue.store.FailBackgroundJob(ctx, job.ID, job.Attempts, time.Now(), err.Error())
+		})
+	default:
+		backoff := w.backoff(job.Attempts)
+		w.logger.Warnf("job %d (%s) failed (attempt %d), retrying in %s: %v",
+			job.ID, job.Type, job.Attempts, backoff, err)
+		w.finish(job, "retried", func(ctx context.Context) error {
+			return w.queue.store.RetryBackgroundJob(ctx, job.ID, job.Attempts, time.Now().Add(backoff), err.Error())
+		})
+	}
+}
+
+// invoke runs the job's handler with a timeout, recovering panics
+func (w *Worker) invoke(job *models.BackgroundJob) (err error) {
+	ctx, cancel := context.WithTimeout(w.handlerCtx, w.handlerTimeout)
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+
+	return w.handlers[job.Type](ctx, &Job{BackgroundJob: job})
+}
+
+// finish records the outcome of a job
+func (w *Worker) finish(job *models.BackgroundJob, result string, record func(ctx context.Context) error) {
+	processedTotal.WithLabelValues(job.Type, result).Inc()
+	ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+	defer cancel()
+
+	err := record(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		w.logger.Warnf("job %d (%s) was claimed by another worker after its lock expired", job.ID, job.Type)
+	} else if err != nil {
+		w.logger.Errorf("failed to record outcome of job %d (%s): %v", job.ID, job.Type, err)
+	}
+}
+
+// cleanup deletes the jobs finished before the retention period
+func (w *Worker) cleanup() {
+	deleted, err := w.queue.store.DeleteFinishedBackgroundJobs(w.ctx, time.Now().Add(-w.retention))
+	if err != nil {
+		w.logger.Errorf("failed to delete finished jobs: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		w.logger.Infof("deleted %d finished jobs", deleted)
+	}
+}
+
+// backoff returns the delay before the job is attempted again
+func (w *Worker) backoff(attempts int32) time.Duration {
+	return time.Duration(math.Min(float64(w.maxRetryBackoff),
+		float64(w.minRetryBackoff)*math.Pow(2, float64(attempts-1))))
+}
@@ -0,0 +1,109 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// supported kv store drivers
+const (
+	// DriverRedis connects to a server speaking the redis protocol (redis, valkey, keydb, etc)
+	DriverRedis = "redis"
+
+	// DriverMemory keeps keys in process, meant for development, tests and single replica deployments
+	DriverMemory = "memory"
+)
+
+// EventType describes the change reported by Watch
+type EventType int
+
+const (
+	// EventPut is reported when a key is set
+	EventPut EventType = iota
+
+	// EventDelete is reported when a key is deleted
+	EventDelete
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	if t == EventDelete {
+		return "delete"
+	}
+
+	return "put"
+}
+
+// Event is a change of a watched key
+type Event struct {
+	// Type of change
+	Type EventType
+
+	// Key that changed
+	Key string
+
+	// Value the key was set to (nil for deletes)
+	Value []byte
+}
+
+// KeyValue is a key and its value as returned by Scan
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// Store is a key value store shared by the replicas of a service
+// a zero ttl keeps keys until they are deleted
+type Store interface {
+	// Get returns the value of a key and whether it exists
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set sets the value of a key
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete removes a key (deleting a missing key is not an error)
+	Delete(ctx context.Context, key string) error
+
+	// CompareAndSwap sets key to new (with a ttl) only if its current value is old
+	// a nil old value requires the key to not exist
+	CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+
+	// CompareAndDelete removes key only if its current value is old
+	CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error)
+
+	// Scan returns the keys starting with the prefix and their values, ordered by key
+	Scan(ctx context.Context, prefix string) ([]KeyValue, error)
+
+	// Watch reports changes made through the store to keys starting with the prefix until the context is done
+	// (the channel is closed then). keys expiring are not reported
+	Watch(ctx context.Context, prefix string) (<-chan Event, error)
+
+	// Ping verifies the store is reachable
+	Ping(ctx context.Context) error
+
+	// Close releases the connections of the store
+	Close() error
+}
+
+// NewStore creates the kv store of the configured driver, nil if no driver is configured
+func NewStore(config *proto.KVStoreConfig, logger *log.Entry) (Store, error) {
+	switch config.Driver {
+	case DriverRedis:
+		return NewRedisStore(config, logger)
+	case DriverMemory:
+		return NewMemoryStore(), nil
+	case "":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported kv store driver %q", config.Driver)
+	}
+}
+
+// globEscaper escapes the characters with a special meaning in redis glob patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
@@ -0,0 +1,180 @@
+// Contains kv store unit testcases (run against the memory and redis stores)
+package kvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestMemoryStore unit tests the in process store
+func TestMemoryStore(test *testing.T) {
+	testStore(test, NewMemoryStore(), func(time.Duration) {})
+}
+
+// TestRedisStore unit tests the redis store against an in process redis server
+func TestRedisStore(test *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		test.Errorf("failed to start redis server: %v", err)
+		return
+	}
+	defer server.Close()
+
+	store, err := NewStore(&proto.KVStoreConfig{Driver: DriverRedis, FqdnOrIP: server.Host(), Port: server.Port(),
+		KeyPrefix: "test:"}, log.WithField("test", "kvstore"))
+	if err != nil {
+		test.Errorf("failed to connect to redis: %v", err)
+		return
+	}
+	defer store.Close()
+
+	testStore(test, store, server.FastForward)
+
+	// keys are namespaced with the prefix
+	if !server.Exists("test:a/2") {
+		test.Errorf("key not stored with the prefix, keys: %v", server.Keys())
+		return
+	}
+}
+
+// testStore exercises a store, advance moves the store's clock forward (to expire keys)
+func testStore(test *testing.T, store Store, advance func(time.Duration)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := store.Watch(ctx, "a/")
+	if err != nil {
+		test.Errorf("failed to watch keys: %v", err)
+		return
+	}
+
+	if err := store.Set(ctx, "a/1", []byte("one"), 0); err != nil {
+		test.Errorf("failed to set key: %v", err)
+		return
+	}
+
+	if value, ok, err := store.Get(ctx, "a/1"); err != nil || !ok || string(value) != "one" {
+		test.Errorf("unexpected value %q (%v): %v", value, ok, err)
+		return
+	}
+
+	if _, ok, err := store.Get(ctx, "missing"); err != nil || ok {
+		test.Errorf("missing key found: %v", err)
+		return
+	}
+
+	// compare and swap requires the current value, or the key to not exist for a nil old value
+	if swapped, err := store.CompareAndSwap(ctx, "a/1", nil, []byte("two"), 0); err != nil || swapped {
+		test.Errorf("existing key swapped: %v", err)
+		return
+	}
+
+	if swapped, err := store.CompareAndSwap(ctx, "a/1", []byte("two"), []byte("three"), 0); err != nil || swapped {
+		test.Errorf("key swapped with a stale value: %v", err)
+		return
+	}
+
+	if swapped, err := store.CompareAndSwap(ctx, "a/1", []byte("one"), []byte("two"), 0); err != nil || !swapped {
+		test.Errorf("key not swapped: %v", err)
+		return
+	}
+
+	if swapped, err := store.CompareAndSwap(ctx, "a/2", nil, []byte("new"), time.Minute); err != nil || !swapped {
+		test.Errorf("missing key not swapped: %v", err)
+		return
+	}
+
+	if err := store.Set(ctx, "b/1", []byte("other"), 0); err != nil {
+		test.Errorf("failed to set key: %v", err)
+		return
+	}
+
+	// compare and delete requires the current value
+	if deleted, err := store.CompareAndDelete(ctx, "b/1", []byte("stale")); err != nil || deleted {
+		test.Errorf("key deleted with a stale value: %v", err)
+		return
+	}
+
+	if deleted, err := store.CompareAndDelete(ctx, "b/1", []byte("other")); err != nil || !deleted {
+		test.Errorf("key not deleted: %v", err)
+		return
+	}
+
+	if deleted, err := store.CompareAndDelete(ctx, "b/1", []byte("other")); err != nil || deleted {
+		test.Errorf("missing key deleted: %v", err)
+		return
+	}
+
+	if err := store.Set(ctx, "b/1", []byte("other"), 0); err != nil {
+		test.Errorf("failed to set key: %v", err)
+		return
+	}
+
+	if err := store.Delete(ctx, "a/1"); err != nil {
+		test.Errorf("failed to delete key: %v", err)
+		return
+	}
+
+	// watchers receive the changes of matching keys in order
+	expected := []Event{
+		{Type: EventPut, Key: "a/1", Value: []byte("one")},
+		{Type: EventPut, Key: "a/1", Value: []byte("two")},
+		{Type: EventPut, Key: "a/2", Value: []byte("new")},
+		{Type: EventDelete, Key: "a/1"},
+	}
+	for _, want := range expected {
+		select {
+		case event := <-events:
+			if event.Type != want.Type || event.Key != want.Key || string(event.Value) != string(want.Value) {
+				test.Errorf("unexpected event %s %s %q, expected %s %s %q", event.Type, event.Key, event.Value,
+					want.Type, want.Key, want.Value)
+				return
+			}
+		case <-time.After(5 * time.Second):
+			test.Errorf("no event for %s %s", want.Type, want.Key)
+			return
+		}
+	}
+
+	if err := store.Set(ctx, "a/*", []byte("glob"), 0); err != nil {
+		test.Errorf("failed to set key: %v", err)
+		return
+	}
+
+	// scans return the matching keys in order (glob characters in keys are not special)
+	result, err := store.Scan(ctx, "a/")
+	if err != nil || len(result) != 2 || result[0].Key != "a/*" || result[1].Key != "a/2" ||
+		string(result[1].Value) != "new" {
+		test.Errorf("unexpected scan result %v: %v", result, err)
+		return
+	}
+
+	if result, err := store.Scan(ctx, "a/*"); err != nil || len(result) != 1 {
+		test.Errorf("unexpected scan result %v: %v", result, err)
+		return
+	}
+
+	// keys expire after their ttl
+	if err := store.Set(ctx, "a/3", []byte("short"), 10*time.Millisecond); err != nil {
+		test.Errorf("failed to set key: %v", err)
+		return
+	}
+
+	advance(time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok, err := store.Get(ctx, "a/3"); err != nil || ok {
+		test.Errorf("expired key found: %v", err)
+		return
+	}
+
+	// the watch ends with its context
+	cancel()
+	for range events {
+	}
+}
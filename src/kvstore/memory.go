@@ -0,0 +1,229 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often expired keys are removed from a memory store
+const memorySweepInterval = 1 * time.Minute
+
+// memoryEntry is a value held by the memory store (expiresAt is zero for keys without a ttl)
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// expired reports whether the entry expired at the given time
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// memoryWatcher queues the events of a Watch call, so writers never block on slow watchers
+type memoryWatcher struct {
+	prefix string
+
+	lock   sync.Mutex
+	queue  []Event
+	signal chan struct{}
+}
+
+// MemoryStore is an in process Store, changes are not shared with other replicas
+type MemoryStore struct {
+	lock      sync.Mutex
+	entries   map[string]memoryEntry
+	watchers  map[*memoryWatcher]struct{}
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]memoryEntry),
+		watchers:  make(map[*memoryWatcher]struct{}),
+		lastSweep: time.Now(),
+	}
+}
+
+// Get returns the value of a key and whether it exists
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	value, ok := m.get(key, time.Now())
+	return value, ok, nil
+}
+
+// Set sets the value of a key
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.set(key, value, ttl, time.Now())
+	return nil
+}
+
+// Delete removes a key
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.get(key, time.Now()); !ok {
+		return nil
+	}
+
+	delete(m.entries, key)
+	m.notify(Event{Type: EventDelete, Key: key})
+	return nil
+}
+
+// CompareAndSwap sets key to new only if its current value is old (nil requires the key to not exist)
+func (m *MemoryStore) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	current, ok := m.get(key, now)
+	if (old == nil && ok) || (old != nil && (!ok || !bytes.Equal(current, old))) {
+		return false, nil
+	}
+
+	m.set(key, new, ttl, now)
+	return true, nil
+}
+
+// CompareAndDelete removes key only if its current value is old
+func (m *MemoryStore) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	current, ok := m.get(key, time.Now())
+	if !ok || !bytes.Equal(current, old) {
+		return false, nil
+	}
+
+	delete(m.entries, key)
+	m.notify(Event{Type: EventDelete, Key: key})
+	return true, nil
+}
+
+// Scan returns the keys starting with the prefix and their values, ordered by key
+func (m *MemoryStore) Scan(ctx context.Context, prefix string) ([]KeyValue, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	var result []KeyValue
+	for key, entry := range m.entries {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			result = append(result, KeyValue{Key: key, Value: append([]byte(nil), entry.value...)})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result, nil
+}
+
+// Watch reports changes to keys starting with the prefix until the context is done
+func (m *MemoryStore) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	watcher := &memoryWatcher{prefix: prefix, signal: make(chan struct{}, 1)}
+	m.lock.Lock()
+	m.watchers[watcher] = struct{}{}
+	m.lock.Unlock()
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer func() {
+			m.lock.Lock()
+			delete(m.watchers, watcher)
+			m.lock.Unlock()
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-watcher.signal:
+			}
+
+			watcher.lock.Lock()
+			queue := watcher.queue
+			watcher.queue = nil
+			watcher.lock.Unlock()
+
+			for _, event := range queue {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Ping always succeeds
+func (m *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close is a no-op
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// get returns the value of an unexpired key, the store must be locked
+func (m *MemoryStore) get(key string, now time.Time) ([]byte, bool) {
+	entry, ok := m.entries[key]
+	if !ok || entry.expired(now) {
+		return nil, false
+	}
+
+	return append([]byte(nil), entry.value...), true
+}
+
+// set stores a value and notifies watchers, the store must be locked
+func (m *MemoryStore) set(key string, value []byte, ttl time.Duration, now time.Time) {
+	entry := memoryEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expiresAt = now.Add(ttl)
+	}
+
+	m.entries[key] = entry
+	m.notify(Event{Type: EventPut, Key: key, Value: append([]byte(nil), value...)})
+
+	// expired keys are dropped lazily when read, sweep the ones never read again
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.lastSweep = now
+		for key, entry := range m.entries {
+			if entry.expired(now) {
+				delete(m.entries, key)
+			}
+		}
+	}
+}
+
+// notify queues an event for the watchers of the key, the store must be locked
+func (m *MemoryStore) notify(event Event) {
+	for watcher := range m.watchers {
+		if !strings.HasPrefix(event.Key, watcher.prefix) {
+			continue
+		}
+
+		watcher.lock.Lock()
+		watcher.queue = append(watcher.queue, event)
+		watcher.lock.Unlock()
+
+		select {
+		case watcher.signal <- struct{}{}:
+		default:
+		}
+	}
+}
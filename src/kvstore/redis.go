@@ -0,0 +1,301 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultRedisPort      = "6379"
+	defaultConnectTimeout = 5 * time.Second
+
+	// number of keys requested per SCAN call
+	scanBatchSize = 100
+
+	// changes made through the store are published on channels named after this prefix and the key
+	changesChannel = "__kvstore_changes__:"
+)
+
+// compareAndSwapScript sets KEYS[1] to ARGV[3] (with a ttl of ARGV[4] milliseconds) if its value is ARGV[2]
+// ARGV[1] is "0" when the key must not exist
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if ARGV[1] == '0' then
+	if current then return 0 end
+elseif current ~= ARGV[2] then
+	return 0
+end
+if tonumber(ARGV[4]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// compareAndDeleteScript deletes KEYS[1] if its value is ARGV[1]
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+// RedisStore is a Store backed by a server speaking the redis protocol
+// keys are namespaced with the configured key prefix so services may share a server
+type RedisStore struct {
+	// client of the redis server
+	client *redis.Client
+
+	// keyPrefix is prepended to all keys
+	keyPrefix string
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewRedisStore connects to the configured redis server and verifies the connection with a ping
+func NewRedisStore(config *proto.KVStoreConfig, logger *log.Entry) (*RedisStore, error) {
+	connectTimeout, err := util.ParseDuration(config.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	port := config.Port
+	if port == "" {
+		port = defaultRedisPort
+	}
+
+	options := &redis.Options{
+		Addr:        net.JoinHostPort(config.FqdnOrIP, port),
+		Password:    config.Password,
+		DB:          int(config.Database),
+		DialTimeout: connectTimeout,
+		PoolSize:    int(config.PoolSize),
+	}
+
+	if config.Tls {
+		options.TLSConfig = &tls.Config{ServerName: config.FqdnOrIP, MinVersion: tls.VersionTLS12}
+	}
+
+	store := &RedisStore{
+		client:    redis.NewClient(options),
+		keyPrefix: config.KeyPrefix,
+		logger:    logger,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := store.Ping(ctx); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to connect to the kv store at %s: %v", options.Addr, err)
+	}
+
+	return store, nil
+}
+
+// Get returns the value of a key and whether it exists
+func (r *RedisStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := r.client.Get(ctx, r.keyPrefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// Set sets the value of a key
+func (r *RedisStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := r.client.Set(ctx, r.keyPrefix+key, value, ttl).Err(); err != nil {
+		return err
+	}
+
+	r.publish(ctx, Event{Type: EventPut, Key: key, Value: value})
+	return nil
+}
+
+// Delete removes a key
+func (r *RedisStore) Delete(ctx context.Context, key string) error {
+	deleted, err := r.client.Del(ctx, r.keyPrefix+key).Result()
+	if err != nil {
+		return err
+	}
+
+	if deleted > 0 {
+		r.publish(ctx, Event{Type: EventDelete, Key: key})
+	}
+
+	return nil
+}
+
+// CompareAndSwap sets key to new only if its current value is old (nil requires the key to not exist)
+// the comparison and update run atomically in a script on the server
+func (r *RedisStore) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	mustExist := "1"
+	if old == nil {
+		mustExist = "0"
+	}
+
+	swapped, err := compareAndSwapScript.Run(ctx, r.client, []string{r.keyPrefix + key},
+		mustExist, old, new, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+
+	if swapped == 0 {
+		return false, nil
+	}
+
+	r.publish(ctx, Event{Type: EventPut, Key: key, Value: new})
+	return true, nil
+}
+
+// CompareAndDelete removes key only if its current value is old
+// the comparison and delete run atomically in a script on the server
+func (r *RedisStore) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	deleted, err := compareAndDeleteScript.Run(ctx, r.client, []string{r.keyPrefix + key}, old).Int()
+	if err != nil {
+		return false, err
+	}
+
+	if deleted == 0 {
+		return false, nil
+	}
+
+	r.publish(ctx, Event{Type: EventDelete, Key: key})
+	return true, nil
+}
+
+// Scan returns the keys starting with the prefix and their values, ordered by key
+// the scan is not a snapshot, keys changing while it runs may or may not be returned
+func (r *RedisStore) Scan(ctx context.Context, prefix string) ([]KeyValue, error) {
+	match := globEscaper.Replace(r.keyPrefix+prefix) + "*"
+	var result []KeyValue
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, match, scanBatchSize).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keys) > 0 {
+			values, err := r.client.MGet(ctx, keys...).Result()
+			if err != nil {
+				return nil, err
+			}
+
+			for i, value := range values {
+				// keys deleted or expired since the scan returned them are skipped
+				if value, ok := value.(string); ok {
+					result = append(result, KeyValue{Key: strings.TrimPrefix(keys[i], r.keyPrefix), Value: []byte(value)})
+				}
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	// SCAN may return a key more than once
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	unique := result[:0]
+	for i, kv := range result {
+		if i == 0 || kv.Key != result[i-1].Key {
+			unique = append(unique, kv)
+		}
+	}
+
+	return unique, nil
+}
+
+// Watch reports changes made through RedisStores to keys starting with the prefix until the context is done
+// changes are delivered over redis pub/sub, so changes made while the connection is down are missed
+func (r *RedisStore) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	channelPrefix := r.keyPrefix + changesChannel
+	pubsub := r.client.PSubscribe(ctx, globEscaper.Replace(channelPrefix+prefix)+"*")
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			var message *redis.Message
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case message, ok = <-messages:
+			}
+
+			if !ok {
+				return
+			}
+
+			if message.Payload == "" {
+				continue
+			}
+
+			event := Event{Type: EventPut, Key: strings.TrimPrefix(message.Channel, channelPrefix)}
+			if message.Payload[0] == 'D' {
+				event.Type = EventDelete
+			} else {
+				event.Value = []byte(message.Payload[1:])
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// Ping verifies the redis server is reachable
+func (r *RedisStore) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close closes the connections to the redis server
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
+
+// publish notifies watchers of a change, failures are logged as the change itself succeeded
+// the payload is "P<value>" for puts and "D" for deletes
+func (r *RedisStore) publish(ctx context.Context, event Event) {
+	payload := "D"
+	if event.Type == EventPut {
+		payload = "P" + string(event.Value)
+	}
+
+	if err := r.client.Publish(ctx, r.keyPrefix+changesChannel+event.Key, payload).Err(); err != nil {
+		r.logger.Warnf("failed to publish kv store change of %s: %v", event.Key, err)
+	}
+}
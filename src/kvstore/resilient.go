@@ -0,0 +1,101 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"test_service/resilience"
+)
+
+// ResilientStore guards the calls to a store with a resilience policy
+// reads, writes and deletes are retried by the policy, compare and swap is not (a lost response would
+// make a retry fail although the swap succeeded). Watch and Ping are passed through: watches are long lived
+// and pings are health checks which must reach the store while the breaker is open
+type ResilientStore struct {
+	store  Store
+	policy *resilience.Policy
+}
+
+// NewResilientStore wraps a store with the policy
+func NewResilientStore(store Store, policy *resilience.Policy) *ResilientStore {
+	return &ResilientStore{store: store, policy: policy}
+}
+
+// Get returns the value of a key and whether it exists
+func (r *ResilientStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	var found bool
+	err := r.policy.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		value, found, err = r.store.Get(ctx, key)
+		return err
+	})
+
+	return value, found, err
+}
+
+// Set sets the value of a key
+func (r *ResilientStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.policy.Execute(ctx, func(ctx context.Context) error {
+		return r.store.Set(ctx, key, value, ttl)
+	})
+}
+
+// Delete removes a key
+func (r *ResilientStore) Delete(ctx context.Context, key string) error {
+	return r.policy.Execute(ctx, func(ctx context.Context) error {
+		return r.store.Delete(ctx, key)
+	})
+}
+
+// CompareAndSwap sets key to new only if its current value is old, it is attempted once
+func (r *ResilientStore) CompareAndSwap(ctx context.Context, key string, old, new []byte,
+	ttl time.Duration) (bool, error) {
+	ctx, done, err := r.policy.Guard(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	swapped, err := r.store.CompareAndSwap(ctx, key, old, new, ttl)
+	done(err)
+	return swapped, err
+}
+
+// CompareAndDelete removes key only if its current value is old, it is attempted once
+func (r *ResilientStore) CompareAndDelete(ctx context.Context, key string, old []byte) (bool, error) {
+	ctx, done, err := r.policy.Guard(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := r.store.CompareAndDelete(ctx, key, old)
+	done(err)
+	return deleted, err
+}
+
+// Scan returns the keys starting with the prefix and their values
+func (r *ResilientStore) Scan(ctx context.Context, prefix string) ([]KeyValue, error) {
+	var values []KeyValue
+	err := r.policy.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		values, err = r.store.Scan(ctx, prefix)
+		return err
+	})
+
+	return values, err
+}
+
+// Watch reports changes to keys starting with the prefix
+func (r *ResilientStore) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	return r.store.Watch(ctx, prefix)
+}
+
+// Ping verifies the store is reachable
+func (r *ResilientStore) Ping(ctx context.Context) error {
+	return r.store.Ping(ctx)
+}
+
+// Close releases the connections of the store
+func (r *ResilientStore) Close() error {
+	return r.store.Close()
+}
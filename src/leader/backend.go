@@ -0,0 +1,32 @@
+package leader
+
+import (
+	"context"
+	"time"
+)
+
+// supported leadership lease backends
+const (
+	// BackendDatastore holds the lease with a postgres advisory lock
+	BackendDatastore = "datastore"
+
+	// BackendKVStore holds the lease in a key of the kv store
+	BackendKVStore = "kvstore"
+)
+
+// Backend holds the leadership lease of an election
+// every term of leadership gets a fencing token greater than the tokens of the previous terms, so
+// resources written by the leader can reject writes of leaders that lost their lease without noticing
+type Backend interface {
+	// Acquire tries to become the leader, returning the fencing token of the new term
+	Acquire(ctx context.Context, identity string, lease time.Duration) (int64, bool, error)
+
+	// Renew extends the lease of the term, returning false if the term ended
+	Renew(ctx context.Context, identity string, token int64, lease time.Duration) (bool, error)
+
+	// Release ends the term so other replicas may take over without waiting for the lease to expire
+	Release(ctx context.Context, token int64) error
+
+	// Leader returns the identity of the current leader (empty if there is none)
+	Leader(ctx context.Context) (string, error)
+}
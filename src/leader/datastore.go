@@ -0,0 +1,143 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"test_service/repository"
+)
+
+// queries of the datastore backend, leases are recorded in the leader_elections table (for the fencing
+// tokens and the leader's identity) while the advisory lock guarantees a single leader
+const (
+	acquireLeaseQuery = `INSERT INTO leader_elections (name, leader, token, expires_at)
+	VALUES ($1, $2, 1, now() + $3 * interval '1 millisecond')
+	ON CONFLICT (name) DO UPDATE SET leader = excluded.leader, token = leader_elections.token + 1,
+		expires_at = excluded.expires_at
+	RETURNING token`
+
+	renewLeaseQuery = `UPDATE leader_elections SET expires_at = now() + $3 * interval '1 millisecond'
+	WHERE name = $1 AND token = $2`
+
+	releaseLeaseQuery = `UPDATE leader_elections SET expires_at = now() WHERE name = $1 AND token = $2`
+
+	leaderQuery = `SELECT leader FROM leader_elections WHERE name = $1 AND expires_at > now()`
+)
+
+// DatastoreBackend holds leases with postgres advisory locks
+// the lock is session scoped, so the leader holds it on a dedicated connection: leadership is lost as soon as
+// the connection breaks (the lease only bounds how long the leader keeps working without reaching the datastore)
+type DatastoreBackend struct {
+	// db of the repository
+	db *sql.DB
+
+	// election name and the key of its advisory lock
+	election string
+	lockKey  int64
+
+	// conn holding the lock while leading
+	lock sync.Mutex
+	conn *sql.Conn
+}
+
+// NewDatastoreBackend creates a backend for the named election, the repository must be on postgres
+func NewDatastoreBackend(repo *repository.Repository, election string) (*DatastoreBackend, error) {
+	if name := repo.DbConn.Dialector.Name(); name != repository.DriverPostgres {
+		return nil, fmt.Errorf("leader election with the datastore requires postgres (not %s)", name)
+	}
+
+	db, err := repo.DbConn.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := fnv.New64a()
+	hash.Write([]byte(keyPrefix + election))
+	return &DatastoreBackend{db: db, election: election, lockKey: int64(hash.Sum64())}, nil
+}
+
+// Acquire tries to take the advisory lock and starts a new term in the leader_elections table
+func (b *DatastoreBackend) Acquire(ctx context.Context, identity string, lease time.Duration) (int64, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", b.lockKey).Scan(&locked); err != nil || !locked {
+		conn.Close()
+		return 0, false, err
+	}
+
+	var token int64
+	err = conn.QueryRowContext(ctx, acquireLeaseQuery, b.election, identity, lease.Milliseconds()).Scan(&token)
+	if err != nil {
+		b.unlock(conn)
+		return 0, false, err
+	}
+
+	b.conn = conn
+	return token, true, nil
+}
+
+// Renew extends the term's lease, on the connection holding the lock
+func (b *DatastoreBackend) Renew(ctx context.Context, identity string, token int64, lease time.Duration) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.conn == nil {
+		return false, nil
+	}
+
+	result, err := b.conn.ExecContext(ctx, renewLeaseQuery, b.election, token, lease.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+
+	renewed, err := result.RowsAffected()
+	return renewed > 0, err
+}
+
+// Release expires the term's lease and releases the advisory lock
+func (b *DatastoreBackend) Release(ctx context.Context, token int64) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.conn == nil {
+		return nil
+	}
+
+	_, err := b.conn.ExecContext(ctx, releaseLeaseQuery, b.election, token)
+	b.unlock(b.conn)
+	b.conn = nil
+	return err
+}
+
+// Leader returns the identity recorded by the current term
+func (b *DatastoreBackend) Leader(ctx context.Context) (string, error) {
+	var leader string
+	err := b.db.QueryRowContext(ctx, leaderQuery, b.election).Scan(&leader)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return leader, err
+}
+
+// unlock releases the advisory lock and returns its connection to the pool
+// should the unlock fail the connection is discarded instead, ending the session holding the lock
+func (b *DatastoreBackend) unlock(conn *sql.Conn) {
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", b.lockKey); err != nil {
+		conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	}
+
+	conn.Close()
+}
@@ -0,0 +1,312 @@
+package leader
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewInterval = 5 * time.Second
+	defaultRetryInterval = 2 * time.Second
+
+	// releaseTimeout bounds releasing leadership when the elector stops
+	releaseTimeout = 5 * time.Second
+)
+
+// metrics exported by the elector, labelled by election
+var (
+	isLeader = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "leader_election_is_leader",
+		Help: "Whether this replica is the leader of the election (1) or not (0)",
+	}, []string{"election"})
+
+	transitionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "leader_election_transitions_total",
+		Help: "Number of times this replica gained or lost leadership of the election",
+	}, []string{"election"})
+)
+
+// tokenKey is the context key of the fencing token
+type tokenKey struct{}
+
+// FencingToken returns the fencing token of the term a singleton task runs in
+func FencingToken(ctx context.Context) (int64, bool) {
+	token, ok := ctx.Value(tokenKey{}).(int64)
+	return token, ok
+}
+
+// Elector campaigns for leadership of an election and runs singleton tasks while this replica is the leader
+// the leader renews its lease every renewInterval, and steps down if it cannot renew it before it expires
+// (other replicas may take over once it expired), the other replicas retry acquiring it every retryInterval
+type Elector struct {
+	// backend holding the lease
+	backend Backend
+
+	// name of the election and the identity of this replica
+	name     string
+	identity string
+
+	// election settings
+	leaseDuration time.Duration
+	renewInterval time.Duration
+	retryInterval time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// lock guards the state of the term below and the registered tasks and callbacks
+	lock sync.Mutex
+
+	// fencing token of the term (0 when not leading) and when its lease was last renewed
+	token     int64
+	renewedAt time.Time
+
+	// term context (cancelled when leadership is lost) and the tasks running in it
+	termCtx    context.Context
+	termCancel context.CancelFunc
+	termTasks  sync.WaitGroup
+
+	// tasks and callbacks registered with the elector
+	tasks     []task
+	callbacks []func(leader bool, token int64)
+
+	// cancel stops campaigning
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// task is a singleton task run by the leader
+type task struct {
+	name string
+	fn   func(ctx context.Context)
+}
+
+// NewElector creates an elector for the configured election, identity names this replica
+func NewElector(config *proto.LeaderElectionConfig, name string, backend Backend, identity string,
+	logger *log.Entry) (*Elector, error) {
+	leaseDuration, err := util.ParseDuration(config.LeaseDuration, defaultLeaseDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	renewInterval, err := util.ParseDuration(config.RenewInterval, defaultRenewInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	retryInterval, err := util.ParseDuration(config.RetryInterval, defaultRetryInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Elector{
+		backend:       backend,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewInterval: renewInterval,
+		retryInterval: retryInterval,
+		logger:        logger.WithField("election", name),
+	}, nil
+}
+
+// Identity returns the identity of this replica
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+// IsLeader reports whether this replica is the leader
+func (e *Elector) IsLeader() bool {
+	_, ok := e.Token()
+	return ok
+}
+
+// Token returns the fencing token of the current term if this replica is the leader
+func (e *Elector) Token() (int64, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.token, e.token != 0
+}
+
+// Leader returns the identity of the current leader (empty if there is none)
+func (e *Elector) Leader(ctx context.Context) (string, error) {
+	if e.IsLeader() {
+		return e.identity, nil
+	}
+
+	return e.backend.Leader(ctx)
+}
+
+// OnChange registers a callback called when this replica gains (with the term's fencing token) or loses
+// leadership. callbacks run on the elector's goroutine and should return quickly
+func (e *Elector) OnChange(callback func(leader bool, token int64)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.callbacks = append(e.callbacks, callback)
+}
+
+// RunWhileLeader runs fn whenever this replica becomes the leader, its context (carrying the fencing token)
+// is cancelled when leadership is lost. fn should run until then, leadership is not handed over before it returns
+func (e *Elector) RunWhileLeader(name string, fn func(ctx context.Context)) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	t := task{name: name, fn: fn}
+	e.tasks = append(e.tasks, t)
+	if e.token != 0 {
+		e.startTask(t)
+	}
+}
+
+// Start campaigns for leadership in the background until stopped
+func (e *Elector) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	isLeader.WithLabelValues(e.name).Set(0)
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			interval := e.retryInterval
+			if e.IsLeader() {
+				e.renew(ctx)
+			} else {
+				e.acquire(ctx)
+			}
+
+			if e.IsLeader() {
+				interval = e.renewInterval
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// Stop stops campaigning, stepping down (after the singleton tasks returned) if this replica is the leader
+func (e *Elector) Stop() {
+	if e.cancel != nil {
+		e.cancel()
+	}
+
+	e.wg.Wait()
+	e.stepDown("the elector stopped")
+}
+
+// acquire tries to become the leader
+func (e *Elector) acquire(ctx context.Context) {
+	attemptCtx, cancel := context.WithTimeout(ctx, e.renewInterval)
+	defer cancel()
+
+	attemptedAt := time.Now()
+	token, acquired, err := e.backend.Acquire(attemptCtx, e.identity, e.leaseDuration)
+	if err != nil {
+		e.logger.Warnf("failed to acquire leadership: %v", err)
+		return
+	}
+
+	if !acquired {
+		return
+	}
+
+	e.logger.Infof("%s became the leader (fencing token %d)", e.identity, token)
+	isLeader.WithLabelValues(e.name).Set(1)
+	transitionsTotal.WithLabelValues(e.name).Inc()
+
+	e.lock.Lock()
+	e.token = token
+	e.renewedAt = attemptedAt
+	e.termCtx, e.termCancel = context.WithCancel(context.WithValue(context.Background(), tokenKey{}, token))
+	for _, t := range e.tasks {
+		e.startTask(t)
+	}
+
+	callbacks := e.callbacks
+	e.lock.Unlock()
+
+	for _, callback := range callbacks {
+		callback(true, token)
+	}
+}
+
+// renew extends the lease, stepping down if the term ended or the lease is about to expire
+func (e *Elector) renew(ctx context.Context) {
+	e.lock.Lock()
+	token, renewedAt := e.token, e.renewedAt
+	e.lock.Unlock()
+
+	attemptCtx, cancel := context.WithTimeout(ctx, e.renewInterval)
+	defer cancel()
+
+	attemptedAt := time.Now()
+	renewed, err := e.backend.Renew(attemptCtx, e.identity, token, e.leaseDuration)
+	switch {
+	case err == nil && renewed:
+		e.lock.Lock()
+		e.renewedAt = attemptedAt
+		e.lock.Unlock()
+	case err == nil:
+		e.stepDown("the lease was lost")
+	case time.Since(renewedAt) >= e.leaseDuration-e.renewInterval:
+		// the lease expires before the next renewal, another replica may take over
+		e.stepDown("the lease could not be renewed: " + err.Error())
+	default:
+		e.logger.Warnf("failed to renew leadership, retrying: %v", err)
+	}
+}
+
+// stepDown gives up leadership: singleton tasks are stopped (and awaited) before the lease is released
+func (e *Elector) stepDown(reason string) {
+	e.lock.Lock()
+	token := e.token
+	if token == 0 {
+		e.lock.Unlock()
+		return
+	}
+
+	e.token = 0
+	e.termCancel()
+	callbacks := e.callbacks
+	e.lock.Unlock()
+
+	e.logger.Warnf("%s is no longer the leader: %s", e.identity, reason)
+	isLeader.WithLabelValues(e.name).Set(0)
+	transitionsTotal.WithLabelValues(e.name).Inc()
+	e.termTasks.Wait()
+
+	for _, callback := range callbacks {
+		callback(false, token)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	if err := e.backend.Release(ctx, token); err != nil {
+		e.logger.Warnf("failed to release leadership: %v", err)
+	}
+}
+
+// startTask runs a singleton task in the current term, the elector must be locked
+func (e *Elector) startTask(t task) {
+	ctx := e.termCtx
+	e.termTasks.Add(1)
+	go func() {
+		defer e.termTasks.Done()
+		e.logger.Debugf("starting singleton task %s", t.name)
+		t.fn(ctx)
+	}()
+}
@@ -0,0 +1,141 @@
+// Contains leader election unit testcases
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/kvstore"
+	proto "test_service/protobuf/generated"
+)
+
+// TestElector unit tests electing a single leader, running singleton tasks and handing leadership over
+func TestElector(test *testing.T) {
+	store := kvstore.NewMemoryStore()
+	defer store.Close()
+
+	config := &proto.LeaderElectionConfig{LeaseDuration: "300ms", RenewInterval: "50ms", RetryInterval: "20ms"}
+	terms := make(chan int64, 10)
+	electors := map[string]*Elector{}
+	for _, identity := range []string{"first", "second"} {
+		elector, err := NewElector(config, "test", NewKVStoreBackend(store, "test"), identity,
+			log.WithField("test", "leader"))
+		if err != nil {
+			test.Errorf("failed to create elector: %v", err)
+			return
+		}
+
+		elector.RunWhileLeader("task", func(ctx context.Context) {
+			token, _ := FencingToken(ctx)
+			terms <- token
+			<-ctx.Done()
+		})
+
+		elector.Start()
+		defer elector.Stop()
+		electors[identity] = elector
+	}
+
+	// a single replica leads and runs the task
+	var firstToken int64
+	select {
+	case firstToken = <-terms:
+	case <-time.After(5 * time.Second):
+		test.Errorf("no leader elected")
+		return
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	leaders := 0
+	var current *Elector
+	for _, elector := range electors {
+		if elector.IsLeader() {
+			leaders++
+			current = elector
+		}
+	}
+
+	if leaders != 1 || len(terms) != 0 {
+		test.Errorf("unexpected number of leaders %d (%d terms)", leaders, len(terms)+1)
+		return
+	}
+
+	for _, elector := range electors {
+		if leader, err := elector.Leader(context.Background()); err != nil || leader != current.Identity() {
+			test.Errorf("unexpected leader %q, expected %q: %v", leader, current.Identity(), err)
+			return
+		}
+	}
+
+	// leadership is handed over (with a greater fencing token) when the leader stops
+	current.Stop()
+	select {
+	case token := <-terms:
+		if token <= firstToken {
+			test.Errorf("fencing token %d not greater than %d", token, firstToken)
+			return
+		}
+	case <-time.After(5 * time.Second):
+		test.Errorf("leadership not handed over")
+		return
+	}
+}
+
+// takeoverStore runs a hook after reading a key, e.g. to let another replica take a lease over in between
+type takeoverStore struct {
+	kvstore.Store
+
+	afterGet func()
+}
+
+// Get reads the key then runs the hook once
+func (s *takeoverStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, ok, err := s.Store.Get(ctx, key)
+	if hook := s.afterGet; hook != nil {
+		s.afterGet = nil
+		hook()
+	}
+
+	return value, ok, err
+}
+
+// TestKVStoreRelease unit tests that releasing an expired term leaves the lease of the new leader intact
+func TestKVStoreRelease(test *testing.T) {
+	ctx := context.Background()
+	store := kvstore.NewMemoryStore()
+	defer store.Close()
+
+	old := &takeoverStore{Store: store}
+	oldBackend, newBackend := NewKVStoreBackend(old, "test"), NewKVStoreBackend(store, "test")
+	oldToken, acquired, err := oldBackend.Acquire(ctx, "old", time.Minute)
+	if err != nil || !acquired {
+		test.Errorf("failed to acquire lease: %v", err)
+		return
+	}
+
+	// the lease expires and the new leader acquires it while the old leader releases its term
+	var newToken int64
+	old.afterGet = func() {
+		store.Delete(ctx, oldBackend.leaseKey)
+		if newToken, acquired, err = newBackend.Acquire(ctx, "new", time.Minute); err != nil || !acquired {
+			test.Errorf("failed to take the lease over: %v", err)
+		}
+	}
+
+	if err := oldBackend.Release(ctx, oldToken); err != nil {
+		test.Errorf("failed to release lease: %v", err)
+		return
+	}
+
+	if leader, err := newBackend.Leader(ctx); err != nil || leader != "new" {
+		test.Errorf("lease of the new leader released, leader %q: %v", leader, err)
+		return
+	}
+
+	if renewed, err := newBackend.Renew(ctx, "new", newToken, time.Minute); err != nil || !renewed {
+		test.Errorf("failed to renew the new lease: %v", err)
+	}
+}
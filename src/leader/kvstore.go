@@ -0,0 +1,136 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"test_service/kvstore"
+)
+
+// keyPrefix namespaces elections in the kv store
+const keyPrefix = "leader/"
+
+// kvLease is the value of an election's lease key
+type kvLease struct {
+	Identity string `json:"identity"`
+	Token    int64  `json:"token"`
+}
+
+// KVStoreBackend holds leases in the kv store: the lease key expires unless renewed by the leader, and a
+// counter key (kept forever) hands out the fencing tokens
+type KVStoreBackend struct {
+	// store holding the keys
+	store kvstore.Store
+
+	// keys of the lease and of the fencing token counter
+	leaseKey string
+	tokenKey string
+}
+
+// NewKVStoreBackend creates a backend for the named election
+func NewKVStoreBackend(store kvstore.Store, election string) *KVStoreBackend {
+	return &KVStoreBackend{
+		store:    store,
+		leaseKey: keyPrefix + election + "/lease",
+		tokenKey: keyPrefix + election + "/token",
+	}
+}
+
+// Acquire creates the lease key if it does not exist
+func (b *KVStoreBackend) Acquire(ctx context.Context, identity string, lease time.Duration) (int64, bool, error) {
+	if _, ok, err := b.store.Get(ctx, b.leaseKey); err != nil || ok {
+		return 0, false, err
+	}
+
+	// tokens of failed attempts are skipped, the tokens of terms still increase
+	token, err := b.nextToken(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	value, err := json.Marshal(&kvLease{Identity: identity, Token: token})
+	if err != nil {
+		return 0, false, err
+	}
+
+	acquired, err := b.store.CompareAndSwap(ctx, b.leaseKey, nil, value, lease)
+	if err != nil || !acquired {
+		return 0, false, err
+	}
+
+	return token, true, nil
+}
+
+// Renew resets the ttl of the lease key if it still holds the term
+func (b *KVStoreBackend) Renew(ctx context.Context, identity string, token int64, lease time.Duration) (bool, error) {
+	current, raw, err := b.get(ctx)
+	if err != nil || raw == nil || current.Token != token {
+		return false, err
+	}
+
+	return b.store.CompareAndSwap(ctx, b.leaseKey, raw, raw, lease)
+}
+
+// Release deletes the lease key if it still holds the term
+// the delete compares the value read, so a lease taken over in between by another replica is left intact
+func (b *KVStoreBackend) Release(ctx context.Context, token int64) error {
+	current, raw, err := b.get(ctx)
+	if err != nil || raw == nil || current.Token != token {
+		return err
+	}
+
+	_, err = b.store.CompareAndDelete(ctx, b.leaseKey, raw)
+	return err
+}
+
+// Leader returns the identity held by the lease key
+func (b *KVStoreBackend) Leader(ctx context.Context) (string, error) {
+	current, _, err := b.get(ctx)
+	return current.Identity, err
+}
+
+// get returns the current lease and the value it was decoded from (nil if there is no lease)
+func (b *KVStoreBackend) get(ctx context.Context) (*kvLease, []byte, error) {
+	var current kvLease
+	value, ok, err := b.store.Get(ctx, b.leaseKey)
+	if err != nil || !ok {
+		return &current, nil, err
+	}
+
+	if err := json.Unmarshal(value, &current); err != nil {
+		return &current, nil, err
+	}
+
+	return &current, value, nil
+}
+
+// nextToken increments the fencing token counter
+func (b *KVStoreBackend) nextToken(ctx context.Context) (int64, error) {
+	for {
+		value, ok, err := b.store.Get(ctx, b.tokenKey)
+		if err != nil {
+			return 0, err
+		}
+
+		var token int64
+		if ok {
+			if token, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return 0, err
+			}
+		} else {
+			value = nil
+		}
+
+		next := []byte(strconv.FormatInt(token+1, 10))
+		swapped, err := b.store.CompareAndSwap(ctx, b.tokenKey, value, next, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		if swapped {
+			return token + 1, nil
+		}
+	}
+}
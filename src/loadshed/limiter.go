@@ -0,0 +1,181 @@
+// Load shedding package bounding the number of requests the service works on concurrently
+// requests beyond the concurrency limit are rejected early instead of queueing up, which keeps
+// latency bounded under overload. the limit is either fixed or adapted (AIMD) to observed latency
+
+package loadshed
+
+import (
+	"sync"
+	"time"
+)
+
+// Priority classes of requests
+const (
+	// PriorityCritical requests (e.g. health checks) are never shed
+	PriorityCritical = "critical"
+
+	// PriorityNormal requests are shed once the concurrency limit is reached
+	PriorityNormal = "normal"
+
+	// PriorityLow requests are shed first, once the in-flight count reaches a fraction of the limit
+	PriorityLow = "low"
+)
+
+// limiter modes
+const (
+	// ModeFixed uses a fixed concurrency limit
+	ModeFixed = "fixed"
+
+	// ModeAdaptive adjusts the limit using additive increase/multiplicative decrease driven by latency
+	ModeAdaptive = "adaptive"
+)
+
+// tuning constants for the adaptive limiter
+const (
+	// backoffRatio is applied to the limit when latency exceeds the target
+	backoffRatio = 0.9
+
+	// lowPriorityRatio of the limit above which low priority requests are shed
+	lowPriorityRatio = 0.8
+)
+
+// ConcurrencyLimiter admits requests while the number of in-flight requests is below the limit
+type ConcurrencyLimiter struct {
+	// limiter mode (fixed or adaptive)
+	mode string
+
+	// bounds of the limit (the fixed limit is maxLimit)
+	minLimit float64
+	maxLimit float64
+
+	// latency above which the adaptive limiter backs off
+	targetLatency time.Duration
+
+	// lock guards the in-flight count and current limit
+	lock     sync.Mutex
+	inFlight int
+	limit    float64
+
+	// last time the limit was decreased, decreases are spaced by targetLatency
+	// so a single burst of slow requests does not collapse the limit
+	lastDecrease time.Time
+}
+
+// NewConcurrencyLimiter creates a limiter. in adaptive mode the limit starts at initialLimit
+// and moves between minLimit and maxLimit, in fixed mode maxLimit is used
+func NewConcurrencyLimiter(mode string, minLimit, initialLimit, maxLimit int, targetLatency time.Duration) *ConcurrencyLimiter {
+	limit := float64(maxLimit)
+	if mode == ModeAdaptive {
+		limit = float64(initialLimit)
+	}
+
+	return &ConcurrencyLimiter{
+		mode:          mode,
+		minLimit:      float64(minLimit),
+		maxLimit:      float64(maxLimit),
+		targetLatency: targetLatency,
+		limit:         limit,
+	}
+}
+
+// Acquire admits a request of the given priority. on success the returned function must be called
+// once the request completes so the slot is released and its latency recorded
+func (l *ConcurrencyLimiter) Acquire(priority string) (func(), bool) {
+	if !l.admit(priority) {
+		return nil, false
+	}
+
+	start := time.Now()
+	return func() { l.release(time.Since(start)) }, true
+}
+
+// AcquireSlot admits a request like Acquire, but the returned function only releases the slot
+// used for streams, whose lifetime says nothing about the latency of the service
+func (l *ConcurrencyLimiter) AcquireSlot(priority string) (func(), bool) {
+	if !l.admit(priority) {
+		return nil, false
+	}
+
+	return l.free, true
+}
+
+// Stats returns the current in-flight count and limit
+func (l *ConcurrencyLimiter) Stats() (int, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inFlight, int(l.limit)
+}
+
+// admit takes a slot for a request of the given priority if the in-flight count allows it
+func (l *ConcurrencyLimiter) admit(priority string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	switch priority {
+	case PriorityCritical:
+	case PriorityLow:
+		if float64(l.inFlight) >= l.limit*lowPriorityRatio {
+			return false
+		}
+	default:
+		if float64(l.inFlight) >= l.limit {
+			return false
+		}
+	}
+
+	l.inFlight++
+	return true
+}
+
+// free releases a slot without adapting the limit
+func (l *ConcurrencyLimiter) free() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.inFlight--
+}
+
+// release frees a slot and adapts the limit to the observed latency
+func (l *ConcurrencyLimiter) release(latency time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+	if l.mode != ModeAdaptive {
+		return
+	}
+
+	now := time.Now()
+	if latency > l.targetLatency {
+		if now.Sub(l.lastDecrease) >= l.targetLatency {
+			l.limit = maxFloat(l.minLimit, l.limit*backoffRatio)
+			l.lastDecrease = now
+		}
+
+		return
+	}
+
+	// only grow the limit while it is actually being used, otherwise an idle service
+	// would drift up to maxLimit and lose its protection
+	if float64(inFlight) >= l.limit/2 {
+		l.limit = minFloat(l.maxLimit, l.limit+1/l.limit)
+	}
+}
+
+// minFloat returns the smaller of two values
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// maxFloat returns the larger of two values
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,162 @@
+// Contains load shedding unit testcases
+package loadshed
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestAdaptiveLimit unit tests the additive increase/multiplicative decrease of the limit and its bounds
+func TestAdaptiveLimit(test *testing.T) {
+	testcases := []struct {
+		name     string
+		mode     string
+		limit    int
+		inFlight int
+		latency  []time.Duration
+		expected float64
+	}{
+		{name: "increase", mode: ModeAdaptive, limit: 10, inFlight: 5, latency: []time.Duration{time.Millisecond},
+			expected: 10.1},
+		{name: "no increase when idle", mode: ModeAdaptive, limit: 10, inFlight: 4,
+			latency: []time.Duration{time.Millisecond}, expected: 10},
+		{name: "decrease", mode: ModeAdaptive, limit: 10, inFlight: 1, latency: []time.Duration{time.Second},
+			expected: 9},
+		{name: "decreases are spaced", mode: ModeAdaptive, limit: 10, inFlight: 2,
+			latency: []time.Duration{time.Second, time.Second}, expected: 9},
+		{name: "max clamp", mode: ModeAdaptive, limit: 20, inFlight: 20, latency: []time.Duration{time.Millisecond},
+			expected: 20},
+		{name: "min clamp", mode: ModeAdaptive, limit: 5, inFlight: 1, latency: []time.Duration{time.Second},
+			expected: 5},
+		{name: "fixed", mode: ModeFixed, limit: 20, inFlight: 1, latency: []time.Duration{time.Second},
+			expected: 20},
+	}
+
+	for _, tc := range testcases {
+		limiter := NewConcurrencyLimiter(tc.mode, 5, tc.limit, 20, 100*time.Millisecond)
+		limiter.inFlight = tc.inFlight
+		for _, latency := range tc.latency {
+			limiter.release(latency)
+		}
+
+		if math.Abs(limiter.limit-tc.expected) > 1e-9 || limiter.inFlight != tc.inFlight-len(tc.latency) {
+			test.Errorf("testcase %s: expected limit %v, got %v (%d in flight)", tc.name, tc.expected,
+				limiter.limit, limiter.inFlight)
+		}
+	}
+}
+
+// TestPriorities unit tests the share of the limit available to each priority
+func TestPriorities(test *testing.T) {
+	testcases := []struct {
+		priority string
+		inFlight int
+		admitted bool
+	}{
+		{priority: PriorityNormal, inFlight: 9, admitted: true},
+		{priority: PriorityNormal, inFlight: 10, admitted: false},
+		{priority: PriorityLow, inFlight: 7, admitted: true},
+		{priority: PriorityLow, inFlight: 8, admitted: false},
+		{priority: PriorityCritical, inFlight: 100, admitted: true},
+	}
+
+	for _, tc := range testcases {
+		limiter := NewConcurrencyLimiter(ModeFixed, 1, 10, 10, time.Second)
+		limiter.inFlight = tc.inFlight
+		release, admitted := limiter.Acquire(tc.priority)
+		if admitted != tc.admitted {
+			test.Errorf("testcase %s with %d in flight: expected admitted=%v", tc.priority, tc.inFlight, tc.admitted)
+			continue
+		}
+
+		if admitted {
+			release()
+			if limiter.inFlight != tc.inFlight {
+				test.Errorf("testcase %s: slot not released (%d in flight)", tc.priority, limiter.inFlight)
+			}
+		}
+	}
+}
+
+// TestClassify unit tests the priority of routes and RPC methods, admin endpoints are only critical when configured
+func TestClassify(test *testing.T) {
+	logger := log.WithField("test", "loadshed")
+	shedder, err := NewShedder(&proto.LoadSheddingConfig{}, logger)
+	if err != nil {
+		test.Errorf("failed to create shedder: %v", err)
+		return
+	}
+
+	configured, err := NewShedder(&proto.LoadSheddingConfig{Priorities: []*proto.PriorityClass{
+		{Priority: PriorityCritical, Routes: []string{"* /v1/admin/*"}}}}, logger)
+	if err != nil {
+		test.Errorf("failed to create shedder: %v", err)
+		return
+	}
+
+	testcases := []struct {
+		shedder  *Shedder
+		method   string
+		route    string
+		priority string
+	}{
+		{shedder: shedder, method: "GET", route: "/v1/health", priority: PriorityCritical},
+		{shedder: shedder, method: "GET", route: "/v1/admin/apikeys", priority: PriorityNormal},
+		{shedder: configured, method: "GET", route: "/v1/admin/apikeys", priority: PriorityCritical},
+		{shedder: configured, method: "GET", route: "/v1/ping", priority: PriorityNormal},
+	}
+
+	for _, tc := range testcases {
+		if priority := tc.shedder.classifyRoute(tc.method, tc.route); priority != tc.priority {
+			test.Errorf("testcase %s %s: unexpected priority %s, expected %s", tc.method, tc.route, priority,
+				tc.priority)
+		}
+	}
+}
+
+// TestStreamLifetime unit tests that streams hold a slot without their lifetime shrinking the limit
+func TestStreamLifetime(test *testing.T) {
+	shedder, err := NewShedder(&proto.LoadSheddingConfig{Mode: ModeAdaptive, MaxInFlight: 10, InitialInFlight: 10,
+		TargetLatency: "1ms"}, log.WithField("test", "loadshed"))
+	if err != nil {
+		test.Errorf("failed to create shedder: %v", err)
+		return
+	}
+
+	interceptor := shedder.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"}
+	err = interceptor(nil, nil, info, func(srv interface{}, ss grpc.ServerStream) error {
+		if inFlight, _ := shedder.limiter.Stats(); inFlight != 1 {
+			test.Errorf("stream does not hold a slot (%d in flight)", inFlight)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		test.Errorf("stream rejected: %v", err)
+		return
+	}
+
+	if inFlight, limit := shedder.limiter.Stats(); inFlight != 0 || limit != 10 {
+		test.Errorf("unexpected limiter state after the stream ended: %d in flight, limit %d", inFlight, limit)
+	}
+
+	// unary requests slower than the target still shrink the limit
+	_, err = shedder.UnaryServerInterceptor()(context.Background(), nil,
+		&grpc.UnaryServerInfo{FullMethod: "/test_service.TestServiceRPC/Ping"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			time.Sleep(5 * time.Millisecond)
+			return nil, nil
+		})
+	if _, limit := shedder.limiter.Stats(); err != nil || limit != 9 {
+		test.Errorf("slow request did not shrink the limit (%d): %v", limit, err)
+	}
+}
@@ -0,0 +1,236 @@
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultMaxInFlight   = 1000
+	defaultTargetLatency = "250ms"
+)
+
+// metrics exported by the load shedder
+var (
+	shedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "loadshed_requests_shed_total",
+		Help: "Number of requests rejected by the load shedder",
+	}, []string{"server", "priority"})
+
+	inFlightGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "loadshed_requests_in_flight",
+		Help: "Number of requests currently being served",
+	})
+
+	limitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "loadshed_concurrency_limit",
+		Help: "Current concurrency limit",
+	})
+)
+
+// builtinCritical lists requests that are never shed regardless of the configured priorities
+// requests are classified before authentication, so only cheap unauthenticated endpoints are listed
+var builtinCritical = priorityClass{
+	priority: PriorityCritical,
+	routes:   []string{"GET /v1/health", "GET /v1/ready", "GET /metrics"},
+	rpcs:     []string{"/grpc.health.v1.Health/*"},
+}
+
+// priorityClass maps routes and RPC methods to a priority
+type priorityClass struct {
+	priority string
+	routes   []string
+	rpcs     []string
+}
+
+// Shedder rejects requests once the REST and RPC servers (combined) are working on too many requests
+type Shedder struct {
+	// limiter shared by both servers
+	limiter *ConcurrencyLimiter
+
+	// priority classes, requests matching none are normal priority
+	classes []priorityClass
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewShedder creates a load shedder from the config
+func NewShedder(config *proto.LoadSheddingConfig, logger *log.Entry) (*Shedder, error) {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeFixed
+	}
+
+	if mode != ModeFixed && mode != ModeAdaptive {
+		return nil, fmt.Errorf("unsupported load shedding mode %q", mode)
+	}
+
+	maxInFlight := int(config.MaxInFlight)
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+
+	minInFlight := int(config.MinInFlight)
+	if minInFlight <= 0 || minInFlight > maxInFlight {
+		minInFlight = 1
+	}
+
+	initialInFlight := int(config.InitialInFlight)
+	if initialInFlight < minInFlight || initialInFlight > maxInFlight {
+		initialInFlight = maxInFlight
+	}
+
+	targetLatencyValue := config.TargetLatency
+	if targetLatencyValue == "" {
+		targetLatencyValue = defaultTargetLatency
+	}
+
+	targetLatency, err := util.ParseDuration(targetLatencyValue, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	shedder := &Shedder{
+		limiter: NewConcurrencyLimiter(mode, minInFlight, initialInFlight, maxInFlight, targetLatency),
+		classes: []priorityClass{builtinCritical},
+		logger:  logger,
+	}
+
+	for _, class := range config.Priorities {
+		if class.Priority != PriorityCritical && class.Priority != PriorityLow && class.Priority != PriorityNormal {
+			return nil, fmt.Errorf("unsupported load shedding priority %q", class.Priority)
+		}
+
+		for _, route := range class.Routes {
+			if _, _, err := util.SplitRoute(route); err != nil {
+				return nil, err
+			}
+		}
+
+		shedder.classes = append(shedder.classes, priorityClass{
+			priority: class.Priority,
+			routes:   class.Routes,
+			rpcs:     class.Rpcs,
+		})
+	}
+
+	_, limit := shedder.limiter.Stats()
+	limitGauge.Set(float64(limit))
+	logger.Infof("load shedding enabled (mode: %s, limit: %d)", mode, limit)
+	return shedder, nil
+}
+
+// GinMiddleware sheds REST requests over the concurrency limit with a 503
+func (s *Shedder) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		priority := s.classifyRoute(c.Request.Method, c.FullPath())
+		release, ok := s.acquire("rest", priority, s.limiter.Acquire)
+		if !ok {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server overloaded"})
+			return
+		}
+
+		defer release()
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor sheds unary RPCs over the concurrency limit with Unavailable
+func (s *Shedder) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		release, ok := s.acquire("rpc", s.classifyRPC(info.FullMethod), s.limiter.Acquire)
+		if !ok {
+			return nil, status.Error(codes.Unavailable, "server overloaded")
+		}
+
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor sheds streaming RPCs over the concurrency limit with Unavailable
+// a stream holds its slot for its entire lifetime, which is not fed to the adaptive limit as latency
+// (long lived streams such as health watches would otherwise shrink the limit whenever they end)
+func (s *Shedder) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		release, ok := s.acquire("rpc", s.classifyRPC(info.FullMethod), s.limiter.AcquireSlot)
+		if !ok {
+			return status.Error(codes.Unavailable, "server overloaded")
+		}
+
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+// acquire admits a request through the limiter (Acquire or AcquireSlot) and keeps the metrics up to date
+func (s *Shedder) acquire(server, priority string, acquire func(string) (func(), bool)) (func(), bool) {
+	release, ok := acquire(priority)
+	if !ok {
+		shedTotal.WithLabelValues(server, priority).Inc()
+		s.logger.Debugf("shedding %s %s priority request", server, priority)
+		return nil, false
+	}
+
+	inFlightGauge.Inc()
+	return func() {
+		release()
+		inFlightGauge.Dec()
+		_, limit := s.limiter.Stats()
+		limitGauge.Set(float64(limit))
+	}, true
+}
+
+// classifyRoute returns the priority of a REST route
+func (s *Shedder) classifyRoute(method, route string) string {
+	return s.classify(func(class priorityClass) bool {
+		for _, pattern := range class.routes {
+			if util.MatchRoute(pattern, method, route) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// classifyRPC returns the priority of an RPC method
+func (s *Shedder) classifyRPC(fullMethod string) string {
+	return s.classify(func(class priorityClass) bool {
+		for _, pattern := range class.rpcs {
+			if util.MatchPattern(pattern, fullMethod) {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// classify returns the priority of the first class matching the request (normal if none match)
+func (s *Shedder) classify(matches func(priorityClass) bool) string {
+	for _, class := range s.classes {
+		if matches(class) {
+			return class.priority
+		}
+	}
+
+	return PriorityNormal
+}
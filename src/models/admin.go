@@ -0,0 +1,11 @@
+package models
+
+// LogLevelRequest is the request body to change the server's logging level
+type LogLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+}
+
+// LogLevelResponse is the server response for the log level API endpoints
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
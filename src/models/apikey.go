@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// ApiKey is the persisted representation of an API key
+// only a hash of the key is stored, the plaintext key is returned once on creation/rotation
+type ApiKey struct {
+	// ID uniquely identifies the key
+	ID string `gorm:"primaryKey;size:36" json:"id"`
+
+	// Name is a human readable description of the key owner/purpose
+	Name string `gorm:"size:128;not null" json:"name"`
+
+	// Prefix holds the first few characters of the key to help identify it
+	Prefix string `gorm:"size:16;not null" json:"prefix"`
+
+	// HashedKey is the hex encoded SHA-256 digest of the key
+	HashedKey string `gorm:"size:64;uniqueIndex;not null" json:"-"`
+
+	// Scopes granted to the key (comma separated)
+	Scopes string `gorm:"size:1024" json:"-"`
+
+	// ExpiresAt is when the key stops being valid (nil if it never expires)
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// RevokedAt is when the key was revoked (nil if active)
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+
+	// LastUsedAt is when the key was last used to authenticate a request
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ScopeList returns the scopes of the key as a slice
+func (k *ApiKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return nil
+	}
+
+	return strings.Split(k.Scopes, ",")
+}
+
+// SetScopes stores the scopes of the key
+func (k *ApiKey) SetScopes(scopes []string) {
+	k.Scopes = strings.Join(scopes, ",")
+}
+
+// IsActive checks if the key is neither revoked nor expired
+func (k *ApiKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+// CreateApiKeyRequest is the request body for the create api key endpoint
+type CreateApiKeyRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expiresIn"`
+}
+
+// ApiKeyResponse is the server representation of an API key
+// Key is only populated when a key is created or rotated
+type ApiKeyResponse struct {
+	*ApiKey
+	Scopes []string `json:"scopes"`
+	Key    string   `json:"key,omitempty"`
+}
+
+// ListApiKeysResponse is the server response for the list api keys endpoint
+type ListApiKeysResponse struct {
+	ApiKeys []ApiKeyResponse `json:"apiKeys"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import "time"
+
+// statuses of a background job
+const (
+	// JobPending jobs wait for their run at time (and a free worker)
+	JobPending = "pending"
+
+	// JobRunning jobs are claimed by a worker
+	JobRunning = "running"
+
+	// JobSucceeded jobs completed successfully
+	JobSucceeded = "succeeded"
+
+	// JobFailed jobs failed maxAttempts times and are not retried
+	JobFailed = "failed"
+)
+
+// BackgroundJob is a job of the job queue, stored in the background_jobs table
+type BackgroundJob struct {
+	// ID of the job
+	ID int64 `gorm:"primaryKey;autoIncrement" json:"id"`
+
+	// Type of the job, selecting its handler
+	Type string `gorm:"size:128;not null" json:"type"`
+
+	// Payload of the job (JSON encoded)
+	Payload []byte `json:"payload,omitempty"`
+
+	// UniqueKey de-duplicates jobs, a job is not enqueued while another pending or running job has its key
+	UniqueKey *string `gorm:"size:256" json:"uniqueKey,omitempty"`
+
+	// Priority of the job, jobs with a greater priority run first
+	Priority int32 `gorm:"not null;default:0" json:"priority"`
+
+	// Status of the job (pending, running, succeeded or failed)
+	Status string `gorm:"size:16;not null" json:"status"`
+
+	// Attempts is the number of times the job was claimed by a worker
+	Attempts int32 `gorm:"not null;default:0" json:"attempts"`
+
+	// MaxAttempts after which the job fails
+	MaxAttempts int32 `gorm:"not null" json:"maxAttempts"`
+
+	// RunAt is when the job is (re)run
+	RunAt time.Time `gorm:"not null" json:"runAt"`
+
+	// LockedBy is the worker running the job, which holds it until LockedUntil
+	LockedBy    string     `gorm:"size:256" json:"lockedBy,omitempty"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+
+	// LastError of the last failed attempt
+	LastError string `json:"lastError,omitempty"`
+
+	// FinishedAt is when the job succeeded or failed
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BackgroundJobCount is the number of jobs of a type in a status
+type BackgroundJobCount struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// ListBackgroundJobsResponse is the server response for the list background jobs endpoint
+type ListBackgroundJobsResponse struct {
+	Jobs   []BackgroundJob      `json:"jobs"`
+	Counts []BackgroundJobCount `json:"counts"`
+}
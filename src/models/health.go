@@ -0,0 +1,31 @@
+package models
+
+// HealthResponse is the server response for the health API endpoint
+type HealthResponse struct {
+	Status string `json:"status"`
+
+	// Leader election status (omitted if leader election is disabled)
+	Leader *LeaderStatus `json:"leader,omitempty"`
+}
+
+// LeaderStatus reports the current leader of the service's replicas
+type LeaderStatus struct {
+	// Leader is the identity of the current leader (empty during elections)
+	Leader string `json:"leader"`
+
+	// Identity of the replica serving the request and whether it is the leader
+	Identity string `json:"identity"`
+	IsLeader bool   `json:"isLeader"`
+
+	// FencingToken of the current term (only reported by the leader)
+	FencingToken int64 `json:"fencingToken,omitempty"`
+
+	// Error looking up the leader
+	Error string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the server response for the readiness API endpoint
+type ReadinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
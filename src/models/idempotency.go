@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// IdempotencyKey stores the state of a request made with an idempotency key in the idempotency_keys table
+type IdempotencyKey struct {
+	// Key identifies the idempotency key (scoped to its caller)
+	Key string `gorm:"primaryKey;size:256"`
+
+	// Record of the request (its fingerprint and, once completed, its response), opaque to the repository
+	Record []byte `gorm:"not null"`
+
+	// ExpiresAt is when the key may be reused
+	ExpiresAt time.Time `gorm:"not null"`
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+)
+
+// JobResponse is the server representation of a scheduled job and its last run
+type JobResponse struct {
+	Name         string     `json:"name"`
+	Schedule     string     `json:"schedule"`
+	LeaderOnly   bool       `json:"leaderOnly"`
+	Running      bool       `json:"running"`
+	NextRunAt    *time.Time `json:"nextRunAt,omitempty"`
+	LastRunAt    *time.Time `json:"lastRunAt,omitempty"`
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastResult   string     `json:"lastResult,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	Runs         int64      `json:"runs"`
+	Failures     int64      `json:"failures"`
+}
+
+// ListJobsResponse is the server response for the list jobs endpoint
+type ListJobsResponse struct {
+	Jobs []JobResponse `json:"jobs"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// OutboxEvent is an event recorded in the outbox table alongside the business writes of a transaction
+// the outbox relay publishes it once the transaction commits
+type OutboxEvent struct {
+	// ID orders the events (ascending ids are published first)
+	ID int64 `gorm:"primaryKey;autoIncrement" json:"id"`
+
+	// AggregateKey identifies the entity the event belongs to, events of a key are published in order
+	AggregateKey string `gorm:"size:256;not null" json:"aggregateKey"`
+
+	// Topic the event is published to
+	Topic string `gorm:"size:256;not null" json:"topic"`
+
+	// Payload of the event
+	Payload []byte `json:"payload"`
+
+	// Attempts is the number of failed publish attempts
+	Attempts int32 `gorm:"not null;default:0" json:"attempts"`
+
+	// NextAttemptAt is when the event is (re)published
+	NextAttemptAt time.Time `gorm:"not null" json:"nextAttemptAt"`
+
+	// LastError of the last failed publish attempt
+	LastError string `json:"lastError,omitempty"`
+
+	// DeliveredAt is when the event was published (nil while pending)
+	DeliveredAt *time.Time `json:"deliveredAt,omitempty"`
+
+	// DiscardedAt is when the event was given up on after too many attempts (nil while pending)
+	DiscardedAt *time.Time `json:"discardedAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -0,0 +1,44 @@
+package models
+
+// RpcServicesResponse is the server response for the rpc introspection endpoint
+type RpcServicesResponse struct {
+	// Services hosted by the rpc server
+	Services []RpcService `json:"services"`
+
+	// Messages holds the schema of every message referenced by the services, keyed by full name
+	Messages map[string]RpcMessage `json:"messages"`
+}
+
+// RpcService describes a gRPC service
+type RpcService struct {
+	Name    string      `json:"name"`
+	File    string      `json:"file,omitempty"`
+	Methods []RpcMethod `json:"methods"`
+}
+
+// RpcMethod describes a gRPC method
+type RpcMethod struct {
+	Name            string `json:"name"`
+	FullMethod      string `json:"fullMethod"`
+	Request         string `json:"request,omitempty"`
+	Response        string `json:"response,omitempty"`
+	ClientStreaming bool   `json:"clientStreaming"`
+	ServerStreaming bool   `json:"serverStreaming"`
+}
+
+// RpcMessage describes the schema of a protobuf message
+type RpcMessage struct {
+	Fields []RpcField `json:"fields"`
+}
+
+// RpcField describes a field of a protobuf message
+type RpcField struct {
+	Name     string `json:"name"`
+	JsonName string `json:"jsonName"`
+	Number   int32  `json:"number"`
+	Type     string `json:"type"`
+	TypeName string `json:"typeName,omitempty"`
+	Repeated bool   `json:"repeated,omitempty"`
+	Map      bool   `json:"map,omitempty"`
+	OneOf    string `json:"oneOf,omitempty"`
+}
@@ -0,0 +1,36 @@
+package outbox
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/models"
+)
+
+// Publisher delivers outbox events to their destination (a message broker, a webhook, etc)
+// events are delivered at least once, publishers (or consumers) should de-duplicate by event id
+type Publisher interface {
+	// Publish delivers the event, an error causes it to be retried
+	Publish(ctx context.Context, event *models.OutboxEvent) error
+}
+
+// PublisherFunc adapts a function to the Publisher interface
+type PublisherFunc func(ctx context.Context, event *models.OutboxEvent) error
+
+// Publish calls the function
+func (f PublisherFunc) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	return f(ctx, event)
+}
+
+// LogPublisher logs events instead of delivering them, it is used when a service does not provide a publisher
+type LogPublisher struct {
+	// logger object
+	Logger *log.Entry
+}
+
+// Publish logs the event
+func (p *LogPublisher) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	p.Logger.Infof("outbox event %d (%s) published to %s: %s", event.ID, event.AggregateKey, event.Topic, event.Payload)
+	return nil
+}
@@ -0,0 +1,256 @@
+package outbox
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultPollInterval    = 1 * time.Second
+	defaultBatchSize       = 100
+	defaultPublishTimeout  = 10 * time.Second
+	defaultMinRetryBackoff = 1 * time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+	defaultRetention       = 24 * time.Hour
+
+	// delivered events are deleted at this interval
+	cleanupInterval = 10 * time.Minute
+
+	// claimed events are leased for the publish timeout plus this margin to record the outcome
+	claimMargin = 10 * time.Second
+)
+
+// metrics exported by the relay, labelled by topic
+var (
+	publishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Number of outbox events published",
+	}, []string{"topic"})
+
+	publishFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_publish_failures_total",
+		Help: "Number of failed outbox event publish attempts",
+	}, []string{"topic"})
+
+	discardedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_discarded_total",
+		Help: "Number of outbox events discarded after too many publish attempts",
+	}, []string{"topic"})
+)
+
+// Store is the datastore the relay reads events from
+type Store interface {
+	repository.OutboxRepository
+}
+
+// Relay publishes the events recorded in the outbox
+// events of an aggregate key are published one at a time in the order they were recorded; a failing event
+// is retried with backoff and holds back the later events of its key (until it is discarded after maxAttempts)
+// relays of several replicas may run concurrently, each event is claimed by the relay publishing it
+type Relay struct {
+	// store holding the outbox and the publisher events are delivered to
+	store     Store
+	publisher Publisher
+
+	// relay settings
+	pollInterval    time.Duration
+	batchSize       int
+	publishTimeout  time.Duration
+	maxAttempts     int32
+	minRetryBackoff time.Duration
+	maxRetryBackoff time.Duration
+	retention       time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// ctx is cancelled to stop the relay (interrupting in-flight publishes)
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRelay creates an outbox relay publishing the store's events to the publisher
+func NewRelay(config *proto.OutboxConfig, store Store, publisher Publisher, logger *log.Entry) (*Relay, error) {
+	pollInterval, err := util.ParseDuration(config.PollInterval, defaultPollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	publishTimeout, err := util.ParseDuration(config.PublishTimeout, defaultPublishTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	minRetryBackoff, err := util.ParseDuration(config.MinRetryBackoff, defaultMinRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetryBackoff, err := util.ParseDuration(config.MaxRetryBackoff, defaultMaxRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	retention, err := util.ParseDuration(config.Retention, defaultRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := int(config.BatchSize)
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Relay{
+		store:           store,
+		publisher:       publisher,
+		pollInterval:    pollInterval,
+		batchSize:       batchSize,
+		publishTimeout:  publishTimeout,
+		maxAttempts:     config.MaxAttempts,
+		minRetryBackoff: minRetryBackoff,
+		maxRetryBackoff: maxRetryBackoff,
+		retention:       retention,
+		logger:          logger,
+		ctx:             ctx,
+		cancel:          cancel,
+	}, nil
+}
+
+// Start polls the outbox and cleans up delivered events in the background until stopped
+func (r *Relay) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		pollTicker := time.NewTicker(r.pollInterval)
+		defer pollTicker.Stop()
+		cleanupTicker := time.NewTicker(cleanupInterval)
+		defer cleanupTicker.Stop()
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-pollTicker.C:
+				r.drain()
+			case <-cleanupTicker.C:
+				r.cleanup()
+			}
+		}
+	}()
+}
+
+// Stop stops the relay, interrupting in-flight publishes (they are retried by the next relay)
+func (r *Relay) Stop() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+// drain publishes batches of events until no more events are published
+// every batch holds at most one event per aggregate key, so keys with many pending events take several batches
+func (r *Relay) drain() {
+	for r.ctx.Err() == nil {
+		published, err := r.publishBatch(r.ctx)
+		if err != nil {
+			r.logger.Errorf("failed to relay outbox events: %v", err)
+			return
+		}
+
+		if published == 0 {
+			return
+		}
+	}
+}
+
+// publishBatch publishes the pending events, returning how many were published
+// no transaction is held while publishing: each event is first claimed (leased for the publish timeout) so
+// concurrent relays skip it, and its outcome is recorded once published. events of a relay stopping mid publish
+// are retried once their lease expires
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	events, err := r.store.PendingOutboxEvents(ctx, r.batchSize, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for i := range events {
+		event := &events[i]
+		now := time.Now()
+		claimed, err := r.store.ClaimOutboxEvent(ctx, event.ID, now, now.Add(r.publishTimeout+claimMargin))
+		if err != nil {
+			return published, err
+		}
+
+		if !claimed {
+			continue
+		}
+
+		publishCtx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+		err = r.publisher.Publish(publishCtx, event)
+		cancel()
+
+		if err == nil {
+			publishedTotal.WithLabelValues(event.Topic).Inc()
+			if err := r.store.MarkOutboxEventDelivered(ctx, event.ID, time.Now()); err != nil {
+				return published, err
+			}
+
+			published++
+			continue
+		}
+
+		publishFailuresTotal.WithLabelValues(event.Topic).Inc()
+		attempts := event.Attempts + 1
+		if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+			r.logger.Errorf("discarding outbox event %d (%s) after %d attempts: %v",
+				event.ID, event.AggregateKey, attempts, err)
+			discardedTotal.WithLabelValues(event.Topic).Inc()
+			if err := r.store.DiscardOutboxEvent(ctx, event.ID, time.Now(), err.Error()); err != nil {
+				return published, err
+			}
+
+			continue
+		}
+
+		backoff := r.backoff(attempts)
+		r.logger.Warnf("failed to publish outbox event %d (%s, attempt %d), retrying in %s: %v",
+			event.ID, event.AggregateKey, attempts, backoff, err)
+		if err := r.store.MarkOutboxEventFailed(ctx, event.ID, time.Now().Add(backoff), err.Error()); err != nil {
+			return published, err
+		}
+	}
+
+	return published, nil
+}
+
+// cleanup deletes the events delivered before the retention period
+func (r *Relay) cleanup() {
+	deleted, err := r.store.DeleteDeliveredOutboxEvents(r.ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		r.logger.Errorf("failed to delete delivered outbox events: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		r.logger.Infof("deleted %d delivered outbox events", deleted)
+	}
+}
+
+// backoff returns the delay before the given publish attempt is retried
+func (r *Relay) backoff(attempts int32) time.Duration {
+	return time.Duration(math.Min(float64(r.maxRetryBackoff),
+		float64(r.minRetryBackoff)*math.Pow(2, float64(attempts-1))))
+}
@@ -0,0 +1,138 @@
+// Contains outbox relay unit testcases
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/repository"
+)
+
+// TestRelay unit tests ordering per aggregate key, retries, discarding and cleanup of outbox events
+func TestRelay(test *testing.T) {
+	logger := log.WithField("test", "outbox")
+	repo, err := repository.NewRepository(&proto.DatastoreConfig{Driver: repository.DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	// the first event of order-1 fails once, events of order-2 are discarded after two attempts
+	var published []string
+	failures := map[string]int{"order-1/created": 1, "order-2/created": 2}
+	publisher := PublisherFunc(func(ctx context.Context, event *models.OutboxEvent) error {
+		name := event.AggregateKey + "/" + string(event.Payload)
+		if failures[name] > 0 {
+			failures[name]--
+			return errors.New("broker unavailable")
+		}
+
+		published = append(published, name)
+		return nil
+	})
+
+	relay, err := NewRelay(&proto.OutboxConfig{MaxAttempts: 2, MinRetryBackoff: "1ms", MaxRetryBackoff: "1ms"},
+		repo, publisher, logger)
+	if err != nil {
+		test.Errorf("failed to create relay: %v", err)
+		return
+	}
+
+	// events recorded in a rolled back transaction are never published
+	failure := errors.New("failure")
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		repo.EnqueueOutboxEvent(ctx, &models.OutboxEvent{AggregateKey: "order-3", Topic: "orders", Payload: []byte("created")})
+		return failure
+	})
+	if err != failure {
+		test.Errorf("unexpected transaction error: %v", err)
+		return
+	}
+
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		for _, event := range []string{"order-1/created", "order-2/created", "order-1/paid", "order-2/paid"} {
+			key, payload := event[:7], event[8:]
+			err := repo.EnqueueOutboxEvent(ctx, &models.OutboxEvent{AggregateKey: key, Topic: "orders", Payload: []byte(payload)})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		test.Errorf("failed to enqueue events: %v", err)
+		return
+	}
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * time.Millisecond)
+		if _, err := relay.publishBatch(ctx); err != nil {
+			test.Errorf("failed to publish events: %v", err)
+			return
+		}
+	}
+
+	// events are published in order per aggregate key, the discarded event no longer holds back its key
+	perKey := map[string][]string{}
+	for _, name := range published {
+		perKey[name[:7]] = append(perKey[name[:7]], name[8:])
+	}
+
+	if len(published) != 3 || len(perKey["order-1"]) != 2 || perKey["order-1"][0] != "created" ||
+		len(perKey["order-2"]) != 1 || perKey["order-2"][0] != "paid" {
+		test.Errorf("unexpected published events: %v", published)
+		return
+	}
+
+	// events claimed by another relay are skipped until their lease expires
+	event := &models.OutboxEvent{AggregateKey: "order-3", Topic: "orders", Payload: []byte("created")}
+	if err := repo.EnqueueOutboxEvent(ctx, event); err != nil {
+		test.Errorf("failed to enqueue event: %v", err)
+		return
+	}
+
+	now := time.Now()
+	if claimed, err := repo.ClaimOutboxEvent(ctx, event.ID, now, now.Add(time.Hour)); err != nil || !claimed {
+		test.Errorf("failed to claim event (claimed %v): %v", claimed, err)
+		return
+	}
+
+	if claimed, err := repo.ClaimOutboxEvent(ctx, event.ID, now, now.Add(time.Hour)); err != nil || claimed {
+		test.Errorf("event claimed twice (claimed %v): %v", claimed, err)
+		return
+	}
+
+	if count, err := relay.publishBatch(ctx); err != nil || count != 0 || len(published) != 3 {
+		test.Errorf("claimed event published (%d published): %v", count, err)
+		return
+	}
+
+	// delivered events are deleted once the retention period passes
+	relay.retention = 0
+	relay.cleanup()
+	deleted, err := repo.DeleteDeliveredOutboxEvents(ctx, time.Now())
+	if err != nil || deleted != 0 {
+		test.Errorf("delivered events not cleaned up (%d left): %v", deleted, err)
+		return
+	}
+}
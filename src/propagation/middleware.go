@@ -0,0 +1,91 @@
+package propagation
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// GinMiddleware extracts propagated headers from REST requests into the request context
+// requests without a request id are assigned one, which is echoed in the response
+func GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		values := Values{}
+		for _, header := range Headers {
+			if value := c.GetHeader(header); value != "" {
+				values[header] = value
+			}
+		}
+
+		if values[RequestIDHeader] == "" {
+			values[RequestIDHeader] = NewRequestID()
+		}
+
+		c.Header(RequestIDHeader, values[RequestIDHeader])
+		c.Request = c.Request.WithContext(NewContext(c.Request.Context(), values))
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor extracts propagated metadata from unary RPCs into the context
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		return handler(fromIncomingMetadata(ctx), req)
+	}
+}
+
+// StreamServerInterceptor extracts propagated metadata from streaming RPCs into the context
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		return handler(srv, &contextServerStream{ServerStream: ss, ctx: fromIncomingMetadata(ss.Context())})
+	}
+}
+
+// fromIncomingMetadata returns a context carrying the propagated values of an incoming RPC
+// RPCs without a request id are assigned one, which is returned in the response header
+func fromIncomingMetadata(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := Values{}
+	for _, header := range Headers {
+		if found := md.Get(strings.ToLower(header)); len(found) > 0 && found[0] != "" {
+			values[header] = found[0]
+		}
+	}
+
+	if values[RequestIDHeader] == "" {
+		values[RequestIDHeader] = NewRequestID()
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), values[RequestIDHeader]))
+	return NewContext(ctx, values)
+}
+
+// AppendToOutgoingContext adds the propagated values carried by the context to outgoing RPC metadata
+func AppendToOutgoingContext(ctx context.Context) context.Context {
+	var pairs []string
+	for header, value := range FromContext(ctx) {
+		pairs = append(pairs, strings.ToLower(header), value)
+	}
+
+	if len(pairs) == 0 {
+		return ctx
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, pairs...)
+}
+
+// contextServerStream overrides the context of a server stream
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the overridden context
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
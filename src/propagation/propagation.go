@@ -0,0 +1,63 @@
+// Propagation package carries request scoped values (request id, W3C trace context)
+// from incoming REST/RPC requests to outbound calls made while serving them
+
+package propagation
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// header (and RPC metadata) names of the propagated values
+const (
+	// RequestIDHeader identifies a request across services
+	RequestIDHeader = "X-Request-Id"
+
+	// TraceParentHeader is the W3C trace context parent header
+	TraceParentHeader = "Traceparent"
+
+	// TraceStateHeader is the W3C trace context vendor state header
+	TraceStateHeader = "Tracestate"
+)
+
+// Headers lists all propagated headers
+var Headers = []string{RequestIDHeader, TraceParentHeader, TraceStateHeader}
+
+// valuesKey is the context key under which propagated values are stored
+type valuesKey struct{}
+
+// Values holds the propagated values of a request, keyed by header name
+type Values map[string]string
+
+// NewContext returns a copy of the context carrying the values
+func NewContext(ctx context.Context, values Values) context.Context {
+	return context.WithValue(ctx, valuesKey{}, values)
+}
+
+// FromContext returns the propagated values carried by the context (nil if none)
+func FromContext(ctx context.Context) Values {
+	values, _ := ctx.Value(valuesKey{}).(Values)
+	return values
+}
+
+// RequestID returns the request id carried by the context (empty if none)
+func RequestID(ctx context.Context) string {
+	return FromContext(ctx)[RequestIDHeader]
+}
+
+// WithRequestID returns a copy of the context carrying the request id (other values are kept)
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	values := Values{}
+	for header, value := range FromContext(ctx) {
+		values[header] = value
+	}
+
+	values[RequestIDHeader] = requestID
+	return NewContext(ctx, values)
+}
+
+// NewRequestID generates a new request id
+func NewRequestID() string {
+	return uuid.New().String()
+}
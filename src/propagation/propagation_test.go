@@ -0,0 +1,108 @@
+// Contains propagation unit testcases
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceParent is a valid W3C trace context parent used by the testcases
+const traceParent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+// TestGinMiddleware unit tests extracting propagated headers from REST requests
+func TestGinMiddleware(test *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(GinMiddleware())
+
+	var values Values
+	engine.GET("/ping", func(c *gin.Context) {
+		values = FromContext(c.Request.Context())
+	})
+
+	testcases := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{name: "propagated", headers: map[string]string{RequestIDHeader: "request-1", TraceParentHeader: traceParent}},
+		{name: "generated request id"},
+	}
+
+	for _, tc := range testcases {
+		request := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		for header, value := range tc.headers {
+			request.Header.Set(header, value)
+		}
+
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+
+		requestID := recorder.Header().Get(RequestIDHeader)
+		if requestID == "" || values[RequestIDHeader] != requestID ||
+			values[TraceParentHeader] != tc.headers[TraceParentHeader] {
+			test.Errorf("testcase %s: unexpected values %v (response request id %q)", tc.name, values, requestID)
+			continue
+		}
+
+		if expected, ok := tc.headers[RequestIDHeader]; ok && requestID != expected {
+			test.Errorf("testcase %s: request id %q not propagated, got %q", tc.name, expected, requestID)
+		}
+	}
+}
+
+// TestRPCPropagation unit tests extracting propagated metadata from RPCs and forwarding it to outbound RPCs
+func TestRPCPropagation(test *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "request-1",
+		"traceparent", traceParent))
+
+	var values Values
+	interceptor := UnaryServerInterceptor()
+	_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			values = FromContext(ctx)
+			return nil, nil
+		})
+	if err != nil || values[RequestIDHeader] != "request-1" || values[TraceParentHeader] != traceParent {
+		test.Errorf("unexpected values %v: %v", values, err)
+		return
+	}
+
+	// the values are forwarded with outbound calls (as lower cased metadata)
+	md, _ := metadata.FromOutgoingContext(AppendToOutgoingContext(NewContext(context.Background(), values)))
+	if len(md.Get("x-request-id")) != 1 || md.Get("x-request-id")[0] != "request-1" ||
+		len(md.Get("traceparent")) != 1 {
+		test.Errorf("unexpected outgoing metadata %v", md)
+		return
+	}
+
+	// RPCs without a request id are assigned one
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			values = FromContext(ctx)
+			return nil, nil
+		})
+	if err != nil || values[RequestIDHeader] == "" {
+		test.Errorf("request id not generated %v: %v", values, err)
+	}
+}
+
+// TestWithRequestID unit tests replacing the request id of a context
+func TestWithRequestID(test *testing.T) {
+	original := Values{RequestIDHeader: "request-1", TraceParentHeader: traceParent}
+	ctx := WithRequestID(NewContext(context.Background(), original), "request-2")
+
+	if RequestID(ctx) != "request-2" || FromContext(ctx)[TraceParentHeader] != traceParent ||
+		original[RequestIDHeader] != "request-1" {
+		test.Errorf("unexpected values %v (original %v)", FromContext(ctx), original)
+	}
+
+	if RequestID(context.Background()) != "" || AppendToOutgoingContext(context.Background()) != context.Background() {
+		test.Errorf("values found in an empty context")
+	}
+}
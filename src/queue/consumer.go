@@ -0,0 +1,333 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultConcurrency     = 1
+	defaultMinRetryBackoff = 1 * time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+	defaultHandlerTimeout  = 20 * time.Second
+	defaultDrainTimeout    = 30 * time.Second
+
+	// deadLetterSuffix is appended to the topic to name the default dead letter topic
+	deadLetterSuffix = ".dead"
+
+	// subscribeRetryInterval is how long the consumer waits after failing to receive a message
+	subscribeRetryInterval = 1 * time.Second
+)
+
+// headers added to dead lettered messages
+const (
+	DeadLetterTopicHeader    = "X-Dead-Letter-Topic"
+	DeadLetterErrorHeader    = "X-Dead-Letter-Error"
+	DeadLetterAttemptsHeader = "X-Dead-Letter-Attempts"
+)
+
+// metrics exported by the consumers, labelled by consumer
+var (
+	handledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "queue_messages_handled_total",
+		Help: "Number of messages handled by result (ok, retried, dead_lettered)",
+	}, []string{"consumer", "result"})
+
+	handlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "queue_handler_duration_seconds",
+		Help:    "Time taken to handle a message",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"consumer"})
+
+	inFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "queue_messages_in_flight",
+		Help: "Number of messages being handled",
+	}, []string{"consumer"})
+)
+
+// Handler handles a delivered message, a returned error (or panic) retries the message with backoff
+type Handler func(ctx context.Context, delivery *Delivery) error
+
+// Consumer is a pool of workers handling the messages of a topic
+// messages failing maxAttempts times are published to the dead letter topic. closing the consumer stops
+// receiving messages and waits for in-flight ones (up to the drain timeout, after which handlers are cancelled)
+type Consumer struct {
+	// name of the consumer, its topic and group
+	name  string
+	topic string
+	group string
+
+	// broker delivering the messages and their handler
+	broker  Broker
+	handler Handler
+
+	// consumer settings
+	concurrency     int
+	maxAttempts     int
+	minRetryBackoff time.Duration
+	maxRetryBackoff time.Duration
+	deadLetterTopic string
+	handlerTimeout  time.Duration
+	drainTimeout    time.Duration
+
+	// logger object
+	logger *log.Entry
+
+	// subscription to the topic
+	subscription Subscription
+
+	// ctx is cancelled to stop receiving messages, handlerCtx to interrupt handlers after the drain timeout
+	ctx           context.Context
+	cancel        context.CancelFunc
+	handlerCtx    context.Context
+	handlerCancel context.CancelFunc
+	wg            sync.WaitGroup
+	inFlight      sync.WaitGroup
+}
+
+// NewConsumer creates a consumer handling the configured topic's messages with the handler
+func NewConsumer(config *proto.ConsumerConfig, broker Broker, handler Handler, logger *log.Entry) (*Consumer, error) {
+	if config.Topic == "" {
+		return nil, fmt.Errorf("consumer %s has no topic", config.Name)
+	}
+
+	minRetryBackoff, err := util.ParseDuration(config.MinRetryBackoff, defaultMinRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	maxRetryBackoff, err := util.ParseDuration(config.MaxRetryBackoff, defaultMaxRetryBackoff)
+	if err != nil {
+		return nil, err
+	}
+
+	handlerTimeout, err := util.ParseDuration(config.HandlerTimeout, defaultHandlerTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// a handler outliving the ack wait would have its message redelivered and handled twice concurrently
+	if ackWait := broker.AckWait(); ackWait > 0 && handlerTimeout >= ackWait {
+		return nil, fmt.Errorf("consumer %s handler timeout %s must be shorter than the queue's ack wait %s",
+			config.Name, handlerTimeout, ackWait)
+	}
+
+	drainTimeout, err := util.ParseDuration(config.DrainTimeout, defaultDrainTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	concurrency := int(config.Concurrency)
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	group := config.Group
+	if group == "" {
+		group = config.Name
+	}
+
+	deadLetterTopic := config.DeadLetterTopic
+	if deadLetterTopic == "" {
+		deadLetterTopic = config.Topic + deadLetterSuffix
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	handlerCtx, handlerCancel := context.WithCancel(context.Background())
+	return &Consumer{
+		name:            config.Name,
+		topic:           config.Topic,
+		group:           group,
+		broker:          broker,
+		handler:         handler,
+		concurrency:     concurrency,
+		maxAttempts:     int(config.MaxAttempts),
+		minRetryBackoff: minRetryBackoff,
+		maxRetryBackoff: maxRetryBackoff,
+		deadLetterTopic: deadLetterTopic,
+		handlerTimeout:  handlerTimeout,
+		drainTimeout:    drainTimeout,
+		logger:          logger.WithField("consumer", config.Name),
+		ctx:             ctx,
+		cancel:          cancel,
+		handlerCtx:      handlerCtx,
+		handlerCancel:   handlerCancel,
+	}, nil
+}
+
+// Start subscribes to the topic and handles its messages in the background until closed
+func (c *Consumer) Start() error {
+	subscription, err := c.broker.Subscribe(c.ctx, c.topic, c.group)
+	if err != nil {
+		return err
+	}
+
+	c.subscription = subscription
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		// a worker slot is taken before receiving, so messages are not held while all workers are busy
+		slots := make(chan struct{}, c.concurrency)
+		for {
+			select {
+			case slots <- struct{}{}:
+			case <-c.ctx.Done():
+				return
+			}
+
+			delivery, err := subscription.Next(c.ctx)
+			if err != nil {
+				<-slots
+				if c.ctx.Err() != nil {
+					return
+				}
+
+				c.logger.Warnf("failed to receive message from %s: %v", c.topic, err)
+				select {
+				case <-time.After(subscribeRetryInterval):
+				case <-c.ctx.Done():
+					return
+				}
+
+				continue
+			}
+
+			c.inFlight.Add(1)
+			inFlight.WithLabelValues(c.name).Inc()
+			go func() {
+				defer func() {
+					inFlight.WithLabelValues(c.name).Dec()
+					<-slots
+					c.inFlight.Done()
+				}()
+
+				c.handle(delivery)
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// Close stops receiving messages and drains the in-flight ones
+// handlers still running after the drain timeout are cancelled, their messages are redelivered by the broker
+func (c *Consumer) Close() {
+	c.cancel()
+	c.wg.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		c.inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(c.drainTimeout):
+		c.logger.Warnf("cancelling in-flight messages after waiting %s", c.drainTimeout)
+		c.handlerCancel()
+		<-drained
+	}
+
+	c.handlerCancel()
+	if c.subscription != nil {
+		c.subscription.Close()
+	}
+}
+
+// handle runs the handler and settles the delivery with the broker
+func (c *Consumer) handle(delivery *Delivery) {
+	start := time.Now()
+	err := c.invoke(delivery)
+	handlerDuration.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+	if err == nil {
+		handledTotal.WithLabelValues(c.name, "ok").Inc()
+		if err := delivery.Ack(); err != nil {
+			c.logger.Warnf("failed to acknowledge message %s: %v", delivery.ID, err)
+		}
+
+		return
+	}
+
+	if c.maxAttempts > 0 && delivery.Attempt >= c.maxAttempts {
+		if c.deadLetter(delivery, err) {
+			return
+		}
+	}
+
+	backoff := c.backoff(delivery.Attempt)
+	c.logger.Warnf("failed to handle message %s of %s (attempt %d), retrying in %s: %v",
+		delivery.ID, c.topic, delivery.Attempt, backoff, err)
+	handledTotal.WithLabelValues(c.name, "retried").Inc()
+	if err := delivery.Nack(backoff); err != nil {
+		c.logger.Warnf("failed to reject message %s: %v", delivery.ID, err)
+	}
+}
+
+// invoke runs the handler with a timeout, recovering panics
+func (c *Consumer) invoke(delivery *Delivery) (err error) {
+	ctx, cancel := context.WithTimeout(c.handlerCtx, c.handlerTimeout)
+	defer cancel()
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("handler panicked: %v", recovered)
+		}
+	}()
+
+	return c.handler(ctx, delivery)
+}
+
+// deadLetter publishes a message that failed too many times to the dead letter topic and acknowledges it
+// the message is retried if it cannot be dead lettered
+func (c *Consumer) deadLetter(delivery *Delivery, cause error) bool {
+	headers := make(map[string]string, len(delivery.Headers)+3)
+	for name, value := range delivery.Headers {
+		headers[name] = value
+	}
+
+	headers[DeadLetterTopicHeader] = c.topic
+	headers[DeadLetterErrorHeader] = cause.Error()
+	headers[DeadLetterAttemptsHeader] = strconv.Itoa(delivery.Attempt)
+
+	// the id is derived from the message's as brokers de-duplicate ids across topics
+	message := &Message{Headers: headers, Body: delivery.Body}
+	if delivery.ID != "" {
+		message.ID = delivery.ID + deadLetterSuffix
+	}
+
+	ctx, cancel := context.WithTimeout(c.handlerCtx, c.handlerTimeout)
+	defer cancel()
+	if err := c.broker.Publish(ctx, c.deadLetterTopic, message); err != nil {
+		c.logger.Errorf("failed to dead letter message %s to %s: %v", delivery.ID, c.deadLetterTopic, err)
+		return false
+	}
+
+	c.logger.Errorf("dead lettered message %s to %s after %d attempts: %v",
+		delivery.ID, c.deadLetterTopic, delivery.Attempt, cause)
+	handledTotal.WithLabelValues(c.name, "dead_lettered").Inc()
+	if err := delivery.Ack(); err != nil {
+		c.logger.Warnf("failed to acknowledge message %s: %v", delivery.ID, err)
+	}
+
+	return true
+}
+
+// backoff returns the delay before the given attempt is retried
+func (c *Consumer) backoff(attempt int) time.Duration {
+	return time.Duration(math.Min(float64(c.maxRetryBackoff),
+		float64(c.minRetryBackoff)*math.Pow(2, float64(attempt-1))))
+}
@@ -0,0 +1,179 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryGroup queues the messages of a topic for the subscriptions of a group
+type memoryGroup struct {
+	lock    sync.Mutex
+	pending []*Delivery
+
+	// signal wakes a subscription waiting for messages
+	signal chan struct{}
+}
+
+// push queues a delivery and wakes a waiting subscription
+func (g *memoryGroup) push(delivery *Delivery) {
+	g.lock.Lock()
+	g.pending = append(g.pending, delivery)
+	g.lock.Unlock()
+	g.wake()
+}
+
+// pop dequeues the next delivery
+func (g *memoryGroup) pop() (*Delivery, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if len(g.pending) == 0 {
+		return nil, false
+	}
+
+	delivery := g.pending[0]
+	g.pending = g.pending[1:]
+	if len(g.pending) > 0 {
+		g.wake()
+	}
+
+	return delivery, true
+}
+
+// wake signals a waiting subscription
+func (g *memoryGroup) wake() {
+	select {
+	case g.signal <- struct{}{}:
+	default:
+	}
+}
+
+// MemoryBroker is a Broker keeping messages in process
+// messages published to a topic without subscribed groups are dropped, and deliveries that are never
+// settled are not redelivered (there is no ack wait)
+type MemoryBroker struct {
+	lock sync.Mutex
+
+	// groups subscribed to each topic
+	groups map[string]map[string]*memoryGroup
+	closed bool
+}
+
+// NewMemoryBroker creates an empty in process broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{groups: make(map[string]map[string]*memoryGroup)}
+}
+
+// Publish queues the message for every group subscribed to the topic
+func (m *MemoryBroker) Publish(ctx context.Context, topic string, message *Message) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("the queue is closed")
+	}
+
+	for _, group := range m.groups[topic] {
+		group.push(m.delivery(group, topic, *message, 1))
+	}
+
+	return nil
+}
+
+// Subscribe receives the messages of the topic
+func (m *MemoryBroker) Subscribe(ctx context.Context, topic, group string) (Subscription, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("the queue is closed")
+	}
+
+	if m.groups[topic] == nil {
+		m.groups[topic] = make(map[string]*memoryGroup)
+	}
+
+	g, ok := m.groups[topic][group]
+	if !ok {
+		g = &memoryGroup{signal: make(chan struct{}, 1)}
+		m.groups[topic][group] = g
+	}
+
+	return &memorySubscription{group: g, done: make(chan struct{})}, nil
+}
+
+// AckWait is zero, unsettled deliveries are never redelivered
+func (m *MemoryBroker) AckWait() time.Duration {
+	return 0
+}
+
+// Ping fails once the broker is closed
+func (m *MemoryBroker) Ping(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("the queue is closed")
+	}
+
+	return nil
+}
+
+// Close stops accepting messages
+func (m *MemoryBroker) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.closed = true
+	return nil
+}
+
+// delivery creates a delivery of the message, nacks queue it again after the delay
+func (m *MemoryBroker) delivery(group *memoryGroup, topic string, message Message, attempt int) *Delivery {
+	return &Delivery{
+		Message: message,
+		Topic:   topic,
+		Attempt: attempt,
+		ack:     func() error { return nil },
+		nack: func(delay time.Duration) error {
+			time.AfterFunc(delay, func() {
+				group.push(m.delivery(group, topic, message, attempt+1))
+			})
+
+			return nil
+		},
+	}
+}
+
+// memorySubscription receives the messages queued for its group
+type memorySubscription struct {
+	group *memoryGroup
+
+	// done is closed with the subscription
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Next waits for the next delivery
+func (s *memorySubscription) Next(ctx context.Context) (*Delivery, error) {
+	for {
+		if delivery, ok := s.group.pop(); ok {
+			return delivery, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.done:
+			return nil, fmt.Errorf("the subscription is closed")
+		case <-s.group.signal:
+		}
+	}
+}
+
+// Close stops receiving messages
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
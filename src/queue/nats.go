@@ -0,0 +1,250 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultStream         = "queue"
+	defaultConnectTimeout = 5 * time.Second
+	defaultAckWait        = 30 * time.Second
+
+	// fetchWait bounds each pull request waiting for messages
+	fetchWait = 5 * time.Second
+)
+
+// invalidConsumerChars are replaced in the names of JetStream consumers
+var invalidConsumerChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// NATSBroker is a Broker backed by NATS JetStream
+// topics are subjects of a stream, and every group is a durable pull consumer of the topic's subject,
+// so messages published while no replica is consuming are kept until they are handled
+type NATSBroker struct {
+	// conn to the server and its JetStream context
+	conn *nats.Conn
+	js   nats.JetStreamContext
+
+	// stream holding the topics
+	stream string
+
+	// ackWait after which unacknowledged deliveries are redelivered
+	ackWait time.Duration
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewNATSBroker connects to the configured NATS server, creating the stream if it does not exist
+func NewNATSBroker(config *proto.QueueConfig, logger *log.Entry) (*NATSBroker, error) {
+	connectTimeout, err := util.ParseDuration(config.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	ackWait, err := util.ParseDuration(config.AckWait, defaultAckWait)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := config.Stream
+	if stream == "" {
+		stream = defaultStream
+	}
+
+	options := []nats.Option{
+		nats.Timeout(connectTimeout),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warnf("disconnected from the queue: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			logger.Infof("reconnected to the queue at %s", conn.ConnectedUrl())
+		}),
+	}
+
+	if config.Username != "" {
+		options = append(options, nats.UserInfo(config.Username, config.Password))
+	}
+
+	conn, err := nats.Connect(config.Url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the queue at %s: %v", config.Url, err)
+	}
+
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	broker := &NATSBroker{conn: conn, js: js, stream: stream, ackWait: ackWait, logger: logger}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := broker.ensureStream(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return broker, nil
+}
+
+// Publish publishes the message to the topic's subject, waiting for the stream to persist it
+// JetStream drops publishes repeating the id of a message published within its duplicate window
+func (n *NATSBroker) Publish(ctx context.Context, topic string, message *Message) error {
+	msg := nats.NewMsg(n.subject(topic))
+	msg.Data = message.Body
+	for name, value := range message.Headers {
+		msg.Header.Set(name, value)
+	}
+
+	options := []nats.PubOpt{nats.Context(ctx)}
+	if message.ID != "" {
+		options = append(options, nats.MsgId(message.ID))
+	}
+
+	_, err := n.js.PublishMsg(msg, options...)
+	return err
+}
+
+// Subscribe binds to the durable consumer of the group, creating it if it does not exist
+// the consumer outlives the subscription, so the group keeps its position when its subscriptions close
+func (n *NATSBroker) Subscribe(ctx context.Context, topic, group string) (Subscription, error) {
+	subject := n.subject(topic)
+	durable := invalidConsumerChars.ReplaceAllString(group+"_"+topic, "_")
+	_, err := n.js.ConsumerInfo(n.stream, durable, nats.Context(ctx))
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = n.js.AddConsumer(n.stream, &nats.ConsumerConfig{
+			Durable:       durable,
+			FilterSubject: subject,
+			AckPolicy:     nats.AckExplicitPolicy,
+			AckWait:       n.ackWait,
+			MaxDeliver:    -1,
+			DeliverPolicy: nats.DeliverNewPolicy,
+		}, nats.Context(ctx))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the consumer of %s: %v", topic, err)
+	}
+
+	sub, err := n.js.PullSubscribe(subject, durable, nats.Bind(n.stream, durable))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsSubscription{sub: sub, stream: n.stream}, nil
+}
+
+// AckWait after which JetStream redelivers unacknowledged deliveries
+func (n *NATSBroker) AckWait() time.Duration {
+	return n.ackWait
+}
+
+// Ping verifies the connection to the server
+func (n *NATSBroker) Ping(ctx context.Context) error {
+	if !n.conn.IsConnected() {
+		return fmt.Errorf("not connected to the queue (%s)", n.conn.Status())
+	}
+
+	_, err := n.js.StreamInfo(n.stream, nats.Context(ctx))
+	return err
+}
+
+// Close flushes pending publishes and closes the connection
+func (n *NATSBroker) Close() error {
+	return n.conn.Drain()
+}
+
+// ensureStream creates the stream holding the topics if it does not exist
+func (n *NATSBroker) ensureStream(ctx context.Context) error {
+	_, err := n.js.StreamInfo(n.stream, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		n.logger.Infof("creating queue stream %s", n.stream)
+		_, err = n.js.AddStream(&nats.StreamConfig{
+			Name:     n.stream,
+			Subjects: []string{n.stream + ".>"},
+		}, nats.Context(ctx))
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to set up queue stream %s: %v", n.stream, err)
+	}
+
+	return nil
+}
+
+// subject returns the subject of a topic
+func (n *NATSBroker) subject(topic string) string {
+	return n.stream + "." + topic
+}
+
+// natsSubscription pulls the messages of a durable consumer
+type natsSubscription struct {
+	sub    *nats.Subscription
+	stream string
+}
+
+// Next pulls the next message, polling in fetchWait intervals until one is available
+func (s *natsSubscription) Next(ctx context.Context) (*Delivery, error) {
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+		msgs, err := s.sub.Fetch(1, nats.Context(fetchCtx))
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		if errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded) || (err == nil && len(msgs) == 0) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.delivery(msgs[0])
+	}
+}
+
+// Close stops pulling messages, the durable consumer is kept
+func (s *natsSubscription) Close() error {
+	return s.sub.Unsubscribe()
+}
+
+// delivery converts a pulled message
+func (s *natsSubscription) delivery(msg *nats.Msg) (*Delivery, error) {
+	metadata, err := msg.Metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(msg.Header))
+	for name := range msg.Header {
+		headers[name] = msg.Header.Get(name)
+	}
+
+	return &Delivery{
+		Message: Message{
+			ID:      msg.Header.Get(nats.MsgIdHdr),
+			Headers: headers,
+			Body:    msg.Data,
+		},
+		Topic:   msg.Subject[len(s.stream)+1:],
+		Attempt: int(metadata.NumDelivered),
+		ack:     func() error { return msg.Ack() },
+		nack:    func(delay time.Duration) error { return msg.NakWithDelay(delay) },
+	}, nil
+}
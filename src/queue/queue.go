@@ -0,0 +1,111 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// supported message broker drivers
+const (
+	// DriverNATS connects to a NATS server with JetStream enabled
+	DriverNATS = "nats"
+
+	// DriverMemory keeps messages in process, meant for development and tests
+	DriverMemory = "memory"
+)
+
+// Message is published to a topic
+type Message struct {
+	// ID of the message, brokers supporting it drop duplicate publishes of an id
+	ID string
+
+	// Headers of the message (e.g. the request id)
+	Headers map[string]string
+
+	// Body of the message
+	Body []byte
+}
+
+// Delivery is a message delivered to a subscription, which must acknowledge it (Ack) or ask for it to be
+// redelivered (Nack). deliveries neither acked nor nacked are redelivered after the broker's ack wait
+type Delivery struct {
+	Message
+
+	// Topic the message was published to
+	Topic string
+
+	// Attempt is the delivery attempt of the message (1 for the first delivery)
+	Attempt int
+
+	// ack and nack settle the delivery with the broker
+	once sync.Once
+	ack  func() error
+	nack func(delay time.Duration) error
+}
+
+// Ack acknowledges the message, it is not delivered again
+func (d *Delivery) Ack() error {
+	err := fmt.Errorf("delivery of message %s already settled", d.ID)
+	d.once.Do(func() { err = d.ack() })
+	return err
+}
+
+// Nack asks for the message to be redelivered after the delay
+func (d *Delivery) Nack(delay time.Duration) error {
+	err := fmt.Errorf("delivery of message %s already settled", d.ID)
+	d.once.Do(func() { err = d.nack(delay) })
+	return err
+}
+
+// Publisher publishes messages to topics
+type Publisher interface {
+	// Publish publishes a message to a topic
+	Publish(ctx context.Context, topic string, message *Message) error
+}
+
+// Subscription receives the messages of a topic
+type Subscription interface {
+	// Next waits for the next delivery until the context is done
+	Next(ctx context.Context) (*Delivery, error)
+
+	// Close stops receiving messages
+	Close() error
+}
+
+// Broker is a message broker shared by the replicas of a service
+type Broker interface {
+	Publisher
+
+	// Subscribe receives the messages of a topic published after the group was first subscribed
+	// every message is delivered to a single subscription of the group (e.g. the replicas of a service)
+	Subscribe(ctx context.Context, topic, group string) (Subscription, error)
+
+	// AckWait after which unsettled deliveries are redelivered (zero if they are never redelivered)
+	AckWait() time.Duration
+
+	// Ping verifies the broker is reachable
+	Ping(ctx context.Context) error
+
+	// Close closes the connection to the broker
+	Close() error
+}
+
+// NewBroker connects to the configured message broker, nil is returned if no driver is configured
+func NewBroker(config *proto.QueueConfig, logger *log.Entry) (Broker, error) {
+	switch config.Driver {
+	case "":
+		return nil, nil
+	case DriverNATS:
+		return NewNATSBroker(config, logger)
+	case DriverMemory:
+		return NewMemoryBroker(), nil
+	default:
+		return nil, fmt.Errorf("unsupported queue driver %q", config.Driver)
+	}
+}
@@ -0,0 +1,166 @@
+// Contains queue unit testcases (run against the memory and nats brokers)
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestMemoryBroker unit tests consumers of the in process broker
+func TestMemoryBroker(test *testing.T) {
+	testBroker(test, NewMemoryBroker())
+}
+
+// TestNATSBroker unit tests consumers of the nats broker against an in process server
+func TestNATSBroker(test *testing.T) {
+	natsServer, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true,
+		StoreDir: test.TempDir()})
+	if err != nil {
+		test.Errorf("failed to create nats server: %v", err)
+		return
+	}
+
+	go natsServer.Start()
+	defer natsServer.Shutdown()
+	if !natsServer.ReadyForConnections(5 * time.Second) {
+		test.Errorf("nats server not ready")
+		return
+	}
+
+	broker, err := NewBroker(&proto.QueueConfig{Driver: DriverNATS, Url: natsServer.ClientURL(), Stream: "test",
+		AckWait: "5s"}, log.WithField("test", "queue"))
+	if err != nil {
+		test.Errorf("failed to connect to nats: %v", err)
+		return
+	}
+	defer broker.Close()
+
+	// handlers must finish before their message is redelivered
+	_, err = NewConsumer(&proto.ConsumerConfig{Name: "orders", Topic: "orders", HandlerTimeout: "5s"}, broker,
+		func(ctx context.Context, delivery *Delivery) error { return nil }, log.WithField("test", "queue"))
+	if err == nil {
+		test.Errorf("consumer with a handler timeout as long as the ack wait accepted")
+		return
+	}
+
+	testBroker(test, broker)
+}
+
+// testBroker exercises consumers of a broker: handling, retries, dead lettering and draining
+func testBroker(test *testing.T, broker Broker) {
+	ctx := context.Background()
+	logger := log.WithField("test", "queue")
+
+	var lock sync.Mutex
+	handled := map[string]int{}
+	started := make(chan struct{}, 10)
+	release := make(chan struct{})
+	consumer, err := NewConsumer(&proto.ConsumerConfig{Name: "orders", Topic: "orders", Concurrency: 2,
+		MaxAttempts: 3, MinRetryBackoff: "10ms", MaxRetryBackoff: "50ms", HandlerTimeout: "2s"}, broker,
+		func(ctx context.Context, delivery *Delivery) error {
+			lock.Lock()
+			handled[string(delivery.Body)]++
+			lock.Unlock()
+
+			switch string(delivery.Body) {
+			case "fail":
+				return errors.New("failure")
+			case "panic":
+				panic("failure")
+			case "slow":
+				started <- struct{}{}
+				<-release
+			}
+
+			return nil
+		}, logger)
+	if err != nil {
+		test.Errorf("failed to create consumer: %v", err)
+		return
+	}
+
+	if err := consumer.Start(); err != nil {
+		test.Errorf("failed to start consumer: %v", err)
+		return
+	}
+
+	deadLetters, err := broker.Subscribe(ctx, "orders.dead", "test")
+	if err != nil {
+		test.Errorf("failed to subscribe to dead letters: %v", err)
+		return
+	}
+	defer deadLetters.Close()
+
+	for i, body := range []string{"ok", "fail", "panic"} {
+		if err := broker.Publish(ctx, "orders", &Message{ID: fmt.Sprint(i), Body: []byte(body)}); err != nil {
+			test.Errorf("failed to publish message: %v", err)
+			return
+		}
+	}
+
+	// failing messages are dead lettered after maxAttempts
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	dead := map[string]string{}
+	for len(dead) < 2 {
+		delivery, err := deadLetters.Next(waitCtx)
+		if err != nil {
+			test.Errorf("messages not dead lettered (%v): %v", dead, err)
+			return
+		}
+
+		dead[string(delivery.Body)] = delivery.Headers[DeadLetterAttemptsHeader]
+		delivery.Ack()
+	}
+
+	lock.Lock()
+	counts := fmt.Sprint(handled)
+	lock.Unlock()
+	if dead["fail"] != "3" || dead["panic"] != "3" || counts != "map[fail:3 ok:1 panic:3]" {
+		test.Errorf("unexpected dead letters %v (handled %s)", dead, counts)
+		return
+	}
+
+	// closing the consumer waits for in-flight messages
+	if err := broker.Publish(ctx, "orders", &Message{ID: "slow", Body: []byte("slow")}); err != nil {
+		test.Errorf("failed to publish message: %v", err)
+		return
+	}
+
+	select {
+	case <-started:
+	case <-time.After(10 * time.Second):
+		test.Errorf("slow message not handled")
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		consumer.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		test.Errorf("consumer closed with a message in flight")
+		return
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		test.Errorf("consumer not closed after draining")
+		return
+	}
+}
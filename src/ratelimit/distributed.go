@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// maxSwapAttempts bounds the optimistic concurrency retries on a contended bucket
+const maxSwapAttempts = 5
+
+// SharedStore holds bucket state shared by all replicas (satisfied by the service's kv store)
+type SharedStore interface {
+	// Get returns the value of a key and whether it exists
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// CompareAndSwap sets key to new (with a ttl) only if its current value is old
+	// a nil old value requires the key to not exist
+	CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+}
+
+// distributedLimiter keeps bucket state in a shared store so limits apply across replicas
+type distributedLimiter struct {
+	store  SharedStore
+	prefix string
+}
+
+// NewDistributedLimiter creates a limiter backed by a shared store
+// keys are namespaced with the prefix (typically the service name)
+func NewDistributedLimiter(store SharedStore, prefix string) Limiter {
+	return &distributedLimiter{store: store, prefix: prefix}
+}
+
+// Allow takes a token from the shared bucket using optimistic concurrency
+func (d *distributedLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	storeKey := d.prefix + "/ratelimit/" + key
+
+	// state expires once the bucket would have been refilled
+	ttl := time.Duration(limit.Burst)*limit.interval() + time.Second
+
+	for attempt := 0; attempt < maxSwapAttempts; attempt++ {
+		current, found, err := d.store.Get(ctx, storeKey)
+		if err != nil {
+			return false, 0, err
+		}
+
+		var tat time.Time
+		if found {
+			nanos, err := strconv.ParseInt(string(current), 10, 64)
+			if err != nil {
+				return false, 0, fmt.Errorf("corrupt rate limit state for %s: %v", storeKey, err)
+			}
+
+			tat = time.Unix(0, nanos)
+		} else {
+			current = nil
+		}
+
+		allowed, newTat, retryAfter := gcra(time.Now(), tat, limit)
+		if !allowed {
+			return false, retryAfter, nil
+		}
+
+		swapped, err := d.store.CompareAndSwap(ctx, storeKey, current,
+			[]byte(strconv.FormatInt(newTat.UnixNano(), 10)), ttl)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if swapped {
+			return true, 0, nil
+		}
+	}
+
+	// heavily contended bucket, ask the client to back off for a single token interval
+	return false, limit.interval(), nil
+}
@@ -0,0 +1,98 @@
+// Rate limiting package for REST and RPC requests
+// limits are token buckets evaluated with the generic cell rate algorithm (GCRA),
+// which only needs a single timestamp per bucket. this keeps the in-memory state small
+// and lets the distributed limiter store bucket state in the kv store
+
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often idle buckets are dropped from the local limiter
+const sweepInterval = 1 * time.Minute
+
+// Limit describes a token bucket
+type Limit struct {
+	// Rate at which tokens are replenished (per second)
+	Rate float64
+
+	// Burst is the bucket size
+	Burst int
+}
+
+// Enabled returns false for limits that should not be enforced
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// interval returns the time it takes to replenish a single token
+func (l Limit) interval() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// Limiter decides whether a request charged to a bucket (key) may proceed
+type Limiter interface {
+	// Allow takes a token from the bucket. if none are available it returns false
+	// along with the time after which a token will be available
+	Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// gcra evaluates a request arriving at now against a bucket whose theoretical arrival time is tat
+// returns the new theoretical arrival time if allowed, or the time to wait otherwise
+func gcra(now, tat time.Time, limit Limit) (bool, time.Time, time.Duration) {
+	interval := limit.interval()
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-time.Duration(limit.Burst) * interval)
+	if now.Before(allowAt) {
+		return false, tat, allowAt.Sub(now)
+	}
+
+	return true, newTat, 0
+}
+
+// localLimiter keeps bucket state in memory (limits apply per service instance)
+type localLimiter struct {
+	lock      sync.Mutex
+	buckets   map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewLocalLimiter creates an in-memory limiter
+func NewLocalLimiter() Limiter {
+	return &localLimiter{
+		buckets:   make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket
+func (l *localLimiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	allowed, tat, retryAfter := gcra(now, l.buckets[key], limit)
+	if allowed {
+		l.buckets[key] = tat
+	}
+
+	// buckets whose arrival time is in the past are full and can be dropped
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for bucketKey, bucketTat := range l.buckets {
+			if bucketTat.Before(now) {
+				delete(l.buckets, bucketKey)
+			}
+		}
+
+		l.lastSweep = now
+	}
+
+	return allowed, retryAfter, nil
+}
@@ -0,0 +1,76 @@
+// Contains rate limiter unit testcases
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore is a shared store used to test the distributed limiter
+type memoryStore struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (m *memoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, ok := m.values[key]
+	return value, ok, nil
+}
+
+func (m *memoryStore) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	current, ok := m.values[key]
+	if (old == nil && ok) || (old != nil && (!ok || !bytes.Equal(current, old))) {
+		return false, nil
+	}
+
+	m.values[key] = new
+	return true, nil
+}
+
+// TestLimiters unit tests burst and refill behavior of the local and distributed limiters
+func TestLimiters(test *testing.T) {
+	limiters := map[string]Limiter{
+		"local":       NewLocalLimiter(),
+		"distributed": NewDistributedLimiter(&memoryStore{values: make(map[string][]byte)}, "test_service"),
+	}
+
+	limit := Limit{Rate: 10, Burst: 5}
+	for name, limiter := range limiters {
+		// the whole burst is available right away
+		for i := 0; i < limit.Burst; i++ {
+			allowed, _, err := limiter.Allow(context.Background(), "client", limit)
+			if err != nil || !allowed {
+				test.Errorf("%s: request %d within burst was rejected (err: %v)", name, i, err)
+				return
+			}
+		}
+
+		allowed, retryAfter, err := limiter.Allow(context.Background(), "client", limit)
+		if err != nil || allowed {
+			test.Errorf("%s: request exceeding burst was allowed (err: %v)", name, err)
+			return
+		}
+
+		if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+			test.Errorf("%s: unexpected retry after %s", name, retryAfter)
+		}
+
+		// buckets are independent per key
+		if allowed, _, _ := limiter.Allow(context.Background(), "other-client", limit); !allowed {
+			test.Errorf("%s: request from another client was rejected", name)
+		}
+
+		// a token is replenished after the retry interval
+		time.Sleep(retryAfter)
+		if allowed, _, _ := limiter.Allow(context.Background(), "client", limit); !allowed {
+			test.Errorf("%s: request after refill was rejected", name)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"test_service/auth"
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// globalKey is the bucket shared by all clients
+const globalKey = "global"
+
+// rule is a parsed per client rate limit rule
+type rule struct {
+	name   string
+	routes []string
+	rpcs   []string
+	limit  Limit
+}
+
+// RateLimiter enforces global and per client limits on REST and RPC requests
+type RateLimiter struct {
+	// limiter holding bucket state (local or distributed)
+	limiter Limiter
+
+	// limit shared by all clients
+	global Limit
+
+	// default limit for each client
+	perClient Limit
+
+	// per route/RPC overrides of the per client limit
+	rules []rule
+
+	// proxies allowed to forward the REST client address in X-Forwarded-For
+	trustedProxies []*net.IPNet
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewRateLimiter creates a rate limiter from the config using the limiter to hold bucket state
+func NewRateLimiter(config *proto.RateLimitConfig, limiter Limiter, logger *log.Entry) (*RateLimiter, error) {
+	rateLimiter := &RateLimiter{
+		limiter:   limiter,
+		global:    toLimit(config.Global),
+		perClient: toLimit(config.PerClient),
+		logger:    logger,
+	}
+
+	for i, configRule := range config.Rules {
+		if configRule.Name == "" {
+			return nil, fmt.Errorf("rate limit rule %d has no name", i)
+		}
+
+		for _, route := range configRule.Routes {
+			if _, _, err := util.SplitRoute(route); err != nil {
+				return nil, fmt.Errorf("rate limit rule %s: %v", configRule.Name, err)
+			}
+		}
+
+		rateLimiter.rules = append(rateLimiter.rules, rule{
+			name:   configRule.Name,
+			routes: configRule.Routes,
+			rpcs:   configRule.Rpcs,
+			limit:  toLimit(configRule.Limit),
+		})
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		network, err := parseNetwork(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit trusted proxy %s: %v", proxy, err)
+		}
+
+		rateLimiter.trustedProxies = append(rateLimiter.trustedProxies, network)
+	}
+
+	return rateLimiter, nil
+}
+
+// allow charges a request from the client against the global limit and the client's limit for the target
+// the target's rule is picked by the matcher, falling back to the default per client limit
+func (r *RateLimiter) allow(ctx context.Context, client string, matches func(rule) bool) (bool, time.Duration) {
+	if r.global.Enabled() {
+		if allowed, retryAfter := r.take(ctx, globalKey, r.global); !allowed {
+			return false, retryAfter
+		}
+	}
+
+	bucket, limit := "default", r.perClient
+	for _, rule := range r.rules {
+		if matches(rule) {
+			bucket, limit = rule.name, rule.limit
+			break
+		}
+	}
+
+	if !limit.Enabled() {
+		return true, 0
+	}
+
+	return r.take(ctx, "client/"+client+"/"+bucket, limit)
+}
+
+// take charges a single bucket. limiter failures are logged and the request is let through (fail open)
+func (r *RateLimiter) take(ctx context.Context, key string, limit Limit) (bool, time.Duration) {
+	allowed, retryAfter, err := r.limiter.Allow(ctx, key, limit)
+	if err != nil {
+		r.logger.Errorf("rate limiter failure for %s, allowing request: %v", key, err)
+		return true, 0
+	}
+
+	return allowed, retryAfter
+}
+
+// GinMiddleware enforces rate limits on REST requests, returning 429 with a Retry-After header when exceeded
+func (r *RateLimiter) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method, route := c.Request.Method, c.FullPath()
+		client := clientIdentity(c.Request.Context(), r.clientIP(c.Request))
+		allowed, retryAfter := r.allow(c.Request.Context(), client, func(rule rule) bool {
+			for _, pattern := range rule.routes {
+				if util.MatchRoute(pattern, method, route) {
+					return true
+				}
+			}
+
+			return false
+		})
+
+		if !allowed {
+			r.logger.Debugf("rate limit exceeded for %s on %s %s", client, method, route)
+			c.Header("Retry-After", retryAfterSeconds(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// UnaryServerInterceptor enforces rate limits on unary RPCs, returning ResourceExhausted when exceeded
+func (r *RateLimiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+		if err := r.allowRPC(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor enforces rate limits on stream creation, returning ResourceExhausted when exceeded
+func (r *RateLimiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+		if err := r.allowRPC(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// allowRPC charges an RPC against the limits
+func (r *RateLimiter) allowRPC(ctx context.Context, fullMethod string) error {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	client := clientIdentity(ctx, ip)
+	allowed, retryAfter := r.allow(ctx, client, func(rule rule) bool {
+		for _, pattern := range rule.rpcs {
+			if util.MatchPattern(pattern, fullMethod) {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	if allowed {
+		return nil
+	}
+
+	r.logger.Debugf("rate limit exceeded for %s on %s", client, fullMethod)
+	grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(retryAfter)))
+	return status.Errorf(codes.ResourceExhausted, "rate limit exceeded, retry after %s", retryAfter.Round(time.Millisecond))
+}
+
+// clientIP returns the address of a REST client. X-Forwarded-For is only honored when the peer is a trusted
+// proxy, the client is then the right most forwarded address that is not itself a trusted proxy
+func (r *RateLimiter) clientIP(request *http.Request) string {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(request.RemoteAddr))
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+
+	if !r.trusted(net.ParseIP(ip)) {
+		return ip
+	}
+
+	forwarded := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+
+		ip = hop.String()
+		if !r.trusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// trusted checks if the address belongs to a trusted proxy
+func (r *RateLimiter) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range r.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseNetwork parses a CIDR or a single IP address
+func parseNetwork(address string) (*net.IPNet, error) {
+	if strings.Contains(address, "/") {
+		_, network, err := net.ParseCIDR(address)
+		return network, err
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address")
+	}
+
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// clientIdentity identifies the caller by its authenticated subject (e.g. api key), falling back to its IP
+func clientIdentity(ctx context.Context, ip string) string {
+	if principal := auth.PrincipalFromContext(ctx); principal.IsAuthenticated() {
+		return principal.Subject
+	}
+
+	return "ip:" + ip
+}
+
+// retryAfterSeconds formats a wait time for the Retry-After header (whole seconds, rounded up)
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+// toLimit converts a config limit
+func toLimit(limit *proto.RateLimit) Limit {
+	if limit == nil {
+		return Limit{}
+	}
+
+	return Limit{Rate: limit.RatePerSecond, Burst: int(limit.Burst)}
+}
@@ -0,0 +1,82 @@
+// Contains rate limiter middleware unit testcases
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestClientIP unit tests that forwarded client addresses are only honored from trusted proxies
+func TestClientIP(test *testing.T) {
+	rateLimiter, err := NewRateLimiter(&proto.RateLimitConfig{
+		PerClient:      &proto.RateLimit{RatePerSecond: 0.001, Burst: 1},
+		TrustedProxies: []string{"10.0.0.1", "192.168.0.0/16"},
+	}, NewLocalLimiter(), log.WithField("test", "ratelimit"))
+	if err != nil {
+		test.Errorf("failed to create rate limiter: %v", err)
+		return
+	}
+
+	testcases := []struct {
+		name      string
+		peer      string
+		forwarded string
+		expected  string
+	}{
+		{name: "direct", peer: "203.0.113.7:4000", expected: "203.0.113.7"},
+		{name: "spoofed", peer: "203.0.113.7:4000", forwarded: "198.51.100.1", expected: "203.0.113.7"},
+		{name: "trusted proxy", peer: "10.0.0.1:4000", forwarded: "198.51.100.1", expected: "198.51.100.1"},
+		{name: "proxy chain", peer: "10.0.0.1:4000", forwarded: "1.2.3.4, 198.51.100.1, 192.168.1.1",
+			expected: "198.51.100.1"},
+		{name: "invalid hop", peer: "10.0.0.1:4000", forwarded: "garbage", expected: "10.0.0.1"},
+	}
+
+	for _, tc := range testcases {
+		request := httptest.NewRequest(http.MethodGet, "/v1/ping", nil)
+		request.RemoteAddr = tc.peer
+		if tc.forwarded != "" {
+			request.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+
+		if ip := rateLimiter.clientIP(request); ip != tc.expected {
+			test.Errorf("%s: unexpected client ip %s, expected %s", tc.name, ip, tc.expected)
+			return
+		}
+	}
+
+	// rotating X-Forwarded-For from an untrusted peer does not get a fresh bucket
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(rateLimiter.GinMiddleware())
+	router.GET("/v1/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i, forwarded := range []string{"198.51.100.1", "198.51.100.2"} {
+		request := httptest.NewRequest(http.MethodGet, "/v1/ping", nil)
+		request.RemoteAddr = "203.0.113.8:4000"
+		request.Header.Set("X-Forwarded-For", forwarded)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		expected := http.StatusOK
+		if i > 0 {
+			expected = http.StatusTooManyRequests
+		}
+
+		if recorder.Code != expected {
+			test.Errorf("request %d: unexpected status %d, expected %d", i, recorder.Code, expected)
+			return
+		}
+	}
+
+	if _, err := NewRateLimiter(&proto.RateLimitConfig{TrustedProxies: []string{"proxy"}}, NewLocalLimiter(),
+		log.WithField("test", "ratelimit")); err == nil {
+		test.Errorf("invalid trusted proxy accepted")
+		return
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"test_service/models"
+)
+
+// CreateApiKey persists a new api key
+func (r *Repository) CreateApiKey(ctx context.Context, key *models.ApiKey) error {
+	return r.conn(ctx).Create(key).Error
+}
+
+// GetApiKey fetches an api key by its id
+func (r *Repository) GetApiKey(ctx context.Context, id string) (*models.ApiKey, error) {
+	var key models.ApiKey
+	if err := r.reader(ctx).Where("id = ?", id).First(&key).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// GetApiKeyByHash fetches an api key by the hash of the key
+func (r *Repository) GetApiKeyByHash(ctx context.Context, hashedKey string) (*models.ApiKey, error) {
+	var key models.ApiKey
+	if err := r.reader(ctx).Where("hashed_key = ?", hashedKey).First(&key).Error; err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// ListApiKeys fetches all api keys (including revoked and expired ones)
+func (r *Repository) ListApiKeys(ctx context.Context) ([]models.ApiKey, error) {
+	var keys []models.ApiKey
+	if err := r.reader(ctx).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// UpdateApiKeyHash replaces the hash (and prefix) of an api key as part of rotation
+func (r *Repository) UpdateApiKeyHash(ctx context.Context, id, prefix, hashedKey string) error {
+	return r.updateApiKey(ctx, id, map[string]interface{}{
+		"prefix":     prefix,
+		"hashed_key": hashedKey,
+	})
+}
+
+// RevokeApiKey marks an api key as revoked
+func (r *Repository) RevokeApiKey(ctx context.Context, id string, revokedAt time.Time) error {
+	return r.updateApiKey(ctx, id, map[string]interface{}{"revoked_at": revokedAt})
+}
+
+// UpdateApiKeysLastUsed records the last time each api key (by id) was used
+func (r *Repository) UpdateApiKeysLastUsed(ctx context.Context, lastUsed map[string]time.Time) error {
+	return r.WithTx(ctx, func(ctx context.Context) error {
+		for id, usedAt := range lastUsed {
+			err := r.conn(ctx).Model(&models.ApiKey{}).Where("id = ?", id).
+				UpdateColumn("last_used_at", usedAt).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// updateApiKey applies updates to an api key, returning gorm.ErrRecordNotFound if it does not exist
+func (r *Repository) updateApiKey(ctx context.Context, id string, updates map[string]interface{}) error {
+	result := r.conn(ctx).Model(&models.ApiKey{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
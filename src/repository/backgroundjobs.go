@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"test_service/models"
+)
+
+// activeJobStatuses are the statuses of jobs holding their unique key
+var activeJobStatuses = []string{models.JobPending, models.JobRunning}
+
+// BackgroundJobFilter selects the jobs listed by ListBackgroundJobs
+type BackgroundJobFilter struct {
+	// Type and Status of the jobs (any if empty)
+	Type   string
+	Status string
+
+	// Limit of the number of jobs, the most recent are returned
+	Limit int
+}
+
+// EnqueueBackgroundJob records a pending job, reporting whether it was created
+// a job whose unique key is held by a pending or running job is not created, the existing job is loaded
+// into it instead. called with a context from WithTx, the job only runs if the transaction commits
+func (r *Repository) EnqueueBackgroundJob(ctx context.Context, job *models.BackgroundJob) (bool, error) {
+	query := r.conn(ctx)
+	if job.UniqueKey != nil {
+		query = query.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "unique_key"}},
+			// the predicate of the unique index, spelled out as sqlite only matches it literally
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "status IN ('pending', 'running')"}}},
+			DoNothing:   true,
+		})
+	}
+
+	result := query.Create(job)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		return true, nil
+	}
+
+	err := r.conn(ctx).Where("unique_key = ? AND status IN ?", *job.UniqueKey, activeJobStatuses).First(job).Error
+	return false, err
+}
+
+// ClaimBackgroundJobs claims up to limit due jobs of the given types for a worker, which holds them until lockedUntil
+// jobs whose worker did not finish them before their lock expired are claimed again. jobs run by priority
+// (greatest first) and then by run at time. on postgres jobs being claimed by other workers are skipped
+func (r *Repository) ClaimBackgroundJobs(ctx context.Context, types []string, limit int, worker string, now,
+	lockedUntil time.Time) ([]models.BackgroundJob, error) {
+	var claimed []models.BackgroundJob
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		claimed = nil
+		db := r.conn(ctx)
+		query := db.Where("type IN ? AND ((status = ? AND run_at <= ?) OR (status = ? AND locked_until <= ?))",
+			types, models.JobPending, now, models.JobRunning, now).Order("priority DESC, run_at, id").Limit(limit)
+		if db.Dialector.Name() == DriverPostgres {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var jobs []models.BackgroundJob
+		if err := query.Find(&jobs).Error; err != nil {
+			return err
+		}
+
+		for i := range jobs {
+			job := &jobs[i]
+
+			// the attempts of the job guard against another worker claiming it concurrently (sqlite)
+			result := db.Model(&models.BackgroundJob{}).
+				Where("id = ? AND status = ? AND attempts = ?", job.ID, job.Status, job.Attempts).
+				UpdateColumns(map[string]interface{}{
+					"status":       models.JobRunning,
+					"attempts":     job.Attempts + 1,
+					"locked_by":    worker,
+					"locked_until": lockedUntil,
+					"updated_at":   now,
+				})
+			if result.Error != nil {
+				return result.Error
+			}
+
+			if result.RowsAffected == 0 {
+				continue
+			}
+
+			job.Status = models.JobRunning
+			job.Attempts++
+			job.LockedBy = worker
+			job.LockedUntil = &lockedUntil
+			job.UpdatedAt = now
+			claimed = append(claimed, *job)
+		}
+
+		return nil
+	})
+
+	return claimed, err
+}
+
+// CompleteBackgroundJob records that the given attempt of a job succeeded
+func (r *Repository) CompleteBackgroundJob(ctx context.Context, id int64, attempt int32, finishedAt time.Time) error {
+	return r.finishBackgroundJob(ctx, id, attempt, map[string]interface{}{
+		"status":      models.JobSucceeded,
+		"finished_at": finishedAt,
+		"updated_at":  finishedAt,
+	})
+}
+
+// RetryBackgroundJob records that the given attempt of a job failed and when it is run again
+func (r *Repository) RetryBackgroundJob(ctx context.Context, id int64, attempt int32, runAt time.Time,
+	lastError string) error {
+	return r.finishBackgroundJob(ctx, id, attempt, map[string]interface{}{
+		"status":     models.JobPending,
+		"run_at":     runAt,
+		"last_error": lastError,
+		"updated_at": time.Now(),
+	})
+}
+
+// FailBackgroundJob records that the given attempt of a job failed and it is not run again
+func (r *Repository) FailBackgroundJob(ctx context.Context, id int64, attempt int32, finishedAt time.Time,
+	lastError string) error {
+	return r.finishBackgroundJob(ctx, id, attempt, map[string]interface{}{
+		"status":      models.JobFailed,
+		"last_error":  lastError,
+		"finished_at": finishedAt,
+		"updated_at":  finishedAt,
+	})
+}
+
+// GetBackgroundJob fetches a job by its id
+func (r *Repository) GetBackgroundJob(ctx context.Context, id int64) (*models.BackgroundJob, error) {
+	var job models.BackgroundJob
+	if err := r.reader(ctx).Where("id = ?", id).First(&job).Error; err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// ListBackgroundJobs fetches the most recent jobs matching the filter
+func (r *Repository) ListBackgroundJobs(ctx context.Context, filter BackgroundJobFilter) ([]models.BackgroundJob, error) {
+	query := r.reader(ctx).Order("id DESC")
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var jobs []models.BackgroundJob
+	if err := query.Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// CountBackgroundJobs counts the jobs of each type by status
+func (r *Repository) CountBackgroundJobs(ctx context.Context) ([]models.BackgroundJobCount, error) {
+	var counts []models.BackgroundJobCount
+	err := r.reader(ctx).Model(&models.BackgroundJob{}).Select("type, status, COUNT(*) AS count").
+		Group("type, status").Order("type, status").Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// DeleteFinishedBackgroundJobs deletes the jobs finished before the given time, returning how many were deleted
+func (r *Repository) DeleteFinishedBackgroundJobs(ctx context.Context, before time.Time) (int64, error) {
+	result := r.conn(ctx).Where("finished_at < ?", before).Delete(&models.BackgroundJob{})
+	return result.RowsAffected, result.Error
+}
+
+// finishBackgroundJob applies updates to a job claimed for the given attempt, releasing its lock
+// gorm.ErrRecordNotFound is returned if the job is no longer held by that attempt (its lock expired)
+func (r *Repository) finishBackgroundJob(ctx context.Context, id int64, attempt int32,
+	updates map[string]interface{}) error {
+	updates["locked_by"] = ""
+	updates["locked_until"] = nil
+	result := r.conn(ctx).Model(&models.BackgroundJob{}).
+		Where("id = ? AND status = ? AND attempts = ?", id, models.JobRunning, attempt).UpdateColumns(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultHealthCheckInterval is how often the datastore is pinged when the config does not specify it
+const defaultHealthCheckInterval = 10 * time.Second
+
+// metrics exported by the health checker, labelled by database (e.g. primary)
+var (
+	datastoreUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_up",
+		Help: "Whether the last health check of the datastore succeeded",
+	}, []string{"database"})
+
+	poolOpenConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_pool_open_connections",
+		Help: "Number of open connections to the datastore",
+	}, []string{"database"})
+
+	poolInUseConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_pool_in_use_connections",
+		Help: "Number of connections to the datastore currently in use",
+	}, []string{"database"})
+
+	poolIdleConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_pool_idle_connections",
+		Help: "Number of idle connections to the datastore",
+	}, []string{"database"})
+
+	poolWaitCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_pool_wait_count",
+		Help: "Total number of times a query waited for a free connection",
+	}, []string{"database"})
+
+	poolWaitSeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "datastore_pool_wait_seconds",
+		Help: "Total time queries waited for a free connection",
+	}, []string{"database"})
+)
+
+// Pinger is a database checked by the health checker (the repository's primary or a replica)
+type Pinger interface {
+	// Ping verifies the database is reachable
+	Ping(ctx context.Context) error
+
+	// Stats returns the connection pool stats
+	Stats() sql.DBStats
+}
+
+// HealthChecker periodically pings the datastore and publishes its connection pool stats
+type HealthChecker struct {
+	// database being checked and its name in metrics
+	db       Pinger
+	database string
+
+	// interval between checks, each check is bounded by the interval too
+	interval time.Duration
+
+	// onChange is called whenever the datastore becomes healthy (nil error) or unhealthy
+	onChange func(error)
+
+	// result of the last check
+	lock sync.RWMutex
+	err  error
+
+	// stopCh stops the checker
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewHealthChecker creates a health checker for the database, reported under the database name
+func NewHealthChecker(db Pinger, database string, interval time.Duration, onChange func(error),
+	logger *log.Entry) *HealthChecker {
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+
+	return &HealthChecker{
+		db:       db,
+		database: database,
+		interval: interval,
+		onChange: onChange,
+		stopCh:   make(chan struct{}),
+		logger:   logger,
+	}
+}
+
+// Start checks the datastore once and then in the background until stopped
+func (h *HealthChecker) Start() {
+	h.check()
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.check()
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background checks
+func (h *HealthChecker) Stop() {
+	close(h.stopCh)
+	h.wg.Wait()
+}
+
+// Err returns the error of the last check (nil if the datastore is healthy)
+func (h *HealthChecker) Err() error {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.err
+}
+
+// check pings the datastore, records the result and publishes the pool stats
+func (h *HealthChecker) check() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.interval)
+	err := h.db.Ping(ctx)
+	cancel()
+
+	h.lock.Lock()
+	changed := (err == nil) != (h.err == nil)
+	h.err = err
+	h.lock.Unlock()
+
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+
+	datastoreUp.WithLabelValues(h.database).Set(up)
+	stats := h.db.Stats()
+	poolOpenConnections.WithLabelValues(h.database).Set(float64(stats.OpenConnections))
+	poolInUseConnections.WithLabelValues(h.database).Set(float64(stats.InUse))
+	poolIdleConnections.WithLabelValues(h.database).Set(float64(stats.Idle))
+	poolWaitCount.WithLabelValues(h.database).Set(float64(stats.WaitCount))
+	poolWaitSeconds.WithLabelValues(h.database).Set(stats.WaitDuration.Seconds())
+
+	if !changed {
+		return
+	}
+
+	if err != nil {
+		h.logger.Errorf("datastore %s is unhealthy: %v", h.database, err)
+	} else {
+		h.logger.Infof("datastore %s is healthy", h.database)
+	}
+
+	if h.onChange != nil {
+		h.onChange(err)
+	}
+}
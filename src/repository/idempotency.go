@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"test_service/models"
+)
+
+// CreateIdempotencyKey records an idempotency key unless it exists and has not expired, reporting whether it
+// was created
+func (r *Repository) CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (bool, error) {
+	created := false
+	err := r.WithTx(ctx, func(ctx context.Context) error {
+		db := r.conn(ctx)
+		if err := db.Where("key = ? AND expires_at <= ?", key.Key, time.Now()).
+			Delete(&models.IdempotencyKey{}).Error; err != nil {
+			return err
+		}
+
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(key)
+		created = result.RowsAffected > 0
+		return result.Error
+	})
+
+	return created, err
+}
+
+// GetIdempotencyKey fetches an idempotency key that has not expired (from the primary)
+func (r *Repository) GetIdempotencyKey(ctx context.Context, key string) (*models.IdempotencyKey, error) {
+	var idempotencyKey models.IdempotencyKey
+	err := r.conn(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&idempotencyKey).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &idempotencyKey, nil
+}
+
+// UpdateIdempotencyKey replaces the record of an idempotency key and when it expires, returning
+// gorm.ErrRecordNotFound unless the key still holds the old record
+func (r *Repository) UpdateIdempotencyKey(ctx context.Context, key string, old, record []byte,
+	expiresAt time.Time) error {
+	result := r.conn(ctx).Model(&models.IdempotencyKey{}).Where("key = ? AND record = ?", key, old).
+		UpdateColumns(map[string]interface{}{"record": record, "expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// DeleteIdempotencyKey deletes an idempotency key if it still holds the record, reporting whether it was deleted
+func (r *Repository) DeleteIdempotencyKey(ctx context.Context, key string, record []byte) (bool, error) {
+	result := r.conn(ctx).Where("key = ? AND record = ?", key, record).Delete(&models.IdempotencyKey{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys expired before the given time, returning how many
+// were deleted
+func (r *Repository) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	result := r.conn(ctx).Where("expires_at < ?", before).Delete(&models.IdempotencyKey{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"test_service/models"
+)
+
+// ApiKeyRepository persists api keys
+type ApiKeyRepository interface {
+	// CreateApiKey persists a new api key
+	CreateApiKey(ctx context.Context, key *models.ApiKey) error
+
+	// GetApiKey fetches an api key by its id
+	GetApiKey(ctx context.Context, id string) (*models.ApiKey, error)
+
+	// GetApiKeyByHash fetches an api key by the hash of the key
+	GetApiKeyByHash(ctx context.Context, hashedKey string) (*models.ApiKey, error)
+
+	// ListApiKeys fetches all api keys
+	ListApiKeys(ctx context.Context) ([]models.ApiKey, error)
+
+	// UpdateApiKeyHash replaces the hash (and prefix) of an api key
+	UpdateApiKeyHash(ctx context.Context, id, prefix, hashedKey string) error
+
+	// RevokeApiKey marks an api key as revoked
+	RevokeApiKey(ctx context.Context, id string, revokedAt time.Time) error
+
+	// UpdateApiKeysLastUsed records the last time each api key (by id) was used
+	UpdateApiKeysLastUsed(ctx context.Context, lastUsed map[string]time.Time) error
+}
+
+// OutboxRepository persists events published by the outbox relay
+type OutboxRepository interface {
+	// EnqueueOutboxEvent records an event in the outbox (in the transaction carried by the context)
+	EnqueueOutboxEvent(ctx context.Context, event *models.OutboxEvent) error
+
+	// PendingOutboxEvents fetches the oldest pending event of each aggregate key that is due
+	PendingOutboxEvents(ctx context.Context, limit int, now time.Time) ([]models.OutboxEvent, error)
+
+	// ClaimOutboxEvent leases a due event until the given time, returning false if it is no longer due
+	ClaimOutboxEvent(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error)
+
+	// MarkOutboxEventDelivered records that an event was published
+	MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error
+
+	// MarkOutboxEventFailed records a failed publish attempt and when the event is retried
+	MarkOutboxEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+
+	// DiscardOutboxEvent gives up on publishing an event
+	DiscardOutboxEvent(ctx context.Context, id int64, discardedAt time.Time, lastError string) error
+
+	// DeleteDeliveredOutboxEvents deletes the events delivered before the given time
+	DeleteDeliveredOutboxEvents(ctx context.Context, before time.Time) (int64, error)
+}
+
+// BackgroundJobRepository persists the jobs of the job queue
+type BackgroundJobRepository interface {
+	// EnqueueBackgroundJob records a pending job (in the transaction carried by the context), reporting whether
+	// it was created or another pending or running job holds its unique key
+	EnqueueBackgroundJob(ctx context.Context, job *models.BackgroundJob) (bool, error)
+
+	// ClaimBackgroundJobs claims due jobs of the given types for a worker until lockedUntil
+	ClaimBackgroundJobs(ctx context.Context, types []string, limit int, worker string, now,
+		lockedUntil time.Time) ([]models.BackgroundJob, error)
+
+	// CompleteBackgroundJob records that the given attempt of a job succeeded
+	CompleteBackgroundJob(ctx context.Context, id int64, attempt int32, finishedAt time.Time) error
+
+	// RetryBackgroundJob records that the given attempt of a job failed and when it is run again
+	RetryBackgroundJob(ctx context.Context, id int64, attempt int32, runAt time.Time, lastError string) error
+
+	// FailBackgroundJob records that the given attempt of a job failed and it is not run again
+	FailBackgroundJob(ctx context.Context, id int64, attempt int32, finishedAt time.Time, lastError string) error
+
+	// GetBackgroundJob fetches a job by its id
+	GetBackgroundJob(ctx context.Context, id int64) (*models.BackgroundJob, error)
+
+	// ListBackgroundJobs fetches the most recent jobs matching the filter
+	ListBackgroundJobs(ctx context.Context, filter BackgroundJobFilter) ([]models.BackgroundJob, error)
+
+	// CountBackgroundJobs counts the jobs of each type by status
+	CountBackgroundJobs(ctx context.Context) ([]models.BackgroundJobCount, error)
+
+	// DeleteFinishedBackgroundJobs deletes the jobs finished before the given time
+	DeleteFinishedBackgroundJobs(ctx context.Context, before time.Time) (int64, error)
+}
+
+// IdempotencyKeyRepository persists the idempotency keys of requests
+type IdempotencyKeyRepository interface {
+	// CreateIdempotencyKey records an idempotency key unless it exists and has not expired
+	CreateIdempotencyKey(ctx context.Context, key *models.IdempotencyKey) (bool, error)
+
+	// GetIdempotencyKey fetches an idempotency key that has not expired
+	GetIdempotencyKey(ctx context.Context, key string) (*models.IdempotencyKey, error)
+
+	// UpdateIdempotencyKey replaces the record of an idempotency key still holding the old record
+	UpdateIdempotencyKey(ctx context.Context, key string, old, record []byte, expiresAt time.Time) error
+
+	// DeleteIdempotencyKey deletes an idempotency key still holding the record
+	DeleteIdempotencyKey(ctx context.Context, key string, record []byte) (bool, error)
+
+	// DeleteExpiredIdempotencyKeys deletes the idempotency keys expired before the given time
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Transactor runs functions in datastore transactions
+type Transactor interface {
+	// WithTx runs fn in a transaction carried by the context passed to fn
+	WithTx(ctx context.Context, fn func(ctx context.Context) error, options ...TxOption) error
+}
+
+// Store groups the repositories the service's controllers and components depend on
+// Repository implements it on top of gorm (postgres or sqlite), tests may substitute fakes
+type Store interface {
+	Transactor
+	ApiKeyRepository
+	OutboxRepository
+	BackgroundJobRepository
+	IdempotencyKeyRepository
+}
+
+// ensure the gorm repository implements the store
+var _ Store = (*Repository)(nil)
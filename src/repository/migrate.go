@@ -0,0 +1,390 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Migrations holds the service's versioned schema migrations, in a directory per driver
+// (SQL types differ between drivers). files are named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*/*.sql
+var Migrations embed.FS
+
+// migrationsTable records applied migrations
+const migrationsTable = "schema_migrations"
+
+// migrationLockKey identifies the postgres advisory lock held while migrating
+const migrationLockKey = 7201564309
+
+// migrationFile matches the names of migration files
+var migrationFile = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// migrationName matches names of new migrations
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Migration is a versioned schema change
+type Migration struct {
+	// Version orders migrations
+	Version int64
+
+	// Name describes the migration
+	Name string
+
+	// Up and Down hold the SQL applying and reverting the migration
+	Up   string
+	Down string
+
+	// Checksum of the up migration, used to detect migrations modified after being applied
+	Checksum string
+}
+
+// MigrationStatus reports whether a migration has been applied
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+
+	// Modified is set for applied migrations whose file changed since
+	Modified bool `json:"modified"`
+}
+
+// schemaMigration is a row of the migrations table
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey"`
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrator applies and reverts schema migrations
+type Migrator struct {
+	// db connection the migrations are applied to
+	db *gorm.DB
+
+	// migrations sorted by version
+	migrations []Migration
+
+	// logger object
+	logger *log.Entry
+}
+
+// NewMigrator creates a migrator for the migrations found in the source
+func NewMigrator(db *gorm.DB, source fs.FS, logger *log.Entry) (*Migrator, error) {
+	migrations, err := LoadMigrations(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations, logger: logger}, nil
+}
+
+// Migrator creates a migrator for the service's embedded migrations of the repository's driver
+func (r *Repository) Migrator(logger *log.Entry) (*Migrator, error) {
+	source, err := fs.Sub(Migrations, "migrations/"+r.DbConn.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	return NewMigrator(r.DbConn, source, logger)
+}
+
+// LoadMigrations reads the migrations found at the root of the source, sorted by version
+func LoadMigrations(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %v", entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(data)
+			sum := sha256.Sum256(data)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies all pending migrations, returning the number applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.verify(applied); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		m.logger.Infof("applying migration %d_%s", migration.Version, migration.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+
+			return tx.Table(migrationsTable).Create(&schemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Checksum:  migration.Checksum,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+
+		if err != nil {
+			return count, fmt.Errorf("migration %d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Down reverts the latest applied migrations (up to steps of them), returning the number reverted
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == "" {
+			return count, fmt.Errorf("migration %d_%s has no down migration", migration.Version, migration.Name)
+		}
+
+		m.logger.Infof("reverting migration %d_%s", migration.Version, migration.Name)
+		err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+
+			return tx.Table(migrationsTable).Where("version = ?", migration.Version).Delete(&schemaMigration{}).Error
+		})
+
+		if err != nil {
+			return count, fmt.Errorf("reverting migration %d_%s failed: %v", migration.Version, migration.Name, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Status reports the state of every known migration
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+			status.Modified = record.Checksum != migration.Checksum
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// applied returns the applied migrations keyed by version, creating the migrations table if needed
+func (m *Migrator) applied(ctx context.Context) (map[int64]schemaMigration, error) {
+	// sqlite drivers only read columns declared as DATETIME (or DATE/TIMESTAMP) back as times
+	timestampType := "TIMESTAMP WITH TIME ZONE"
+	if m.db.Dialector.Name() != DriverPostgres {
+		timestampType = "DATETIME"
+	}
+
+	db := m.db.WithContext(ctx)
+	err := db.Exec("CREATE TABLE IF NOT EXISTS " + migrationsTable + " (" +
+		"version BIGINT PRIMARY KEY, " +
+		"name VARCHAR(255) NOT NULL, " +
+		"checksum VARCHAR(64) NOT NULL, " +
+		"applied_at " + timestampType + " NOT NULL)").Error
+	if err != nil {
+		return nil, err
+	}
+
+	var records []schemaMigration
+	if err := db.Table(migrationsTable).Order("version").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int64]schemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+// verify fails if applied migrations were modified or are unknown to this build
+func (m *Migrator) verify(applied map[int64]schemaMigration) error {
+	known := map[int64]bool{}
+	for _, migration := range m.migrations {
+		known[migration.Version] = true
+		if record, ok := applied[migration.Version]; ok && record.Checksum != migration.Checksum {
+			return fmt.Errorf("migration %d_%s was modified after being applied", migration.Version, migration.Name)
+		}
+	}
+
+	for version, record := range applied {
+		if !known[version] {
+			return fmt.Errorf("applied migration %d_%s is unknown, the schema is newer than this build",
+				version, record.Name)
+		}
+	}
+
+	return nil
+}
+
+// lock serializes migrations across replicas with a postgres advisory lock
+// the lock is session scoped, so it is held on a dedicated connection until released
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	if m.db.Dialector.Name() != DriverPostgres {
+		return func() {}, nil
+	}
+
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.logger.Debug("waiting for the migration lock")
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to acquire the migration lock: %v", err)
+	}
+
+	return func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey); err != nil {
+			m.logger.Errorf("failed to release the migration lock: %v", err)
+		}
+
+		conn.Close()
+	}, nil
+}
+
+// CreateMigration writes empty up/down files for a new migration to the driver directories under dir
+// the version is the current UTC time (YYYYMMDDHHMMSS) so that migrations created on
+// different branches do not collide
+func CreateMigration(dir, name string) ([]string, error) {
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("invalid migration name %q (lower case letters, digits and '_' allowed)", name)
+	}
+
+	version := time.Now().UTC().Format("20060102150405")
+	var files []string
+	for _, driver := range []string{DriverPostgres, DriverSQLite} {
+		files = append(files,
+			filepath.Join(dir, driver, fmt.Sprintf("%s_%s.up.sql", version, name)),
+			filepath.Join(dir, driver, fmt.Sprintf("%s_%s.down.sql", version, name)))
+	}
+
+	for _, path := range files {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s already exists", path)
+		}
+	}
+
+	for _, path := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+
+		if err := ioutil.WriteFile(path, []byte("-- "+filepath.Base(path)+"\n"), 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,78 @@
+// Contains schema migration unit testcases
+package repository
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// TestLoadMigrations unit tests parsing, ordering and validation of migration files
+func TestLoadMigrations(test *testing.T) {
+	source := fstest.MapFS{
+		"0002_add_orders.up.sql":        {Data: []byte("CREATE TABLE orders (id INT);")},
+		"0002_add_orders.down.sql":      {Data: []byte("DROP TABLE orders;")},
+		"0001_create_api_keys.up.sql":   {Data: []byte("CREATE TABLE api_keys (id INT);")},
+		"0001_create_api_keys.down.sql": {Data: []byte("DROP TABLE api_keys;")},
+		"README.md":                     {Data: []byte("not a migration")},
+		"20220101000000_no_down.up.sql": {Data: []byte("SELECT 1;")},
+	}
+
+	migrations, err := LoadMigrations(source)
+	if err != nil {
+		test.Errorf("failed to load migrations: %v", err)
+		return
+	}
+
+	if len(migrations) != 3 || migrations[0].Name != "create_api_keys" || migrations[1].Version != 2 ||
+		migrations[2].Down != "" {
+		test.Errorf("unexpected migrations: %+v", migrations)
+		return
+	}
+
+	if migrations[0].Checksum == "" || migrations[0].Checksum == migrations[1].Checksum {
+		test.Errorf("invalid migration checksums")
+		return
+	}
+
+	// down migrations without an up migration are rejected
+	if _, err := LoadMigrations(fstest.MapFS{"0003_x.down.sql": {Data: []byte("SELECT 1;")}}); err == nil {
+		test.Errorf("migration without an up migration was accepted")
+		return
+	}
+
+	// the embedded migrations must be valid and define the same versions for every driver
+	var versions []int64
+	for _, driver := range []string{DriverPostgres, DriverSQLite} {
+		embedded, err := fs.Sub(Migrations, "migrations/"+driver)
+		if err != nil {
+			test.Errorf("failed to open embedded %s migrations: %v", driver, err)
+			return
+		}
+
+		migrations, err := LoadMigrations(embedded)
+		if err != nil || len(migrations) == 0 {
+			test.Errorf("invalid embedded %s migrations: %v", driver, err)
+			return
+		}
+
+		if versions == nil {
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			continue
+		}
+
+		if len(versions) != len(migrations) {
+			test.Errorf("%s migrations differ from %s migrations", driver, DriverPostgres)
+			return
+		}
+
+		for i, migration := range migrations {
+			if migration.Version != versions[i] {
+				test.Errorf("%s migration %d has no %s counterpart", driver, migration.Version, DriverPostgres)
+				return
+			}
+		}
+	}
+}
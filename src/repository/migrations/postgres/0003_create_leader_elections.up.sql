@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS leader_elections (
+    name VARCHAR(256) PRIMARY KEY,
+    leader VARCHAR(256) NOT NULL,
+    token BIGINT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
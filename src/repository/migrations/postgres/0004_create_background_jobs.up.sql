@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS background_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    type VARCHAR(128) NOT NULL,
+    payload BYTEA,
+    unique_key VARCHAR(256),
+    priority INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_by VARCHAR(256),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_background_jobs_unique_key ON background_jobs (unique_key)
+    WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_background_jobs_pending ON background_jobs (priority DESC, run_at, id)
+    WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_background_jobs_running ON background_jobs (locked_until)
+    WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_background_jobs_finished_at ON background_jobs (finished_at);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(36) PRIMARY KEY,
+    name VARCHAR(128) NOT NULL,
+    prefix VARCHAR(16) NOT NULL,
+    hashed_key VARCHAR(64) NOT NULL,
+    scopes VARCHAR(1024),
+    expires_at DATETIME,
+    revoked_at DATETIME,
+    last_used_at DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_hashed_key ON api_keys (hashed_key);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    aggregate_key VARCHAR(256) NOT NULL,
+    topic VARCHAR(256) NOT NULL,
+    payload BLOB,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error TEXT,
+    delivered_at DATETIME,
+    discarded_at DATETIME,
+    created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_pending ON outbox_events (aggregate_key, id)
+    WHERE delivered_at IS NULL AND discarded_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_events_delivered_at ON outbox_events (delivered_at);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS background_jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type VARCHAR(128) NOT NULL,
+    payload BLOB,
+    unique_key VARCHAR(256),
+    priority INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    run_at DATETIME NOT NULL,
+    locked_by VARCHAR(256),
+    locked_until DATETIME,
+    last_error TEXT,
+    finished_at DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_background_jobs_unique_key ON background_jobs (unique_key)
+    WHERE status IN ('pending', 'running');
+CREATE INDEX IF NOT EXISTS idx_background_jobs_pending ON background_jobs (priority DESC, run_at, id)
+    WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_background_jobs_running ON background_jobs (locked_until)
+    WHERE status = 'running';
+CREATE INDEX IF NOT EXISTS idx_background_jobs_finished_at ON background_jobs (finished_at);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key VARCHAR(256) PRIMARY KEY,
+    record BLOB NOT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"test_service/models"
+)
+
+// EnqueueOutboxEvent records an event in the outbox
+// called with a context from WithTx, the event is only published if the transaction commits
+// ids are assigned at insert rather than at commit, so on postgres enqueues of an aggregate key are serialized
+// with an advisory lock held until the transaction ends: the ids of a key then follow the commit order, and the
+// relay (publishing the lowest id first) never publishes an event before an earlier one committed later
+func (r *Repository) EnqueueOutboxEvent(ctx context.Context, event *models.OutboxEvent) error {
+	if event.NextAttemptAt.IsZero() {
+		event.NextAttemptAt = time.Now()
+	}
+
+	return r.WithTx(ctx, func(ctx context.Context) error {
+		db := r.conn(ctx)
+		if db.Dialector.Name() == DriverPostgres {
+			if err := db.Exec("SELECT pg_advisory_xact_lock(?)", outboxLockKey(event.AggregateKey)).Error; err != nil {
+				return err
+			}
+		}
+
+		return db.Create(event).Error
+	})
+}
+
+// ClaimOutboxEvent leases a due event until the given time so other relays skip it while it is published,
+// returning false if the event was delivered, discarded or claimed by another relay in the meantime
+func (r *Repository) ClaimOutboxEvent(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error) {
+	result := r.conn(ctx).Model(&models.OutboxEvent{}).
+		Where("id = ? AND delivered_at IS NULL AND discarded_at IS NULL AND next_attempt_at <= ?", id, now).
+		UpdateColumn("next_attempt_at", leaseUntil)
+	return result.RowsAffected > 0, result.Error
+}
+
+// PendingOutboxEvents fetches the oldest pending event of each aggregate key that is due at the given time
+// later events of a key are only returned once the earlier ones are delivered (or discarded), which keeps
+// events of a key in order. the events are not reserved, they are claimed one by one with ClaimOutboxEvent
+func (r *Repository) PendingOutboxEvents(ctx context.Context, limit int, now time.Time) ([]models.OutboxEvent, error) {
+	db := r.conn(ctx)
+	heads := db.Session(&gorm.Session{NewDB: true}).Model(&models.OutboxEvent{}).Select("MIN(id)").
+		Where("delivered_at IS NULL AND discarded_at IS NULL").Group("aggregate_key")
+
+	var events []models.OutboxEvent
+	err := db.Where("id IN (?) AND next_attempt_at <= ?", heads, now).Order("id").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// MarkOutboxEventDelivered records that an event was published
+func (r *Repository) MarkOutboxEventDelivered(ctx context.Context, id int64, deliveredAt time.Time) error {
+	return r.updateOutboxEvent(ctx, id, map[string]interface{}{"delivered_at": deliveredAt})
+}
+
+// MarkOutboxEventFailed records a failed publish attempt and when the event is retried
+func (r *Repository) MarkOutboxEventFailed(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	return r.updateOutboxEvent(ctx, id, map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"next_attempt_at": nextAttemptAt,
+		"last_error":      lastError,
+	})
+}
+
+// DiscardOutboxEvent gives up on publishing an event, unblocking the later events of its aggregate key
+func (r *Repository) DiscardOutboxEvent(ctx context.Context, id int64, discardedAt time.Time, lastError string) error {
+	return r.updateOutboxEvent(ctx, id, map[string]interface{}{
+		"attempts":     gorm.Expr("attempts + 1"),
+		"discarded_at": discardedAt,
+		"last_error":   lastError,
+	})
+}
+
+// DeleteDeliveredOutboxEvents deletes the events delivered before the given time, returning how many were deleted
+func (r *Repository) DeleteDeliveredOutboxEvents(ctx context.Context, before time.Time) (int64, error) {
+	result := r.conn(ctx).Where("delivered_at < ?", before).Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// updateOutboxEvent applies updates to an outbox event, returning gorm.ErrRecordNotFound if it does not exist
+func (r *Repository) updateOutboxEvent(ctx context.Context, id int64, updates map[string]interface{}) error {
+	result := r.conn(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).UpdateColumns(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// outboxLockKey returns the key of the advisory lock serializing the enqueues of an aggregate key
+func outboxLockKey(aggregateKey string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte("outbox/" + aggregateKey))
+	return int64(hash.Sum64())
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	protobuf "google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// replicationLagQuery returns the replication lag of a postgres replica in seconds
+// a replica that replayed everything it received is not lagging, even if the primary was idle for a while
+const replicationLagQuery = `SELECT CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) END`
+
+// replicationLag is exported by the replica health checks
+var replicationLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "datastore_replication_lag_seconds",
+	Help: "Replication lag of the datastore replica",
+}, []string{"database"})
+
+// primaryKey is the context key forcing reads to the primary
+type primaryKey struct{}
+
+// WithPrimary returns a context whose reads go to the primary, for reads that must observe
+// preceding writes (replicas apply writes asynchronously)
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// RequiresPrimary reports whether reads with the context must observe preceding writes (they run in a
+// transaction or were forced to the primary), caches in front of the repository must be bypassed too
+func RequiresPrimary(ctx context.Context) bool {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return true
+	}
+
+	forced, _ := ctx.Value(primaryKey{}).(bool)
+	return forced
+}
+
+// Replica is a read only copy of the datastore
+type Replica struct {
+	// Name of the replica in logs and metrics
+	Name string
+
+	// DbConn is the connection to the replica
+	DbConn *gorm.DB
+
+	// maxLag above which the replica is unhealthy (0 disables the lag check)
+	maxLag time.Duration
+
+	// healthy is set (1) while the replica serves reads
+	healthy int32
+}
+
+// Ping verifies the replica is reachable and not lagging behind the primary by more than the threshold
+func (r *Replica) Ping(ctx context.Context) error {
+	sqlDB, err := r.DbConn.DB()
+	if err != nil {
+		return err
+	}
+
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return err
+	}
+
+	if r.maxLag <= 0 {
+		return nil
+	}
+
+	var seconds float64
+	if err := sqlDB.QueryRowContext(ctx, replicationLagQuery).Scan(&seconds); err != nil {
+		return fmt.Errorf("failed to query replication lag: %v", err)
+	}
+
+	replicationLag.WithLabelValues(r.Name).Set(seconds)
+	if lag := time.Duration(seconds * float64(time.Second)); lag > r.maxLag {
+		return fmt.Errorf("replication lag %s exceeds %s", lag.Round(time.Millisecond), r.maxLag)
+	}
+
+	return nil
+}
+
+// Stats returns the connection pool stats of the replica
+func (r *Replica) Stats() sql.DBStats {
+	sqlDB, err := r.DbConn.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+
+	return sqlDB.Stats()
+}
+
+// Healthy reports whether the replica serves reads
+func (r *Replica) Healthy() bool {
+	return atomic.LoadInt32(&r.healthy) == 1
+}
+
+// SetHealthy adds the replica to (or removes it from) the replicas serving reads
+// it is meant to be the onChange callback of the replica's health checker
+func (r *Replica) SetHealthy(err error) {
+	healthy := int32(0)
+	if err == nil {
+		healthy = 1
+	}
+
+	atomic.StoreInt32(&r.healthy, healthy)
+}
+
+// Replicas returns the read replicas of the repository
+func (r *Repository) Replicas() []*Replica {
+	return r.replicas
+}
+
+// reader returns the connection reads should use: the transaction carried by the context, the primary
+// if forced by the context, or the next healthy replica (round robin). the primary serves reads when no
+// replica is healthy
+func (r *Repository) reader(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+
+	if forced, _ := ctx.Value(primaryKey{}).(bool); forced || len(r.replicas) == 0 {
+		return r.DbConn.WithContext(ctx)
+	}
+
+	next := int(atomic.AddUint32(&r.next, 1))
+	for i := range r.replicas {
+		replica := r.replicas[(next+i)%len(r.replicas)]
+		if replica.Healthy() {
+			return replica.DbConn.WithContext(ctx)
+		}
+	}
+
+	return r.DbConn.WithContext(ctx)
+}
+
+// openReplicas opens connection pools to the configured replicas
+// replicas are not required to be reachable, their health checks decide whether they serve reads
+func openReplicas(dbConfig *proto.DatastoreConfig) ([]*Replica, error) {
+	if len(dbConfig.Replicas) == 0 {
+		return nil, nil
+	}
+
+	if dbConfig.Driver != DriverPostgres {
+		return nil, fmt.Errorf("replicas are not supported by the %s driver", dbConfig.Driver)
+	}
+
+	maxLag, err := util.ParseDuration(dbConfig.MaxReplicaLag, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas []*Replica
+	for i, replicaConfig := range dbConfig.Replicas {
+		name := replicaConfig.Name
+		if name == "" {
+			name = fmt.Sprintf("replica-%d", i+1)
+		}
+
+		config := protobuf.Clone(dbConfig).(*proto.DatastoreConfig)
+		config.FqdnOrIP = replicaConfig.FqdnOrIP
+		config.Port = replicaConfig.Port
+		config.Replicas = nil
+
+		dialector, err := newDialector(config)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, err
+		}
+
+		dbConn, err := open(dialector, config)
+		if err != nil {
+			closeReplicas(replicas)
+			return nil, fmt.Errorf("failed to open replica %s: %v", name, err)
+		}
+
+		replicas = append(replicas, &Replica{Name: name, DbConn: dbConn, maxLag: maxLag, healthy: 1})
+	}
+
+	return replicas, nil
+}
+
+// closeReplicas closes the connections to the replicas
+func closeReplicas(replicas []*Replica) {
+	for _, replica := range replicas {
+		if sqlDB, err := replica.DbConn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+}
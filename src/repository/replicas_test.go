@@ -0,0 +1,97 @@
+// Contains read replica routing unit testcases
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	proto "test_service/protobuf/generated"
+)
+
+// openNamedDatabase opens an in-memory database holding its name, so tests can tell databases apart
+func openNamedDatabase(name string) (*gorm.DB, error) {
+	dbConn, err := open(sqlite.Open(":memory:"), &proto.DatastoreConfig{Driver: DriverSQLite})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dbConn.Exec("CREATE TABLE names (name TEXT)").Error; err != nil {
+		return nil, err
+	}
+
+	return dbConn, dbConn.Exec("INSERT INTO names (name) VALUES (?)", name).Error
+}
+
+// TestReplicaRouting unit tests that reads are balanced across healthy replicas
+func TestReplicaRouting(test *testing.T) {
+	primary, err := openNamedDatabase("primary")
+	if err != nil {
+		test.Errorf("failed to open the primary: %v", err)
+		return
+	}
+
+	repo := &Repository{DbConn: primary}
+	for _, name := range []string{"replica-1", "replica-2"} {
+		dbConn, err := openNamedDatabase(name)
+		if err != nil {
+			test.Errorf("failed to open %s: %v", name, err)
+			return
+		}
+
+		repo.replicas = append(repo.replicas, &Replica{Name: name, DbConn: dbConn, healthy: 1})
+	}
+	defer repo.Close()
+
+	// read returns the name of the database serving a read
+	read := func(ctx context.Context) string {
+		var name string
+		repo.reader(ctx).Raw("SELECT name FROM names").Scan(&name)
+		return name
+	}
+
+	ctx := context.Background()
+	if first, second := read(ctx), read(ctx); first == second || first == "primary" || second == "primary" {
+		test.Errorf("reads not balanced across replicas: %s, %s", first, second)
+		return
+	}
+
+	if name := read(WithPrimary(ctx)); name != "primary" {
+		test.Errorf("forced read served by %s", name)
+		return
+	}
+
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		if name := read(ctx); name != "primary" {
+			return errors.New("read in a transaction served by " + name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		test.Errorf("%v", err)
+		return
+	}
+
+	// unhealthy replicas are skipped, and the primary serves reads when no replica is healthy
+	repo.replicas[0].SetHealthy(errors.New("down"))
+	if first, second := read(ctx), read(ctx); first != "replica-2" || second != "replica-2" {
+		test.Errorf("reads served by unhealthy replica: %s, %s", first, second)
+		return
+	}
+
+	repo.replicas[1].SetHealthy(errors.New("down"))
+	if name := read(ctx); name != "primary" {
+		test.Errorf("read served by %s with no healthy replica", name)
+		return
+	}
+
+	if _, err := openReplicas(&proto.DatastoreConfig{Driver: DriverSQLite,
+		Replicas: []*proto.ReplicaConfig{{FqdnOrIP: "localhost"}}}); err == nil {
+		test.Errorf("replicas of an sqlite datastore were accepted")
+		return
+	}
+}
@@ -1,40 +1,255 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
 	proto "test_service/protobuf/generated"
+	"test_service/util"
 )
 
+// supported datastore drivers
+const (
+	// DriverPostgres is the production datastore
+	DriverPostgres = "postgres"
+
+	// DriverSQLite is a pure Go embedded datastore meant for development and tests
+	DriverSQLite = "sqlite"
+)
+
+// defaults used when the datastore config does not specify them
+const (
+	defaultSslMode        = "prefer"
+	defaultConnectTimeout = 5 * time.Second
+	defaultStartupTimeout = 30 * time.Second
+
+	// connection attempts at startup back off exponentially between these bounds
+	minConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff = 5 * time.Second
+)
+
+// Repository implements the Store on top of a gorm connection
 type Repository struct {
-	// DbConn is the database connection
+	// DbConn is the database connection (primary)
 	DbConn *gorm.DB
+
+	// replicas serving reads and the round robin counter selecting them
+	replicas []*Replica
+	next     uint32
+
+	// logger object
+	logger *log.Entry
 }
 
-func NewRepository(dbConfig *proto.DatastoreConfig) (*Repository, error) {
-	dbConn, err := initializeDbConn(dbConfig)
+// NewRepository connects to the datastore using the configured driver
+// connecting is retried with backoff until the startup timeout expires, so the
+// service can start alongside its database
+func NewRepository(dbConfig *proto.DatastoreConfig, logger *log.Entry) (*Repository, error) {
+	dialector, err := newDialector(dbConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Repository{DbConn: dbConn}, nil
+	startupTimeout, err := util.ParseDuration(dbConfig.StartupTimeout, defaultStartupTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(startupTimeout)
+	for attempt := 1; ; attempt++ {
+		dbConn, err := connect(dialector, dbConfig)
+		if err == nil {
+			replicas, err := openReplicas(dbConfig)
+			if err != nil {
+				closeConn(dbConn)
+				return nil, err
+			}
+
+			return &Repository{DbConn: dbConn, replicas: replicas, logger: logger}, nil
+		}
+
+		backoff := time.Duration(math.Min(float64(maxConnectBackoff),
+			float64(minConnectBackoff)*math.Pow(2, float64(attempt-1))))
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("failed to connect to the datastore after %d attempts: %v", attempt, err)
+		}
+
+		logger.Warnf("failed to connect to the datastore (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		time.Sleep(backoff)
+	}
 }
 
-// initializeDBConn will create a connection to the db based on db config
-func initializeDbConn(dbConfig *proto.DatastoreConfig) (*gorm.DB, error) {
-	dbURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s sslmode=disable",
-		dbConfig.FqdnOrIP, dbConfig.Port, dbConfig.Username, dbConfig.DbName, dbConfig.Password)
+// Ping verifies the datastore is reachable
+func (r *Repository) Ping(ctx context.Context) error {
+	sqlDB, err := r.DbConn.DB()
+	if err != nil {
+		return err
+	}
 
-	dbConn, err := gorm.Open(postgres.Open(dbURL), &gorm.Config{})
+	return sqlDB.PingContext(ctx)
+}
+
+// Stats returns the connection pool stats of the primary
+func (r *Repository) Stats() sql.DBStats {
+	sqlDB, err := r.DbConn.DB()
+	if err != nil {
+		return sql.DBStats{}
+	}
+
+	return sqlDB.Stats()
+}
+
+// Close closes the connections to the datastore
+func (r *Repository) Close() error {
+	closeReplicas(r.replicas)
+	sqlDB, err := r.DbConn.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.Close()
+}
+
+// connect opens a connection pool with the configured limits and verifies it with a ping
+func connect(dialector gorm.Dialector, dbConfig *proto.DatastoreConfig) (*gorm.DB, error) {
+	connectTimeout, err := util.ParseDuration(dbConfig.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConn, err := open(dialector, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := dbConn.DB()
 	if err != nil {
 		return nil, err
 	}
 
-	// migrate the schema
-	//dbConn.AutoMigrate(&models.MyTableDefition{})
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
 
 	return dbConn, nil
 }
+
+// open opens a connection pool with the configured limits (connections are established lazily)
+func open(dialector gorm.Dialector, dbConfig *proto.DatastoreConfig) (*gorm.DB, error) {
+	dbConn, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := dbConn.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer and every connection to ":memory:" opens a separate database,
+	// so all queries share one connection
+	if dbConfig.Driver == DriverSQLite {
+		sqlDB.SetMaxOpenConns(1)
+	} else {
+		sqlDB.SetMaxOpenConns(int(dbConfig.MaxOpenConns))
+		if dbConfig.MaxIdleConns > 0 {
+			sqlDB.SetMaxIdleConns(int(dbConfig.MaxIdleConns))
+		}
+	}
+
+	connMaxLifetime, err := util.ParseDuration(dbConfig.ConnMaxLifetime, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	connMaxIdleTime, err := util.ParseDuration(dbConfig.ConnMaxIdleTime, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB.SetConnMaxLifetime(connMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(connMaxIdleTime)
+	return dbConn, nil
+}
+
+// closeConn closes a connection pool
+func closeConn(dbConn *gorm.DB) {
+	if sqlDB, err := dbConn.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// newDialector creates the gorm dialector of the configured driver
+func newDialector(dbConfig *proto.DatastoreConfig) (gorm.Dialector, error) {
+	switch dbConfig.Driver {
+	case DriverPostgres:
+		dsn, err := postgresDSN(dbConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		return postgres.Open(dsn), nil
+	case DriverSQLite:
+		return sqlite.Open(dbConfig.DbName), nil
+	case "":
+		return nil, fmt.Errorf("datastore driver not configured")
+	default:
+		return nil, fmt.Errorf("unsupported datastore driver %q", dbConfig.Driver)
+	}
+}
+
+// postgresDSN builds the connection string of a postgres datastore
+func postgresDSN(dbConfig *proto.DatastoreConfig) (string, error) {
+	sslMode := dbConfig.SslMode
+	if sslMode == "" {
+		sslMode = defaultSslMode
+	}
+
+	connectTimeout, err := util.ParseDuration(dbConfig.ConnectTimeout, defaultConnectTimeout)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]string{
+		"host":            dbConfig.FqdnOrIP,
+		"port":            dbConfig.Port,
+		"user":            dbConfig.Username,
+		"password":        dbConfig.Password,
+		"dbname":          dbConfig.DbName,
+		"sslmode":         sslMode,
+		"sslrootcert":     dbConfig.SslRootCert,
+		"sslcert":         dbConfig.SslCert,
+		"sslkey":          dbConfig.SslKey,
+		"connect_timeout": fmt.Sprint(int(math.Ceil(connectTimeout.Seconds()))),
+	}
+
+	var keys []string
+	for key, value := range params {
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	// values are quoted so passwords and paths may contain spaces and quotes
+	sort.Strings(keys)
+	var dsn []string
+	quoter := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, key := range keys {
+		dsn = append(dsn, fmt.Sprintf("%s='%s'", key, quoter.Replace(params[key])))
+	}
+
+	return strings.Join(dsn, " "), nil
+}
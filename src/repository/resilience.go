@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"test_service/resilience"
+)
+
+// keys of the statement settings holding the state of a statement guarded by the policy
+const (
+	guardDoneKey    = "resilience:done"
+	guardContextKey = "resilience:context"
+)
+
+// UsePolicy guards the statements run on the primary (including transactions) with the resilience policy
+// statements are not retried as they may not be safe to repeat, transactions are retried by WithTx.
+// replicas are not guarded, reads fall back to the primary when they are unhealthy
+func (r *Repository) UsePolicy(policy *resilience.Policy) error {
+	policy = policy.WithClassifier(isUnavailable)
+	before := func(db *gorm.DB) {
+		if db.Error != nil {
+			return
+		}
+
+		ctx, done, err := policy.Guard(db.Statement.Context)
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+
+		db.InstanceSet(guardContextKey, db.Statement.Context)
+		db.InstanceSet(guardDoneKey, done)
+		db.Statement.Context = ctx
+	}
+
+	after := func(db *gorm.DB) {
+		done, ok := db.InstanceGet(guardDoneKey)
+		if !ok {
+			return
+		}
+
+		if ctx, ok := db.InstanceGet(guardContextKey); ok {
+			db.Statement.Context = ctx.(context.Context)
+		}
+
+		done.(func(error))(db.Error)
+	}
+
+	callback := r.DbConn.Callback()
+	for _, err := range []error{
+		callback.Create().Before("*").Register("resilience:before_create", before),
+		callback.Create().After("*").Register("resilience:after_create", after),
+		callback.Query().Before("*").Register("resilience:before_query", before),
+		callback.Query().After("*").Register("resilience:after_query", after),
+		callback.Update().Before("*").Register("resilience:before_update", before),
+		callback.Update().After("*").Register("resilience:after_update", after),
+		callback.Delete().Before("*").Register("resilience:before_delete", before),
+		callback.Delete().After("*").Register("resilience:after_delete", after),
+		callback.Raw().Before("*").Register("resilience:before_raw", before),
+		callback.Raw().After("*").Register("resilience:after_raw", after),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isUnavailable reports whether a statement failed because the datastore is unreachable, overloaded or timed out
+// (errors of the statement itself, e.g. constraint violations or missing records, are not)
+func isUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// connection exceptions, insufficient resources and the server shutting down
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		state := pgErr.SQLState()
+		return strings.HasPrefix(state, "08") || strings.HasPrefix(state, "53") || strings.HasPrefix(state, "57P")
+	}
+
+	return false
+}
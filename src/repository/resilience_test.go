@@ -0,0 +1,105 @@
+// Contains resilience policy integration unit testcases
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+	"test_service/resilience"
+)
+
+// connectionError mimics the postgres error returned when the connection to the server fails
+type connectionError struct{}
+
+func (connectionError) Error() string    { return "connection failure" }
+func (connectionError) SQLState() string { return "08006" }
+
+// TestUsePolicy unit tests guarding statements with a circuit breaker
+func TestUsePolicy(test *testing.T) {
+	logger := log.WithField("test", "resilience")
+	repo, err := NewRepository(&proto.DatastoreConfig{Driver: DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	policy, err := resilience.NewPolicy("datastore", &proto.DependencyConfig{Timeout: "1s",
+		Breaker: &proto.BreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeout: "1h"}}, logger)
+	if err != nil {
+		test.Errorf("failed to create policy: %v", err)
+		return
+	}
+
+	if err := repo.UsePolicy(policy); err != nil {
+		test.Errorf("failed to use policy: %v", err)
+		return
+	}
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	// errors of the statements themselves do not open the breaker
+	if err := repo.CreateApiKey(ctx, &models.ApiKey{ID: "1", Name: "one", HashedKey: "1"}); err != nil {
+		test.Errorf("failed to create api key: %v", err)
+		return
+	}
+
+	if _, err := repo.GetApiKey(ctx, "2"); !errors.Is(err, gorm.ErrRecordNotFound) {
+		test.Errorf("unexpected error getting a missing api key: %v", err)
+		return
+	}
+
+	if err := repo.CreateApiKey(ctx, &models.ApiKey{ID: "1", Name: "one", HashedKey: "1"}); err == nil {
+		test.Errorf("duplicate api key created")
+		return
+	}
+
+	if policy.State() != resilience.StateClosed {
+		test.Errorf("breaker opened by statement errors")
+		return
+	}
+
+	// statements are rejected while the breaker is open
+	_, done, err := policy.Guard(ctx)
+	if err != nil {
+		test.Errorf("call rejected: %v", err)
+		return
+	}
+
+	done(driver.ErrBadConn)
+	if _, err := repo.GetApiKey(ctx, "1"); !errors.Is(err, resilience.ErrBreakerOpen) {
+		test.Errorf("statement not rejected by an open breaker: %v", err)
+		return
+	}
+
+	for err, unavailable := range map[error]bool{
+		driver.ErrBadConn:        true,
+		context.DeadlineExceeded: true,
+		connectionError{}:        true,
+		serializationError{}:     false,
+		gorm.ErrRecordNotFound:   false,
+		context.Canceled:         false,
+	} {
+		if isUnavailable(err) != unavailable {
+			test.Errorf("unexpected classification of %v", err)
+			return
+		}
+	}
+}
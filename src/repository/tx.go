@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaults of transactions started with WithTx
+const (
+	defaultTxMaxRetries = 3
+
+	// retries back off exponentially (with jitter) between these bounds
+	minTxBackoff = 20 * time.Millisecond
+	maxTxBackoff = 1 * time.Second
+)
+
+// postgres error codes (SQLSTATE) of transactions that may succeed when retried
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// sqlite result codes of transactions that may succeed when retried
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// txKey is the context key of the transaction started by WithTx
+type txKey struct{}
+
+// txOptions holds the options of a transaction
+type txOptions struct {
+	sql.TxOptions
+	maxRetries int
+}
+
+// TxOption configures a transaction started with WithTx
+type TxOption func(*txOptions)
+
+// WithIsolation sets the isolation level of the transaction (postgres, sqlite transactions are serializable)
+func WithIsolation(level sql.IsolationLevel) TxOption {
+	return func(options *txOptions) {
+		options.Isolation = level
+	}
+}
+
+// ReadOnly starts a read only transaction
+func ReadOnly() TxOption {
+	return func(options *txOptions) {
+		options.ReadOnly = true
+	}
+}
+
+// WithMaxRetries sets how many times the transaction is retried on serialization failures and deadlocks
+func WithMaxRetries(retries int) TxOption {
+	return func(options *txOptions) {
+		options.maxRetries = retries
+	}
+}
+
+// WithTx runs fn in a transaction, committing it if fn returns nil and rolling it back otherwise
+// the transaction is carried by the context passed to fn, so repository calls made with that context
+// participate in it. calling WithTx with a context already carrying a transaction runs fn in the
+// enclosing transaction (the options are ignored)
+// transactions failing with a serialization failure or deadlock are retried with backoff, so fn
+// may be called more than once and should not have side effects outside the datastore
+func (r *Repository) WithTx(ctx context.Context, fn func(ctx context.Context) error, options ...TxOption) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+
+	txOpts := txOptions{maxRetries: defaultTxMaxRetries}
+	for _, option := range options {
+		option(&txOpts)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.DbConn.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(ContextWithTx(ctx, tx))
+		}, &txOpts.TxOptions)
+		if err == nil || !isRetryable(err) || attempt > txOpts.maxRetries {
+			return err
+		}
+
+		backoff := time.Duration(math.Min(float64(maxTxBackoff),
+			float64(minTxBackoff)*math.Pow(2, float64(attempt-1))))
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if r.logger != nil {
+			r.logger.Warnf("transaction failed (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// ContextWithTx returns a context carrying a transaction started with gorm directly (e.g. DbConn.Transaction),
+// so repository calls made with it participate in the transaction
+func ContextWithTx(ctx context.Context, tx *gorm.DB) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// conn returns the connection writes use: the transaction carried by the context, or the primary
+func (r *Repository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx
+	}
+
+	return r.DbConn.WithContext(ctx)
+}
+
+// isRetryable reports whether a transaction failed with a serialization failure or deadlock
+func isRetryable(err error) bool {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		state := pgErr.SQLState()
+		return state == sqlStateSerializationFailure || state == sqlStateDeadlockDetected
+	}
+
+	var sqliteErr interface{ Code() int }
+	if errors.As(err, &sqliteErr) {
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+// Contains transaction helper unit testcases
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/models"
+	proto "test_service/protobuf/generated"
+)
+
+// serializationError mimics the postgres error returned for serialization failures
+type serializationError struct{}
+
+func (serializationError) Error() string    { return "could not serialize access" }
+func (serializationError) SQLState() string { return sqlStateSerializationFailure }
+
+// TestWithTx unit tests commits, rollbacks, nested transactions and retries
+func TestWithTx(test *testing.T) {
+	logger := log.WithField("test", "tx")
+	repo, err := NewRepository(&proto.DatastoreConfig{Driver: DriverSQLite, DbName: ":memory:"}, logger)
+	if err != nil {
+		test.Errorf("failed to open the datastore: %v", err)
+		return
+	}
+	defer repo.Close()
+
+	migrator, err := repo.Migrator(logger)
+	if err != nil {
+		test.Errorf("failed to create migrator: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := migrator.Up(ctx); err != nil {
+		test.Errorf("failed to migrate the datastore: %v", err)
+		return
+	}
+
+	// nested calls participate in the enclosing transaction and are rolled back with it
+	failure := errors.New("failure")
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := repo.CreateApiKey(ctx, &models.ApiKey{ID: "1", Name: "one", HashedKey: "1"}); err != nil {
+			return err
+		}
+
+		return repo.WithTx(ctx, func(ctx context.Context) error {
+			if err := repo.CreateApiKey(ctx, &models.ApiKey{ID: "2", Name: "two", HashedKey: "2"}); err != nil {
+				return err
+			}
+
+			return failure
+		})
+	})
+	if err != failure {
+		test.Errorf("unexpected transaction error: %v", err)
+		return
+	}
+
+	if keys, err := repo.ListApiKeys(ctx); err != nil || len(keys) != 0 {
+		test.Errorf("rolled back transaction persisted %d keys: %v", len(keys), err)
+		return
+	}
+
+	// serialization failures are retried and the successful attempt is committed
+	attempts := 0
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		attempts++
+		if err := repo.CreateApiKey(ctx, &models.ApiKey{ID: "1", Name: "one", HashedKey: "1"}); err != nil {
+			return err
+		}
+
+		if attempts < 3 {
+			return serializationError{}
+		}
+
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		test.Errorf("transaction not retried (%d attempts): %v", attempts, err)
+		return
+	}
+
+	if _, err := repo.GetApiKey(ctx, "1"); err != nil {
+		test.Errorf("committed key not found: %v", err)
+		return
+	}
+
+	// retries are bounded
+	attempts = 0
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		attempts++
+		return serializationError{}
+	}, WithMaxRetries(1), ReadOnly())
+	if !isRetryable(err) || attempts != 2 {
+		test.Errorf("unexpected retries (%d attempts): %v", attempts, err)
+		return
+	}
+}
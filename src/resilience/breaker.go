@@ -0,0 +1,182 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// State of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets calls through, counting consecutive failures
+	StateClosed State = iota
+
+	// StateHalfOpen lets a limited number of probing calls through after the open timeout
+	StateHalfOpen
+
+	// StateOpen rejects calls until the open timeout expires
+	StateOpen
+)
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome of a call admitted by the breaker
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+
+	// outcomeIgnored calls neither succeeded nor failed (e.g. cancelled by the caller)
+	outcomeIgnored
+)
+
+// breaker is a consecutive failures circuit breaker
+// calls admitted in a previous state (generation) do not affect the current one
+type breaker struct {
+	// settings of the breaker
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	// onChange is called (without holding the lock) when the state changes
+	onChange func(from, to State)
+
+	// lock guards the state below
+	lock       sync.Mutex
+	state      State
+	generation uint64
+	failures   int
+	openedAt   time.Time
+	probes     int
+	successes  int
+}
+
+// allow admits a call, returning the generation to record its outcome with
+// ErrBreakerOpen is returned while the breaker is open or all probes of the half-open breaker are in flight
+func (b *breaker) allow() (uint64, error) {
+	b.lock.Lock()
+	var transition func()
+	defer func() {
+		b.lock.Unlock()
+		if transition != nil {
+			transition()
+		}
+	}()
+
+	if b.state == StateOpen {
+		if time.Since(b.openedAt) < b.openTimeout {
+			return 0, ErrBreakerOpen
+		}
+
+		transition = b.setState(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes+b.successes >= b.halfOpenRequests {
+			return 0, ErrBreakerOpen
+		}
+
+		b.probes++
+	}
+
+	return b.generation, nil
+}
+
+// record records the outcome of a call admitted in the generation
+func (b *breaker) record(generation uint64, result outcome) {
+	b.lock.Lock()
+	var transition func()
+	defer func() {
+		b.lock.Unlock()
+		if transition != nil {
+			transition()
+		}
+	}()
+
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+		case outcomeFailure:
+			b.failures++
+			if b.failures >= b.failureThreshold {
+				transition = b.setState(StateOpen)
+			}
+		}
+	case StateHalfOpen:
+		b.probes--
+		switch result {
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.halfOpenRequests {
+				transition = b.setState(StateClosed)
+			}
+		case outcomeFailure:
+			transition = b.setState(StateOpen)
+		}
+	}
+}
+
+// current returns the state of the breaker
+func (b *breaker) current() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// an open breaker whose timeout expired lets the next call through
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+
+	return b.state
+}
+
+// setState moves the breaker to a new generation in the state, returning the notification of the change
+// called with the lock held
+func (b *breaker) setState(state State) func() {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if state == StateOpen {
+		// the breaker moves to half-open once the timeout expires, even if no call is made in the meantime
+		b.openedAt = time.Now()
+		generation := b.generation
+		time.AfterFunc(b.openTimeout, func() {
+			b.lock.Lock()
+			var transition func()
+			if b.generation == generation && b.state == StateOpen {
+				transition = b.setState(StateHalfOpen)
+			}
+
+			b.lock.Unlock()
+			if transition != nil {
+				transition()
+			}
+		})
+	}
+
+	return func() {
+		if b.onChange != nil {
+			b.onChange(from, state)
+		}
+	}
+}
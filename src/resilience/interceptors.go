@@ -0,0 +1,37 @@
+package resilience
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IsRPCFailure treats errors of RPCs showing the server unavailable or overloaded as failures
+// (errors returned by the server's handlers, e.g. NotFound or InvalidArgument, are not)
+func IsRPCFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return DefaultClassifier(err)
+	default:
+		return false
+	}
+}
+
+// UnaryClientInterceptor guards the unary RPCs of a client with the breaker, bulkhead and timeout of the policy
+// RPCs are not retried by the interceptor, as only the client knows which methods are safe to repeat
+func (p *Policy) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	policy := p.WithClassifier(IsRPCFailure)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		callCtx, done, err := policy.Guard(ctx)
+		if err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+
+		err = invoker(callCtx, method, req, reply, cc, opts...)
+		done(err)
+		return err
+	}
+}
@@ -0,0 +1,363 @@
+// Resilience package guarding the calls of the service to its dependencies (datastore, kv store,
+// downstream services) with timeouts, retries with exponential backoff and jitter, circuit breakers
+// and bulkheads, configured per dependency
+
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultInitialBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff       = 2 * time.Second
+	defaultMultiplier       = 2
+	defaultFailureThreshold = 5
+	defaultOpenTimeout      = 30 * time.Second
+	defaultHalfOpenRequests = 1
+)
+
+// errors of calls rejected by a policy
+var (
+	// ErrBreakerOpen is returned while the circuit breaker of the dependency is open
+	ErrBreakerOpen = errors.New("circuit breaker is open")
+
+	// ErrBulkheadFull is returned when the concurrent calls to the dependency are at their limit
+	ErrBulkheadFull = errors.New("too many concurrent calls")
+)
+
+// metrics exported by the policies, labelled by dependency
+var (
+	callsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_calls_total",
+		Help: "Number of call attempts to a dependency by result (success, failure, cancelled, breaker_open, bulkhead_full)",
+	}, []string{"dependency", "result"})
+
+	retriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_retries_total",
+		Help: "Number of retried calls to a dependency",
+	}, []string{"dependency"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resilience_breaker_state",
+		Help: "State of the circuit breaker of a dependency (0 closed, 1 half-open, 2 open)",
+	}, []string{"dependency"})
+
+	breakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resilience_breaker_transitions_total",
+		Help: "Number of circuit breaker state changes of a dependency by new state",
+	}, []string{"dependency", "state"})
+
+	bulkheadInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "resilience_bulkhead_in_flight",
+		Help: "Number of concurrent calls to a dependency holding a bulkhead slot",
+	}, []string{"dependency"})
+)
+
+// Classifier reports whether the error of a call is a failure of the dependency
+// failures count towards opening the breaker and are retried, other errors (e.g. not found) are returned as is
+type Classifier func(err error) bool
+
+// Policy guards the calls to a dependency
+// the breaker and bulkhead of a policy are shared by its copies with other classifiers (see WithClassifier)
+type Policy struct {
+	// name of the dependency
+	name string
+
+	// timeout of each attempt (none if zero)
+	timeout time.Duration
+
+	// retry settings (retries are disabled if maxAttempts is 1)
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+
+	// breaker of the dependency (nil if disabled)
+	breaker *breaker
+
+	// slots of the bulkhead (nil if disabled) and how long a call waits for one
+	slots   chan struct{}
+	maxWait time.Duration
+
+	// isFailure classifies the errors of calls
+	isFailure Classifier
+
+	// listeners of breaker state changes, shared by the copies of the policy
+	listeners *listeners
+
+	// logger object
+	logger *log.Entry
+}
+
+// listeners are notified of the state changes of a breaker
+type listeners struct {
+	lock      sync.Mutex
+	callbacks []func(State)
+}
+
+// NewPolicy creates the policy of a dependency from its config
+func NewPolicy(name string, config *proto.DependencyConfig, logger *log.Entry) (*Policy, error) {
+	timeout, err := util.ParseDuration(config.Timeout, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		name:        name,
+		timeout:     timeout,
+		maxAttempts: 1,
+		isFailure:   DefaultClassifier,
+		listeners:   &listeners{},
+		logger:      logger.WithField("dependency", name),
+	}
+
+	if retry := config.Retry; retry != nil && retry.MaxAttempts > 1 {
+		if policy.initialBackoff, err = util.ParseDuration(retry.InitialBackoff, defaultInitialBackoff); err != nil {
+			return nil, err
+		}
+
+		if policy.maxBackoff, err = util.ParseDuration(retry.MaxBackoff, defaultMaxBackoff); err != nil {
+			return nil, err
+		}
+
+		if retry.Jitter < 0 || retry.Jitter > 1 {
+			return nil, fmt.Errorf("retry jitter of %s must be between 0 and 1", name)
+		}
+
+		policy.maxAttempts = int(retry.MaxAttempts)
+		policy.multiplier = retry.Multiplier
+		if policy.multiplier < 1 {
+			policy.multiplier = defaultMultiplier
+		}
+
+		policy.jitter = retry.Jitter
+	}
+
+	if breakerConfig := config.Breaker; breakerConfig != nil && breakerConfig.Enabled {
+		openTimeout, err := util.ParseDuration(breakerConfig.OpenTimeout, defaultOpenTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		policy.breaker = &breaker{
+			failureThreshold: int(breakerConfig.FailureThreshold),
+			openTimeout:      openTimeout,
+			halfOpenRequests: int(breakerConfig.HalfOpenRequests),
+			onChange:         policy.stateChanged,
+		}
+
+		if policy.breaker.failureThreshold <= 0 {
+			policy.breaker.failureThreshold = defaultFailureThreshold
+		}
+
+		if policy.breaker.halfOpenRequests <= 0 {
+			policy.breaker.halfOpenRequests = defaultHalfOpenRequests
+		}
+
+		breakerState.WithLabelValues(name).Set(float64(StateClosed))
+	}
+
+	if bulkhead := config.Bulkhead; bulkhead != nil && bulkhead.MaxConcurrent > 0 {
+		if policy.maxWait, err = util.ParseDuration(bulkhead.MaxWait, 0); err != nil {
+			return nil, err
+		}
+
+		policy.slots = make(chan struct{}, bulkhead.MaxConcurrent)
+	}
+
+	return policy, nil
+}
+
+// DefaultClassifier treats every error as a failure, except the caller cancelling the call
+func DefaultClassifier(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+// Name returns the name of the dependency
+func (p *Policy) Name() string {
+	return p.name
+}
+
+// WithClassifier returns a copy of the policy classifying errors with the classifier
+// the copy shares the breaker and bulkhead of the policy
+func (p *Policy) WithClassifier(isFailure Classifier) *Policy {
+	policy := *p
+	policy.isFailure = isFailure
+	return &policy
+}
+
+// State returns the state of the breaker (closed if the breaker is disabled)
+func (p *Policy) State() State {
+	if p.breaker == nil {
+		return StateClosed
+	}
+
+	return p.breaker.current()
+}
+
+// Err returns an error while the breaker is open, meant as a readiness check
+func (p *Policy) Err() error {
+	if p.State() == StateOpen {
+		return fmt.Errorf("%s: %w", p.name, ErrBreakerOpen)
+	}
+
+	return nil
+}
+
+// OnStateChange registers a callback called with the new state when the breaker changes state
+func (p *Policy) OnStateChange(callback func(State)) {
+	p.listeners.lock.Lock()
+	defer p.listeners.lock.Unlock()
+	p.listeners.callbacks = append(p.listeners.callbacks, callback)
+}
+
+// Guard admits a single attempt of a call through the breaker and the bulkhead
+// it returns the context of the attempt (bounded by the timeout) and the function recording its outcome,
+// which must be called once the attempt is done. ErrBreakerOpen or ErrBulkheadFull are returned if the call
+// is rejected
+func (p *Policy) Guard(ctx context.Context) (context.Context, func(err error), error) {
+	var generation uint64
+	if p.breaker != nil {
+		var err error
+		if generation, err = p.breaker.allow(); err != nil {
+			callsTotal.WithLabelValues(p.name, "breaker_open").Inc()
+			return ctx, nil, fmt.Errorf("%s: %w", p.name, err)
+		}
+	}
+
+	if p.slots != nil {
+		if err := p.acquire(ctx); err != nil {
+			// the attempt was not made, so it does not count towards the breaker
+			if p.breaker != nil {
+				p.breaker.record(generation, outcomeIgnored)
+			}
+
+			return ctx, nil, err
+		}
+	}
+
+	callCtx, cancel := ctx, context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		callCtx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+
+	var once sync.Once
+	done := func(err error) {
+		once.Do(func() {
+			cancel()
+			if p.slots != nil {
+				<-p.slots
+				bulkheadInFlight.WithLabelValues(p.name).Dec()
+			}
+
+			result, label := outcomeSuccess, "success"
+			switch {
+			case err == nil:
+			case ctx.Err() != nil:
+				// the caller gave up on the call, which says nothing about the dependency
+				result, label = outcomeIgnored, "cancelled"
+			case p.isFailure(err):
+				result, label = outcomeFailure, "failure"
+			}
+
+			callsTotal.WithLabelValues(p.name, label).Inc()
+			if p.breaker != nil {
+				p.breaker.record(generation, result)
+			}
+		})
+	}
+
+	return callCtx, done, nil
+}
+
+// Execute calls fn guarded by the policy, retrying failures with exponential backoff and jitter
+// fn is called with the context of each attempt and must be safe to repeat if retries are configured
+func (p *Policy) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		callCtx, done, err := p.Guard(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(callCtx)
+		done(err)
+		if err == nil || attempt >= p.maxAttempts || ctx.Err() != nil || !p.isFailure(err) {
+			return err
+		}
+
+		backoff := p.backoff(attempt)
+		p.logger.Debugf("call failed (attempt %d), retrying in %s: %v", attempt, backoff, err)
+		retriesTotal.WithLabelValues(p.name).Inc()
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// acquire takes a bulkhead slot, waiting up to the max wait for one
+func (p *Policy) acquire(ctx context.Context) error {
+	select {
+	case p.slots <- struct{}{}:
+		bulkheadInFlight.WithLabelValues(p.name).Inc()
+		return nil
+	default:
+	}
+
+	if p.maxWait > 0 {
+		timer := time.NewTimer(p.maxWait)
+		defer timer.Stop()
+		select {
+		case p.slots <- struct{}{}:
+			bulkheadInFlight.WithLabelValues(p.name).Inc()
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	callsTotal.WithLabelValues(p.name, "bulkhead_full").Inc()
+	return fmt.Errorf("%s: %w", p.name, ErrBulkheadFull)
+}
+
+// backoff returns the delay before retrying after the attempt, randomizing the jitter fraction of it
+func (p *Policy) backoff(attempt int) time.Duration {
+	backoff := math.Min(float64(p.maxBackoff), float64(p.initialBackoff)*math.Pow(p.multiplier, float64(attempt-1)))
+	return time.Duration(backoff * (1 - p.jitter*rand.Float64()))
+}
+
+// stateChanged reports a state change of the breaker to the metrics, logs and listeners
+func (p *Policy) stateChanged(from, to State) {
+	breakerState.WithLabelValues(p.name).Set(float64(to))
+	breakerTransitions.WithLabelValues(p.name, to.String()).Inc()
+	if to == StateOpen {
+		p.logger.Warnf("circuit breaker opened (was %s)", from)
+	} else {
+		p.logger.Infof("circuit breaker %s (was %s)", to, from)
+	}
+
+	p.listeners.lock.Lock()
+	callbacks := append([]func(State){}, p.listeners.callbacks...)
+	p.listeners.lock.Unlock()
+	for _, callback := range callbacks {
+		callback(to)
+	}
+}
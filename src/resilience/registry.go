@@ -0,0 +1,55 @@
+package resilience
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// Registry holds the policies of the configured dependencies
+type Registry struct {
+	policies map[string]*Policy
+}
+
+// NewRegistry creates the policies of the dependencies in the config
+func NewRegistry(config *proto.ResilienceConfig, logger *log.Entry) (*Registry, error) {
+	registry := &Registry{policies: map[string]*Policy{}}
+	for _, dependency := range config.Dependencies {
+		if dependency.Name == "" {
+			return nil, fmt.Errorf("resilience policy without a dependency name")
+		}
+
+		if _, ok := registry.policies[dependency.Name]; ok {
+			return nil, fmt.Errorf("duplicate resilience policy of %s", dependency.Name)
+		}
+
+		policy, err := NewPolicy(dependency.Name, dependency, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		registry.policies[dependency.Name] = policy
+	}
+
+	return registry, nil
+}
+
+// Policy returns the policy of a dependency and whether one is configured
+func (r *Registry) Policy(name string) (*Policy, bool) {
+	policy, ok := r.policies[name]
+	return policy, ok
+}
+
+// Policies returns the configured policies ordered by dependency name
+func (r *Registry) Policies() []*Policy {
+	policies := make([]*Policy, 0, len(r.policies))
+	for _, policy := range r.policies {
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool { return policies[i].name < policies[j].name })
+	return policies
+}
@@ -0,0 +1,199 @@
+// Contains resilience policy unit testcases
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestBreaker unit tests opening, probing and closing the circuit breaker
+func TestBreaker(test *testing.T) {
+	registry, err := NewRegistry(&proto.ResilienceConfig{Dependencies: []*proto.DependencyConfig{{
+		Name:    "downstream",
+		Breaker: &proto.BreakerConfig{Enabled: true, FailureThreshold: 3, OpenTimeout: "50ms", HalfOpenRequests: 2},
+	}}}, log.WithField("test", "resilience"))
+	if err != nil {
+		test.Errorf("failed to create registry: %v", err)
+		return
+	}
+
+	policy, ok := registry.Policy("downstream")
+	if !ok {
+		test.Errorf("policy of the dependency not found")
+		return
+	}
+
+	var changes int32
+	policy.OnStateChange(func(State) { atomic.AddInt32(&changes, 1) })
+
+	failure := errors.New("unavailable")
+	call := func(err error) error {
+		return policy.Execute(context.Background(), func(ctx context.Context) error { return err })
+	}
+
+	// errors that are not failures and successes reset the consecutive failures
+	notFound := errors.New("not found")
+	policy = policy.WithClassifier(func(err error) bool { return err != nil && err != notFound })
+	for _, err := range []error{failure, failure, nil, failure, notFound, failure, failure} {
+		call(err)
+	}
+
+	if policy.State() != StateClosed || policy.Err() != nil {
+		test.Errorf("breaker opened before reaching the failure threshold: %s", policy.State())
+		return
+	}
+
+	call(failure)
+	if policy.State() != StateOpen || !errors.Is(policy.Err(), ErrBreakerOpen) {
+		test.Errorf("breaker not opened after consecutive failures: %s", policy.State())
+		return
+	}
+
+	calls := 0
+	err = policy.Execute(context.Background(), func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	if !errors.Is(err, ErrBreakerOpen) || calls != 0 {
+		test.Errorf("call let through an open breaker: %v", err)
+		return
+	}
+
+	// a failing probe opens the breaker again, the half-open requests close it once they succeed
+	time.Sleep(60 * time.Millisecond)
+	if policy.State() != StateHalfOpen {
+		test.Errorf("breaker not half-open after the open timeout: %s", policy.State())
+		return
+	}
+
+	call(failure)
+	if policy.State() != StateOpen {
+		test.Errorf("breaker not opened after a failing probe: %s", policy.State())
+		return
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	var probes []func(error)
+	for i := 0; i < 2; i++ {
+		_, done, err := policy.Guard(context.Background())
+		if err != nil {
+			test.Errorf("probe rejected: %v", err)
+			return
+		}
+
+		probes = append(probes, done)
+	}
+
+	if err := call(nil); !errors.Is(err, ErrBreakerOpen) {
+		test.Errorf("call beyond the half-open requests let through: %v", err)
+		return
+	}
+
+	for _, done := range probes {
+		done(nil)
+	}
+
+	// closed -> open -> half-open -> open -> half-open -> closed
+	if policy.State() != StateClosed || atomic.LoadInt32(&changes) != 5 {
+		test.Errorf("breaker not closed after successful probes: %s (%d changes)", policy.State(), changes)
+		return
+	}
+}
+
+// TestRetries unit tests retrying failures with backoff and per attempt timeouts
+func TestRetries(test *testing.T) {
+	policy, err := NewPolicy("downstream", &proto.DependencyConfig{
+		Timeout: "20ms",
+		Retry:   &proto.RetryConfig{MaxAttempts: 3, InitialBackoff: "10ms", MaxBackoff: "15ms", Jitter: 0.5},
+	}, log.WithField("test", "resilience"))
+	if err != nil {
+		test.Errorf("failed to create policy: %v", err)
+		return
+	}
+
+	// attempts exceeding the timeout are cancelled and retried
+	attempts := 0
+	start := time.Now()
+	err = policy.Execute(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		test.Errorf("call not retried (%d attempts): %v", attempts, err)
+		return
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond+10*time.Millisecond {
+		test.Errorf("retries did not back off (%s)", elapsed)
+		return
+	}
+
+	// attempts are bounded, errors that are not failures are not retried
+	attempts = 0
+	failure := errors.New("unavailable")
+	if err := policy.Execute(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return failure
+	}); err != failure || attempts != 3 {
+		test.Errorf("unexpected error after %d attempts: %v", attempts, err)
+		return
+	}
+
+	attempts = 0
+	policy = policy.WithClassifier(func(err error) bool { return err != failure && DefaultClassifier(err) })
+	if err := policy.Execute(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return failure
+	}); err != failure || attempts != 1 {
+		test.Errorf("error retried (%d attempts): %v", attempts, err)
+		return
+	}
+}
+
+// TestBulkhead unit tests bounding concurrent calls
+func TestBulkhead(test *testing.T) {
+	policy, err := NewPolicy("downstream", &proto.DependencyConfig{
+		Bulkhead: &proto.BulkheadConfig{MaxConcurrent: 1, MaxWait: "20ms"},
+	}, log.WithField("test", "resilience"))
+	if err != nil {
+		test.Errorf("failed to create policy: %v", err)
+		return
+	}
+
+	_, done, err := policy.Guard(context.Background())
+	if err != nil {
+		test.Errorf("failed to admit call: %v", err)
+		return
+	}
+
+	if _, _, err := policy.Guard(context.Background()); !errors.Is(err, ErrBulkheadFull) {
+		test.Errorf("call beyond the bulkhead admitted: %v", err)
+		return
+	}
+
+	// a call waiting for a slot is admitted once one is released
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		done(nil)
+	}()
+
+	_, done, err = policy.Guard(context.Background())
+	if err != nil {
+		test.Errorf("waiting call not admitted: %v", err)
+		return
+	}
+
+	done(nil)
+}
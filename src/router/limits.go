@@ -0,0 +1,302 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the service config does not specify them
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 30 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultRequestTimeout    = 30 * time.Second
+	defaultMaxBodyBytes      = 4 << 20
+)
+
+// ErrBodyTooLarge is returned reading a request body beyond the size limit of its route
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// routeLimit is a parsed per route override
+type routeLimit struct {
+	routes         []string
+	maxBodyBytes   int64
+	requestTimeout time.Duration
+}
+
+// Limits holds the API server's connection timeouts and per request limits
+type Limits struct {
+	// connection level timeouts and header limit applied to the http server
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+
+	// default per request limits
+	maxBodyBytes   int64
+	requestTimeout time.Duration
+
+	// per route overrides (first match wins)
+	overrides []routeLimit
+}
+
+// NewLimits parses the API server limits from the service config
+func NewLimits(config *proto.ServiceConfig) (*Limits, error) {
+	limits := &Limits{
+		maxHeaderBytes: int(config.MaxHeaderBytes),
+		maxBodyBytes:   config.MaxBodyBytes,
+	}
+
+	if limits.maxHeaderBytes <= 0 {
+		limits.maxHeaderBytes = http.DefaultMaxHeaderBytes
+	}
+
+	if limits.maxBodyBytes <= 0 {
+		limits.maxBodyBytes = defaultMaxBodyBytes
+	}
+
+	durations := []struct {
+		target       *time.Duration
+		value        string
+		defaultValue time.Duration
+	}{
+		{&limits.readHeaderTimeout, config.ReadHeaderTimeout, defaultReadHeaderTimeout},
+		{&limits.readTimeout, config.ReadTimeout, defaultReadTimeout},
+		{&limits.writeTimeout, config.WriteTimeout, defaultWriteTimeout},
+		{&limits.idleTimeout, config.IdleTimeout, defaultIdleTimeout},
+		{&limits.requestTimeout, config.RequestTimeout, defaultRequestTimeout},
+	}
+
+	for _, d := range durations {
+		parsed, err := util.ParseDuration(d.value, d.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+
+		*d.target = parsed
+	}
+
+	for _, override := range config.RouteLimits {
+		for _, route := range override.Routes {
+			if _, _, err := util.SplitRoute(route); err != nil {
+				return nil, err
+			}
+		}
+
+		requestTimeout, err := util.ParseDuration(override.RequestTimeout, limits.requestTimeout)
+		if err != nil {
+			return nil, err
+		}
+
+		maxBodyBytes := override.MaxBodyBytes
+		if maxBodyBytes <= 0 {
+			maxBodyBytes = limits.maxBodyBytes
+		}
+
+		limits.overrides = append(limits.overrides, routeLimit{
+			routes:         override.Routes,
+			maxBodyBytes:   maxBodyBytes,
+			requestTimeout: requestTimeout,
+		})
+	}
+
+	// the connection's write deadline must leave room to send the timeout response
+	for _, timeout := range limits.requestTimeouts() {
+		if timeout >= limits.writeTimeout {
+			return nil, fmt.Errorf("request timeout %s must be lower than the write timeout %s",
+				timeout, limits.writeTimeout)
+		}
+	}
+
+	return limits, nil
+}
+
+// ApplyTo configures the http server's timeouts and header size limit
+func (l *Limits) ApplyTo(srv *http.Server) {
+	srv.ReadHeaderTimeout = l.readHeaderTimeout
+	srv.ReadTimeout = l.readTimeout
+	srv.WriteTimeout = l.writeTimeout
+	srv.IdleTimeout = l.idleTimeout
+	srv.MaxHeaderBytes = l.maxHeaderBytes
+}
+
+// GinMiddleware enforces the body size limit (413) and handler timeout (504) of each request
+// bodies declaring a larger Content-Length are rejected upfront, reading past the limit of a body whose size is
+// not known upfront (chunked) fails with ErrBodyTooLarge, and whatever the handler responds to it is replaced
+// by the 413. the request context is cancelled once the timeout expires and anything the handler writes afterwards
+// is discarded. handlers are not preempted: the 504 is sent once the handler returns, so one ignoring its context
+// holds the connection (and the client) until it does
+func (l *Limits) GinMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maxBodyBytes, requestTimeout := l.forRoute(c.Request.Method, c.FullPath())
+		if c.Request.ContentLength > maxBodyBytes {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrBodyTooLarge.Error()})
+			return
+		}
+
+		body := &limitedBody{ReadCloser: c.Request.Body, remaining: maxBodyBytes}
+		c.Request.Body = body
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), requestTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		writer := &limitWriter{ResponseWriter: c.Writer, ctx: ctx, body: body}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		if !writer.discarded() {
+			return
+		}
+
+		// the rest of the body (or the late response) is not read, so the connection is not reused
+		c.Writer.Header().Set("Connection", "close")
+		if body.exceeded() {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": ErrBodyTooLarge.Error()})
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out"})
+	}
+}
+
+// forRoute returns the body size limit and request timeout for a request
+func (l *Limits) forRoute(method, route string) (int64, time.Duration) {
+	for _, override := range l.overrides {
+		for _, pattern := range override.routes {
+			if util.MatchRoute(pattern, method, route) {
+				return override.maxBodyBytes, override.requestTimeout
+			}
+		}
+	}
+
+	return l.maxBodyBytes, l.requestTimeout
+}
+
+// requestTimeouts returns all configured request timeouts
+func (l *Limits) requestTimeouts() []time.Duration {
+	timeouts := []time.Duration{l.requestTimeout}
+	for _, override := range l.overrides {
+		timeouts = append(timeouts, override.requestTimeout)
+	}
+
+	return timeouts
+}
+
+// limitedBody fails reads past the size limit of a request body with ErrBodyTooLarge
+type limitedBody struct {
+	io.ReadCloser
+
+	// bytes left before reaching the limit
+	remaining int64
+
+	// set (1) once the limit has been exceeded
+	overLimit int32
+}
+
+// Read reads from the body, failing once more than the limit has been read
+func (b *limitedBody) Read(data []byte) (int, error) {
+	if b.exceeded() {
+		return 0, ErrBodyTooLarge
+	}
+
+	// one byte beyond the remaining ones is enough to detect the body exceeds the limit
+	if int64(len(data)) > b.remaining+1 {
+		data = data[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(data)
+	if int64(n) > b.remaining {
+		atomic.StoreInt32(&b.overLimit, 1)
+		n, err = int(b.remaining), ErrBodyTooLarge
+	}
+
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// exceeded returns true if reading the body went past the limit
+func (b *limitedBody) exceeded() bool {
+	return atomic.LoadInt32(&b.overLimit) == 1
+}
+
+// limitWriter discards a handler's response if it is written after the request timed out or its body
+// exceeded the limit, so the middleware can respond instead
+type limitWriter struct {
+	gin.ResponseWriter
+	ctx  context.Context
+	body *limitedBody
+
+	lock    sync.Mutex
+	expired bool
+}
+
+// WriteHeader forwards the status code unless the request has timed out or its body exceeded the limit
+func (w *limitWriter) WriteHeader(code int) {
+	if w.checkExpired() {
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// WriteHeaderNow flushes the status code unless the request has timed out or its body exceeded the limit
+func (w *limitWriter) WriteHeaderNow() {
+	if w.checkExpired() {
+		return
+	}
+
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write forwards the response body unless the request has timed out or its body exceeded the limit
+// late writes are reported as successful since gin's renderers panic on write errors
+func (w *limitWriter) Write(data []byte) (int, error) {
+	if w.checkExpired() {
+		return len(data), nil
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString forwards the response body unless the request has timed out or its body exceeded the limit
+func (w *limitWriter) WriteString(data string) (int, error) {
+	if w.checkExpired() {
+		return len(data), nil
+	}
+
+	return w.ResponseWriter.WriteString(data)
+}
+
+// checkExpired returns true if the request timed out or its body exceeded the limit before the handler
+// started its response
+func (w *limitWriter) checkExpired() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.expired && !w.ResponseWriter.Written() &&
+		(w.ctx.Err() == context.DeadlineExceeded || w.body.exceeded()) {
+		w.expired = true
+	}
+
+	return w.expired
+}
+
+// discarded returns true if the handler's response was discarded and the middleware's should be sent
+func (w *limitWriter) discarded() bool {
+	return w.checkExpired()
+}
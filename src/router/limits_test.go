@@ -0,0 +1,78 @@
+// Contains request limits unit testcases
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	proto "test_service/protobuf/generated"
+)
+
+// TestLimits unit tests rejecting large bodies with a 413 and slow handlers with a 504
+func TestLimits(test *testing.T) {
+	limits, err := NewLimits(&proto.ServiceConfig{MaxBodyBytes: 16, RequestTimeout: "20ms"})
+	if err != nil {
+		test.Errorf("failed to create limits: %v", err)
+		return
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(limits.GinMiddleware())
+	engine.POST("/echo", func(c *gin.Context) {
+		var request map[string]string
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, request)
+	})
+	engine.GET("/slow", func(c *gin.Context) {
+		time.Sleep(40 * time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"message": "late"})
+	})
+
+	large := `{"message": "more than sixteen bytes"}`
+	testcases := []struct {
+		name          string
+		method        string
+		path          string
+		body          string
+		contentLength int64
+		code          int
+	}{
+		{name: "small body", method: http.MethodPost, path: "/echo", body: `{"a": "b"}`, contentLength: 10,
+			code: http.StatusOK},
+		{name: "invalid body", method: http.MethodPost, path: "/echo", body: `{`, contentLength: 1,
+			code: http.StatusBadRequest},
+		{name: "content length", method: http.MethodPost, path: "/echo", body: large,
+			contentLength: int64(len(large)), code: http.StatusRequestEntityTooLarge},
+		{name: "chunked", method: http.MethodPost, path: "/echo", body: large, contentLength: -1,
+			code: http.StatusRequestEntityTooLarge},
+		{name: "timeout", method: http.MethodGet, path: "/slow", code: http.StatusGatewayTimeout},
+	}
+
+	for _, tc := range testcases {
+		request := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		request.ContentLength = tc.contentLength
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, request)
+
+		if recorder.Code != tc.code {
+			test.Errorf("testcase %s: expected status %d, got %d (%s)", tc.name, tc.code, recorder.Code,
+				recorder.Body.String())
+			continue
+		}
+
+		if tc.code != http.StatusOK && tc.code != http.StatusBadRequest &&
+			(recorder.Header().Get("Connection") != "close" || strings.Count(recorder.Body.String(), "error") != 1) {
+			test.Errorf("testcase %s: unexpected response %v %s", tc.name, recorder.Header(), recorder.Body.String())
+		}
+	}
+}
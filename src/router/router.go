@@ -5,28 +5,62 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
-	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"test_service/controllers"
-	"test_service/repository"
 )
 
 // NewRouter initializes a new API router based on Gin
 // also registers API endpoints and their handlers with the router
-func NewRouter(fh *os.File, repo *repository.Repository, logger *log.Entry) (*gin.Engine, error) {
+// middleware is applied (in order) to every route
+func NewRouter(fh *os.File, ctrl *controllers.Controller, middleware ...gin.HandlerFunc) (*gin.Engine, error) {
 	// write API logs to the server's logfile
 	// XXX: if these logs become too chatty, we may have to remove this
 	// 		or write to a separate file
 	gin.DefaultWriter = io.MultiWriter(fh)
 
 	r := gin.Default()
+	// forwarded client addresses are spoofable, only the rate limiter honors them (from its trusted proxies)
+	r.ForwardedByClientIP = false
 	//gin.SetMode(gin.ReleaseMode)
-
-	// create an instance of the controller
-	ctrl := controllers.NewController(repo, logger)
+	r.Use(middleware...)
 
 	// add routes
 	r.GET("/v1/ping", ctrl.Ping)
+	r.GET("/v1/health", ctrl.Health)
+	r.GET("/v1/ready", ctrl.Ready)
+
+	// expose prometheus metrics
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// admin routes (access is expected to be restricted through the authorization policy)
+	admin := r.Group("/v1/admin")
+	admin.GET("/loglevel", ctrl.LogLevel)
+	admin.PUT("/loglevel", ctrl.SetLogLevel)
+	if ctrl.Config != nil {
+		admin.GET("/config", ctrl.ShowConfig)
+	}
+
+	if ctrl.RpcServer != nil {
+		admin.GET("/rpc/services", ctrl.RpcServices)
+	}
+
+	if ctrl.ApiKeys != nil {
+		admin.POST("/apikeys", ctrl.CreateApiKey)
+		admin.GET("/apikeys", ctrl.ListApiKeys)
+		admin.POST("/apikeys/:id/rotate", ctrl.RotateApiKey)
+		admin.DELETE("/apikeys/:id", ctrl.RevokeApiKey)
+	}
+
+	if ctrl.Scheduler != nil {
+		admin.GET("/jobs", ctrl.ListJobs)
+		admin.POST("/jobs/:name/trigger", ctrl.TriggerJob)
+	}
+
+	if ctrl.JobQueue != nil {
+		admin.GET("/jobqueue", ctrl.ListBackgroundJobs)
+		admin.GET("/jobqueue/:id", ctrl.GetBackgroundJob)
+	}
 
 	return r, nil
 }
@@ -0,0 +1,404 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+
+	"test_service/leader"
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults used when the config does not specify them
+const (
+	defaultTimeout = 10 * time.Minute
+)
+
+// results of a job run
+const (
+	ResultOK      = "ok"
+	ResultFailed  = "failed"
+	ResultTimeout = "timeout"
+)
+
+// errors returned when triggering jobs
+var (
+	ErrJobNotFound = errors.New("job not found")
+	ErrJobRunning  = errors.New("job is already running")
+	ErrNotLeader   = errors.New("job only runs on the leader")
+)
+
+// metrics exported by the scheduler, labelled by job
+var (
+	runsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "scheduler_job_runs_total",
+		Help: "Number of job runs by result (ok, failed, timeout)",
+	}, []string{"job", "result"})
+
+	runDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "scheduler_job_duration_seconds",
+		Help:    "Time taken to run a job",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"job"})
+
+	lastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "scheduler_job_last_success_timestamp_seconds",
+		Help: "Time the job last ran successfully",
+	}, []string{"job"})
+)
+
+// JobFunc is the function of a job, a returned error (or panic) fails the run
+type JobFunc func(ctx context.Context) error
+
+// JobStatus is the state of a job and the outcome of its last run
+type JobStatus struct {
+	// Name of the job and its schedule
+	Name     string
+	Schedule string
+
+	// LeaderOnly jobs run only on the leader
+	LeaderOnly bool
+
+	// Running reports whether the job is running on this replica
+	Running bool
+
+	// NextRunAt is when the job is next scheduled to run
+	NextRunAt time.Time
+
+	// last run of the job, LastRunAt is zero if it never ran on this replica
+	LastRunAt    time.Time
+	LastDuration time.Duration
+	LastResult   string
+	LastError    string
+
+	// Runs and Failures count the runs of the job on this replica
+	Runs     int64
+	Failures int64
+}
+
+// job is a scheduled job
+type job struct {
+	name       string
+	spec       string
+	schedule   cron.Schedule
+	jitter     time.Duration
+	timeout    time.Duration
+	leaderOnly bool
+	fn         JobFunc
+
+	// lock guards the status and the cancel func of the running run
+	lock   sync.Mutex
+	status JobStatus
+	cancel context.CancelFunc
+}
+
+// Scheduler runs the jobs of the service on their schedules
+// a job is not started while its previous run is still running (the run is skipped), and leader only jobs are
+// skipped on replicas that are not the leader (their runs are cancelled when leadership is lost)
+type Scheduler struct {
+	// jobs by name and their names in config order
+	jobs  map[string]*job
+	names []string
+
+	// elector of the leader, nil without leader election
+	elector *leader.Elector
+
+	// logger object
+	logger *log.Entry
+
+	// ctx is cancelled to stop scheduling and cancel running jobs
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler of the configured jobs, their functions are looked up by name
+func NewScheduler(config *proto.SchedulerConfig, funcs map[string]JobFunc, elector *leader.Elector,
+	logger *log.Entry) (*Scheduler, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		jobs:    make(map[string]*job),
+		elector: elector,
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for _, jobConfig := range config.Jobs {
+		if jobConfig.Disabled {
+			continue
+		}
+
+		j, err := newJob(jobConfig, funcs[jobConfig.Name])
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		if j.leaderOnly && elector == nil {
+			cancel()
+			return nil, fmt.Errorf("job %s runs only on the leader, but leader election is not enabled", j.name)
+		}
+
+		if _, ok := s.jobs[j.name]; ok {
+			cancel()
+			return nil, fmt.Errorf("job %s is configured more than once", j.name)
+		}
+
+		s.jobs[j.name] = j
+		s.names = append(s.names, j.name)
+	}
+
+	if elector != nil {
+		elector.OnChange(func(isLeader bool, _ int64) {
+			if !isLeader {
+				s.cancelLeaderOnly()
+			}
+		})
+	}
+
+	return s, nil
+}
+
+// newJob parses the schedule and settings of a job
+func newJob(config *proto.JobConfig, fn JobFunc) (*job, error) {
+	if fn == nil {
+		return nil, fmt.Errorf("job %s has no registered function", config.Name)
+	}
+
+	spec := config.Schedule
+	var schedule cron.Schedule
+	if config.Interval != "" {
+		interval, err := util.ParseDuration(config.Interval, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if interval <= 0 {
+			return nil, fmt.Errorf("job %s has an invalid interval %q", config.Name, config.Interval)
+		}
+
+		spec = "@every " + interval.String()
+		schedule = intervalSchedule(interval)
+	} else if spec != "" {
+		var err error
+		schedule, err = cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %s has an invalid schedule %q: %v", config.Name, spec, err)
+		}
+	} else {
+		return nil, fmt.Errorf("job %s has no schedule or interval", config.Name)
+	}
+
+	jitter, err := util.ParseDuration(config.Jitter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := util.ParseDuration(config.Timeout, defaultTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job{
+		name:       config.Name,
+		spec:       spec,
+		schedule:   schedule,
+		jitter:     jitter,
+		timeout:    timeout,
+		leaderOnly: config.LeaderOnly,
+		fn:         fn,
+		status:     JobStatus{Name: config.Name, Schedule: spec, LeaderOnly: config.LeaderOnly},
+	}, nil
+}
+
+// Start schedules the jobs in the background until stopped
+func (s *Scheduler) Start() {
+	for _, name := range s.names {
+		j := s.jobs[name]
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.schedule(j)
+		}()
+	}
+}
+
+// Stop stops scheduling jobs, cancels the running ones and waits for them to return
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Status returns the status of the jobs in config order
+func (s *Scheduler) Status() []JobStatus {
+	statuses := make([]JobStatus, 0, len(s.names))
+	for _, name := range s.names {
+		statuses = append(statuses, s.jobs[name].snapshot())
+	}
+
+	return statuses
+}
+
+// Trigger starts a run of the job right away, without waiting for it to complete
+func (s *Scheduler) Trigger(name string) (JobStatus, error) {
+	j, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, ErrJobNotFound
+	}
+
+	if j.leaderOnly && !s.elector.IsLeader() {
+		return j.snapshot(), ErrNotLeader
+	}
+
+	if !s.start(j) {
+		return j.snapshot(), ErrJobRunning
+	}
+
+	s.logger.Infof("triggered job %s", name)
+	return j.snapshot(), nil
+}
+
+// schedule starts the runs of a job at the times of its schedule
+func (s *Scheduler) schedule(j *job) {
+	for {
+		now := time.Now()
+		next := j.schedule.Next(now)
+		if j.jitter > 0 {
+			next = next.Add(time.Duration(rand.Int63n(int64(j.jitter))))
+		}
+
+		j.lock.Lock()
+		j.status.NextRunAt = next
+		j.lock.Unlock()
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		if j.leaderOnly && !s.elector.IsLeader() {
+			s.logger.Debugf("skipping job %s, not the leader", j.name)
+			continue
+		}
+
+		if !s.start(j) {
+			s.logger.Warnf("skipping job %s, its previous run is still running", j.name)
+		}
+	}
+}
+
+// start runs the job in the background unless it is already running
+func (s *Scheduler) start(j *job) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.status.Running || s.ctx.Err() != nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, j.timeout)
+	j.status.Running = true
+	j.cancel = cancel
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.run(ctx, j)
+	}()
+
+	return true
+}
+
+// run runs the job and records the outcome
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	start := time.Now()
+	err := invoke(ctx, j.fn)
+	duration := time.Since(start)
+
+	result := ResultOK
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result = ResultTimeout
+	case err != nil:
+		result = ResultFailed
+	}
+
+	runsTotal.WithLabelValues(j.name, result).Inc()
+	runDuration.WithLabelValues(j.name).Observe(duration.Seconds())
+	if err != nil {
+		s.logger.Errorf("job %s %s after %s: %v", j.name, result, duration, err)
+	} else {
+		lastSuccess.WithLabelValues(j.name).SetToCurrentTime()
+		s.logger.Debugf("job %s completed in %s", j.name, duration)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.status.Running = false
+	j.cancel = nil
+	j.status.LastRunAt = start
+	j.status.LastDuration = duration
+	j.status.LastResult = result
+	j.status.LastError = ""
+	j.status.Runs++
+	if err != nil {
+		j.status.LastError = err.Error()
+		j.status.Failures++
+	}
+}
+
+// invoke runs the job function, recovering panics
+func invoke(ctx context.Context, fn JobFunc) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// cancelLeaderOnly cancels the running leader only jobs once leadership is lost
+func (s *Scheduler) cancelLeaderOnly() {
+	for _, j := range s.jobs {
+		if !j.leaderOnly {
+			continue
+		}
+
+		j.lock.Lock()
+		if j.cancel != nil {
+			s.logger.Warnf("cancelling job %s, leadership lost", j.name)
+			j.cancel()
+		}
+
+		j.lock.Unlock()
+	}
+}
+
+// intervalSchedule runs a job at a fixed interval (cron.Every rounds intervals to seconds)
+type intervalSchedule time.Duration
+
+// Next returns the time an interval after t
+func (i intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// snapshot returns a copy of the job's status
+func (j *job) snapshot() JobStatus {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.status
+}
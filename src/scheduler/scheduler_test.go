@@ -0,0 +1,162 @@
+// Contains scheduler unit testcases
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"test_service/kvstore"
+	"test_service/leader"
+	proto "test_service/protobuf/generated"
+)
+
+// TestScheduler unit tests running jobs on their schedules, overlap prevention, timeouts and triggering
+func TestScheduler(test *testing.T) {
+	var ticks int32
+	release := make(chan struct{})
+	scheduler, err := NewScheduler(&proto.SchedulerConfig{Jobs: []*proto.JobConfig{
+		{Name: "tick", Interval: "20ms"},
+		{Name: "slow", Schedule: "@every 1h"},
+		{Name: "timeout", Schedule: "@hourly", Timeout: "20ms"},
+		{Name: "disabled", Interval: "20ms", Disabled: true},
+	}}, map[string]JobFunc{
+		"tick": func(ctx context.Context) error {
+			atomic.AddInt32(&ticks, 1)
+			return nil
+		},
+		"slow": func(ctx context.Context) error {
+			<-release
+			return errors.New("failure")
+		},
+		"timeout": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, nil, log.WithField("test", "scheduler"))
+	if err != nil {
+		test.Errorf("failed to create scheduler: %v", err)
+		return
+	}
+
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	// interval jobs run repeatedly
+	time.Sleep(150 * time.Millisecond)
+	if count := atomic.LoadInt32(&ticks); count < 3 {
+		test.Errorf("interval job ran %d times", count)
+		return
+	}
+
+	statuses := scheduler.Status()
+	if len(statuses) != 3 || statuses[0].Name != "tick" || statuses[0].LastResult != ResultOK ||
+		statuses[1].Schedule != "@every 1h" || statuses[1].Runs != 0 ||
+		time.Until(statuses[1].NextRunAt) < 59*time.Minute {
+		test.Errorf("unexpected job statuses %+v", statuses)
+		return
+	}
+
+	// triggered jobs do not overlap
+	if _, err := scheduler.Trigger("slow"); err != nil {
+		test.Errorf("failed to trigger job: %v", err)
+		return
+	}
+
+	if _, err := scheduler.Trigger("slow"); err != ErrJobRunning {
+		test.Errorf("unexpected error triggering a running job: %v", err)
+		return
+	}
+
+	if _, err := scheduler.Trigger("disabled"); err != ErrJobNotFound {
+		test.Errorf("unexpected error triggering a disabled job: %v", err)
+		return
+	}
+
+	close(release)
+	if !waitFor(func() bool { return scheduler.Status()[1].Runs == 1 }) {
+		test.Errorf("triggered job did not complete")
+		return
+	}
+
+	if status := scheduler.Status()[1]; status.LastResult != ResultFailed || status.LastError != "failure" ||
+		status.Failures != 1 || status.Running {
+		test.Errorf("unexpected status of failed job %+v", status)
+		return
+	}
+
+	// runs are cancelled after the timeout
+	if _, err := scheduler.Trigger("timeout"); err != nil {
+		test.Errorf("failed to trigger job: %v", err)
+		return
+	}
+
+	if !waitFor(func() bool { return scheduler.Status()[2].LastResult == ResultTimeout }) {
+		test.Errorf("unexpected status of timed out job %+v", scheduler.Status()[2])
+		return
+	}
+}
+
+// TestLeaderOnly unit tests leader only jobs are triggered only on the leader
+func TestLeaderOnly(test *testing.T) {
+	logger := log.WithField("test", "scheduler")
+	config := &proto.SchedulerConfig{Jobs: []*proto.JobConfig{{Name: "singleton", Schedule: "@daily",
+		LeaderOnly: true}}}
+	funcs := map[string]JobFunc{"singleton": func(ctx context.Context) error { return nil }}
+	if _, err := NewScheduler(config, funcs, nil, logger); err == nil {
+		test.Errorf("leader only job accepted without leader election")
+		return
+	}
+
+	store := kvstore.NewMemoryStore()
+	defer store.Close()
+
+	elector, err := leader.NewElector(&proto.LeaderElectionConfig{RetryInterval: "20ms"}, "test",
+		leader.NewKVStoreBackend(store, "test"), "replica", logger)
+	if err != nil {
+		test.Errorf("failed to create elector: %v", err)
+		return
+	}
+
+	scheduler, err := NewScheduler(config, funcs, elector, logger)
+	if err != nil {
+		test.Errorf("failed to create scheduler: %v", err)
+		return
+	}
+
+	scheduler.Start()
+	defer scheduler.Stop()
+	if _, err := scheduler.Trigger("singleton"); err != ErrNotLeader {
+		test.Errorf("unexpected error triggering a leader only job on a follower: %v", err)
+		return
+	}
+
+	elector.Start()
+	defer elector.Stop()
+	if !waitFor(elector.IsLeader) {
+		test.Errorf("replica not elected")
+		return
+	}
+
+	if _, err := scheduler.Trigger("singleton"); err != nil {
+		test.Errorf("failed to trigger job on the leader: %v", err)
+		return
+	}
+}
+
+// waitFor polls the condition for up to 5 seconds
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return true
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return false
+}
@@ -1,5 +1,11 @@
 package server
 
+import (
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
 // Config for the service
 type Config struct {
 	// Service specific config like name, ip, etc
@@ -15,6 +21,78 @@ type Config struct {
 
 		// RpcPort where service hosts the RPC server
 		RpcPort string `yaml:"rpcPort"`
+
+		// ReadHeaderTimeout allowed to read request headers (e.g. "5s")
+		ReadHeaderTimeout string `yaml:"readHeaderTimeout"`
+
+		// ReadTimeout allowed to read an entire request
+		ReadTimeout string `yaml:"readTimeout"`
+
+		// WriteTimeout allowed to write a response
+		WriteTimeout string `yaml:"writeTimeout"`
+
+		// IdleTimeout of keep-alive connections
+		IdleTimeout string `yaml:"idleTimeout"`
+
+		// MaxHeaderBytes limits the size of request headers
+		MaxHeaderBytes int32 `yaml:"maxHeaderBytes"`
+
+		// MaxBodyBytes limits the size of request bodies
+		MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+
+		// RequestTimeout bounds the time spent handling a request
+		RequestTimeout string `yaml:"requestTimeout"`
+
+		// RouteLimits override body size and request timeout per route
+		RouteLimits []struct {
+			// Routes in the form "<METHOD> <path>"
+			Routes []string `yaml:"routes"`
+
+			// MaxBodyBytes for matching routes
+			MaxBodyBytes int64 `yaml:"maxBodyBytes"`
+
+			// RequestTimeout for matching routes
+			RequestTimeout string `yaml:"requestTimeout"`
+		} `yaml:"routeLimits"`
+
+		// RpcServer tuning options
+		RpcServer struct {
+			// KeepaliveTime after which idle clients are pinged (e.g. "2h")
+			KeepaliveTime string `yaml:"keepaliveTime"`
+
+			// KeepaliveTimeout waiting for a ping ack
+			KeepaliveTimeout string `yaml:"keepaliveTimeout"`
+
+			// KeepaliveMinTime is the minimum interval between client pings
+			KeepaliveMinTime string `yaml:"keepaliveMinTime"`
+
+			// KeepalivePermitWithoutStream allows client pings without active streams
+			KeepalivePermitWithoutStream bool `yaml:"keepalivePermitWithoutStream"`
+
+			// MaxConnectionIdle after which idle connections are closed
+			MaxConnectionIdle string `yaml:"maxConnectionIdle"`
+
+			// MaxConnectionAge after which connections are closed (forces rebalancing)
+			MaxConnectionAge string `yaml:"maxConnectionAge"`
+
+			// MaxConnectionAgeGrace for in-flight RPCs once a connection is too old
+			MaxConnectionAgeGrace string `yaml:"maxConnectionAgeGrace"`
+
+			// MaxRecvMsgBytes is the largest accepted request message
+			MaxRecvMsgBytes int32 `yaml:"maxRecvMsgBytes"`
+
+			// MaxSendMsgBytes is the largest response message
+			MaxSendMsgBytes int32 `yaml:"maxSendMsgBytes"`
+
+			// MaxConcurrentStreams per connection
+			MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams"`
+
+			// Compression available to clients ("gzip", or "none" to refuse compressed requests)
+			Compression string `yaml:"compression"`
+
+			// Reflection registers the gRPC server reflection service
+			Reflection bool `yaml:"reflection"`
+		} `yaml:"rpcServer"`
 	} `yaml:"service"`
 
 	// Logging details for the service
@@ -31,6 +109,9 @@ type Config struct {
 
 	// Datastore configuration for persisting service data
 	Datastore struct {
+		// Driver of the datastore ("postgres" or "sqlite")
+		Driver string `yaml:"driver"`
+
 		// FqdnOrIP of datastore
 		FqdnOrIP string `yaml:"fqdnOrIP"`
 
@@ -45,8 +126,252 @@ type Config struct {
 
 		// DBName represents the database name where data is stored
 		DbName string `yaml:"dbName"`
+
+		// MigrateOnStartup applies pending schema migrations when the server starts
+		MigrateOnStartup bool `yaml:"migrateOnStartup"`
+
+		// MaxOpenConns limits the number of open connections (0 is unlimited)
+		MaxOpenConns int32 `yaml:"maxOpenConns"`
+
+		// MaxIdleConns limits the number of idle connections in the pool
+		MaxIdleConns int32 `yaml:"maxIdleConns"`
+
+		// ConnMaxLifetime closes connections after this long (e.g. "30m")
+		ConnMaxLifetime string `yaml:"connMaxLifetime"`
+
+		// ConnMaxIdleTime closes connections idle for this long (e.g. "5m")
+		ConnMaxIdleTime string `yaml:"connMaxIdleTime"`
+
+		// ConnectTimeout bounds each connection attempt (e.g. "5s")
+		ConnectTimeout string `yaml:"connectTimeout"`
+
+		// SslMode of postgres connections (disable, prefer, require, verify-ca, verify-full)
+		SslMode string `yaml:"sslMode"`
+
+		// SslRootCert is the CA certificate file verifying the server
+		SslRootCert string `yaml:"sslRootCert"`
+
+		// SslCert is the client certificate file (mutual TLS)
+		SslCert string `yaml:"sslCert"`
+
+		// SslKey is the client key file (mutual TLS)
+		SslKey string `yaml:"sslKey"`
+
+		// StartupTimeout is how long connecting is retried at startup (e.g. "30s")
+		StartupTimeout string `yaml:"startupTimeout"`
+
+		// HealthCheckInterval is how often the datastore is pinged (e.g. "10s")
+		HealthCheckInterval string `yaml:"healthCheckInterval"`
+
+		// Replicas serving reads (postgres)
+		Replicas []struct {
+			// Name of the replica in logs and metrics
+			Name string `yaml:"name"`
+
+			// FqdnOrIP of the replica
+			FqdnOrIP string `yaml:"fqdnOrIP"`
+
+			// Port where the replica is listening for incoming connections
+			Port string `yaml:"port"`
+		} `yaml:"replicas"`
+
+		// MaxReplicaLag above which a replica stops serving reads (e.g. "10s")
+		MaxReplicaLag string `yaml:"maxReplicaLag"`
 	} `yaml:"datastore"`
 
+	// Outbox relay configuration
+	Outbox struct {
+		// Enabled starts the outbox relay
+		Enabled bool `yaml:"enabled"`
+
+		// PollInterval is how often the outbox is checked for pending events (e.g. "1s")
+		PollInterval string `yaml:"pollInterval"`
+
+		// BatchSize is the maximum number of events published per poll
+		BatchSize int32 `yaml:"batchSize"`
+
+		// PublishTimeout bounds each publish attempt (e.g. "10s")
+		PublishTimeout string `yaml:"publishTimeout"`
+
+		// MaxAttempts after which an event is discarded (0 retries forever)
+		MaxAttempts int32 `yaml:"maxAttempts"`
+
+		// MinRetryBackoff between publish attempts (e.g. "1s")
+		MinRetryBackoff string `yaml:"minRetryBackoff"`
+
+		// MaxRetryBackoff between publish attempts (e.g. "5m")
+		MaxRetryBackoff string `yaml:"maxRetryBackoff"`
+
+		// Retention of delivered events (e.g. "24h")
+		Retention string `yaml:"retention"`
+	} `yaml:"outbox"`
+
+	// Job queue configuration
+	JobQueue struct {
+		// Enabled allows enqueueing jobs and starts the workers
+		Enabled bool `yaml:"enabled"`
+
+		// Concurrency is the number of jobs a replica runs at a time
+		Concurrency int32 `yaml:"concurrency"`
+
+		// PollInterval is how often idle workers check for due jobs (e.g. "1s")
+		PollInterval string `yaml:"pollInterval"`
+
+		// HandlerTimeout bounds each run of a job (e.g. "5m")
+		HandlerTimeout string `yaml:"handlerTimeout"`
+
+		// LockTimeout after which a job of an unresponsive worker is run again (e.g. "10m")
+		LockTimeout string `yaml:"lockTimeout"`
+
+		// MaxAttempts of jobs enqueued without one
+		MaxAttempts int32 `yaml:"maxAttempts"`
+
+		// MinRetryBackoff between attempts (e.g. "1s")
+		MinRetryBackoff string `yaml:"minRetryBackoff"`
+
+		// MaxRetryBackoff between attempts (e.g. "1h")
+		MaxRetryBackoff string `yaml:"maxRetryBackoff"`
+
+		// DrainTimeout is how long stopping waits for running jobs (e.g. "30s")
+		DrainTimeout string `yaml:"drainTimeout"`
+
+		// Retention of finished jobs (e.g. "168h")
+		Retention string `yaml:"retention"`
+	} `yaml:"jobQueue"`
+
+	// Cache configuration
+	Cache struct {
+		// Enabled turns on caching of repository reads
+		Enabled bool `yaml:"enabled"`
+
+		// MaxEntries bounds the number of entities in the in process tier
+		MaxEntries int32 `yaml:"maxEntries"`
+
+		// MaxBytes bounds the size of the in process tier
+		MaxBytes int64 `yaml:"maxBytes"`
+
+		// TTL of cached entities (e.g. "5m")
+		TTL string `yaml:"ttl"`
+
+		// LocalTTL bounds how long entities stay in the in process tier (e.g. "30s")
+		LocalTTL string `yaml:"localTTL"`
+
+		// NegativeTTL is how long lookups of missing entities are cached (e.g. "30s")
+		NegativeTTL string `yaml:"negativeTTL"`
+
+		// Entities override the ttls of specific entities
+		Entities []struct {
+			// Name of the entity
+			Name string `yaml:"name"`
+
+			// TTL, LocalTTL and NegativeTTL of the entity
+			TTL         string `yaml:"ttl"`
+			LocalTTL    string `yaml:"localTTL"`
+			NegativeTTL string `yaml:"negativeTTL"`
+		} `yaml:"entities"`
+	} `yaml:"cache"`
+
+	// LeaderElection configuration
+	LeaderElection struct {
+		// Backend holding the leadership lease (datastore or kvstore), empty disables leader election
+		Backend string `yaml:"backend"`
+
+		// Name of the election (defaults to the service name)
+		Name string `yaml:"name"`
+
+		// LeaseDuration is how long leadership lasts without renewal (e.g. "15s")
+		LeaseDuration string `yaml:"leaseDuration"`
+
+		// RenewInterval is how often the leader renews its lease (e.g. "5s")
+		RenewInterval string `yaml:"renewInterval"`
+
+		// RetryInterval is how often other replicas try to acquire leadership (e.g. "2s")
+		RetryInterval string `yaml:"retryInterval"`
+	} `yaml:"leaderElection"`
+
+	// Queue configuration
+	Queue struct {
+		// Driver of the message broker (nats or memory), empty disables the queue
+		Driver string `yaml:"driver"`
+
+		// URL of the broker (e.g. "nats://localhost:4222")
+		URL string `yaml:"url"`
+
+		// Username and Password authenticating with the broker
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+
+		// Stream holding the service's topics
+		Stream string `yaml:"stream"`
+
+		// ConnectTimeout bounds connecting to the broker (e.g. "5s")
+		ConnectTimeout string `yaml:"connectTimeout"`
+
+		// AckWait is how long a delivery may stay unacknowledged before it is redelivered (e.g. "30s")
+		AckWait string `yaml:"ackWait"`
+
+		// Consumers of the service
+		Consumers []struct {
+			// Name of the consumer (and of its handler)
+			Name string `yaml:"name"`
+
+			// Topic consumed
+			Topic string `yaml:"topic"`
+
+			// Group sharing the topic's messages (defaults to the consumer name)
+			Group string `yaml:"group"`
+
+			// Concurrency is the number of messages handled at a time
+			Concurrency int32 `yaml:"concurrency"`
+
+			// MaxAttempts after which failing messages are dead lettered (0 retries forever)
+			MaxAttempts int32 `yaml:"maxAttempts"`
+
+			// MinRetryBackoff and MaxRetryBackoff bound the backoff between attempts (e.g. "1s", "5m")
+			MinRetryBackoff string `yaml:"minRetryBackoff"`
+			MaxRetryBackoff string `yaml:"maxRetryBackoff"`
+
+			// DeadLetterTopic receives the messages that failed MaxAttempts times
+			DeadLetterTopic string `yaml:"deadLetterTopic"`
+
+			// HandlerTimeout bounds handling a message, shorter than the queue's ack wait (e.g. "20s")
+			HandlerTimeout string `yaml:"handlerTimeout"`
+
+			// DrainTimeout bounds waiting for in-flight messages on shutdown (e.g. "30s")
+			DrainTimeout string `yaml:"drainTimeout"`
+		} `yaml:"consumers"`
+	} `yaml:"queue"`
+
+	// Scheduler configuration
+	Scheduler struct {
+		// Enabled starts the scheduler
+		Enabled bool `yaml:"enabled"`
+
+		// Jobs of the service
+		Jobs []struct {
+			// Name of the job (and of its function)
+			Name string `yaml:"name"`
+
+			// Schedule is a cron expression or descriptor (e.g. "*/5 * * * *", "@every 10m")
+			Schedule string `yaml:"schedule"`
+
+			// Interval runs the job at a fixed interval instead of a schedule (e.g. "30s")
+			Interval string `yaml:"interval"`
+
+			// Jitter delays every run by a random duration up to it (e.g. "10s")
+			Jitter string `yaml:"jitter"`
+
+			// Timeout bounds a run of the job (e.g. "5m")
+			Timeout string `yaml:"timeout"`
+
+			// LeaderOnly runs the job only on the elected leader
+			LeaderOnly bool `yaml:"leaderOnly"`
+
+			// Disabled jobs are not scheduled
+			Disabled bool `yaml:"disabled"`
+		} `yaml:"jobs"`
+	} `yaml:"scheduler"`
+
 	// KVStore configuration
 	KVStore struct {
 		// FqdnOrIP of the kv store
@@ -54,5 +379,290 @@ type Config struct {
 
 		// Port where kv store is listening for incoming connections
 		Port string `yaml:"port"`
+
+		// Driver of the kv store (redis or memory), empty disables the kv store
+		Driver string `yaml:"driver"`
+
+		// Password of the kv store
+		Password string `yaml:"password"`
+
+		// Database number to select
+		Database int32 `yaml:"database"`
+
+		// KeyPrefix is prepended to all keys
+		KeyPrefix string `yaml:"keyPrefix"`
+
+		// ConnectTimeout bounds each connection attempt (e.g. "5s")
+		ConnectTimeout string `yaml:"connectTimeout"`
+
+		// PoolSize is the maximum number of connections
+		PoolSize int32 `yaml:"poolSize"`
+
+		// Tls connects to the kv store over TLS
+		Tls bool `yaml:"tls"`
 	} `yaml:"kvstore"`
+
+	// Authorization configuration (role based access control)
+	Authorization struct {
+		// PolicyFile mapping roles/scopes to routes and RPC methods
+		PolicyFile string `yaml:"policyFile"`
+
+		// Mode of enforcement (enforce, audit). authorization is disabled if empty
+		Mode string `yaml:"mode"`
+
+		// DefaultDeny rejects requests not matching any policy rule
+		DefaultDeny bool `yaml:"defaultDeny"`
+
+		// ReloadInterval at which the policy file is checked for changes (e.g. "30s")
+		ReloadInterval string `yaml:"reloadInterval"`
+	} `yaml:"authorization"`
+
+	// Authentication configuration
+	Authentication struct {
+		// ApiKeys authentication (keys are stored hashed in the datastore)
+		ApiKeys struct {
+			// Enabled turns on api key authentication and admin endpoints
+			Enabled bool `yaml:"enabled"`
+
+			// CacheTTL for in-memory api key lookups (e.g. "1m")
+			CacheTTL string `yaml:"cacheTTL"`
+
+			// LastUsedFlushInterval at which key usage is persisted (e.g. "1m")
+			LastUsedFlushInterval string `yaml:"lastUsedFlushInterval"`
+		} `yaml:"apiKeys"`
+	} `yaml:"authentication"`
+
+	// RateLimit configuration for incoming REST and RPC requests
+	RateLimit struct {
+		// Enabled turns on rate limiting
+		Enabled bool `yaml:"enabled"`
+
+		// Global limit shared by all clients
+		Global RateLimit `yaml:"global"`
+
+		// PerClient default limit for each client (api key, subject or IP)
+		PerClient RateLimit `yaml:"perClient"`
+
+		// Rules overriding the per client limit for routes and RPC methods
+		Rules []struct {
+			// Name of the rule
+			Name string `yaml:"name"`
+
+			// Routes in the form "<METHOD> <path>"
+			Routes []string `yaml:"routes"`
+
+			// Rpcs are gRPC full method names
+			Rpcs []string `yaml:"rpcs"`
+
+			// Limit for each client on matching requests
+			Limit RateLimit `yaml:"limit"`
+		} `yaml:"rules"`
+
+		// Distributed keeps limiter state in the kv store (limits apply across replicas)
+		Distributed bool `yaml:"distributed"`
+
+		// TrustedProxies are the IPs or CIDRs of proxies whose X-Forwarded-For header identifies REST clients
+		TrustedProxies []string `yaml:"trustedProxies"`
+	} `yaml:"rateLimit"`
+
+	// Idempotency configuration of mutating REST requests and RPCs
+	Idempotency struct {
+		// Enabled honors idempotency keys
+		Enabled bool `yaml:"enabled"`
+
+		// Backend storing the keys and responses ("kvstore" or "datastore")
+		Backend string `yaml:"backend"`
+
+		// Window during which the response of a key is replayed (e.g. "24h")
+		Window string `yaml:"window"`
+
+		// InFlightTimeout after which the key of a request that never completed is released (e.g. "1m")
+		InFlightTimeout string `yaml:"inFlightTimeout"`
+
+		// Routes in the form "<METHOD> <path>" (all mutating routes if empty)
+		Routes []string `yaml:"routes"`
+
+		// Rpcs are gRPC full method names (all unary RPCs if empty)
+		Rpcs []string `yaml:"rpcs"`
+	} `yaml:"idempotency"`
+
+	// Resilience policies of outbound dependencies
+	Resilience struct {
+		// Dependencies guarded by a policy
+		Dependencies []struct {
+			// Name of the dependency ("datastore", "kvstore" or an outbound client)
+			Name string `yaml:"name"`
+
+			// Timeout of each attempt (e.g. "2s")
+			Timeout string `yaml:"timeout"`
+
+			// Retry of failed calls
+			Retry Retry `yaml:"retry"`
+
+			// Breaker failing fast while the dependency keeps failing
+			Breaker Breaker `yaml:"breaker"`
+
+			// Bulkhead bounding concurrent calls
+			Bulkhead Bulkhead `yaml:"bulkhead"`
+
+			// Readiness reports the server as not ready while the breaker is open
+			Readiness bool `yaml:"readiness"`
+		} `yaml:"dependencies"`
+	} `yaml:"resilience"`
+
+	// HttpClients the service calls other APIs with
+	HttpClients struct {
+		// Clients by name
+		Clients []struct {
+			// Name the client is looked up by
+			Name string `yaml:"name"`
+
+			// BaseUrl relative request urls are resolved against
+			BaseUrl string `yaml:"baseUrl"`
+
+			// Timeout of a request including retries (e.g. "10s")
+			Timeout string `yaml:"timeout"`
+
+			// ConnectTimeout of establishing a connection (e.g. "2s")
+			ConnectTimeout string `yaml:"connectTimeout"`
+
+			// ResponseHeaderTimeout of each attempt (e.g. "5s")
+			ResponseHeaderTimeout string `yaml:"responseHeaderTimeout"`
+
+			// IdleConnTimeout after which idle connections are closed (e.g. "90s")
+			IdleConnTimeout string `yaml:"idleConnTimeout"`
+
+			// MaxIdleConnsPerHost kept open
+			MaxIdleConnsPerHost int32 `yaml:"maxIdleConnsPerHost"`
+
+			// Tls of https requests
+			Tls struct {
+				// CaFile verifying the server (system CAs if empty)
+				CaFile string `yaml:"caFile"`
+
+				// CertFile is the client certificate file (mutual TLS)
+				CertFile string `yaml:"certFile"`
+
+				// KeyFile is the client key file (mutual TLS)
+				KeyFile string `yaml:"keyFile"`
+
+				// ServerName verified in the server certificate
+				ServerName string `yaml:"serverName"`
+
+				// InsecureSkipVerify disables verifying the server (development only)
+				InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+			} `yaml:"tls"`
+
+			// Retry of failed idempotent requests
+			Retry Retry `yaml:"retry"`
+
+			// Breaker failing fast while the API keeps failing
+			Breaker Breaker `yaml:"breaker"`
+
+			// Bulkhead bounding concurrent requests
+			Bulkhead Bulkhead `yaml:"bulkhead"`
+		} `yaml:"clients"`
+
+		// RedactHeaders logged as REDACTED in addition to the default ones
+		RedactHeaders []string `yaml:"redactHeaders"`
+	} `yaml:"httpClients"`
+
+	// LoadShedding configuration bounding concurrent REST and RPC requests
+	LoadShedding struct {
+		// Enabled turns on load shedding
+		Enabled bool `yaml:"enabled"`
+
+		// Mode is either "fixed" or "adaptive"
+		Mode string `yaml:"mode"`
+
+		// MaxInFlight is the fixed limit (upper bound in adaptive mode)
+		MaxInFlight int32 `yaml:"maxInFlight"`
+
+		// MinInFlight is the lower bound of the adaptive limit
+		MinInFlight int32 `yaml:"minInFlight"`
+
+		// InitialInFlight is the starting adaptive limit
+		InitialInFlight int32 `yaml:"initialInFlight"`
+
+		// TargetLatency above which the adaptive limit is decreased (e.g. "250ms")
+		TargetLatency string `yaml:"targetLatency"`
+
+		// Priorities classifying routes and RPC methods (critical, normal, low)
+		Priorities []struct {
+			// Priority of matching requests
+			Priority string `yaml:"priority"`
+
+			// Routes in the form "<METHOD> <path>"
+			Routes []string `yaml:"routes"`
+
+			// Rpcs are gRPC full method names
+			Rpcs []string `yaml:"rpcs"`
+		} `yaml:"priorities"`
+	} `yaml:"loadShedding"`
+}
+
+// RateLimit is a token bucket definition
+type RateLimit struct {
+	// RatePerSecond at which tokens are replenished
+	RatePerSecond float64 `yaml:"ratePerSecond"`
+
+	// Burst is the number of requests allowed at once
+	Burst int32 `yaml:"burst"`
+}
+
+// Retry controls retrying failed calls with exponential backoff
+type Retry struct {
+	// MaxAttempts including the first one (1 or less disables retries)
+	MaxAttempts int32 `yaml:"maxAttempts"`
+
+	// InitialBackoff before the first retry (e.g. "100ms")
+	InitialBackoff string `yaml:"initialBackoff"`
+
+	// MaxBackoff between retries (e.g. "2s")
+	MaxBackoff string `yaml:"maxBackoff"`
+
+	// Multiplier of the backoff after each retry (defaults to 2)
+	Multiplier float64 `yaml:"multiplier"`
+
+	// Jitter is the randomized fraction of each backoff (0 to 1)
+	Jitter float64 `yaml:"jitter"`
+}
+
+// Breaker controls a circuit breaker
+type Breaker struct {
+	// Enabled turns on the circuit breaker
+	Enabled bool `yaml:"enabled"`
+
+	// FailureThreshold is the number of consecutive failures opening the breaker
+	FailureThreshold int32 `yaml:"failureThreshold"`
+
+	// OpenTimeout after which probing calls are let through (e.g. "30s")
+	OpenTimeout string `yaml:"openTimeout"`
+
+	// HalfOpenRequests is the number of successful probes closing the breaker
+	HalfOpenRequests int32 `yaml:"halfOpenRequests"`
+}
+
+// Bulkhead bounds concurrent calls
+type Bulkhead struct {
+	// MaxConcurrent calls (0 disables the bulkhead)
+	MaxConcurrent int32 `yaml:"maxConcurrent"`
+
+	// MaxWait for a free slot (e.g. "100ms")
+	MaxWait string `yaml:"maxWait"`
+}
+
+// ReadConfig reads the service config from a yaml file
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
 }
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+
+	"test_service/propagation"
+)
+
+// ginMiddleware builds the chain of middleware applied to every REST request
+func (s *Server) ginMiddleware() []gin.HandlerFunc {
+	// request ids are assigned first so that every response (including rejections) carries one
+	middleware := []gin.HandlerFunc{propagation.GinMiddleware()}
+
+	// shed load first so that rejected requests cost as little as possible
+	if s.Shedder != nil {
+		middleware = append(middleware, s.Shedder.GinMiddleware())
+	}
+
+	// bound request body sizes and handler time
+	if s.ApiLimits != nil {
+		middleware = append(middleware, s.ApiLimits.GinMiddleware())
+	}
+
+	// authentication has to run before authorization so the policy sees the caller's principal
+	if s.ApiKeyManager != nil {
+		middleware = append(middleware, s.ApiKeyManager.GinMiddleware())
+	}
+
+	// rate limits are keyed by the caller's identity, so they are also applied after authentication
+	if s.RateLimiter != nil {
+		middleware = append(middleware, s.RateLimiter.GinMiddleware())
+	}
+
+	if s.PolicyEngine != nil {
+		middleware = append(middleware, s.PolicyEngine.GinMiddleware())
+	}
+
+	// keys are scoped to the caller, and only requests that were let through hold them
+	if s.Idempotency != nil {
+		middleware = append(middleware, s.Idempotency.GinMiddleware())
+	}
+
+	return middleware
+}
+
+// grpcServerOptions builds the options (interceptor chains, etc) used to create the RPC server
+func (s *Server) grpcServerOptions() []grpc.ServerOption {
+	unaryInterceptors := []grpc.UnaryServerInterceptor{propagation.UnaryServerInterceptor()}
+	streamInterceptors := []grpc.StreamServerInterceptor{propagation.StreamServerInterceptor()}
+	if s.Shedder != nil {
+		unaryInterceptors = append(unaryInterceptors, s.Shedder.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.Shedder.StreamServerInterceptor())
+	}
+
+	if s.ApiKeyManager != nil {
+		unaryInterceptors = append(unaryInterceptors, s.ApiKeyManager.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.ApiKeyManager.StreamServerInterceptor())
+	}
+
+	if s.RateLimiter != nil {
+		unaryInterceptors = append(unaryInterceptors, s.RateLimiter.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.RateLimiter.StreamServerInterceptor())
+	}
+
+	if s.PolicyEngine != nil {
+		unaryInterceptors = append(unaryInterceptors, s.PolicyEngine.UnaryServerInterceptor())
+		streamInterceptors = append(streamInterceptors, s.PolicyEngine.StreamServerInterceptor())
+	}
+
+	if s.Idempotency != nil {
+		unaryInterceptors = append(unaryInterceptors, s.Idempotency.UnaryServerInterceptor())
+	}
+
+	// tuning options come first, so requests with a refused compression are rejected before other interceptors
+	options := append([]grpc.ServerOption{}, s.rpcTuning...)
+	return append(options,
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	)
+}
@@ -2,8 +2,17 @@ package server
 
 import (
 	"context"
+	"errors"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"test_service/auth"
+	"test_service/models"
 	proto "test_service/protobuf/generated"
+	"test_service/scheduler"
+	"test_service/util"
 )
 
 // Ping rpc request handler
@@ -14,3 +23,206 @@ func (s *Server) Ping(ctx context.Context, request *proto.PingRequest) (*proto.P
 
 	return response, nil
 }
+
+// CreateApiKey rpc request handler
+func (s *Server) CreateApiKey(ctx context.Context, request *proto.CreateApiKeyRequest) (*proto.ApiKeyResponse, error) {
+	if s.ApiKeyManager == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if request.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "api key name is required")
+	}
+
+	expiresIn, err := util.ParseDuration(request.ExpiresIn, 0)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	key, plaintext, err := s.ApiKeyManager.Create(ctx, request.Name, request.Scopes, expiresIn)
+	if err != nil {
+		return nil, s.apiKeyStatus(err)
+	}
+
+	return &proto.ApiKeyResponse{ApiKey: apiKeyToProto(key), Key: plaintext}, nil
+}
+
+// ListApiKeys rpc request handler
+func (s *Server) ListApiKeys(ctx context.Context, request *proto.ListApiKeysRequest) (*proto.ListApiKeysResponse, error) {
+	if s.ApiKeyManager == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	keys, err := s.ApiKeyManager.List(ctx)
+	if err != nil {
+		return nil, s.apiKeyStatus(err)
+	}
+
+	response := &proto.ListApiKeysResponse{}
+	for i := range keys {
+		response.ApiKeys = append(response.ApiKeys, apiKeyToProto(&keys[i]))
+	}
+
+	return response, nil
+}
+
+// RotateApiKey rpc request handler
+func (s *Server) RotateApiKey(ctx context.Context, request *proto.RotateApiKeyRequest) (*proto.ApiKeyResponse, error) {
+	if s.ApiKeyManager == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	key, plaintext, err := s.ApiKeyManager.Rotate(ctx, request.Id)
+	if err != nil {
+		return nil, s.apiKeyStatus(err)
+	}
+
+	return &proto.ApiKeyResponse{ApiKey: apiKeyToProto(key), Key: plaintext}, nil
+}
+
+// RevokeApiKey rpc request handler
+func (s *Server) RevokeApiKey(ctx context.Context, request *proto.RevokeApiKeyRequest) (*proto.RevokeApiKeyResponse, error) {
+	if s.ApiKeyManager == nil {
+		return nil, status.Error(codes.Unimplemented, "api keys are disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.ApiKeyManager.Revoke(ctx, request.Id); err != nil {
+		return nil, s.apiKeyStatus(err)
+	}
+
+	return &proto.RevokeApiKeyResponse{}, nil
+}
+
+// ListJobs rpc request handler
+func (s *Server) ListJobs(ctx context.Context, request *proto.ListJobsRequest) (*proto.ListJobsResponse, error) {
+	if s.Scheduler == nil {
+		return nil, status.Error(codes.Unimplemented, "scheduler is disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	response := &proto.ListJobsResponse{}
+	for _, jobStatus := range s.Scheduler.Status() {
+		response.Jobs = append(response.Jobs, jobToProto(jobStatus))
+	}
+
+	return response, nil
+}
+
+// TriggerJob rpc request handler
+func (s *Server) TriggerJob(ctx context.Context, request *proto.TriggerJobRequest) (*proto.TriggerJobResponse, error) {
+	if s.Scheduler == nil {
+		return nil, status.Error(codes.Unimplemented, "scheduler is disabled")
+	}
+
+	if err := s.requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	jobStatus, err := s.Scheduler.Trigger(request.Name)
+	switch {
+	case err == nil:
+		return &proto.TriggerJobResponse{Job: jobToProto(jobStatus)}, nil
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, scheduler.ErrJobRunning), errors.Is(err, scheduler.ErrNotLeader):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	default:
+		s.ContextLogger.Errorf("failed to trigger job: %v", err)
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+}
+
+// requireAdmin rejects rpcs that are not made by an admin
+// credentials and scheduled jobs are only managed by admins even when the authorization policy is not enforced
+func (s *Server) requireAdmin(ctx context.Context) error {
+	switch err := auth.RequireScope(ctx, auth.AdminScope); {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case err != nil:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return nil
+}
+
+// apiKeyStatus maps api key manager errors to rpc status errors
+func (s *Server) apiKeyStatus(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrApiKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, auth.ErrInvalidApiKey):
+		return status.Error(codes.FailedPrecondition, "api key is revoked or expired")
+	default:
+		s.ContextLogger.Errorf("api key request failed: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// apiKeyToProto converts an api key model to its proto definition
+func apiKeyToProto(key *models.ApiKey) *proto.ApiKey {
+	protoKey := &proto.ApiKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.ScopeList(),
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+
+	if key.ExpiresAt != nil {
+		protoKey.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+
+	if key.RevokedAt != nil {
+		protoKey.RevokedAt = timestamppb.New(*key.RevokedAt)
+	}
+
+	if key.LastUsedAt != nil {
+		protoKey.LastUsedAt = timestamppb.New(*key.LastUsedAt)
+	}
+
+	return protoKey
+}
+
+// jobToProto converts the status of a job to its proto definition
+func jobToProto(jobStatus scheduler.JobStatus) *proto.Job {
+	job := &proto.Job{
+		Name:       jobStatus.Name,
+		Schedule:   jobStatus.Schedule,
+		LeaderOnly: jobStatus.LeaderOnly,
+		Running:    jobStatus.Running,
+		LastResult: jobStatus.LastResult,
+		LastError:  jobStatus.LastError,
+		Runs:       jobStatus.Runs,
+		Failures:   jobStatus.Failures,
+	}
+
+	if !jobStatus.NextRunAt.IsZero() {
+		job.NextRunAt = timestamppb.New(jobStatus.NextRunAt)
+	}
+
+	if !jobStatus.LastRunAt.IsZero() {
+		job.LastRunAt = timestamppb.New(jobStatus.LastRunAt)
+		job.LastDuration = jobStatus.LastDuration.String()
+	}
+
+	return job
+}
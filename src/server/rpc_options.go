@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+
+	proto "test_service/protobuf/generated"
+	"test_service/util"
+)
+
+// defaults applied to the rpc server when the config does not specify them
+const (
+	defaultKeepaliveTime         = 2 * time.Hour
+	defaultKeepaliveTimeout      = 20 * time.Second
+	defaultKeepaliveMinTime      = 5 * time.Minute
+	defaultMaxConnectionAgeGrace = 30 * time.Second
+)
+
+// rpcTuningOptions translates the rpc server config to gRPC server options
+func (s *Server) rpcTuningOptions() ([]grpc.ServerOption, error) {
+	rpcConfig := s.Config.Service.RpcServer
+	if rpcConfig == nil {
+		rpcConfig = &proto.RpcServerConfig{}
+	}
+
+	var params keepalive.ServerParameters
+	var policy keepalive.EnforcementPolicy
+	durations := []struct {
+		target       *time.Duration
+		value        string
+		defaultValue time.Duration
+	}{
+		{&params.Time, rpcConfig.KeepaliveTime, defaultKeepaliveTime},
+		{&params.Timeout, rpcConfig.KeepaliveTimeout, defaultKeepaliveTimeout},
+		// zero connection idle time and age mean connections are never closed by the server
+		{&params.MaxConnectionIdle, rpcConfig.MaxConnectionIdle, 0},
+		{&params.MaxConnectionAge, rpcConfig.MaxConnectionAge, 0},
+		{&params.MaxConnectionAgeGrace, rpcConfig.MaxConnectionAgeGrace, defaultMaxConnectionAgeGrace},
+		{&policy.MinTime, rpcConfig.KeepaliveMinTime, defaultKeepaliveMinTime},
+	}
+
+	for _, d := range durations {
+		parsed, err := util.ParseDuration(d.value, d.defaultValue)
+		if err != nil {
+			return nil, err
+		}
+
+		*d.target = parsed
+	}
+
+	policy.PermitWithoutStream = rpcConfig.KeepalivePermitWithoutStream
+
+	options := []grpc.ServerOption{
+		grpc.KeepaliveParams(params),
+		grpc.KeepaliveEnforcementPolicy(policy),
+	}
+
+	if rpcConfig.MaxRecvMsgBytes > 0 {
+		options = append(options, grpc.MaxRecvMsgSize(int(rpcConfig.MaxRecvMsgBytes)))
+	}
+
+	if rpcConfig.MaxSendMsgBytes > 0 {
+		options = append(options, grpc.MaxSendMsgSize(int(rpcConfig.MaxSendMsgBytes)))
+	}
+
+	if rpcConfig.MaxConcurrentStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(rpcConfig.MaxConcurrentStreams))
+	}
+
+	// grpc's gzip package registers its (pooled) compressor globally when imported, so without compression
+	// compressed requests are refused by an interceptor instead
+	switch rpcConfig.Compression {
+	case "", "none":
+		options = append(options,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler) (interface{}, error) {
+				if err := uncompressed(ctx); err != nil {
+					return nil, err
+				}
+
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+				handler grpc.StreamHandler) error {
+				if err := uncompressed(ss.Context()); err != nil {
+					return err
+				}
+
+				return handler(srv, ss)
+			}))
+	case gzip.Name:
+	default:
+		return nil, fmt.Errorf("unsupported rpc compression %q", rpcConfig.Compression)
+	}
+
+	s.ContextLogger.Infof("rpc server options: keepalive %+v, enforcement %+v, max recv/send bytes %d/%d, "+
+		"max concurrent streams %d, compression %q", params, policy, rpcConfig.MaxRecvMsgBytes,
+		rpcConfig.MaxSendMsgBytes, rpcConfig.MaxConcurrentStreams, rpcConfig.Compression)
+	return options, nil
+}
+
+// uncompressed rejects requests sent with a compressor when compression is turned off
+func uncompressed(ctx context.Context) error {
+	stream, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ RecvCompress() string })
+	if !ok {
+		return nil
+	}
+
+	if compressor := stream.RecvCompress(); compressor != "" && compressor != encoding.Identity {
+		return status.Errorf(codes.Unimplemented, "rpc compression %q is not supported", compressor)
+	}
+
+	return nil
+}
@@ -17,10 +17,29 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
+	"test_service/auth"
+	"test_service/cache"
+	"test_service/controllers"
+	"test_service/httpclient"
+	"test_service/idempotency"
+	"test_service/jobqueue"
+	"test_service/kvstore"
+	"test_service/leader"
+	"test_service/loadshed"
+	"test_service/models"
+	"test_service/outbox"
 	proto "test_service/protobuf/generated"
+	"test_service/queue"
+	"test_service/ratelimit"
 	"test_service/repository"
+	"test_service/resilience"
 	"test_service/router"
+	"test_service/scheduler"
+	"test_service/util"
 )
 
 // globals
@@ -36,6 +55,21 @@ var (
 	}
 )
 
+// kvStorePingTimeout and queuePingTimeout bound the pings of readiness checks
+const (
+	kvStorePingTimeout = 2 * time.Second
+	queuePingTimeout   = 2 * time.Second
+)
+
+// names of the dependencies whose calls are guarded by the resilience policy configured for them
+const (
+	DependencyDatastore = "datastore"
+	DependencyKVStore   = "kvstore"
+)
+
+// outboxAggregateKeyHeader carries the aggregate key of outbox events published to the queue
+const outboxAggregateKeyHeader = "X-Aggregate-Key"
+
 // Server object for the service
 // contains handlers to api/rpc server, db object, server config, logger, etc
 type Server struct {
@@ -45,9 +79,18 @@ type Server struct {
 	// api server object
 	ApiSrvr *http.Server
 
+	// timeouts and request limits applied to the api server
+	ApiLimits *router.Limits
+
 	// rpc server object (RPCs are implemented through gRPC)
 	RpcSrvr *grpc.Server
 
+	// standard gRPC health service hosted by the rpc server
+	HealthSrvr *health.Server
+
+	// tuning options (keepalive, message sizes, etc) applied to the rpc server
+	rpcTuning []grpc.ServerOption
+
 	// file handle to server's logs
 	LogFileHandle *os.File
 
@@ -69,7 +112,81 @@ type Server struct {
 	// repository object (includes conn object to the db/repo)
 	Repository *repository.Repository
 
-	// XXX: add connection objects for kvstores, queues, etc
+	// Store is the repository wrapped by the cache (if enabled) which controllers and components read through
+	// (nil if the datastore is disabled)
+	Store repository.Store
+
+	// read-through cache of repository entities (nil if the cache is disabled)
+	Cache *cache.Cache
+
+	// health checker of the repository's database (nil if the datastore is disabled)
+	DbHealth *repository.HealthChecker
+
+	// health checkers of the repository's read replicas, unhealthy replicas stop serving reads
+	ReplicaHealth []*repository.HealthChecker
+
+	// readiness checks of the server's dependencies, keyed by name (a nil error means ready)
+	// guarded by readinessLock as health checkers and breakers report while dependencies are being initialized
+	readinessChecks map[string]func() error
+	readinessLock   sync.RWMutex
+
+	// policy engine enforcing role based access control (nil if authorization is disabled)
+	PolicyEngine *auth.PolicyEngine
+
+	// api key manager authenticating api keys (nil if api key authentication is disabled)
+	ApiKeyManager *auth.ApiKeyManager
+
+	// rate limiter for incoming requests (nil if rate limiting is disabled)
+	RateLimiter *ratelimit.RateLimiter
+
+	// Idempotency replays the responses of requests retried with an idempotency key (nil if disabled)
+	Idempotency *idempotency.Idempotency
+
+	// load shedder bounding concurrent requests (nil if load shedding is disabled)
+	Shedder *loadshed.Shedder
+
+	// OutboxPublisher delivers the events recorded in the outbox, set it before calling Run
+	// (events are logged if it is nil)
+	OutboxPublisher outbox.Publisher
+
+	// relay publishing the events recorded in the outbox (nil if the outbox is disabled)
+	OutboxRelay *outbox.Relay
+
+	// JobQueue holds the durable background jobs of the service (nil if the job queue is disabled)
+	JobQueue *jobqueue.Queue
+
+	// JobHandlers run the jobs of the queue (by job type), set them before calling Run
+	JobHandlers map[string]jobqueue.Handler
+
+	// worker running the jobs of the queue, drained on Close (nil if the job queue is disabled)
+	JobWorker *jobqueue.Worker
+
+	// Resilience holds the policies (timeouts, retries, breakers, bulkheads) guarding the calls to dependencies
+	Resilience *resilience.Registry
+
+	// HttpClients are the named HTTP clients configured for calling other APIs (see HttpClient)
+	HttpClients *httpclient.Factory
+
+	// KVStore shared by the replicas of the service (nil if no kv store driver is configured)
+	KVStore kvstore.Store
+
+	// elector running singleton background work on the leader replica (nil if leader election is disabled)
+	LeaderElector *leader.Elector
+
+	// Queue is the message broker shared by the replicas of the service (nil if no queue driver is configured)
+	Queue queue.Broker
+
+	// QueueHandlers handle the messages of the configured consumers (by consumer name), set them before calling Run
+	QueueHandlers map[string]queue.Handler
+
+	// consumers of the queue, drained on Close
+	Consumers []*queue.Consumer
+
+	// ScheduledJobs are the functions of the configured jobs (by job name), set them before calling Run
+	ScheduledJobs map[string]scheduler.JobFunc
+
+	// Scheduler running the periodic jobs of the service (nil if the scheduler is disabled)
+	Scheduler *scheduler.Scheduler
 }
 
 // NewServer initializes a new server object
@@ -96,9 +213,13 @@ func (s *Server) Run() error {
 	// initialize server instance
 	if err := s.initialize(); err != nil {
 		s.ContextLogger.Errorf("failed to initialize server instance: %v", err)
+		s.closeDependencies()
 		return err
 	}
 
+	// create the rpc server up front so its registered services are known to the api server
+	s.RpcSrvr = s.newRPCServer()
+
 	// start api server. this is done in a background thread since it is a blocking call
 	s.wg.Add(2)
 	go s.goRunAPIServer()
@@ -134,19 +255,88 @@ func (s *Server) Close() error {
 	}
 
 	// stop the grpc server
+	if s.HealthSrvr != nil {
+		s.HealthSrvr.Shutdown()
+	}
+
 	s.RpcSrvr.Stop()
+	s.closeDependencies()
+	return nil
+}
+
+// closeDependencies stops the background workers and closes the connections to external systems, including
+// those of a partially initialized server
+func (s *Server) closeDependencies() {
+	// stop scheduling jobs, cancelling the running ones
+	if s.Scheduler != nil {
+		s.Scheduler.Stop()
+	}
+
+	// stop consuming messages, waiting for the in-flight ones
+	for _, consumer := range s.Consumers {
+		consumer.Close()
+	}
+
+	// stop claiming jobs, waiting for the running ones
+	if s.JobWorker != nil {
+		s.JobWorker.Stop()
+	}
+
+	// flush api key usage and stop the api key manager
+	if s.ApiKeyManager != nil {
+		s.ApiKeyManager.Stop()
+	}
+
+	// stop publishing outbox events
+	if s.OutboxRelay != nil {
+		s.OutboxRelay.Stop()
+	}
+
+	// step down (stopping singleton tasks) so another replica takes over right away
+	if s.LeaderElector != nil {
+		s.LeaderElector.Stop()
+	}
+
+	// stop watching the authorization policy
+	if s.PolicyEngine != nil {
+		s.PolicyEngine.Stop()
+	}
+
+	// stop checking the datastore's health
+	if s.DbHealth != nil {
+		s.DbHealth.Stop()
+	}
+
+	for _, replicaHealth := range s.ReplicaHealth {
+		replicaHealth.Stop()
+	}
+
+	// stop watching cache invalidations
+	if s.Cache != nil {
+		s.Cache.Stop()
+	}
+
+	// close the idle connections of the http clients
+	if s.HttpClients != nil {
+		s.HttpClients.Close()
+	}
+
+	// close the queue connection (after the outbox relay and consumers stopped publishing)
+	if s.Queue != nil {
+		s.Queue.Close()
+	}
+
+	// close the kv store connection
+	if s.KVStore != nil {
+		s.KVStore.Close()
+	}
 
 	// close db conn
 	// gorm supports connection pooling so you should only close this connection
 	// if all consumers are done with it
 	if s.Repository != nil {
-		sqlDB, _ := s.Repository.DbConn.DB()
-		if sqlDB != nil {
-			sqlDB.Close()
-		}
+		s.Repository.Close()
 	}
-
-	return nil
 }
 
 // WaitForServerBootup is a utility that helps clients wait before issuing requests against the server
@@ -166,29 +356,651 @@ func (s *Server) WaitForServerBootup(timeout time.Duration) error {
 
 // initialize will setup connections from the server to external systems like datastore, KV store, queues, etc
 func (s *Server) initialize() error {
-	// comment out DB initialization since the template is not pointing to a valid DB (init will fail)
-	// START BLOCK
-	// initialize db connection
-	/*
-		if err := s.initializeDbConn(); err != nil {
-			s.ContextLogger.Errorf("failed to initialize repository connection: %v", err)
-			return err
+	// the health service is created up front so dependency health checks can report to it
+	s.HealthSrvr = health.NewServer()
+	s.readinessChecks = map[string]func() error{}
+
+	// initialize the resilience policies guarding the calls to dependencies
+	if err := s.initializeResilience(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize resilience policies: %v", err)
+		return err
+	}
+
+	// initialize the http clients used to call other APIs
+	if err := s.initializeHttpClients(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize http clients: %v", err)
+		return err
+	}
+
+	// initialize db connection (skipped if no datastore driver is configured)
+	if err := s.initializeDbConn(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize repository connection: %v", err)
+		return err
+	}
+
+	// parse api server timeouts and request limits
+	apiLimits, err := router.NewLimits(s.Config.Service)
+	if err != nil {
+		s.ContextLogger.Errorf("invalid api server limits: %v", err)
+		return err
+	}
+
+	s.ApiLimits = apiLimits
+
+	// parse rpc server tuning options
+	rpcTuning, err := s.rpcTuningOptions()
+	if err != nil {
+		s.ContextLogger.Errorf("invalid rpc server options: %v", err)
+		return err
+	}
+
+	s.rpcTuning = rpcTuning
+
+	// initialize load shedding of REST and RPC requests
+	if err := s.initializeShedder(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize load shedder: %v", err)
+		return err
+	}
+
+	// initialize the kv store connection (skipped if no kv store driver is configured)
+	if err := s.initializeKVStore(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize kv store connection: %v", err)
+		return err
+	}
+
+	// initialize the message broker connection (skipped if no queue driver is configured)
+	if err := s.initializeQueue(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize queue connection: %v", err)
+		return err
+	}
+
+	// initialize the read-through cache in front of the repository
+	if err := s.initializeCache(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize cache: %v", err)
+		return err
+	}
+
+	// initialize leader election (singleton tasks only run on the leader)
+	if err := s.initializeLeaderElector(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize leader election: %v", err)
+		return err
+	}
+
+	// initialize the relay publishing events recorded in the outbox
+	if err := s.initializeOutboxRelay(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize outbox relay: %v", err)
+		return err
+	}
+
+	// initialize the job queue stored in the datastore
+	if err := s.initializeJobQueue(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize job queue: %v", err)
+		return err
+	}
+
+	// initialize api key authentication (keys are stored in the repository)
+	if err := s.initializeApiKeyManager(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize api key manager: %v", err)
+		return err
+	}
+
+	// initialize rate limiting of incoming requests
+	if err := s.initializeRateLimiter(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize rate limiter: %v", err)
+		return err
+	}
+
+	// initialize the authorization policy engine
+	if err := s.initializePolicyEngine(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize authorization policy engine: %v", err)
+		return err
+	}
+
+	// initialize idempotency keys of REST requests and RPCs
+	if err := s.initializeIdempotency(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize idempotency: %v", err)
+		return err
+	}
+
+	// start the queue consumers last, their handlers may depend on any of the above
+	if err := s.initializeConsumers(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize queue consumers: %v", err)
+		return err
+	}
+
+	// start running queued jobs, their handlers may also depend on any of the above
+	if err := s.initializeJobWorker(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize job worker: %v", err)
+		return err
+	}
+
+	// start scheduling the periodic jobs, which may also depend on any of the above
+	if err := s.initializeScheduler(); err != nil {
+		s.ContextLogger.Errorf("failed to initialize scheduler: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// initializeResilience creates the policies of the configured dependencies
+// dependencies with readiness set report the server as not ready while their breaker is open
+func (s *Server) initializeResilience() error {
+	resilienceConfig := s.Config.Resilience
+	if resilienceConfig == nil {
+		resilienceConfig = &proto.ResilienceConfig{}
+	}
+
+	registry, err := resilience.NewRegistry(resilienceConfig, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	for _, dependency := range resilienceConfig.Dependencies {
+		if !dependency.Readiness {
+			continue
 		}
-	*/
-	// END BLOCK
 
-	// XXX: initialize other connection objects like kvstore and queues
+		policy, _ := registry.Policy(dependency.Name)
+		s.addReadinessCheck("breaker/"+dependency.Name, policy.Err)
+		policy.OnStateChange(func(resilience.State) { s.updateServingStatus() })
+	}
+
+	s.Resilience = registry
+	return nil
+}
+
+// initializeHttpClients creates the http clients of the config
+func (s *Server) initializeHttpClients() error {
+	httpClientsConfig := s.Config.HttpClients
+	if httpClientsConfig == nil {
+		httpClientsConfig = &proto.HttpClientsConfig{}
+	}
+
+	factory, err := httpclient.NewFactory(httpClientsConfig, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.HttpClients = factory
 	return nil
 }
 
+// HttpClient returns the http client configured with the name
+// requests made with a context of a REST request or RPC being served propagate its request id and trace context
+func (s *Server) HttpClient(name string) (*httpclient.Client, error) {
+	if s.HttpClients == nil {
+		return nil, fmt.Errorf("%w: %s", httpclient.ErrClientNotFound, name)
+	}
+
+	return s.HttpClients.Client(name)
+}
+
+// initializeDbConn connects to the datastore if a driver is configured
 func (s *Server) initializeDbConn() error {
-	repoConn, err := repository.NewRepository(s.Config.Datastore)
+	if s.Config.Datastore == nil || s.Config.Datastore.Driver == "" {
+		s.ContextLogger.Info("datastore is disabled")
+		return nil
+	}
+
+	repoConn, err := repository.NewRepository(s.Config.Datastore, s.ContextLogger)
 	if err != nil {
 		return err
 	}
 
 	s.ContextLogger.Infof("repository connection initialized successfully")
+	if policy, ok := s.Resilience.Policy(DependencyDatastore); ok {
+		if err := repoConn.UsePolicy(policy); err != nil {
+			repoConn.Close()
+			return err
+		}
+	}
+
 	s.Repository = repoConn
+	s.Store = repoConn
+	if s.Config.Datastore.MigrateOnStartup {
+		// replicas starting together serialize on the migration lock, only the first one applies migrations
+		migrator, err := repoConn.Migrator(s.ContextLogger)
+		if err != nil {
+			return err
+		}
+
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			return err
+		}
+
+		s.ContextLogger.Infof("applied %d schema migrations", applied)
+	}
+
+	// the server is not ready while its database is unreachable
+	healthCheckInterval, err := util.ParseDuration(s.Config.Datastore.HealthCheckInterval, 0)
+	if err != nil {
+		return err
+	}
+
+	s.DbHealth = repository.NewHealthChecker(repoConn, "primary", healthCheckInterval,
+		func(error) { s.updateServingStatus() }, s.ContextLogger)
+	s.addReadinessCheck("datastore", s.DbHealth.Err)
+	s.DbHealth.Start()
+
+	// replicas do not affect readiness, reads fall back to the primary when no replica is healthy
+	for _, replica := range repoConn.Replicas() {
+		replicaHealth := repository.NewHealthChecker(replica, replica.Name, healthCheckInterval,
+			replica.SetHealthy, s.ContextLogger)
+		s.ReplicaHealth = append(s.ReplicaHealth, replicaHealth)
+		replicaHealth.Start()
+	}
+
+	return nil
+}
+
+// addReadinessCheck registers the readiness check of a dependency
+func (s *Server) addReadinessCheck(name string, check func() error) {
+	s.readinessLock.Lock()
+	defer s.readinessLock.Unlock()
+	s.readinessChecks[name] = check
+}
+
+// currentReadinessChecks returns a copy of the registered readiness checks
+func (s *Server) currentReadinessChecks() map[string]func() error {
+	s.readinessLock.RLock()
+	defer s.readinessLock.RUnlock()
+	checks := make(map[string]func() error, len(s.readinessChecks))
+	for name, check := range s.readinessChecks {
+		checks[name] = check
+	}
+
+	return checks
+}
+
+// updateServingStatus reports the server as not serving through the health service while any dependency is unhealthy
+// the checks are run on a copy, they may be slow and further checks may be registered meanwhile
+func (s *Server) updateServingStatus() {
+	status := healthpb.HealthCheckResponse_SERVING
+	for name, check := range s.currentReadinessChecks() {
+		if err := check(); err != nil {
+			s.ContextLogger.Warnf("server is not ready, %s check failed: %v", name, err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	s.HealthSrvr.SetServingStatus("", status)
+}
+
+// initializeShedder sets up load shedding if it is enabled in the config
+func (s *Server) initializeShedder() error {
+	loadSheddingConfig := s.Config.LoadShedding
+	if loadSheddingConfig == nil || !loadSheddingConfig.Enabled {
+		s.ContextLogger.Info("load shedding is disabled")
+		return nil
+	}
+
+	shedder, err := loadshed.NewShedder(loadSheddingConfig, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.Shedder = shedder
+	return nil
+}
+
+// initializeKVStore connects to the kv store if a driver is configured
+func (s *Server) initializeKVStore() error {
+	if s.Config.Kvstore == nil || s.Config.Kvstore.Driver == "" {
+		s.ContextLogger.Info("kv store is disabled")
+		return nil
+	}
+
+	store, err := kvstore.NewStore(s.Config.Kvstore, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.ContextLogger.Infof("kv store (%s) connection initialized successfully", s.Config.Kvstore.Driver)
+	s.KVStore = store
+
+	// the server is not ready while the kv store is unreachable
+	s.addReadinessCheck("kvstore", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), kvStorePingTimeout)
+		defer cancel()
+		return store.Ping(ctx)
+	})
+
+	if policy, ok := s.Resilience.Policy(DependencyKVStore); ok {
+		s.KVStore = kvstore.NewResilientStore(store, policy)
+	}
+
+	return nil
+}
+
+// initializeQueue connects to the message broker if a driver is configured
+func (s *Server) initializeQueue() error {
+	if s.Config.Queue == nil || s.Config.Queue.Driver == "" {
+		s.ContextLogger.Info("queue is disabled")
+		return nil
+	}
+
+	broker, err := queue.NewBroker(s.Config.Queue, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.ContextLogger.Infof("queue (%s) connection initialized successfully", s.Config.Queue.Driver)
+	s.Queue = broker
+
+	// the server is not ready while the broker is unreachable
+	s.addReadinessCheck("queue", func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), queuePingTimeout)
+		defer cancel()
+		return broker.Ping(ctx)
+	})
+
+	return nil
+}
+
+// initializeConsumers starts the configured queue consumers with their registered handlers
+func (s *Server) initializeConsumers() error {
+	if s.Config.Queue == nil || len(s.Config.Queue.Consumers) == 0 {
+		return nil
+	}
+
+	if s.Queue == nil {
+		return fmt.Errorf("queue consumers require a queue connection")
+	}
+
+	for _, consumerConfig := range s.Config.Queue.Consumers {
+		handler, ok := s.QueueHandlers[consumerConfig.Name]
+		if !ok {
+			return fmt.Errorf("no handler registered for queue consumer %s", consumerConfig.Name)
+		}
+
+		consumer, err := queue.NewConsumer(consumerConfig, s.Queue, handler, s.ContextLogger)
+		if err != nil {
+			return err
+		}
+
+		if err := consumer.Start(); err != nil {
+			return fmt.Errorf("failed to start queue consumer %s: %v", consumerConfig.Name, err)
+		}
+
+		s.Consumers = append(s.Consumers, consumer)
+	}
+
+	return nil
+}
+
+// initializeJobQueue sets up the job queue if it is enabled in the config
+func (s *Server) initializeJobQueue() error {
+	if s.Config.JobQueue == nil || !s.Config.JobQueue.Enabled {
+		s.ContextLogger.Info("job queue is disabled")
+		return nil
+	}
+
+	if s.Repository == nil {
+		return fmt.Errorf("the job queue requires a repository connection")
+	}
+
+	s.JobQueue = jobqueue.NewQueue(s.Config.JobQueue, s.Repository, s.ContextLogger)
+	return nil
+}
+
+// initializeJobWorker starts running the queued jobs that have a registered handler
+func (s *Server) initializeJobWorker() error {
+	if s.JobQueue == nil || len(s.JobHandlers) == 0 {
+		return nil
+	}
+
+	identity := s.Config.Host.InstanceName
+	if identity == "" {
+		identity = s.Config.Host.Uuid
+	}
+
+	worker, err := jobqueue.NewWorker(s.Config.JobQueue, s.JobQueue, s.JobHandlers, identity, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	worker.Start()
+	s.JobWorker = worker
+	return nil
+}
+
+// initializeScheduler starts running the configured jobs with their registered functions
+func (s *Server) initializeScheduler() error {
+	if s.Config.Scheduler == nil || !s.Config.Scheduler.Enabled {
+		s.ContextLogger.Info("scheduler is disabled")
+		return nil
+	}
+
+	sched, err := scheduler.NewScheduler(s.Config.Scheduler, s.ScheduledJobs, s.LeaderElector, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	sched.Start()
+	s.Scheduler = sched
+	return nil
+}
+
+// initializeCache sets up the read-through cache if it is enabled in the config, entities are shared with
+// the other replicas through the kv store if one is configured
+func (s *Server) initializeCache() error {
+	if s.Config.Cache == nil || !s.Config.Cache.Enabled {
+		s.ContextLogger.Info("cache is disabled")
+		return nil
+	}
+
+	// the memory kv store is private to the process, it is not a tier shared with the other replicas
+	shared := s.KVStore
+	if s.Config.Kvstore != nil && s.Config.Kvstore.Driver == kvstore.DriverMemory {
+		shared = nil
+	}
+
+	c, err := cache.NewCache(s.Config.Cache, shared, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	s.Cache = c
+	if s.Repository != nil {
+		s.Store = cache.NewCachedStore(s.Repository, c)
+	}
+
+	return nil
+}
+
+// initializeLeaderElector starts campaigning for leadership if leader election is enabled in the config
+// the lease is held by the datastore (postgres advisory locks) or the kv store
+func (s *Server) initializeLeaderElector() error {
+	electionConfig := s.Config.LeaderElection
+	if electionConfig == nil || electionConfig.Backend == "" {
+		s.ContextLogger.Info("leader election is disabled")
+		return nil
+	}
+
+	name := electionConfig.Name
+	if name == "" {
+		name = s.Config.Service.Name
+	}
+
+	var backend leader.Backend
+	switch electionConfig.Backend {
+	case leader.BackendDatastore:
+		if s.Repository == nil {
+			return fmt.Errorf("leader election with the datastore requires a repository connection")
+		}
+
+		datastoreBackend, err := leader.NewDatastoreBackend(s.Repository, name)
+		if err != nil {
+			return err
+		}
+
+		backend = datastoreBackend
+	case leader.BackendKVStore:
+		if s.KVStore == nil {
+			return fmt.Errorf("leader election with the kv store requires a kv store connection")
+		}
+
+		backend = leader.NewKVStoreBackend(s.KVStore, name)
+	default:
+		return fmt.Errorf("unsupported leader election backend %q", electionConfig.Backend)
+	}
+
+	identity := s.Config.Host.InstanceName
+	if identity == "" {
+		identity = s.Config.Host.Uuid
+	}
+
+	elector, err := leader.NewElector(electionConfig, name, backend, identity, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	elector.Start()
+	s.LeaderElector = elector
+	return nil
+}
+
+// initializeOutboxRelay starts the outbox relay if it is enabled in the config
+func (s *Server) initializeOutboxRelay() error {
+	if s.Config.Outbox == nil || !s.Config.Outbox.Enabled {
+		s.ContextLogger.Info("outbox relay is disabled")
+		return nil
+	}
+
+	if s.Repository == nil {
+		return fmt.Errorf("the outbox relay requires a repository connection")
+	}
+
+	// events are published to the queue (to the event's topic) unless the service provides a publisher
+	publisher := s.OutboxPublisher
+	switch {
+	case publisher != nil:
+	case s.Queue != nil:
+		publisher = outbox.PublisherFunc(func(ctx context.Context, event *models.OutboxEvent) error {
+			return s.Queue.Publish(ctx, event.Topic, &queue.Message{
+				ID:      fmt.Sprintf("outbox-%d", event.ID),
+				Headers: map[string]string{outboxAggregateKeyHeader: event.AggregateKey},
+				Body:    event.Payload,
+			})
+		})
+	default:
+		publisher = &outbox.LogPublisher{Logger: s.ContextLogger}
+	}
+
+	relay, err := outbox.NewRelay(s.Config.Outbox, s.Repository, publisher, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	relay.Start()
+	s.OutboxRelay = relay
+	return nil
+}
+
+// initializeApiKeyManager sets up api key authentication if it is enabled in the config
+func (s *Server) initializeApiKeyManager() error {
+	authnConfig := s.Config.Authentication
+	if authnConfig == nil || authnConfig.ApiKeys == nil || !authnConfig.ApiKeys.Enabled {
+		s.ContextLogger.Info("api key authentication is disabled")
+		return nil
+	}
+
+	if s.Repository == nil {
+		return fmt.Errorf("api key authentication requires a repository connection")
+	}
+
+	manager, err := auth.NewApiKeyManager(authnConfig.ApiKeys, s.Store, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	manager.Start()
+	s.ApiKeyManager = manager
+	return nil
+}
+
+// initializeRateLimiter sets up rate limiting if it is enabled in the config
+func (s *Server) initializeRateLimiter() error {
+	rateLimitConfig := s.Config.RateLimit
+	if rateLimitConfig == nil || !rateLimitConfig.Enabled {
+		s.ContextLogger.Info("rate limiting is disabled")
+		return nil
+	}
+
+	limiter := ratelimit.NewLocalLimiter()
+	if rateLimitConfig.Distributed {
+		if s.KVStore == nil {
+			return fmt.Errorf("distributed rate limiting requires a kv store connection")
+		}
+
+		limiter = ratelimit.NewDistributedLimiter(s.KVStore, s.Config.Service.Name)
+	}
+
+	rateLimiter, err := ratelimit.NewRateLimiter(rateLimitConfig, limiter, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.RateLimiter = rateLimiter
+	return nil
+}
+
+// initializePolicyEngine loads the authorization policy if authorization is enabled in the config
+func (s *Server) initializePolicyEngine() error {
+	authzConfig := s.Config.Authorization
+	if authzConfig == nil || authzConfig.Mode == "" {
+		s.ContextLogger.Info("authorization is disabled")
+		return nil
+	}
+
+	engine, err := auth.NewPolicyEngine(authzConfig, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	engine.Start()
+	s.PolicyEngine = engine
+	return nil
+}
+
+// initializeIdempotency sets up the idempotency keys store (kv store or datastore) if enabled in the config
+func (s *Server) initializeIdempotency() error {
+	idempotencyConfig := s.Config.Idempotency
+	if idempotencyConfig == nil || !idempotencyConfig.Enabled {
+		s.ContextLogger.Info("idempotency keys are disabled")
+		return nil
+	}
+
+	var store idempotency.Store
+	switch idempotencyConfig.Backend {
+	case idempotency.BackendKVStore, "":
+		if s.KVStore == nil {
+			return fmt.Errorf("idempotency keys in the kv store require a kv store connection")
+		}
+
+		store = idempotency.NewKVStore(s.KVStore)
+	case idempotency.BackendDatastore:
+		if s.Repository == nil {
+			return fmt.Errorf("idempotency keys in the datastore require a datastore connection")
+		}
+
+		store = idempotency.NewRepositoryStore(s.Repository, s.ContextLogger)
+	default:
+		return fmt.Errorf("unsupported idempotency backend %q", idempotencyConfig.Backend)
+	}
+
+	idempotent, err := idempotency.NewIdempotency(idempotencyConfig, store, s.Config.Service.Name, s.ContextLogger)
+	if err != nil {
+		return err
+	}
+
+	s.Idempotency = idempotent
 	return nil
 }
 
@@ -196,7 +1008,18 @@ func (s *Server) initializeDbConn() error {
 func (s *Server) goRunAPIServer() {
 	defer s.wg.Done()
 
-	r, err := router.NewRouter(s.LogFileHandle, s.Repository, s.ContextLogger)
+	// create an instance of the controller
+	ctrl := controllers.NewController(s.Store, s.ContextLogger)
+	ctrl.ApiKeys = s.ApiKeyManager
+	ctrl.KVStore = s.KVStore
+	ctrl.Leader = s.LeaderElector
+	ctrl.Scheduler = s.Scheduler
+	ctrl.JobQueue = s.JobQueue
+	ctrl.RpcServer = s.RpcSrvr
+	ctrl.Config = s.Config
+	ctrl.ReadinessChecks = s.currentReadinessChecks()
+
+	r, err := router.NewRouter(s.LogFileHandle, &ctrl, s.ginMiddleware()...)
 	if err != nil {
 		s.ContextLogger.Error("failed to initialize api router")
 		s.wg.Done()
@@ -207,6 +1030,7 @@ func (s *Server) goRunAPIServer() {
 		Addr:    ":" + s.Config.Service.ApiPort,
 		Handler: r,
 	}
+	s.ApiLimits.ApplyTo(apiSrvr)
 
 	// store the api handle before starting the server
 	// since starting the server is a blocking call
@@ -223,6 +1047,22 @@ func (s *Server) goRunAPIServer() {
 	}
 }
 
+// newRPCServer creates the server's RPC server and registers the services it hosts
+func (s *Server) newRPCServer() *grpc.Server {
+	grpcServer := grpc.NewServer(s.grpcServerOptions()...)
+	proto.RegisterTestServiceRPCServer(grpcServer, s)
+
+	// register the standard health service so clients and orchestrators can probe the rpc server
+	healthpb.RegisterHealthServer(grpcServer, s.HealthSrvr)
+
+	// server reflection lets tools like grpcurl explore the services without the .proto files
+	if rpcConfig := s.Config.Service.RpcServer; rpcConfig != nil && rpcConfig.Reflection {
+		reflection.Register(grpcServer)
+	}
+
+	return grpcServer
+}
+
 // goRunRPCServer initializes the server's RPC server in the form of a Go routine
 func (s *Server) goRunRPCServer() {
 	s.wg.Done()
@@ -231,10 +1071,7 @@ func (s *Server) goRunRPCServer() {
 		s.ContextLogger.Fatalf("failed to listen on rpc port: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	s.RpcSrvr = grpcServer
-	proto.RegisterTestServiceRPCServer(grpcServer, s)
-	if err := grpcServer.Serve(listener); err != nil {
+	if err := s.RpcSrvr.Serve(listener); err != nil {
 		s.ContextLogger.Infof("error running rpc server on port %s, err: %s",
 			s.Config.Service.RpcPort, err)
 		return
@@ -4,12 +4,30 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
+	"path/filepath"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"test_service/auth"
+	"test_service/client"
+	"test_service/kvstore"
+	"test_service/models"
+	"test_service/propagation"
 	proto "test_service/protobuf/generated"
+	"test_service/queue"
+	"test_service/repository"
 	"test_service/util"
 	"test_service/v1api"
 )
@@ -46,15 +64,19 @@ func TestServer(test *testing.T) {
 		return
 	}
 
+	if resp.Header.Get(propagation.RequestIDHeader) == "" {
+		test.Errorf("API response has no request id")
+		return
+	}
+
 	// test server's grpc capability
-	grpcConn, err := grpc.Dial("127.0.0.1:8001", grpc.WithInsecure())
+	grpcClient, err := client.NewClient(client.Options{Target: "127.0.0.1:8001"})
 	if err != nil {
-		test.Errorf("failed to create connection object for grpc client: %v", err)
+		test.Errorf("failed to create grpc client: %v", err)
 		return
 	}
-	defer grpcConn.Close()
+	defer grpcClient.Close()
 
-	grpcClient := proto.NewTestServiceRPCClient(grpcConn)
 	rpcResponse, err := grpcClient.Ping(context.Background(), &proto.PingRequest{})
 	if err != nil {
 		test.Errorf("failed to issue rpc call to server")
@@ -66,5 +88,271 @@ func TestServer(test *testing.T) {
 		return
 	}
 
+	// test gzip compressed calls round trip
+	rpcResponse, err = grpcClient.Ping(context.Background(), &proto.PingRequest{}, grpc.UseCompressor(gzip.Name))
+	if err != nil || rpcResponse.Message != "pong" {
+		test.Errorf("failed to issue gzip compressed rpc call to server: %v", err)
+		return
+	}
+
+	// test rpc introspection through the api server
+	introspectionResp, err := http.Get("http://127.0.0.1:8000/v1/admin/rpc/services")
+	if err != nil {
+		test.Errorf("failed to issue REST call for rpc introspection: %v", err)
+		return
+	}
+	defer introspectionResp.Body.Close()
+
+	var services models.RpcServicesResponse
+	json.NewDecoder(introspectionResp.Body).Decode(&services)
+	if _, ok := services.Messages["test_service.PingResponse"]; !ok {
+		test.Errorf("rpc introspection did not describe the ping response: %+v", services)
+		return
+	}
+
+	// test readiness of the server's dependencies (datastore)
+	readyResp, err := http.Get("http://127.0.0.1:8000/v1/ready")
+	if err != nil {
+		test.Errorf("failed to issue REST call for readiness: %v", err)
+		return
+	}
+	defer readyResp.Body.Close()
+
+	var readiness models.ReadinessResponse
+	json.NewDecoder(readyResp.Body).Decode(&readiness)
+	if readyResp.StatusCode != http.StatusOK || readiness.Checks["datastore"] != "ok" ||
+		readiness.Checks["kvstore"] != "ok" || readiness.Checks["queue"] != "ok" {
+		test.Errorf("server is not ready: %+v", readiness)
+		return
+	}
+
+	// test the health output reports this (single) replica as the leader
+	healthResp, err := http.Get("http://127.0.0.1:8000/v1/health")
+	if err != nil {
+		test.Errorf("failed to issue REST call for health: %v", err)
+		return
+	}
+	defer healthResp.Body.Close()
+
+	var health models.HealthResponse
+	json.NewDecoder(healthResp.Body).Decode(&health)
+	if health.Leader == nil || !health.Leader.IsLeader || health.Leader.Leader != health.Leader.Identity {
+		test.Errorf("server is not the leader: %+v", health.Leader)
+		return
+	}
+
+	// test persistence through the repository: create an api key over RPC and list it over REST
+	// only admins create api keys, whatever the mode of the authorization policy
+	createRequest := &proto.CreateApiKeyRequest{Name: "test-key", Scopes: []string{"read"}}
+	_, err = grpcClient.CreateApiKey(context.Background(), createRequest)
+	if status.Code(err) != codes.Unauthenticated {
+		test.Errorf("api key created without credentials: %v", err)
+		return
+	}
+
+	_, adminKey, err := serverHelper.server.ApiKeyManager.Create(context.Background(), "admin-key",
+		[]string{auth.AdminScope}, 0)
+	if err != nil {
+		test.Errorf("failed to create admin api key: %v", err)
+		return
+	}
+
+	adminCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "ApiKey "+adminKey)
+	created, err := grpcClient.CreateApiKey(adminCtx, createRequest)
+	if err != nil {
+		test.Errorf("failed to create api key: %v", err)
+		return
+	}
+
+	readCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "ApiKey "+created.Key)
+	_, err = grpcClient.RevokeApiKey(readCtx, &proto.RevokeApiKeyRequest{Id: created.ApiKey.Id})
+	if status.Code(err) != codes.PermissionDenied {
+		test.Errorf("api key revoked without the admin scope: %v", err)
+		return
+	}
+
+	if _, err := grpcClient.ListApiKeys(readCtx, &proto.ListApiKeysRequest{}); status.Code(err) != codes.PermissionDenied {
+		test.Errorf("api keys listed without the admin scope: %v", err)
+		return
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:8000/v1/admin/apikeys", nil)
+	request.Header.Set("Authorization", "ApiKey "+created.Key)
+	apiKeysResp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		test.Errorf("failed to issue REST call to list api keys: %v", err)
+		return
+	}
+	apiKeysResp.Body.Close()
+
+	if apiKeysResp.StatusCode != http.StatusForbidden {
+		test.Errorf("api keys listed without the admin scope: %d", apiKeysResp.StatusCode)
+		return
+	}
+
+	request.Header.Set("Authorization", "ApiKey "+adminKey)
+	apiKeysResp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		test.Errorf("failed to issue REST call to list api keys: %v", err)
+		return
+	}
+	defer apiKeysResp.Body.Close()
+
+	var apiKeys models.ListApiKeysResponse
+	json.NewDecoder(apiKeysResp.Body).Decode(&apiKeys)
+	if len(apiKeys.ApiKeys) != 2 || apiKeys.ApiKeys[1].ID != created.ApiKey.Id {
+		test.Errorf("created api key was not listed: %+v", apiKeys)
+		return
+	}
+
+	// test scheduled jobs: trigger a job over RPC and list its run over REST
+	// only admins trigger jobs, whatever the mode of the authorization policy
+	_, err = grpcClient.TriggerJob(context.Background(), &proto.TriggerJobRequest{Name: "heartbeat"})
+	if status.Code(err) != codes.Unauthenticated {
+		test.Errorf("job triggered without credentials: %v", err)
+		return
+	}
+
+	if _, err := grpcClient.TriggerJob(adminCtx, &proto.TriggerJobRequest{Name: "heartbeat"}); err != nil {
+		test.Errorf("failed to trigger job: %v", err)
+		return
+	}
+
+	var jobs models.ListJobsResponse
+	for i := 0; i < 50 && (len(jobs.Jobs) != 1 || jobs.Jobs[0].Runs != 1); i++ {
+		time.Sleep(20 * time.Millisecond)
+		jobsRequest, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:8000/v1/admin/jobs", nil)
+		jobsRequest.Header.Set("Authorization", "ApiKey "+adminKey)
+		jobsResp, err := http.DefaultClient.Do(jobsRequest)
+		if err != nil {
+			test.Errorf("failed to issue REST call to list jobs: %v", err)
+			return
+		}
+
+		json.NewDecoder(jobsResp.Body).Decode(&jobs)
+		jobsResp.Body.Close()
+	}
+
+	if len(jobs.Jobs) != 1 || jobs.Jobs[0].Runs != 1 || jobs.Jobs[0].LastResult != "ok" {
+		test.Errorf("triggered job did not run: %+v", jobs)
+		return
+	}
+
+	// test the job queue: enqueue a job and poll its status over REST until it ran
+	job, err := serverHelper.server.JobQueue.Enqueue(context.Background(), "echo", map[string]string{"message": "hi"})
+	if err != nil {
+		test.Errorf("failed to enqueue job: %v", err)
+		return
+	}
+
+	var backgroundJob models.BackgroundJob
+	for i := 0; i < 50 && backgroundJob.Status != models.JobSucceeded; i++ {
+		time.Sleep(20 * time.Millisecond)
+		jobResp, err := http.Get(fmt.Sprintf("http://127.0.0.1:8000/v1/admin/jobqueue/%d", job.ID))
+		if err != nil {
+			test.Errorf("failed to issue REST call to get job: %v", err)
+			return
+		}
+
+		json.NewDecoder(jobResp.Body).Decode(&backgroundJob)
+		jobResp.Body.Close()
+	}
+
+	if backgroundJob.Status != models.JobSucceeded || backgroundJob.Attempts != 1 {
+		test.Errorf("enqueued job did not run: %+v", backgroundJob)
+		return
+	}
+
 	serverHelper.CloseServerTestHelper()
 }
+
+// TestRunFailure unit tests that a server failing to initialize closes the dependencies it already set up
+func TestRunFailure(test *testing.T) {
+	testObj, err := util.TestInit("test-service-failure")
+	if err != nil {
+		test.Errorf("failed to initialize test: %v", err)
+		return
+	}
+
+	defer testObj.TestCleanup(test)
+
+	// the consumer has no registered handler, which fails the server after its other dependencies started
+	config := &proto.Config{
+		Service: &proto.ServiceConfig{Name: "test_service", FqdnOrIP: "localhost", ApiPort: "8010", RpcPort: "8011"},
+		Logging: &proto.LoggingConfig{LogDir: testObj.TestDir, LogFile: "test_service.log", LoggingLevel: "info"},
+		Host:    &proto.HostConfig{InstanceName: "test_service-failure"},
+		Datastore: &proto.DatastoreConfig{Driver: repository.DriverSQLite,
+			DbName: filepath.Join(testObj.TestDir, "test_service.db"), MigrateOnStartup: true},
+		Kvstore: &proto.KVStoreConfig{Driver: kvstore.DriverMemory},
+		Queue: &proto.QueueConfig{Driver: queue.DriverMemory,
+			Consumers: []*proto.ConsumerConfig{{Name: "orders", Topic: "orders"}}},
+	}
+
+	server, err := NewServer(config)
+	if err != nil {
+		test.Errorf("failed to create server: %v", err)
+		return
+	}
+
+	if err := server.Run(); err == nil {
+		test.Errorf("server without a consumer handler started")
+		return
+	}
+
+	if server.Repository == nil || server.Repository.Ping(context.Background()) == nil {
+		test.Errorf("datastore connection not closed")
+	}
+}
+
+// TestRpcCompression unit tests that compressed rpc requests are refused when compression is turned off
+func TestRpcCompression(test *testing.T) {
+	for _, compression := range []string{"none", gzip.Name} {
+		s := &Server{
+			Config: &proto.Config{Service: &proto.ServiceConfig{
+				RpcServer: &proto.RpcServerConfig{Compression: compression}}},
+			ContextLogger: log.WithField("test", "compression"),
+		}
+
+		rpcTuning, err := s.rpcTuningOptions()
+		if err != nil {
+			test.Errorf("%s: invalid rpc server options: %v", compression, err)
+			return
+		}
+
+		s.rpcTuning = rpcTuning
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			test.Errorf("failed to listen: %v", err)
+			return
+		}
+
+		rpcServer := grpc.NewServer(s.grpcServerOptions()...)
+		healthpb.RegisterHealthServer(rpcServer, health.NewServer())
+		go rpcServer.Serve(listener)
+		defer rpcServer.Stop()
+
+		conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+		if err != nil {
+			test.Errorf("failed to dial rpc server: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		healthClient := healthpb.NewHealthClient(conn)
+		if _, err := healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{}); err != nil {
+			test.Errorf("%s: uncompressed rpc failed: %v", compression, err)
+			return
+		}
+
+		_, err = healthClient.Check(context.Background(), &healthpb.HealthCheckRequest{}, grpc.UseCompressor(gzip.Name))
+		if compression == gzip.Name && err != nil {
+			test.Errorf("gzip compressed rpc failed: %v", err)
+			return
+		}
+
+		if compression == "none" && status.Code(err) != codes.Unimplemented {
+			test.Errorf("compressed rpc not refused without compression: %v", err)
+			return
+		}
+	}
+}
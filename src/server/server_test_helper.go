@@ -3,16 +3,24 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 
+	"test_service/jobqueue"
+	"test_service/kvstore"
+	"test_service/leader"
 	proto "test_service/protobuf/generated"
+	"test_service/queue"
+	"test_service/repository"
+	"test_service/scheduler"
 	"test_service/util"
 )
 
@@ -40,6 +48,9 @@ func NewServerTestHelper(testObj *util.Test) (*ServerHelper, error) {
 			FqdnOrIP: "localhost",
 			ApiPort:  "8000",
 			RpcPort:  "8001",
+			RpcServer: &proto.RpcServerConfig{
+				Compression: "gzip",
+			},
 		},
 		Logging: &proto.LoggingConfig{
 			LogDir:       testObj.TestDir,
@@ -50,6 +61,39 @@ func NewServerTestHelper(testObj *util.Test) (*ServerHelper, error) {
 			Uuid:         uuid.New().String(),
 			InstanceName: instanceName,
 		},
+		// persist data in an sqlite database under the test directory
+		Datastore: &proto.DatastoreConfig{
+			Driver:           repository.DriverSQLite,
+			DbName:           filepath.Join(testObj.TestDir, "test_service.db"),
+			MigrateOnStartup: true,
+		},
+		Kvstore: &proto.KVStoreConfig{
+			Driver: kvstore.DriverMemory,
+		},
+		Cache: &proto.CacheConfig{Enabled: true},
+		Queue: &proto.QueueConfig{
+			Driver: queue.DriverMemory,
+		},
+		LeaderElection: &proto.LeaderElectionConfig{
+			Backend: leader.BackendKVStore,
+		},
+		Authentication: &proto.AuthenticationConfig{
+			ApiKeys: &proto.ApiKeyConfig{Enabled: true},
+		},
+		JobQueue: &proto.JobQueueConfig{
+			Enabled:      true,
+			PollInterval: "50ms",
+		},
+		Scheduler: &proto.SchedulerConfig{
+			Enabled: true,
+			Jobs:    []*proto.JobConfig{{Name: "heartbeat", Schedule: "@hourly", LeaderOnly: true}},
+		},
+		Resilience: &proto.ResilienceConfig{
+			Dependencies: []*proto.DependencyConfig{
+				{Name: DependencyDatastore, Breaker: &proto.BreakerConfig{Enabled: true}, Readiness: true},
+				{Name: DependencyKVStore, Retry: &proto.RetryConfig{MaxAttempts: 2}},
+			},
+		},
 	}
 
 	server, err := NewServer(config)
@@ -58,6 +102,14 @@ func NewServerTestHelper(testObj *util.Test) (*ServerHelper, error) {
 		return nil, err
 	}
 
+	server.JobHandlers = map[string]jobqueue.Handler{
+		"echo": func(ctx context.Context, job *jobqueue.Job) error { return nil },
+	}
+
+	server.ScheduledJobs = map[string]scheduler.JobFunc{
+		"heartbeat": func(ctx context.Context) error { return nil },
+	}
+
 	// run server in a go routine since it is a blocking call
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -0,0 +1,23 @@
+// Helpers to translate config values into Go types
+
+package util
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseDuration parses a duration string from the service config (e.g. "30s", "5m")
+// an empty value yields the provided default
+func ParseDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %v", value, err)
+	}
+
+	return duration, nil
+}
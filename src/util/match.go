@@ -0,0 +1,49 @@
+// Helpers to match requests (Gin routes and gRPC methods) against config patterns
+
+package util
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MatchPattern matches a Gin route pattern or gRPC full method name against a path.Match glob
+// a pattern ending in "/*" additionally matches the entire subtree below its prefix
+func MatchPattern(pattern, value string) bool {
+	if pattern == value {
+		return true
+	}
+
+	if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")) {
+		return true
+	}
+
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// SplitRoute splits a route pattern of the form "<METHOD> <path>" (e.g. "GET /v1/ping") into method and path
+func SplitRoute(route string) (string, string, error) {
+	fields := strings.Fields(route)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("route %q must be of the form \"<METHOD> <path>\"", route)
+	}
+
+	if _, err := path.Match(fields[1], ""); err != nil {
+		return "", "", fmt.Errorf("bad route pattern %q", route)
+	}
+
+	return fields[0], fields[1], nil
+}
+
+// MatchRoute checks if a request (method + registered Gin route) matches a "<METHOD> <path>" pattern
+// method may be "*" to match any method
+func MatchRoute(pattern, method, route string) bool {
+	patternMethod, patternPath, err := SplitRoute(pattern)
+	if err != nil {
+		return false
+	}
+
+	return (patternMethod == "*" || strings.EqualFold(patternMethod, method)) && MatchPattern(patternPath, route)
+}